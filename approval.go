@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMigrationAlreadyApplied indicates that an attempt was made to
+	// approve and run a migration which has already been applied.
+	ErrMigrationAlreadyApplied = errors.New("migration already applied")
+)
+
+// RequiresApproval marks a migration as needing manual approval, e.g.
+// because it must be coordinated with sign-off from another team. Such a
+// migration is never run by MigrateBatch, MigrateStepByStep,
+// MigrateParallel or MigrateToVersion, which log that it is awaiting
+// approval and leave it pending, along with any repeatable migrations.
+// Later migrations are run as usual. It is applied with ApproveAndRun.
+func RequiresApproval() MigrationOpt {
+	return func(x *migration) error {
+		x.RequiresApproval = true
+		return nil
+	}
+}
+
+// AwaitingApproval returns the names of the pending migrations which
+// require approval, in the order they would be run. See RequiresApproval.
+// The migration table is neither created nor locked.
+func (x *Migrator) AwaitingApproval() ([]string, error) {
+	pending, err := x.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	_, awaiting := x.withholdUnapproved(pending)
+	return awaiting, nil
+}
+
+// ApproveAndRun applies a pending migration as a batch of its own, as
+// RunOne would. It is intended for migrations marked with RequiresApproval,
+// but may be used for any pending migration. If the migration has already
+// been applied, an error wrapping ErrMigrationAlreadyApplied is returned.
+func (x *Migrator) ApproveAndRun(name string) error {
+	x.logAtLevel(LogLevelInfo, "Approved %s\n", name)
+	return x.runOne(name, Up, true)
+}
+
+// withholdUnapproved splits pending migrations into those which may be run
+// and those which require approval, keeping their order.
+func (x *Migrator) withholdUnapproved(pending []string) (runnable []string, awaiting []string) {
+	runnable = make([]string, 0, len(pending))
+	for _, name := range pending {
+		migration, _ := x.registry.Get(name)
+		if migration.RequiresApproval {
+			awaiting = append(awaiting, name)
+		} else {
+			runnable = append(runnable, name)
+		}
+	}
+
+	if len(awaiting) > 0 {
+		x.logAtLevel(LogLevelInfo, "Awaiting approval: %d migrations - %+v\n", len(awaiting), awaiting)
+	}
+	return runnable, awaiting
+}
@@ -0,0 +1,210 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrBackupFailed indicates that the backup taken before running
+	// pending migrations failed, so no migrations were run.
+	ErrBackupFailed = errors.New("pre-migration backup failed")
+)
+
+// BackupError is returned when the backup taken before running pending
+// migrations fails. It can be retrieved from the error returned by a run
+// with errors.As, and matches ErrBackupFailed with errors.Is.
+type BackupError struct {
+	// Stderr is the output of the backup command, if the backup was
+	// taken by PgDumpBackup.
+	Stderr string
+
+	// Underlying is the error returned by the BackupRunner.
+	Underlying error
+}
+
+// Error returns a message including the underlying error and the output
+// of the backup command.
+func (x *BackupError) Error() string {
+	if x.Stderr != "" {
+		return fmt.Sprintf("%v: %v: %s", ErrBackupFailed, x.Underlying, x.Stderr)
+	}
+	return fmt.Sprintf("%v: %v", ErrBackupFailed, x.Underlying)
+}
+
+// Unwrap returns the error returned by the BackupRunner.
+func (x *BackupError) Unwrap() error {
+	return x.Underlying
+}
+
+// Is reports whether target is ErrBackupFailed.
+func (x *BackupError) Is(target error) bool {
+	return target == ErrBackupFailed
+}
+
+// unixSocketPrefix starts the file name of the Unix socket of a Postgres
+// server, which ends with the port.
+const unixSocketPrefix = ".s.PGSQL."
+
+// BackupRunner takes a backup of the DB before pending migrations are run.
+// See WithPreBackup.
+type BackupRunner interface {
+	// Backup backs up the DB and returns a reference to the resulting
+	// artifact, such as a file path or object URL, which will be
+	// recorded alongside the batch.
+	Backup(ctx context.Context, db *pg.DB) (artifact string, err error)
+}
+
+// BackupFunc allows a plain function to be used as a BackupRunner.
+type BackupFunc func(ctx context.Context, db *pg.DB) (string, error)
+
+// Interface Compliance: This ensures compile-time checks
+// that BackupFunc indeed implements all methods of BackupRunner.
+var _ BackupRunner = (BackupFunc)(nil)
+
+// Backup calls the function.
+func (x BackupFunc) Backup(ctx context.Context, db *pg.DB) (string, error) {
+	return x(ctx, db)
+}
+
+// PgDumpBackup is a BackupRunner which runs pg_dump, using the connection
+// settings of the DB, and writes a custom-format archive to Dir.
+type PgDumpBackup struct {
+	// Command is the pg_dump executable. Defaults to "pg_dump".
+	Command string
+
+	// Dir is the directory in which archives are written. Defaults to
+	// the current working directory.
+	Dir string
+
+	// ExtraArgs are passed to pg_dump in addition to the connection
+	// and output arguments.
+	ExtraArgs []string
+}
+
+// Interface Compliance: This ensures compile-time checks
+// that PgDumpBackup indeed implements all methods of BackupRunner.
+var _ BackupRunner = (*PgDumpBackup)(nil)
+
+// Backup runs pg_dump and returns the path of the archive. If pg_dump
+// fails, a *BackupError holding its output is returned.
+func (x PgDumpBackup) Backup(ctx context.Context, db *pg.DB) (string, error) {
+	command := x.Command
+	if command == "" {
+		command = "pg_dump"
+	}
+
+	options := db.Options()
+	host, port, err := pgDumpHostPort(options)
+	if err != nil {
+		return "", err
+	}
+
+	artifact := filepath.Join(
+		x.Dir,
+		fmt.Sprintf("%s_%s.dump", time.Now().UTC().Format("20060102150405"), options.Database),
+	)
+
+	args := []string{
+		"--format=custom",
+		"--file=" + artifact,
+		"--host=" + host,
+		"--port=" + port,
+		"--username=" + options.User,
+		"--dbname=" + options.Database,
+	}
+	args = append(args, x.ExtraArgs...)
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+options.Password)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", &BackupError{
+			Stderr:     string(bytes.TrimSpace(stderr.Bytes())),
+			Underlying: errors.Wrap(err, command),
+		}
+	}
+
+	return artifact, nil
+}
+
+// pgDumpHostPort returns the host and port pg_dump should connect to. For
+// a Unix socket, the host is the directory of the socket, and the port is
+// taken from its name, e.g. /var/run/postgresql/.s.PGSQL.5432.
+func pgDumpHostPort(options *pg.Options) (host string, port string, err error) {
+	if options.Network != "unix" {
+		host, port, err = net.SplitHostPort(options.Addr)
+		return host, port, errors.Wrapf(err, "invalid address %s", options.Addr)
+	}
+
+	dir, name := filepath.Split(options.Addr)
+	port = strings.TrimPrefix(name, unixSocketPrefix)
+	if dir == "" || port == name || port == "" {
+		return "", "", errors.Errorf("invalid socket %s, expected a path ending in %sPORT", options.Addr, unixSocketPrefix)
+	}
+	return filepath.Clean(dir), port, nil
+}
+
+// WithPreBackup initialises a Migrator which runs a backup before applying
+// any pending migrations. If there are no pending migrations, no backup is
+// taken. If the backup fails, no migrations are run.
+//
+// The artifact returned by the backup is recorded in the backup_artifact
+// column of the migration table for every migration it preceded.
+//
+// Intended for use with NewMigrator.
+func WithPreBackup(runner BackupRunner) MigratorOpt {
+	return func(x *Migrator) error {
+		x.backupRunner = runner
+		return nil
+	}
+}
+
+// maybeBackup takes a backup if backups are enabled and there are pending
+// migrations, returning the artifact.
+func (x *Migrator) maybeBackup(db *pg.DB, migrationsToRun []string) (string, error) {
+	if x.backupRunner == nil || len(migrationsToRun) == 0 {
+		return "", nil
+	}
+
+	x.logAtLevel(LogLevelInfo, "Backing up before %d migrations\n", len(migrationsToRun))
+	artifact, err := x.backupRunner.Backup(x.ctx, db)
+	if err != nil {
+		var backupErr *BackupError
+		if errors.As(err, &backupErr) {
+			return "", err
+		}
+		return "", &BackupError{Underlying: err}
+	}
+
+	x.logAtLevel(LogLevelInfo, "Backup created: %s\n", artifact)
+	return artifact, nil
+}
+
+// recordBackup notes the backup artifact against every migration in
+// the given batch.
+func (x *Migrator) recordBackup(db Querier, batch int, artifact string) error {
+	if x.backupRunner == nil {
+		return nil
+	}
+
+	_, err := db.Exec(
+		"UPDATE ? SET backup_artifact = ? WHERE batch = ?",
+		pg.Ident(x.migrationTableName),
+		artifact,
+		batch,
+	)
+	return err
+}
@@ -0,0 +1,40 @@
+package migrations
+
+// startBatch begins a batch of migrations, described by event, which
+// should be a BatchStarted event: the time source, if any, takes the time
+// every migration of the batch is recorded with, the memo of the run, if
+// any, is logged, and the event is emitted with the memo. The batch should
+// be ended with finishRun.
+func (x *Migrator) startBatch(event Event) {
+	if x.timeSource != nil {
+		x.timeSource.startBatch()
+	}
+	if x.memo != "" {
+		x.logAtLevel(LogLevelInfo, "Batch %d memo: %s\n", event.Batch, x.memo)
+	}
+	event.Memo = x.memo
+	x.emit(event)
+}
+
+// finishRun ends a run: the time of the batch, if any, is forgotten, the
+// outcome is recorded if enabled with WithLastRunRecording, the version of
+// the schema is published if enabled with WithSchemaVersionComment and any
+// migrations were run, and the event which ends the run is emitted: ErrorOccurred if err is not nil, or
+// BatchCompleted if any migrations were run.
+func (x *Migrator) finishRun(err error, direction Direction, batch int, count int) {
+	if x.timeSource != nil {
+		x.timeSource.endBatch()
+	}
+	if err != nil || count > 0 {
+		x.recordLastRun(err, direction, batch, count)
+	}
+
+	switch {
+	case err != nil:
+		x.logAtLevel(LogLevelError, "Migration %s failed: %v\n", direction, err)
+		x.emit(Event{Type: ErrorOccurred, Direction: direction, Batch: batch, Err: err, Memo: x.memo})
+	case count > 0:
+		x.publishSchemaVersion()
+		x.emit(Event{Type: BatchCompleted, Direction: direction, Batch: batch, Count: count, Memo: x.memo})
+	}
+}
@@ -0,0 +1,102 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// BatchTxMode determines how the migrations in a batch are split into
+// transactions by MigrateBatch.
+type BatchTxMode byte
+
+const (
+	// SingleTx runs every migration in the batch in a single
+	// transaction. This is the default.
+	SingleTx BatchTxMode = iota
+
+	// TxPerMigration runs each migration in the batch in its own
+	// transaction, while still marking them all as belonging to the
+	// same batch, so that they can be rolled back together.
+	TxPerMigration
+)
+
+// WithBatchTxMode initialises a Migrator which splits the migrations run by
+// MigrateBatch and MigrateToVersion into transactions according to mode
+// (default: SingleTx).
+//
+// With TxPerMigration, locks are only held for the duration of each
+// migration, but a failure leaves the earlier migrations of the batch
+// applied. If the migrator's context is cancelled, the migration which is
+// currently running is allowed to finish, after which a
+// *RunInterruptedError is returned, as with MigrateStepByStep. Since the
+// migration table is not locked between migrations, a lease should be used
+// if other processes may run migrations at the same time. See WithLease.
+//
+// Intended for use with NewMigrator.
+func WithBatchTxMode(mode BatchTxMode) MigratorOpt {
+	return func(x *Migrator) error {
+		x.batchTxMode = mode
+		return nil
+	}
+}
+
+// applyInSeparateTransactions runs each migration in its own transaction,
+// as part of the given batch, followed by any changed repeatable
+// migrations if no migrations remain pending.
+func (x *Migrator) applyInSeparateTransactions(
+	db TxRunner,
+	migrationsToRun []string,
+	batch int,
+	artifact string,
+	remaining int,
+) error {
+	for i, migrationName := range migrationsToRun {
+		if i > 0 {
+			err := x.pace(migrationsToRun[i-1], migrationName)
+			if err != nil {
+				return err
+			}
+		}
+
+		if ctxErr := x.ctx.Err(); ctxErr != nil {
+			x.logAtLevel(LogLevelInfo, "Run interrupted: %d of %d migrations completed\n", i, len(migrationsToRun))
+			return &RunInterruptedError{
+				Completed: migrationsToRun[:i],
+				Remaining: migrationsToRun[i:],
+				Cause:     ctxErr,
+			}
+		}
+
+		err := x.runInTransactions(
+			context.WithoutCancel(x.ctx),
+			db,
+			func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+				err = x.maybeLockTable(stateTx)
+				if err != nil {
+					return err
+				}
+
+				err = x.checkRunWindow([]string{migrationName})
+				if err != nil {
+					return err
+				}
+
+				err = x.applyMigration(tx, stateTx, migrationName, batch)
+				if err != nil {
+					return err
+				}
+
+				return x.recordBackup(stateTx, batch, artifact)
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if remaining > 0 {
+		return nil
+	}
+	return x.runRepeatables(db)
+}
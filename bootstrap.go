@@ -0,0 +1,112 @@
+package migrations
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultBootstrapTemplate is the template of the file generated by Create
+// alongside the first migration in a directory, declaring the registry
+// variable which DefaultMigrationTemplate registers migrations with. For
+// the main package, it also declares a main function running the commands
+// of the cli package, configured from environment variables prefixed with
+// MIGRATIONS, so that the directory builds as it is. See NewMigratorFromEnv.
+const DefaultBootstrapTemplate = `package {{.Package}}
+
+import (
+	"github.com/chainql/migrations"
+{{- if eq .Package "main"}}
+	"github.com/chainql/migrations/cli"
+{{- end}}
+)
+
+//go:generate go run github.com/chainql/migrations/cmd/migrations-gen
+
+// registry holds the migrations of this package, which register themselves
+// with it in init functions.
+var registry migrations.Registry
+{{- if eq .Package "main"}}
+
+func main() {
+	cli.Main(func(opts ...migrations.MigratorOpt) (*migrations.Migrator, error) {
+		opts = append([]migrations.MigratorOpt{migrations.WithMigrations(&registry)}, opts...)
+		return migrations.NewMigratorFromEnv("MIGRATIONS", opts...)
+	})
+}
+{{- end}}
+`
+
+// DirReader is implemented by a CreateFS which can list the files in a
+// directory. Create uses it to find out whether a migration is the first
+// in its directory, and so needs a bootstrap file. See
+// DefaultBootstrapTemplate.
+type DirReader interface {
+	// ReadDir returns the entries of the named directory, or an error for
+	// which errors.Is(err, fs.ErrNotExist) is true if it does not exist.
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+// packageClausePattern matches the package clause of a Go file.
+var packageClausePattern = regexp.MustCompile(`(?m)^package\s+(\w+)`)
+
+// registryReferencePattern matches references to the registry variable
+// declared by the bootstrap file.
+var registryReferencePattern = regexp.MustCompile(`\bregistry\.`)
+
+// bootstrapFileName returns the name of the bootstrap file of a package.
+func bootstrapFileName(packageName string) string {
+	if packageName == "main" {
+		return "main.go"
+	}
+	return "registry.go"
+}
+
+// maybeCreateBootstrapFile generates the bootstrap file for a rendered
+// migration, if it refers to the registry variable and no other Go files
+// exist in the migration directory, and returns its path. Nothing is
+// generated if the CreateFS cannot list the directory, and an empty path
+// is returned.
+func (x *Migrator) maybeCreateBootstrapFile(rendered string) (string, error) {
+	dirReader, ok := x.createFSOrDefault().(DirReader)
+	if !ok || !registryReferencePattern.MatchString(rendered) {
+		return "", nil
+	}
+	match := packageClausePattern.FindStringSubmatch(rendered)
+	if match == nil {
+		return "", nil
+	}
+
+	entries, err := dirReader.ReadDir(x.migrationDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", errors.Wrap(err, "could not read migration directory")
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".go") {
+			return "", nil
+		}
+	}
+
+	t, err := template.New("bootstrap").Parse(DefaultBootstrapTemplate)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse bootstrap template")
+	}
+	buf := &bytes.Buffer{}
+	err = t.Execute(buf, map[string]interface{}{"Package": match[1]})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to render bootstrap template")
+	}
+
+	filePath := filepath.Join(x.migrationDir, bootstrapFileName(match[1]))
+	err = x.createFSOrDefault().WriteFile(filePath, buf.Bytes(), 0644)
+	if err != nil {
+		return "", errors.Wrap(err, "could not write file")
+	}
+	x.logAtLevel(LogLevelInfo, "Created bootstrap file %s", filePath)
+	return filePath, nil
+}
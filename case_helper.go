@@ -100,6 +100,36 @@ func ConvertSnakeCaseToCamelCase(word string) (result string) {
 	return builder.String()
 }
 
+// convertNameToFuncCase converts an already generated migration name
+// into a form suitable for use in a function name.
+//
+// Unlike ConvertSnakeCaseToCamelCase, the casing of letters which do not
+// follow a removed space or underscore is preserved, so that names which
+// are already camel-case are left untouched.
+func convertNameToFuncCase(name string) string {
+	builder := &strings.Builder{}
+	var prevWordBoundary bool
+	for _, char := range name {
+		if char == '_' || char == '-' || unicode.IsSpace(char) {
+			prevWordBoundary = true
+			continue
+		}
+
+		var err error
+		if prevWordBoundary {
+			prevWordBoundary = false
+			_, err = builder.WriteRune(unicode.ToUpper(char))
+		} else {
+			_, err = builder.WriteRune(char)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return builder.String()
+}
+
 // Caser is intended to convert a description to a particular naming
 // convention. It should take spaces into account.
 type Caser interface {
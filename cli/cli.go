@@ -0,0 +1,760 @@
+// Package cli provides a command line interface for managing migrations,
+// intended to be called from the main function of a migrations binary:
+//
+//	var registry migrations.Registry
+//
+//	func main() {
+//		cli.Main(func(opts ...migrations.MigratorOpt) (*migrations.Migrator, error) {
+//			opts = append([]migrations.MigratorOpt{
+//				migrations.WithMigrations(&registry),
+//			}, opts...)
+//			return migrations.NewMigrator(GetDB, opts...)
+//		})
+//	}
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/chainql/migrations"
+)
+
+// Exit codes returned by Run. They are a stable contract, so that deploy
+// scripts can branch on the outcome of a command without parsing its
+// output. When an error matches several codes, the first listed applies.
+const (
+	// ExitSuccess indicates that the command completed successfully. With
+	// -detailed-exit-codes, this means that migrations were run.
+	ExitSuccess = 0
+
+	// ExitFailure indicates that the command failed for a reason not
+	// covered by another exit code.
+	ExitFailure = 1
+
+	// ExitUsage indicates that the command line could not be parsed.
+	ExitUsage = 2
+
+	// ExitLeaseHeld indicates that the command did not run because the
+	// migration lease is held by another process. The command can be
+	// retried once the other process has finished or its lease expired.
+	ExitLeaseHeld = 3
+
+	// ExitLockTimeout indicates that the command gave up waiting for a
+	// lock in the DB, after the lock_timeout of the session expired. The
+	// command can be retried once whatever holds the lock has finished.
+	ExitLockTimeout = 4
+
+	// ExitValidationFailed indicates that the command did not run because
+	// a check failed beforehand, e.g. a preflight check, the run window,
+	// the rollback safety check or the release order of migrations.
+	ExitValidationFailed = 5
+
+	// ExitMigrationFailed indicates that a migration returned an error, so
+	// its batch was rolled back or, with -continue-on-error, only partly
+	// applied.
+	ExitMigrationFailed = 6
+
+	// ExitDrift indicates that the DB does not match the registered
+	// migrations, e.g. because it has migrations applied which are not
+	// registered.
+	ExitDrift = 7
+
+	// ExitNothingToDo indicates that the command succeeded without running
+	// any migrations. It is only returned with -detailed-exit-codes, for
+	// the init, migrate, rollback, reset and approve commands; otherwise
+	// ExitSuccess is returned.
+	ExitNothingToDo = 8
+)
+
+// MigratorFactory creates the Migrator used by the CLI. The CLI passes
+// options of its own, which must be applied after any of the caller's
+// options.
+type MigratorFactory func(opts ...migrations.MigratorOpt) (*migrations.Migrator, error)
+
+const usageText = `Manage database migrations.
+
+Usage:
+  %s [options] <command>
+
+Commands:
+  init          Runs the initial migrations as a separate batch.
+  init-plan     Lists the initial migrations and the statements init would run.
+  migrate       Runs all pending migrations.
+  rollback      Reverts the last batch of migrations.
+  rollback-plan Lists the migrations rollback would revert, and the risk of each.
+  reset         Reverts every applied migration.
+  create <name> Creates a new migration file.
+  approve <name>
+                Runs a migration which requires approval.
+  templates     Lists the templates in the template directory.
+  status        Lists every migration, whether it is applied, and its description.
+  history       Lists the applied migrations.
+  last-run      Prints the outcome of the last run recorded with -record-last-run.
+  heartbeats    Lists the running migrations recorded with -heartbeat, and whether each is stale.
+  reorder       Renames pending migrations which sort before applied ones.
+  manifest      Writes a JSON manifest of the registered migrations.
+  diff <file>   Lists the migrations added, removed or changed since a manifest.
+  schema-version
+                Prints the version of the schema, derived from the applied migrations.
+  schema        Writes a JSON model of the tables, columns, indexes and constraints of the DB.
+  create-from-schema <name> <file>
+                Creates a draft migration changing the DB to the schema in a file written by schema.
+  rename-history <convention>
+                Renames applied migrations to camelCase or snakeCase.
+
+Options:
+`
+
+// tuiCommands are the commands which show the interactive progress display
+// when run with -tui.
+var tuiCommands = map[string]bool{
+	"init":     true,
+	"migrate":  true,
+	"rollback": true,
+	"reset":    true,
+}
+
+// Main runs the CLI with the arguments of the current process, and exits
+// with the resulting exit code. Interrupt and termination signals cancel
+// the context of the Migrator.
+func Main(factory MigratorFactory) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := Run(ctx, factory, os.Args[0], os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// Run runs the CLI with the given arguments, excluding the program name,
+// and returns an exit code. Output is written to stdout and errors are
+// written to stderr.
+func Run(
+	ctx context.Context,
+	factory MigratorFactory,
+	program string,
+	args []string,
+	stdout io.Writer,
+	stderr io.Writer,
+) int {
+	flags := flag.NewFlagSet(program, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), usageText, program)
+		flags.PrintDefaults()
+	}
+
+	oneByOne := flags.Bool("one-by-one", false, "Run each migration in its own transaction and batch (migrate).")
+	profile := flags.String("profile", "", "Apply the settings of a predefined profile: dev, staging or prod. Other options take precedence.")
+	dryRun := flags.Bool("dry-run", false, "Run the checks of init, migrate, rollback and reset and list the pending migrations, without running any.")
+	maxMigrations := flags.Int("max-migrations", 0, "Run at most this many migrations, leaving the rest for the next run (migrate with -one-by-one).")
+	delay := flags.Duration("delay", 0, "Wait this long between migrations, e.g. to let replicas catch up (migrate with -one-by-one).")
+	phase := flags.String("phase", "", "Only run the migrations in this phase of the deploy, e.g. pre-deploy (migrate).")
+	parallel := flags.Bool("parallel", false, "Run independent migrations concurrently (migrate).")
+	tags := flags.String("tags", "", "Comma-separated tags; only run migrations with one of them (migrate).")
+	continueOnError := flags.Bool("continue-on-error", false, "Apply the migrations which succeed when others in the batch fail (migrate).")
+	excludeTags := flags.String("exclude-tags", "", "Comma-separated tags; skip migrations with any of them (migrate).")
+	templateFile := flags.String("template", "", "Path of a template file, or name of a template in the template directory, to use instead of the default template (create).")
+	withTest := flags.Bool("with-test", false, "Also generate a test file for the migration (create).")
+	params := make(map[string]string)
+	flags.Func("param", "Template parameter as name=value, may be repeated (create).", func(value string) error {
+		name, paramValue, found := strings.Cut(value, "=")
+		if !found || name == "" {
+			return fmt.Errorf("expected name=value, got %q", value)
+		}
+		params[name] = paramValue
+		return nil
+	})
+	dropSchema := flags.Bool("drop-schema", false, "Drop and recreate the schema instead of reverting each migration (reset).")
+	leaseTTL := flags.Duration("lease-ttl", 0, "Hold a lease with this TTL while running, e.g. in a Kubernetes Job (0 disables).")
+	leaseHolder := flags.String("lease-holder", "", "Identity recorded in the lease (default: host name and process ID).")
+	maxTxAge := flags.Duration("max-tx-age", 0, "Warn about transactions open for longer than this before running (0 disables).")
+	failOnLongTx := flags.Bool("fail-on-long-tx", false, "Refuse to run if -max-tx-age finds long-running transactions.")
+	maxReplicationLag := flags.Duration("max-replication-lag", 0, "Wait for the replication lag to fall below this before running, and between migrations with -one-by-one; fail if it does not (0 disables).")
+	advisoryLock := flags.Bool("advisory-lock", false, "Hold an advisory lock for the whole run instead of locking the migration table in each transaction; required by -parallel, and then by every runner.")
+	transactionPooling := flags.Bool("transaction-pooling", false, "Avoid session-level locks and settings, for a DB reached through PgBouncer in transaction mode.")
+	checkConnection := flags.Bool("check-connection", false, "Check the connection, server flavour and CREATE privileges before running.")
+	countRows := flags.Bool("count-rows", false, "Count and record the rows affected by each migration.")
+	recordLastRun := flags.Bool("record-last-run", false, "Record the outcome of each run, for last-run and health dashboards (init, migrate, rollback, reset).")
+	heartbeat := flags.Duration("heartbeat", 0, "Record a heartbeat with the elapsed time and progress of each running migration this often, for heartbeats (0 disables).")
+	memo := flags.String("memo", "", "Note, such as a release ticket or change request ID, recorded with the run (init, migrate, rollback, reset).")
+	force := flags.Bool("force", false, "Roll back even if the rollback is found to be risky (rollback), or overwrite existing files (create, create-from-schema).")
+	detailedExitCodes := flags.Bool("detailed-exit-codes", false, "Exit with ExitNothingToDo (8) rather than 0 when no migrations were run (init, migrate, rollback, reset, approve).")
+	secretsEnv := flags.String("secrets-env", "", "Resolve the secrets referenced by migrations from environment variables with this prefix, e.g. SECRET.")
+	notifyChannel := flags.String("notify-channel", "", "Postgres channel to NOTIFY with a JSON payload when each batch succeeds, e.g. schema_migrations.")
+	webhook := flags.String("webhook", "", "URL to post a JSON notification to when each batch starts, succeeds or fails.")
+	lockWait := flags.Duration("lock-wait", 0, "Report the sessions blocking the migration lock after waiting this long (0 disables).")
+	tui := flags.Bool("tui", false, "Show an interactive progress display (init, migrate, rollback, reset).")
+	err := flags.Parse(args)
+	if err != nil {
+		return ExitUsage
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	command := flags.Arg(0)
+	var migrationsRun atomic.Int64
+	opts := []migrations.MigratorOpt{
+		migrations.WithContext(ctx),
+		migrations.WithEventHandler(func(event migrations.Event) {
+			if event.Type == migrations.MigrationCompleted {
+				migrationsRun.Add(1)
+			}
+		}),
+	}
+
+	if *profile != "" {
+		p, err := migrations.ParseProfile(*profile)
+		if err != nil {
+			fmt.Fprintf(stderr, "Invalid -profile: %v\n", err)
+			return ExitUsage
+		}
+		opts = append(opts, migrations.WithProfile(p))
+	}
+	if *dryRun {
+		opts = append(opts, migrations.WithDryRun())
+	}
+	if *withTest {
+		opts = append(opts, migrations.WithCreateTests())
+	}
+	if *continueOnError {
+		opts = append(opts, migrations.WithContinueOnError())
+	}
+	if *leaseTTL > 0 {
+		opts = append(opts, migrations.WithLease(*leaseTTL, *leaseHolder))
+	}
+	if *heartbeat > 0 {
+		opts = append(opts, migrations.WithHeartbeat(*heartbeat))
+	}
+	if *maxTxAge > 0 {
+		action := migrations.PreflightWarn
+		if *failOnLongTx {
+			action = migrations.PreflightFail
+		}
+		opts = append(opts, migrations.WithPreflightChecks(*maxTxAge, action))
+	}
+	if *maxReplicationLag > 0 {
+		opts = append(opts, migrations.WithReplicationLagCheck(migrations.ReplicationLagCheck{
+			MaxLag:  *maxReplicationLag,
+			Retries: 10,
+			Action:  migrations.PreflightFail,
+		}))
+	}
+	if *force && (command == "create" || command == "create-from-schema") {
+		opts = append(opts, migrations.WithForceCreate())
+	}
+	if *advisoryLock {
+		opts = append(opts, migrations.WithLocker(migrations.NewAdvisoryLocker()))
+	}
+	if *transactionPooling {
+		opts = append(opts, migrations.WithTransactionPooling())
+	}
+	if *checkConnection {
+		opts = append(opts, migrations.WithConnectionCheck(0))
+	}
+	if *delay > 0 {
+		opts = append(opts, migrations.WithInterMigrationDelay(*delay))
+	}
+	if *countRows {
+		opts = append(opts, migrations.WithRowCounting())
+	}
+	if *recordLastRun {
+		opts = append(opts, migrations.WithLastRunRecording())
+	}
+	if *secretsEnv != "" {
+		opts = append(opts, migrations.WithSecretsProvider(migrations.EnvSecrets(*secretsEnv)))
+	}
+	if *notifyChannel != "" {
+		opts = append(opts, migrations.WithSchemaChangeNotify(*notifyChannel))
+	}
+	if *webhook != "" {
+		opts = append(opts, migrations.WithNotifier(&migrations.WebhookNotifier{URL: *webhook}))
+	}
+	if *lockWait > 0 {
+		opts = append(opts, migrations.WithOnLockWait(*lockWait, func(wait migrations.LockWait) {
+			printLockWait(stderr, wait)
+		}))
+	}
+
+	var progress *progressView
+	if *tui && tuiCommands[command] {
+		progress = newProgressView(stdout)
+		opts = append(
+			opts,
+			migrations.WithLogger(log.New(io.Discard, "", 0)),
+			migrations.WithEventHandler(progress.HandleEvent),
+			migrations.WithQueryHook(progress),
+		)
+	}
+
+	migrator, err := factory(opts...)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to create migrator: %v\n", err)
+		return ExitFailure
+	}
+
+	if progress != nil {
+		stopProgress := progress.Start(ctx)
+		defer stopProgress()
+	}
+
+	var memoOpts []migrations.RunOpt
+	if *memo != "" {
+		memoOpts = append(memoOpts, migrations.WithMemo(*memo))
+	}
+
+	switch command {
+	case "init":
+		err = migrator.Init(memoOpts...)
+	case "init-plan":
+		err = planInit(migrator, stdout)
+	case "migrate":
+		var runOpts []migrations.RunOpt
+		if *tags != "" {
+			runOpts = append(runOpts, migrations.WithTags(strings.Split(*tags, ",")...))
+		}
+		if *excludeTags != "" {
+			runOpts = append(runOpts, migrations.WithoutTags(strings.Split(*excludeTags, ",")...))
+		}
+
+		if *maxMigrations > 0 {
+			if !*oneByOne {
+				fmt.Fprintln(stderr, "-max-migrations can only be used with -one-by-one.")
+				return ExitUsage
+			}
+			runOpts = append(runOpts, migrations.WithMaxMigrations(*maxMigrations))
+		}
+
+		switch {
+		case *parallel && len(runOpts) > 0:
+			fmt.Fprintln(stderr, "Tags cannot be used with -parallel.")
+			return ExitUsage
+		case *parallel && len(memoOpts) > 0:
+			fmt.Fprintln(stderr, "-memo cannot be used with -parallel.")
+			return ExitUsage
+		case *phase != "" && (*parallel || *oneByOne):
+			fmt.Fprintln(stderr, "-phase cannot be used with -parallel or -one-by-one.")
+			return ExitUsage
+		case *phase != "":
+			err = migrator.MigratePhase(*phase, append(runOpts, memoOpts...)...)
+		case *parallel:
+			err = migrator.MigrateParallel()
+		case *oneByOne:
+			err = migrator.MigrateStepByStep(append(runOpts, memoOpts...)...)
+		default:
+			err = migrator.MigrateBatch(append(runOpts, memoOpts...)...)
+		}
+	case "rollback":
+		runOpts := memoOpts
+		if *force {
+			runOpts = append(runOpts, migrations.WithForce())
+		}
+		err = migrator.Rollback(runOpts...)
+	case "rollback-plan":
+		err = planRollback(migrator, stdout)
+	case "reset":
+		runOpts := memoOpts
+		if *dropSchema {
+			runOpts = append(runOpts, migrations.WithSchemaDrop())
+		}
+		err = migrator.Reset(runOpts...)
+	case "create":
+		if flags.NArg() < 2 {
+			fmt.Fprintln(stderr, "Please enter a migration name.")
+			return ExitUsage
+		}
+		err = create(migrator, flags.Arg(1), *templateFile, params)
+	case "approve":
+		if flags.NArg() < 2 {
+			fmt.Fprintln(stderr, "Please enter a migration name.")
+			return ExitUsage
+		}
+		err = migrator.ApproveAndRun(flags.Arg(1))
+	case "templates":
+		err = listTemplates(migrator, stdout)
+	case "status":
+		err = listStatus(migrator, stdout)
+	case "history":
+		err = listHistory(migrator, stdout)
+	case "last-run":
+		err = printLastRun(migrator, stdout)
+	case "heartbeats":
+		err = printHeartbeats(migrator, stdout)
+	case "reorder":
+		err = reorder(migrator, stdout)
+	case "manifest":
+		err = writeManifest(migrator, stdout)
+	case "schema-version":
+		err = printSchemaVersion(migrator, stdout)
+	case "schema":
+		err = writeSchema(migrator, stdout)
+	case "create-from-schema":
+		if flags.NArg() < 3 {
+			fmt.Fprintln(stderr, "Please enter a migration name and the path of a schema.")
+			return ExitUsage
+		}
+		err = createFromSchema(migrator, flags.Arg(1), flags.Arg(2))
+	case "diff":
+		if flags.NArg() < 2 {
+			fmt.Fprintln(stderr, "Please enter the path of a manifest.")
+			return ExitUsage
+		}
+		err = diffManifest(migrator, flags.Arg(1), stdout)
+	case "rename-history":
+		if flags.NArg() < 2 {
+			fmt.Fprintln(stderr, "Please enter a naming convention.")
+			return ExitUsage
+		}
+		err = renameHistory(migrator, migrations.MigrationNameConvention(flags.Arg(1)), stdout)
+	default:
+		fmt.Fprintf(stderr, "Unknown command %q\n", command)
+		flags.Usage()
+		return ExitUsage
+	}
+
+	if errors.Is(err, migrations.ErrDryRun) {
+		fmt.Fprintf(stdout, "Command %s stopped: %v\n", command, err)
+		return ExitSuccess
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Command %s failed: %v\n", command, err)
+		return exitCode(err)
+	}
+	if *detailedExitCodes && runsMigrations(command) && migrationsRun.Load() == 0 {
+		return ExitNothingToDo
+	}
+	return ExitSuccess
+}
+
+// create creates a migration, using the template in templateFile if it
+// is not empty. If no such file exists, templateFile is treated as the
+// name of a template in the template directory.
+func create(migrator *migrations.Migrator, name string, templateFile string, params map[string]string) error {
+	if templateFile == "" {
+		return migrator.Create(name)
+	}
+
+	template, err := os.ReadFile(templateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return migrator.CreateFromNamedTemplate(name, templateFile, params)
+	}
+	if err != nil {
+		return err
+	}
+	return migrator.CreateFromTemplate(name, string(template))
+}
+
+// listTemplates writes the templates in the template directory, along
+// with their descriptions and parameters.
+func listTemplates(migrator *migrations.Migrator, stdout io.Writer) error {
+	catalog, err := migrator.TemplateCatalog()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range catalog.List() {
+		fmt.Fprintf(stdout, "%s\t%s\n", info.Name, info.Description)
+		for _, param := range info.Params {
+			fmt.Fprintf(stdout, "  -param %s=...\t%s\n", param.Name, param.Description)
+		}
+	}
+	return nil
+}
+
+// listStatus prints every migration with its batch, or "pending" if it
+// has not been applied, and its description and author, if declared.
+func listStatus(migrator *migrations.Migrator, stdout io.Writer) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = fmt.Sprintf("batch %d", status.Batch)
+			if status.Skipped {
+				state += " (skipped)"
+			}
+		} else if status.RequiresApproval {
+			state = "awaiting approval"
+		}
+		if !status.Registered {
+			state += " (unknown)"
+		}
+
+		description := status.Description
+		if status.Author != "" {
+			description = strings.TrimSpace(description + " (" + status.Author + ")")
+		}
+		fmt.Fprintf(stdout, "%s\t%s\t%s\n", status.Name, state, description)
+	}
+	return nil
+}
+
+// listHistory prints the applied migrations, in the order they were
+// applied, with their batch, time, duration, rows affected and any
+// recorded objects.
+func listHistory(migrator *migrations.Migrator, stdout io.Writer) error {
+	history, err := migrator.History()
+	if err != nil {
+		return err
+	}
+
+	for _, applied := range history {
+		fmt.Fprintf(
+			stdout,
+			"%s\t%d\t%s\t%s\t%d\t%s\t%s\n",
+			applied.Name,
+			applied.Batch,
+			applied.MigratedAt.Format(time.RFC3339),
+			applied.Duration,
+			applied.RowsAffected,
+			strings.Join(applied.Objects, ","),
+			applied.Memo,
+		)
+	}
+	return nil
+}
+
+// printLockWait writes the sessions blocking a lock.
+func printLockWait(stderr io.Writer, wait migrations.LockWait) {
+	fmt.Fprintf(stderr, "Waiting %s for %s, blocked by %d sessions:\n", wait.Waited.Round(time.Second), wait.Lock, len(wait.Blockers))
+	for _, blocker := range wait.Blockers {
+		fmt.Fprintf(
+			stderr,
+			"  pid %d\t%s\t%s\t%s\t%s\n",
+			blocker.PID,
+			blocker.User,
+			blocker.ApplicationName,
+			blocker.State,
+			strings.Join(strings.Fields(blocker.Query), " "),
+		)
+	}
+	for _, heartbeat := range wait.Heartbeats {
+		fmt.Fprint(stderr, "  ")
+		printHeartbeat(stderr, heartbeat)
+	}
+}
+
+// writeManifest writes the manifest of the registered migrations as JSON.
+func writeManifest(migrator *migrations.Migrator, stdout io.Writer) error {
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(migrator.Manifest())
+}
+
+// writeSchema writes the introspected schema of the DB as JSON.
+func writeSchema(migrator *migrations.Migrator, stdout io.Writer) error {
+	schema, err := migrator.Introspect()
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(schema)
+}
+
+// createFromSchema creates a draft migration changing the DB to the
+// schema in schemaFile, as written by writeSchema.
+func createFromSchema(migrator *migrations.Migrator, name string, schemaFile string) error {
+	content, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return err
+	}
+
+	var schema migrations.Schema
+	err = json.Unmarshal(content, &schema)
+	if err != nil {
+		return fmt.Errorf("invalid schema %s: %w", schemaFile, err)
+	}
+	return migrator.CreateFromSchemaDiff(name, &schema)
+}
+
+// diffManifest prints the migrations added, removed or changed since the
+// manifest in manifestFile, one per line, prefixed with +, - or ~.
+func diffManifest(migrator *migrations.Migrator, manifestFile string, stdout io.Writer) error {
+	content, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return err
+	}
+
+	var manifest migrations.Manifest
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		return fmt.Errorf("invalid manifest %s: %w", manifestFile, err)
+	}
+
+	diff := migrations.DiffManifests(manifest, migrator.Manifest())
+	for _, name := range diff.Added {
+		fmt.Fprintf(stdout, "+ %s\n", name)
+	}
+	for _, name := range diff.Removed {
+		fmt.Fprintf(stdout, "- %s\n", name)
+	}
+	for _, name := range diff.Changed {
+		fmt.Fprintf(stdout, "~ %s\n", name)
+	}
+	return nil
+}
+
+// renameHistory renames the applied migrations to the given naming
+// convention and prints each rename.
+func renameHistory(
+	migrator *migrations.Migrator,
+	convention migrations.MigrationNameConvention,
+	stdout io.Writer,
+) error {
+	convert, err := migrations.ConventionConverter(convention)
+	if err != nil {
+		return err
+	}
+
+	renames, err := migrator.RenameHistory(convert)
+	for _, rename := range renames {
+		fmt.Fprintf(stdout, "%s -> %s\n", rename.OldName, rename.NewName)
+	}
+	return err
+}
+
+// planRollback prints the plan for rolling back the last batch.
+func planRollback(migrator *migrations.Migrator, stdout io.Writer) error {
+	plan, err := migrator.PlanRollback()
+	if err != nil {
+		return err
+	}
+	if plan == nil {
+		fmt.Fprintln(stdout, "No migrations to roll back.")
+		return nil
+	}
+	fmt.Fprintln(stdout, plan)
+	return nil
+}
+
+// planInit prints what init would do, without changing anything.
+func planInit(migrator *migrations.Migrator, stdout io.Writer) error {
+	plan, err := migrator.PlanInit()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, plan)
+	return nil
+}
+
+// printSchemaVersion prints the version of the schema, the latest applied
+// migration and the release, if any.
+func printSchemaVersion(migrator *migrations.Migrator, stdout io.Writer) error {
+	version, err := migrator.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version.Migration == "" {
+		fmt.Fprintln(stdout, "No migrations applied.")
+		return nil
+	}
+	fmt.Fprintf(stdout, "%s (%s)", version, version.Migration)
+	if version.Release != "" {
+		fmt.Fprintf(stdout, ", release %s", version.Release)
+	}
+	fmt.Fprintln(stdout)
+	return nil
+}
+
+// printLastRun prints the outcome of the last recorded run.
+func printLastRun(migrator *migrations.Migrator, stdout io.Writer) error {
+	lastRun, err := migrator.LastRun()
+	if errors.Is(err, migrations.ErrNoLastRun) {
+		fmt.Fprintln(stdout, "No run recorded.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	timestamp := lastRun.FinishedAt.Format(time.RFC3339)
+	if lastRun.Succeeded {
+		fmt.Fprintf(
+			stdout,
+			"%s %s succeeded: batch %d, %d migrations\n",
+			timestamp,
+			lastRun.Direction,
+			lastRun.Batch,
+			lastRun.Count,
+		)
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "%s %s failed", timestamp, lastRun.Direction)
+	if lastRun.Migration != "" {
+		fmt.Fprintf(stdout, " at %s", lastRun.Migration)
+	}
+	fmt.Fprintf(stdout, ": %s\n", lastRun.Error)
+	return nil
+}
+
+// printHeartbeats prints the heartbeats of the running migrations.
+func printHeartbeats(migrator *migrations.Migrator, stdout io.Writer) error {
+	heartbeats, err := migrator.Heartbeats()
+	if err != nil {
+		return err
+	}
+	if len(heartbeats) == 0 {
+		fmt.Fprintln(stdout, "No migrations running.")
+		return nil
+	}
+	for _, heartbeat := range heartbeats {
+		printHeartbeat(stdout, heartbeat)
+	}
+	return nil
+}
+
+// printHeartbeat prints the heartbeat of a running migration on one line.
+func printHeartbeat(w io.Writer, heartbeat migrations.Heartbeat) {
+	fmt.Fprintf(
+		w,
+		"%s %s (batch %d) by %s: running %s, last beat %s",
+		heartbeat.Migration,
+		heartbeat.Direction,
+		heartbeat.Batch,
+		heartbeat.Holder,
+		heartbeat.Elapsed.Round(time.Second),
+		heartbeat.UpdatedAt.Format(time.RFC3339),
+	)
+	if heartbeat.Step != "" || heartbeat.Current > 0 {
+		fmt.Fprintf(w, ", at %d", heartbeat.Current)
+		if heartbeat.Total > 0 {
+			fmt.Fprintf(w, "/%d", heartbeat.Total)
+		}
+		if heartbeat.Step != "" {
+			fmt.Fprintf(w, " %s", heartbeat.Step)
+		}
+	}
+	if heartbeat.Stale {
+		fmt.Fprint(w, " (stale)")
+	}
+	fmt.Fprintln(w)
+}
+
+// reorder renames out-of-order migrations and prints each rename.
+func reorder(migrator *migrations.Migrator, stdout io.Writer) error {
+	renames, err := migrator.Reorder()
+	for _, rename := range renames {
+		fmt.Fprintf(stdout, "%s -> %s\n", rename.OldName, rename.NewName)
+	}
+	return err
+}
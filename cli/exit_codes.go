@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"errors"
+
+	"github.com/chainql/migrations"
+	"github.com/go-pg/pg/v10"
+)
+
+// lockNotAvailable is the SQLSTATE of the error returned when lock_timeout
+// expires.
+const lockNotAvailable = "55P03"
+
+// validationErrors are the errors returned when a command refuses to run
+// because a check failed.
+var validationErrors = []error{
+	migrations.ErrPreflightFailed,
+	migrations.ErrConnectionCheckFailed,
+	migrations.ErrOutsideRunWindow,
+	migrations.ErrRollbackUnsafe,
+	migrations.ErrIrreversibleMigration,
+	migrations.ErrInvalidMigrationName,
+	migrations.ErrNoMigrationVersion,
+	migrations.ErrVersionOrder,
+	migrations.ErrDependencyCycle,
+	migrations.ErrMigrationNotApplied,
+	migrations.ErrAlreadyInitialized,
+	migrations.ErrReadOnly,
+	migrations.ErrUnknownPhase,
+	migrations.ErrEarlierPhasePending,
+	migrations.ErrMissingPrivileges,
+}
+
+// driftErrors are the errors returned when the DB does not match the
+// registered migrations.
+var driftErrors = []error{
+	migrations.ErrMigrationNotKnown,
+	migrations.ErrInitialMigrationNotKnown,
+	migrations.ErrSchemaDrift,
+}
+
+// exitCode returns the exit code of a command which failed with err.
+func exitCode(err error) int {
+	if errors.Is(err, migrations.ErrLeaseHeld) {
+		return ExitLeaseHeld
+	}
+
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) && pgErr.Field('C') == lockNotAvailable {
+		return ExitLockTimeout
+	}
+
+	for _, validationErr := range validationErrors {
+		if errors.Is(err, validationErr) {
+			return ExitValidationFailed
+		}
+	}
+
+	var migrationErr *migrations.MigrationError
+	var batchErr *migrations.BatchError
+	if errors.As(err, &migrationErr) || errors.As(err, &batchErr) {
+		return ExitMigrationFailed
+	}
+
+	for _, driftErr := range driftErrors {
+		if errors.Is(err, driftErr) {
+			return ExitDrift
+		}
+	}
+	return ExitFailure
+}
+
+// runsMigrations reports whether a command applies or rolls back
+// migrations, so that it can report having nothing to do.
+func runsMigrations(command string) bool {
+	switch command {
+	case "init", "migrate", "rollback", "reset", "approve":
+		return true
+	default:
+		return false
+	}
+}
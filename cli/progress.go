@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainql/migrations"
+	"github.com/go-pg/pg/v10"
+)
+
+// maxStatementWidth is the number of characters of the current statement
+// which are shown by the progress display.
+const maxStatementWidth = 100
+
+// progressState is the state of a single migration in the progress display.
+type progressState byte
+
+const (
+	progressRunning progressState = iota
+	progressDone
+	progressFailed
+)
+
+type progressRow struct {
+	name     string
+	started  time.Time
+	duration time.Duration
+	state    progressState
+	progress string
+}
+
+// progressView renders a live terminal display of a migration run, using
+// events from a Migrator and statements observed by a query hook.
+type progressView struct {
+	mtx         sync.Mutex
+	out         io.Writer
+	started     time.Time
+	direction   migrations.Direction
+	batch       int
+	total       int
+	rows        []progressRow
+	statement   string
+	message     string
+	interrupted bool
+	lines       int
+}
+
+// Interface Compliance: This ensures compile-time checks
+// that progressView indeed implements all methods of pg.QueryHook.
+var _ pg.QueryHook = (*progressView)(nil)
+
+func newProgressView(out io.Writer) *progressView {
+	return &progressView{
+		out:     out,
+		started: time.Now(),
+	}
+}
+
+// Start redraws the display periodically until the returned function is
+// called. When ctx is cancelled, the display notes that the run is being
+// interrupted.
+func (x *progressView) Start(ctx context.Context) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				x.mtx.Lock()
+				x.interrupted = true
+				x.mtx.Unlock()
+			case <-ticker.C:
+			}
+			x.render()
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		x.mtx.Lock()
+		x.statement = ""
+		x.mtx.Unlock()
+		x.render()
+	}
+}
+
+// HandleEvent implements migrations.EventHandler.
+func (x *progressView) HandleEvent(event migrations.Event) {
+	x.mtx.Lock()
+	switch event.Type {
+	case migrations.BatchStarted:
+		x.direction = event.Direction
+		x.batch = event.Batch
+		x.total += event.Count
+	case migrations.MigrationStarted:
+		x.rows = append(x.rows, progressRow{
+			name:    event.Migration,
+			started: event.Time,
+		})
+	case migrations.MigrationCompleted:
+		row := x.findRow(event.Migration)
+		if row != nil {
+			row.state = progressDone
+			row.duration = event.Duration
+		}
+	case migrations.MigrationProgress:
+		row := x.findRow(event.Migration)
+		if row != nil {
+			row.progress = formatProgress(event)
+		}
+	case migrations.BatchCompleted:
+		x.message = fmt.Sprintf("Batch %d completed", event.Batch)
+	case migrations.ErrorOccurred:
+		for i := range x.rows {
+			if x.rows[i].state == progressRunning {
+				x.rows[i].state = progressFailed
+				x.rows[i].duration = event.Time.Sub(x.rows[i].started)
+			}
+		}
+		x.message = fmt.Sprintf("Failed: %v", event.Err)
+	}
+	x.mtx.Unlock()
+
+	x.render()
+}
+
+// findRow returns the most recent row for the named migration, or nil.
+// Expects the mutex to be held.
+func (x *progressView) findRow(name string) *progressRow {
+	for i := len(x.rows) - 1; i >= 0; i-- {
+		if x.rows[i].name == name {
+			return &x.rows[i]
+		}
+	}
+	return nil
+}
+
+// BeforeQuery implements pg.QueryHook, noting the statement being run.
+func (x *progressView) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	query, err := event.FormattedQuery()
+	if err != nil || len(query) == 0 {
+		query, _ = event.UnformattedQuery()
+	}
+
+	statement := strings.Join(strings.Fields(string(query)), " ")
+	if len(statement) > maxStatementWidth {
+		statement = statement[:maxStatementWidth-3] + "..."
+	}
+
+	x.mtx.Lock()
+	x.statement = statement
+	x.mtx.Unlock()
+	return ctx, nil
+}
+
+// AfterQuery implements pg.QueryHook.
+func (x *progressView) AfterQuery(context.Context, *pg.QueryEvent) error {
+	return nil
+}
+
+// render redraws the display over the previous one.
+func (x *progressView) render() {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	var builder strings.Builder
+	if x.lines > 0 {
+		fmt.Fprintf(&builder, "\033[%dA", x.lines)
+	}
+
+	var lines []string
+	completed := 0
+	for _, row := range x.rows {
+		if row.state == progressDone {
+			completed++
+		}
+	}
+	lines = append(lines, fmt.Sprintf(
+		"Migrating %s · batch %d · %d/%d · %s",
+		x.direction,
+		x.batch,
+		completed,
+		x.total,
+		formatElapsed(time.Since(x.started)),
+	))
+
+	for _, row := range x.rows {
+		symbol := "▶"
+		duration := time.Since(row.started)
+		switch row.state {
+		case progressDone:
+			symbol = "✓"
+			duration = row.duration
+		case progressFailed:
+			symbol = "✗"
+			duration = row.duration
+		}
+		lines = append(lines, fmt.Sprintf("  %s %-60s %s", symbol, row.name, formatElapsed(duration)))
+		if row.state == progressRunning && row.progress != "" {
+			lines = append(lines, "      "+row.progress)
+		}
+		if row.state == progressRunning && x.statement != "" {
+			lines = append(lines, "      > "+x.statement)
+		}
+	}
+
+	switch {
+	case x.message != "":
+		lines = append(lines, x.message)
+	case x.interrupted:
+		lines = append(lines, "Interrupted: stopping once it is safe to do so...")
+	default:
+		lines = append(lines, "Press Ctrl-C to stop safely.")
+	}
+
+	for _, line := range lines {
+		builder.WriteString("\033[2K")
+		builder.WriteString(line)
+		builder.WriteString("\n")
+	}
+	// Clear any lines left over from a longer previous render.
+	for i := len(lines); i < x.lines; i++ {
+		builder.WriteString("\033[2K\n")
+	}
+	if len(lines) < x.lines {
+		fmt.Fprintf(&builder, "\033[%dA", x.lines-len(lines))
+	}
+
+	x.lines = len(lines)
+	_, _ = io.WriteString(x.out, builder.String())
+}
+
+// formatProgress formats the progress reported by a migration.
+func formatProgress(event migrations.Event) string {
+	if event.Total <= 0 {
+		return strings.TrimSpace(fmt.Sprintf("%d %s", event.Current, event.Message))
+	}
+	return strings.TrimSpace(fmt.Sprintf(
+		"%d/%d (%.1f%%) %s",
+		event.Current,
+		event.Total,
+		float64(event.Current)*100/float64(event.Total),
+		event.Message,
+	))
+}
+
+// formatElapsed formats a duration as minutes, seconds and tenths.
+func formatElapsed(d time.Duration) string {
+	d = d.Round(100 * time.Millisecond)
+	minutes := int(d / time.Minute)
+	seconds := (d % time.Minute).Seconds()
+	return fmt.Sprintf("%02d:%04.1f", minutes, seconds)
+}
@@ -0,0 +1,34 @@
+// Command migrations-gen checks that every migration file in a migration
+// directory registers itself with the name of the file, and regenerates
+// the index of the migrations in the directory. It is intended to be run
+// with go:generate from the package holding the migrations:
+//
+//	//go:generate go run github.com/chainql/migrations/cmd/migrations-gen
+//
+// See migrations.GenerateMigrationIndex.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/chainql/migrations"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "Migration directory to scan.")
+	check := flag.Bool("check", false, "Only check the migration files, without writing the index.")
+	flag.Parse()
+
+	var err error
+	if *check {
+		_, err = migrations.ScanMigrationDir(*dir)
+	} else {
+		err = migrations.GenerateMigrationIndex(*dir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrations-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
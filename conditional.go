@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+)
+
+// ShouldRunFunc decides whether a migration applies to the DB it is being
+// run against. See ShouldRun.
+type ShouldRunFunc func(tx *pg.Tx, cont *Context) (bool, error)
+
+// ShouldRun makes a migration conditional on predicate, e.g. on whether a
+// table which only exists in some environments is present. The predicate
+// is called in the transaction of the migration, just before its up
+// function would run. If it returns false, the up function is not run, but
+// the migration is still recorded as applied, marked as skipped, so that
+// it is not considered again. The down function of a skipped migration is
+// not run when it is rolled back.
+//
+// Skipped migrations are reported by History and Status.
+func ShouldRun(predicate ShouldRunFunc) MigrationOpt {
+	return func(x *migration) error {
+		x.ShouldRun = predicate
+		return nil
+	}
+}
+
+// shouldRun evaluates the ShouldRun predicate of a migration in tx, if it
+// has one.
+func (x *Migrator) shouldRun(tx *pg.Tx, m migration) (bool, error) {
+	if m.ShouldRun == nil {
+		return true, nil
+	}
+
+	cont := x.migrationContext(m.Name, Up)
+	return m.ShouldRun(tx, &cont)
+}
+
+// wasSkipped reports whether a conditional migration was recorded as
+// skipped. Migrations without a ShouldRun predicate are never skipped, so
+// the migration table is not queried for them.
+func (x *Migrator) wasSkipped(db Querier, m migration) (bool, error) {
+	if m.ShouldRun == nil {
+		return false, nil
+	}
+
+	var skipped []bool
+	_, err := db.Query(
+		&skipped,
+		"SELECT skipped FROM ? WHERE name = ?",
+		pg.Ident(x.migrationTableName),
+		m.Name,
+	)
+	if err != nil {
+		return false, err
+	}
+	return len(skipped) > 0 && skipped[0], nil
+}
@@ -0,0 +1,145 @@
+package migrations
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrConnectionCheckFailed indicates that a run was not started
+	// because the DB could not be reached, or is not suitable for running
+	// migrations. See WithConnectionCheck.
+	ErrConnectionCheckFailed = errors.New("connection check failed")
+)
+
+// WithSSLVerification requires the server certificate to be signed by one
+// of rootCAs, or by a CA trusted by the system if rootCAs is nil, and to
+// match the host the DB is connected to, as with sslmode=verify-full.
+//
+// Intended for use with NewDBFactoryFromOptions or NewDBFactoryFromDSN.
+func WithSSLVerification(rootCAs *x509.CertPool) DBOpt {
+	return func(x *pg.Options) {
+		host, _, err := net.SplitHostPort(x.Addr)
+		if err != nil {
+			host = x.Addr
+		}
+		x.TLSConfig = &tls.Config{
+			ServerName: host,
+			RootCAs:    rootCAs,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+}
+
+// WithConnectionCheck initialises a Migrator which checks the connection
+// to the DB before each run which applies or rolls back migrations, and
+// before any locks are taken. See CheckConnection. minServerVersion is in
+// the format of server_version_num, e.g. 130000 for Postgres 13, and 0
+// skips the version check.
+//
+// Intended for use with NewMigrator.
+func WithConnectionCheck(minServerVersion int) MigratorOpt {
+	return func(x *Migrator) error {
+		x.connectionCheck = true
+		x.minServerVersion = minServerVersion
+		return nil
+	}
+}
+
+// serverInfo describes the server and role of a connection.
+type serverInfo struct {
+	VersionNum int
+	Version    string
+	Role       string
+	Schema     string
+	CanCreate  bool
+}
+
+// serverInfoQuery describes the server and role of a connection, and
+// whether the role may create objects in the given schema, or create the
+// schema if it does not exist.
+const serverInfoQuery = `
+	SELECT
+		current_setting('server_version_num')::int AS version_num,
+		version() AS version,
+		current_user AS role,
+		?0 AS schema,
+		coalesce(
+			(SELECT has_schema_privilege(oid, 'CREATE') FROM pg_namespace WHERE nspname = ?0),
+			has_database_privilege(current_database(), 'CREATE')
+		) AS can_create
+`
+
+// CheckConnection checks that the DB can be reached, that the server is of
+// the expected flavour and, if WithConnectionCheck was given a minimum,
+// version, and that the role may create objects in its current schema and
+// in the schema of the migration table. If WithStateDB was used, both DBs
+// are checked.
+//
+// Problems are reported in an error wrapping ErrConnectionCheckFailed,
+// e.g. "role readonly lacks CREATE on schema public".
+func (x *Migrator) CheckConnection() error {
+	var currentSchema string
+	_, err := x.dbFactory().WithContext(x.ctx).QueryOne(pg.Scan(&currentSchema), "SELECT current_schema()")
+	if err != nil {
+		return errors.Wrapf(ErrConnectionCheckFailed, "cannot query DB: %v", err)
+	}
+
+	err = x.checkServer(x.dbFactory(), currentSchema)
+	if err != nil {
+		return err
+	}
+	return x.checkServer(x.stateDB(), tableSchema(x.migrationTableName))
+}
+
+// checkServer checks the server and role of a DB, and that the role may
+// create objects in schema.
+func (x *Migrator) checkServer(db *pg.DB, schema string) error {
+	err := db.Ping(x.ctx)
+	if err != nil {
+		return errors.Wrapf(ErrConnectionCheckFailed, "cannot connect to DB: %v", err)
+	}
+
+	var info serverInfo
+	_, err = db.WithContext(x.ctx).QueryOne(&info, serverInfoQuery, schema)
+	if err != nil {
+		return errors.Wrapf(ErrConnectionCheckFailed, "cannot query server: %v", err)
+	}
+
+	serverFlavour := Postgres
+	if strings.Contains(info.Version, "CockroachDB") {
+		serverFlavour = CockroachDB
+	}
+	if serverFlavour != x.context.Flavour {
+		return errors.Wrapf(
+			ErrConnectionCheckFailed,
+			"server is %s but the migrator expects %s; see WithPostgresFlavour",
+			serverFlavour,
+			x.context.Flavour,
+		)
+	}
+	if info.VersionNum < x.minServerVersion {
+		return errors.Wrapf(
+			ErrConnectionCheckFailed,
+			"server version %d is older than the minimum of %d",
+			info.VersionNum,
+			x.minServerVersion,
+		)
+	}
+	if !info.CanCreate {
+		return errors.Wrapf(
+			ErrConnectionCheckFailed,
+			"role %s lacks CREATE on schema %s",
+			info.Role,
+			info.Schema,
+		)
+	}
+
+	x.logAtLevel(LogLevelDebug, "Connection check: %s as %s\n", info.Version, info.Role)
+	return nil
+}
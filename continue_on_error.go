@@ -0,0 +1,150 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrDependencyFailed indicates that a migration was not run because
+	// a migration it depends on failed. See WithContinueOnError.
+	ErrDependencyFailed = errors.New("dependency failed")
+)
+
+// BatchError is returned when some migrations of a batch failed while
+// others were applied. See WithContinueOnError.
+type BatchError struct {
+	// Batch is the batch the applied migrations were recorded in.
+	Batch int
+
+	// Applied lists the migrations which were applied, in order.
+	Applied []string
+
+	// Failed holds an error for each migration which was not applied:
+	// a *MigrationError if it failed, or an error wrapping
+	// ErrDependencyFailed if it was skipped because a migration it
+	// depends on was not applied.
+	Failed []error
+}
+
+// Error returns a message listing the failed migrations.
+func (x *BatchError) Error() string {
+	messages := make([]string, 0, len(x.Failed))
+	for _, err := range x.Failed {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf(
+		"%d of %d migrations failed in batch %d: %s",
+		len(x.Failed),
+		len(x.Failed)+len(x.Applied),
+		x.Batch,
+		strings.Join(messages, "; "),
+	)
+}
+
+// Unwrap returns the errors of the failed migrations, so that they can be
+// retrieved with errors.Is and errors.As.
+func (x *BatchError) Unwrap() []error {
+	return x.Failed
+}
+
+// WithContinueOnError initialises a Migrator which keeps going when a
+// migration in a batch fails, as long as the remaining migrations do not
+// depend on it. Each migration is run within a savepoint, so a failed
+// migration is rolled back on its own, and the migrations which succeeded
+// are committed as the batch. A *BatchError listing the failures is then
+// returned.
+//
+// Dependencies are declared with DependsOn, as for MigrateParallel.
+// Migrations which do not declare their dependencies depend on every
+// migration sorted before them, so they are skipped after any failure.
+//
+// This applies to MigrateBatch, and the methods which run a batch in the
+// same way, when using SingleTx. Repeatable migrations are not run after
+// a failure.
+//
+// Intended for use with NewMigrator.
+func WithContinueOnError() MigratorOpt {
+	return func(x *Migrator) error {
+		x.continueOnError = true
+		return nil
+	}
+}
+
+// applyWithSavepoints applies each migration within a savepoint, rolling
+// back only those which fail, and skipping those which depend on a failed
+// migration. The returned BatchError lists the applied migrations and the
+// failures, if any, while the error is only set if the batch could not be
+// run at all.
+func (x *Migrator) applyWithSavepoints(
+	tx *pg.Tx,
+	stateTx *pg.Tx,
+	migrationsToRun []string,
+	batch int,
+) (*BatchError, error) {
+	dependencies, err := x.buildDependencyGraph(migrationsToRun)
+	if err != nil {
+		return nil, err
+	}
+
+	savepointTxs := []*pg.Tx{tx}
+	if stateTx != tx {
+		savepointTxs = append(savepointTxs, stateTx)
+	}
+	execAll := func(query string) error {
+		for _, savepointTx := range savepointTxs {
+			_, err := savepointTx.Exec(query)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	batchErr := &BatchError{Batch: batch}
+	notApplied := make(map[string]struct{})
+	for _, migrationName := range migrationsToRun {
+		var failedDependency string
+		for _, dependency := range dependencies[migrationName] {
+			if _, failed := notApplied[dependency]; failed {
+				failedDependency = dependency
+				break
+			}
+		}
+		if failedDependency != "" {
+			x.logAtLevel(LogLevelInfo, "Skipped %s: depends on %s\n", migrationName, failedDependency)
+			notApplied[migrationName] = struct{}{}
+			batchErr.Failed = append(batchErr.Failed, errors.Wrapf(
+				ErrDependencyFailed,
+				"%s depends on %s",
+				migrationName,
+				failedDependency,
+			))
+			continue
+		}
+
+		err = execAll("SAVEPOINT migration")
+		if err != nil {
+			return nil, err
+		}
+
+		migrationErr := x.applyMigration(tx, stateTx, migrationName, batch)
+		if migrationErr != nil {
+			x.logAtLevel(LogLevelError, "Migration failed, continuing: %v\n", migrationErr)
+			notApplied[migrationName] = struct{}{}
+			batchErr.Failed = append(batchErr.Failed, migrationErr)
+			err = execAll("ROLLBACK TO SAVEPOINT migration")
+		} else {
+			batchErr.Applied = append(batchErr.Applied, migrationName)
+			err = execAll("RELEASE SAVEPOINT migration")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return batchErr, nil
+}
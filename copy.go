@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"io"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// CopyOpt represents an option which can be applied to a COPY run by
+// Context.CopyFrom. See the Copy* functions in this package.
+type CopyOpt func(*copyOptions)
+
+// copyOptions holds the options of a COPY.
+type copyOptions struct {
+	tsv    bool
+	header bool
+}
+
+// CopyTSV makes CopyFrom read tab-separated values in the text format of
+// COPY, in which NULL is written as \N, rather than CSV.
+//
+// Intended for use with Context.CopyFrom.
+func CopyTSV() CopyOpt {
+	return func(x *copyOptions) {
+		x.tsv = true
+	}
+}
+
+// CopyWithHeader makes CopyFrom skip the first line of CSV data, which
+// holds the column names. It has no effect with CopyTSV.
+//
+// Intended for use with Context.CopyFrom.
+func CopyWithHeader() CopyOpt {
+	return func(x *copyOptions) {
+		x.header = true
+	}
+}
+
+// CopyFrom bulk-loads CSV data from r into the given columns of table,
+// using COPY FROM STDIN within the migration's transaction, and returns the
+// number of rows copied. This is much faster than inserting rows one at a
+// time when seeding or backfilling data. The format may be changed with
+// opts, such as CopyTSV.
+//
+// The table may be schema-qualified. If columns is empty, every column of
+// the table must be present in the data, in order.
+func (x *Context) CopyFrom(tx *pg.Tx, table string, columns []string, r io.Reader, opts ...CopyOpt) (int, error) {
+	var options copyOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	query := &strings.Builder{}
+	query.WriteString("COPY ?")
+	params := []interface{}{pg.Ident(table)}
+	if len(columns) > 0 {
+		placeholders := make([]string, 0, len(columns))
+		for _, column := range columns {
+			placeholders = append(placeholders, "?")
+			params = append(params, pg.Ident(column))
+		}
+		query.WriteString(" (" + strings.Join(placeholders, ", ") + ")")
+	}
+	query.WriteString(" FROM STDIN")
+	switch {
+	case options.tsv:
+		query.WriteString(" WITH (FORMAT text)")
+	case options.header:
+		query.WriteString(" WITH (FORMAT csv, HEADER true)")
+	default:
+		query.WriteString(" WITH (FORMAT csv)")
+	}
+
+	result, err := tx.CopyFrom(r, query.String(), params...)
+	if err != nil {
+		return 0, err
+	}
+
+	copied := result.RowsAffected()
+	if x != nil && x.migrator != nil {
+		x.migrator.logAtLevel(LogLevelDebug, "Copied %d rows into %s for %s\n", copied, table, x.migration)
+	}
+	return copied, nil
+}
@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTemplateNotSupported indicates that a template was given when
+	// creating a migration in a format which does not use templates.
+	ErrTemplateNotSupported = errors.New("templates not supported for format")
+)
+
+// CreateFormat determines the files generated when creating a migration.
+type CreateFormat byte
+
+const (
+	// GoFile generates a Go file which registers the migration, using
+	// a template. This is the default.
+	GoFile CreateFormat = iota
+
+	// SQLPair generates a pair of files containing the up and down SQL,
+	// named for use with LoadSQLFiles.
+	SQLPair
+)
+
+// WithCreateFormat initialises a Migrator which generates migrations in
+// the given format when using Create (default: GoFile).
+//
+// Templates are only used for GoFile. Creating a SQLPair migration with
+// a template returns an error wrapping ErrTemplateNotSupported.
+//
+// Intended for use with NewMigrator.
+func WithCreateFormat(format CreateFormat) MigratorOpt {
+	return func(x *Migrator) error {
+		x.createFormat = format
+		return nil
+	}
+}
+
+// createSQLMigrationFiles writes empty up and down SQL files for the
+// named migration, returning the path of the up file.
+func (x *Migrator) createSQLMigrationFiles(filename string, templateString string) (string, error) {
+	if templateString != "" && templateString != DefaultMigrationTemplate {
+		return "", errors.Wrapf(ErrTemplateNotSupported, "migration %s", filename)
+	}
+
+	return x.writeSQLMigrationFiles(
+		filename,
+		"-- Up migration for "+filename+"\n",
+		"-- Down migration for "+filename+"\n",
+	)
+}
+
+// writeSQLMigrationFiles writes up and down SQL files with the given
+// content for the named migration, returning the path of the up file. If
+// the down file cannot be created, the up file is removed again, unless
+// it may have overwritten an existing file with WithForceCreate.
+func (x *Migrator) writeSQLMigrationFiles(filename string, up string, down string) (string, error) {
+	upPath := filepath.Join(x.migrationDir, filename+UpSQLSuffix)
+	downPath := filepath.Join(x.migrationDir, filename+DownSQLSuffix)
+	for _, filePath := range []string{upPath, downPath} {
+		err := x.checkFileNotExists(filePath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err := x.createFile(upPath, []byte(up))
+	if err != nil {
+		return "", err
+	}
+
+	err = x.createFile(downPath, []byte(down))
+	if err != nil {
+		if !x.forceCreate {
+			x.removeCreatedFile(upPath)
+		}
+		return "", err
+	}
+	return upPath, nil
+}
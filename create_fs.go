@@ -0,0 +1,189 @@
+package migrations
+
+import (
+	"io/fs"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// CreateFS is a writable filesystem to which Create writes migration files.
+// Names are paths in the format of the operating system, as returned by
+// filepath.Join, within the migration directory.
+//
+// The default writes to the OS filesystem. Other implementations can
+// target an in-memory filesystem for tests, or collect the files in a code
+// generation pipeline. See migratest.FS.
+type CreateFS interface {
+	// Stat returns information about the named file, or an error for
+	// which errors.Is(err, fs.ErrNotExist) is true if it does not exist.
+	Stat(name string) (fs.FileInfo, error)
+
+	// WriteFile writes data to the named file, creating it if necessary.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// ExclusiveCreateFS is implemented by a CreateFS which can create a file
+// only if it does not already exist, in a single step. Create uses it to
+// avoid overwriting a file created between checking for it and writing
+// it, e.g. by a concurrent Create. Other CreateFSs are checked with Stat
+// before each file is written.
+type ExclusiveCreateFS interface {
+	CreateFS
+
+	// CreateFile writes data to the named file, which must not exist. If
+	// it does, an error for which errors.Is(err, fs.ErrExist) is true is
+	// returned, and the file is not changed.
+	CreateFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// RemoveFS is implemented by a CreateFS which can remove files. Create
+// uses it to remove the files it has written when a later file of the same
+// migration cannot be created, so that no partial migration is left
+// behind. With other CreateFSs such files are kept.
+type RemoveFS interface {
+	CreateFS
+
+	// Remove removes the named file.
+	Remove(name string) error
+}
+
+// osCreateFS is the CreateFS which writes to the OS filesystem.
+type osCreateFS struct{}
+
+// Interface Compliance
+var (
+	_ ExclusiveCreateFS = osCreateFS{}
+	_ RemoveFS          = osCreateFS{}
+	_ DirReader         = osCreateFS{}
+)
+
+// Stat returns information about the named file using os.Stat.
+func (x osCreateFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// WriteFile writes the named file using os.WriteFile.
+func (x osCreateFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+// CreateFile creates the named file with O_EXCL, so that it fails if the
+// file exists.
+func (x osCreateFS) CreateFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(name)
+	}
+	return err
+}
+
+// Remove removes the named file using os.Remove.
+func (x osCreateFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// ReadDir lists the named directory using os.ReadDir.
+func (x osCreateFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// WithCreateFS initialises a Migrator which writes the files generated by
+// Create, and the other Create* methods, to fsys rather than to the OS
+// filesystem. Files are still written within the migration directory.
+//
+// Intended for use with NewMigrator.
+func WithCreateFS(fsys CreateFS) MigratorOpt {
+	return func(x *Migrator) error {
+		x.createFS = fsys
+		return nil
+	}
+}
+
+// createFSOrDefault returns the filesystem to which Create writes.
+func (x *Migrator) createFSOrDefault() CreateFS {
+	if x.createFS == nil {
+		return osCreateFS{}
+	}
+	return x.createFS
+}
+
+// WithForceCreate initialises a Migrator which overwrites existing files
+// when creating migrations, e.g. to regenerate a scaffold with a changed
+// template, instead of failing with ErrFileAlreadyExists.
+//
+// Intended for use with NewMigrator.
+func WithForceCreate() MigratorOpt {
+	return func(x *Migrator) error {
+		x.forceCreate = true
+		return nil
+	}
+}
+
+// checkFileNotExists returns an error wrapping ErrFileAlreadyExists if the
+// named file exists, unless the Migrator was created with
+// WithForceCreate. If the file cannot be checked, e.g. because permission
+// is denied, the error from the CreateFS is returned instead.
+func (x *Migrator) checkFileNotExists(name string) error {
+	if x.forceCreate {
+		return nil
+	}
+
+	_, err := x.createFSOrDefault().Stat(name)
+	switch {
+	case err == nil:
+		return errors.Wrapf(ErrFileAlreadyExists, "file %s", name)
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	default:
+		return errors.Wrapf(err, "could not check file %s", name)
+	}
+}
+
+// createFile writes data to the named file, which must not exist unless
+// the Migrator was created with WithForceCreate. If the CreateFS is an
+// ExclusiveCreateFS, the file is created only if it does not exist, in a
+// single step.
+func (x *Migrator) createFile(name string, data []byte) error {
+	fsys := x.createFSOrDefault()
+	exclusive, ok := fsys.(ExclusiveCreateFS)
+	if x.forceCreate || !ok {
+		err := x.checkFileNotExists(name)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(fsys.WriteFile(name, data, 0644), "could not write file")
+	}
+
+	err := exclusive.CreateFile(name, data, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return errors.Wrapf(ErrFileAlreadyExists, "file %s", name)
+	}
+	return errors.Wrap(err, "could not write file")
+}
+
+// removeCreatedFile removes a file written by Create when a later file of
+// the same migration could not be created, if the CreateFS is a RemoveFS.
+// A failure is only logged, since the error which caused the removal is
+// the one returned.
+func (x *Migrator) removeCreatedFile(name string) {
+	remover, ok := x.createFSOrDefault().(RemoveFS)
+	if !ok {
+		return
+	}
+
+	err := remover.Remove(name)
+	if err != nil {
+		x.logAtLevel(LogLevelError, "Could not remove %s: %v\n", name, err)
+		return
+	}
+	x.logAtLevel(LogLevelDebug, "Removed %s\n", name)
+}
@@ -0,0 +1,178 @@
+// Package ddl provides helpers for common defensive DDL patterns, intended
+// for use from within migration functions.
+//
+// Each helper accepts the migration Context, so that the SQL used can vary
+// depending on the Postgres flavour being migrated. A nil Context is treated
+// as an original Postgres instance.
+//
+// There are no helpers for CONCURRENTLY operations, such as dropping or
+// creating an index concurrently, since migration functions always run in
+// a *pg.Tx, and Postgres does not allow them inside a transaction. To drop
+// an index without queueing writes behind a long lock wait, drop it with
+// DROP INDEX IF EXISTS in a migration of its own, and set
+// migrations.WithLockTimeout so that the migration fails fast and can be
+// retried instead. An index which must be dropped concurrently can be
+// dropped by hand with DROP INDEX CONCURRENTLY, after which the migration
+// dropping it IF EXISTS does nothing.
+package ddl
+
+import (
+	"strings"
+
+	"github.com/chainql/migrations"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrColumnNotFound indicates that a column which was expected to
+	// exist does not.
+	ErrColumnNotFound = errors.New("column not found")
+
+	// ErrColumnAlreadyExists indicates that a column which was expected
+	// not to exist already does.
+	ErrColumnAlreadyExists = errors.New("column already exists")
+)
+
+// flavour returns the Postgres flavour of the migration context.
+func flavour(cont *migrations.Context) migrations.PostgresFlavour {
+	if cont == nil {
+		return migrations.Postgres
+	}
+	return cont.Flavour
+}
+
+// AddColumnIfNotExists adds a column to a table, unless a column with the
+// same name already exists. The definition is the column type followed by
+// any constraints, e.g. "integer NOT NULL DEFAULT 0", and is not escaped.
+func AddColumnIfNotExists(
+	db pg.DBI,
+	cont *migrations.Context,
+	table string,
+	column string,
+	definition string,
+) error {
+	_, err := db.Exec(
+		"ALTER TABLE ? ADD COLUMN IF NOT EXISTS ? ?",
+		pg.Ident(table),
+		pg.Ident(column),
+		pg.Safe(definition),
+	)
+	return errors.Wrapf(err, "add column %s.%s", table, column)
+}
+
+// RenameColumnSafe renames a column, tolerating the rename having already
+// been applied. If only the new column exists, nothing is done. If both
+// or neither of the columns exist, an error is returned.
+func RenameColumnSafe(
+	db pg.DBI,
+	cont *migrations.Context,
+	table string,
+	oldColumn string,
+	newColumn string,
+) error {
+	oldExists, err := columnExists(db, table, oldColumn)
+	if err != nil {
+		return err
+	}
+
+	newExists, err := columnExists(db, table, newColumn)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case oldExists && newExists:
+		return errors.Wrapf(ErrColumnAlreadyExists, "rename %s.%s to %s", table, oldColumn, newColumn)
+	case newExists:
+		return nil
+	case !oldExists:
+		return errors.Wrapf(ErrColumnNotFound, "rename %s.%s to %s", table, oldColumn, newColumn)
+	}
+
+	_, err = db.Exec(
+		"ALTER TABLE ? RENAME COLUMN ? TO ?",
+		pg.Ident(table),
+		pg.Ident(oldColumn),
+		pg.Ident(newColumn),
+	)
+	return errors.Wrapf(err, "rename %s.%s to %s", table, oldColumn, newColumn)
+}
+
+// AddCheckNotValid adds a check constraint to a table without checking
+// the existing rows, so that only a brief lock is needed. The expression
+// is not escaped. The constraint applies to rows written from then on,
+// and should be validated with ValidateConstraint in a later migration.
+//
+// The lock taken to add the constraint is held until the transaction of
+// the migration commits, so ValidateConstraint must run in a migration
+// which is committed separately, e.g. in a later batch or with
+// migrations.WithBatchTxMode(migrations.TxPerMigration), for the lock not
+// to be held while rows are checked.
+//
+// CockroachDB validates constraints online, and does not allow a
+// constraint to be validated in the transaction which added it, so the
+// constraint is added as valid.
+func AddCheckNotValid(
+	db pg.DBI,
+	cont *migrations.Context,
+	table string,
+	constraint string,
+	expression string,
+) error {
+	query := "ALTER TABLE ? ADD CONSTRAINT ? CHECK (?) NOT VALID"
+	if flavour(cont) == migrations.CockroachDB {
+		query = "ALTER TABLE ? ADD CONSTRAINT ? CHECK (?)"
+	}
+	_, err := db.Exec(query, pg.Ident(table), pg.Ident(constraint), pg.Safe(expression))
+	return errors.Wrapf(err, "add constraint %s on %s", constraint, table)
+}
+
+// ValidateConstraint checks the existing rows of a table against a
+// constraint added with AddCheckNotValid, which must have been committed
+// by an earlier migration. Validating only takes a lock which allows
+// reads and writes to continue while rows are checked.
+func ValidateConstraint(
+	db pg.DBI,
+	cont *migrations.Context,
+	table string,
+	constraint string,
+) error {
+	_, err := db.Exec(
+		"ALTER TABLE ? VALIDATE CONSTRAINT ?",
+		pg.Ident(table),
+		pg.Ident(constraint),
+	)
+	return errors.Wrapf(err, "validate constraint %s on %s", constraint, table)
+}
+
+// columnExists reports whether a column exists on a table. If the table
+// is not schema-qualified, the current schema is assumed.
+func columnExists(db pg.DBI, table string, column string) (bool, error) {
+	schema, tableName := splitTableName(table)
+	var exists bool
+	_, err := db.QueryOne(
+		pg.Scan(&exists),
+		`
+			SELECT EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_schema = coalesce(?, current_schema())
+					AND table_name = ?
+					AND column_name = ?
+			)
+		`,
+		schema,
+		tableName,
+		column,
+	)
+	return exists, errors.Wrapf(err, "check column %s.%s", table, column)
+}
+
+// splitTableName splits a possibly schema-qualified table name. A nil
+// schema is returned for unqualified names.
+func splitTableName(table string) (schema interface{}, tableName string) {
+	if before, after, ok := strings.Cut(table, "."); ok {
+		return before, after
+	}
+	return nil, table
+}
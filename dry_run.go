@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrDryRun indicates that a run was stopped before applying or
+	// rolling back any migrations because the Migrator is in dry-run mode.
+	// See WithDryRun.
+	ErrDryRun = errors.New("dry run")
+)
+
+// WithDryRun initialises a Migrator which checks each run which would
+// apply or roll back migrations as usual, including any preflight and
+// connection checks, and logs the pending migrations, but stops before
+// any locks are taken or migrations are run. An error wrapping ErrDryRun
+// is returned instead. Runs which have nothing to do return nil as usual.
+//
+// Use Pending and PlanRollback to find out what a run would do.
+//
+// Intended for use with NewMigrator.
+func WithDryRun() MigratorOpt {
+	return func(x *Migrator) error {
+		x.dryRun = true
+		return nil
+	}
+}
+
+// stopDryRun logs the pending migrations and returns an error wrapping
+// ErrDryRun, if the Migrator is in dry-run mode.
+func (x *Migrator) stopDryRun() error {
+	if !x.dryRun {
+		return nil
+	}
+
+	pending, err := x.Pending()
+	if err != nil {
+		return err
+	}
+	x.logAtLevel(LogLevelInfo, "Dry run: %d migrations pending - %+v\n", len(pending), pending)
+	return errors.Wrap(ErrDryRun, "no migrations were run")
+}
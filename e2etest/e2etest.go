@@ -0,0 +1,349 @@
+// Package e2etest runs a set of migrations end to end against a real
+// Postgres DB, as a conformance suite which downstream repos can run
+// against their own migrations:
+//
+//	suite := e2etest.Suite{
+//		DBFactory: dbFactory,
+//		Opts: []migrations.MigratorOpt{
+//			migrations.WithMigrations(registry),
+//			migrations.WithInitialName("20240101000000_initial"),
+//		},
+//	}
+//	err := suite.Run(ctx)
+//
+// The suite initialises the DB, applies every migration, rolls them back
+// and applies them again, and finally resets the DB, checking
+// that each step leaves the schema as expected. This catches down
+// functions which do not undo their up function, and up functions which
+// cannot be run again after a rollback.
+//
+// The DB should be empty and used only by the suite, e.g. one started in a
+// container by the pgcontainer module:
+//
+//	import "github.com/chainql/migrations/e2etest/pgcontainer"
+//
+// In a test, Suite.Test runs each step as a subtest.
+package e2etest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chainql/migrations"
+	"github.com/chainql/migrations/migratest"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrCheckFailed indicates that a set of migrations did not behave as
+	// expected in a step of the suite.
+	ErrCheckFailed = errors.New("conformance check failed")
+)
+
+// Suite is the end-to-end conformance suite for a set of migrations.
+type Suite struct {
+	// DBFactory connects to the DB which the suite runs against. The DB
+	// should be empty, and is changed freely.
+	DBFactory migrations.DBFactory
+
+	// Opts are the options of the Migrator under test, which should
+	// include the migrations and initial migrations. Only errors are
+	// logged unless the options set another log level.
+	Opts []migrations.MigratorOpt
+
+	// Logf, if not nil, is called to report the progress of the suite.
+	Logf func(format string, args ...interface{})
+}
+
+// Step is a single step of the suite. Steps depend on the steps before
+// them, so they are run in order, and a failed step stops the suite.
+type Step struct {
+	Name string
+	Run  func(ctx context.Context, state *State) error
+}
+
+// State is shared by the steps of a single run of the suite.
+type State struct {
+	// Migrator is the Migrator under test.
+	Migrator *migrations.Migrator
+
+	// Initialised and Migrated are the schemas of the DB once the initial
+	// migrations have been applied, and once every migration has been
+	// applied.
+	Initialised *migrations.Schema
+	Migrated    *migrations.Schema
+
+	// InitialBatch is the batch applied by Init.
+	InitialBatch int
+
+	suite *Suite
+}
+
+// Steps returns the steps of the suite, in the order they are run:
+//
+//   - init runs the initial migrations with Init.
+//   - create generates a migration file with Create, in memory.
+//   - migrate applies every pending migration with MigrateBatch, and
+//     checks that none are left pending.
+//   - rollback reverts the batches applied since init with Rollback, and
+//     checks that the schema is back to what it was after init.
+//   - remigrate applies the migrations again, and checks that the schema
+//     is the same as after migrate.
+//   - reset reverts every migration with Reset, and checks that none are
+//     left applied.
+func (x *Suite) Steps() []Step {
+	return []Step{
+		{Name: "init", Run: stepInit},
+		{Name: "create", Run: stepCreate},
+		{Name: "migrate", Run: stepMigrate},
+		{Name: "rollback", Run: stepRollback},
+		{Name: "remigrate", Run: stepRemigrate},
+		{Name: "reset", Run: stepReset},
+	}
+}
+
+// Run runs every step of the suite in order, stopping at the first which
+// fails. Errors from failed checks wrap ErrCheckFailed, and every error
+// names the step which failed.
+func (x *Suite) Run(ctx context.Context) error {
+	state, err := x.newState(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range x.Steps() {
+		x.logf("Step %s", step.Name)
+		start := time.Now()
+		err = step.Run(ctx, state)
+		if err != nil {
+			return errors.Wrapf(err, "step %s", step.Name)
+		}
+		x.logf("Step %s passed in %s", step.Name, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// Test runs every step of the suite as a subtest of t, skipping the rest
+// once a step fails. Progress is logged to t unless Logf is set.
+func (x *Suite) Test(t *testing.T) {
+	t.Helper()
+	suite := *x
+	if suite.Logf == nil {
+		suite.Logf = t.Logf
+	}
+
+	state, err := suite.newState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range suite.Steps() {
+		passed := t.Run(step.Name, func(t *testing.T) {
+			err := step.Run(context.Background(), state)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+		if !passed {
+			return
+		}
+	}
+}
+
+// newState creates the Migrator under test.
+func (x *Suite) newState(ctx context.Context) (*State, error) {
+	migrator, err := x.newMigrator(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &State{Migrator: migrator, suite: x}, nil
+}
+
+// newMigrator creates a Migrator with the options of the suite, followed
+// by opts.
+func (x *Suite) newMigrator(ctx context.Context, opts ...migrations.MigratorOpt) (*migrations.Migrator, error) {
+	allOpts := []migrations.MigratorOpt{
+		migrations.WithContext(ctx),
+		migrations.WithLogLevel(migrations.LogLevelError),
+	}
+	allOpts = append(allOpts, x.Opts...)
+	allOpts = append(allOpts, opts...)
+	return migrations.NewMigrator(x.DBFactory, allOpts...)
+}
+
+// logf reports progress, if Logf is set.
+func (x *Suite) logf(format string, args ...interface{}) {
+	if x.Logf != nil {
+		x.Logf(format, args...)
+	}
+}
+
+// stepInit runs the initial migrations and records the schema.
+func stepInit(ctx context.Context, state *State) error {
+	err := state.Migrator.Init()
+	if err != nil {
+		return err
+	}
+
+	state.InitialBatch, err = lastBatch(state.Migrator)
+	if err != nil {
+		return err
+	}
+	if state.InitialBatch == 0 {
+		return errors.Wrap(ErrCheckFailed, "no migrations applied by init")
+	}
+
+	state.Initialised, err = state.Migrator.Introspect()
+	return err
+}
+
+// stepCreate generates a migration file in memory, with the options of
+// the suite, so that templates and naming conventions are exercised.
+func stepCreate(ctx context.Context, state *State) error {
+	fsys := migratest.NewFS()
+	migrator, err := state.suite.newMigrator(ctx, migrations.WithCreateFS(fsys))
+	if err != nil {
+		return err
+	}
+
+	err = migrator.Create("e2e check")
+	if err != nil {
+		return err
+	}
+	if len(fsys.Files()) == 0 {
+		return errors.Wrap(ErrCheckFailed, "no files created")
+	}
+	return nil
+}
+
+// stepMigrate applies every pending migration and records the schema.
+func stepMigrate(ctx context.Context, state *State) error {
+	err := migrateAll(state.Migrator)
+	if err != nil {
+		return err
+	}
+
+	state.Migrated, err = state.Migrator.Introspect()
+	return err
+}
+
+// stepRollback reverts every batch applied since init, and checks that
+// the schema is as it was after init.
+func stepRollback(ctx context.Context, state *State) error {
+	for {
+		batch, err := lastBatch(state.Migrator)
+		if err != nil {
+			return err
+		}
+		if batch <= state.InitialBatch {
+			break
+		}
+
+		err = state.Migrator.Rollback()
+		if err != nil {
+			return err
+		}
+
+		after, err := lastBatch(state.Migrator)
+		if err != nil {
+			return err
+		}
+		if after == batch {
+			return errors.Wrapf(ErrCheckFailed, "batch %d not rolled back", batch)
+		}
+	}
+
+	schema, err := state.Migrator.Introspect()
+	if err != nil {
+		return err
+	}
+	return compareSchemas("after rollback", schema, state.Initialised)
+}
+
+// stepRemigrate applies the migrations again, and checks that the schema
+// is as it was after stepMigrate.
+func stepRemigrate(ctx context.Context, state *State) error {
+	err := migrateAll(state.Migrator)
+	if err != nil {
+		return err
+	}
+
+	schema, err := state.Migrator.Introspect()
+	if err != nil {
+		return err
+	}
+	return compareSchemas("after remigrate", schema, state.Migrated)
+}
+
+// stepReset reverts every migration, and checks that none are applied.
+func stepReset(ctx context.Context, state *State) error {
+	err := state.Migrator.Reset()
+	if err != nil {
+		return err
+	}
+
+	applied, err := state.Migrator.Applied()
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		return errors.Wrapf(ErrCheckFailed, "%d migrations still applied after reset", len(applied))
+	}
+	return nil
+}
+
+// migrateAll applies every pending migration, including those which
+// require approval, and checks that none are left pending.
+func migrateAll(migrator *migrations.Migrator) error {
+	err := migrator.MigrateBatch()
+	if err != nil {
+		return err
+	}
+
+	awaiting, err := migrator.AwaitingApproval()
+	if err != nil {
+		return err
+	}
+	for _, name := range awaiting {
+		err = migrator.ApproveAndRun(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	upToDate, pending, err := migrator.IsUpToDate()
+	if err != nil {
+		return err
+	}
+	if !upToDate {
+		return errors.Wrapf(ErrCheckFailed, "%d migrations still pending", pending)
+	}
+	return nil
+}
+
+// lastBatch returns the batch of the most recently applied migration, or
+// 0 if none have been applied.
+func lastBatch(migrator *migrations.Migrator) (int, error) {
+	applied, err := migrator.Applied()
+	if err != nil || len(applied) == 0 {
+		return 0, err
+	}
+	return applied[len(applied)-1].Batch, nil
+}
+
+// compareSchemas returns an error wrapping ErrCheckFailed, listing the
+// differences, if the actual schema differs from the expected one.
+func compareSchemas(when string, actual *migrations.Schema, expected *migrations.Schema) error {
+	changes := migrations.DiffSchemas(actual, expected)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, 0, len(changes))
+	for _, change := range changes {
+		descriptions = append(descriptions, change.Description)
+	}
+	return errors.Wrapf(ErrCheckFailed, "schema %s differs: %s", when, strings.Join(descriptions, "; "))
+}
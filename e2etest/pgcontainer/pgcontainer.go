@@ -0,0 +1,128 @@
+// Package pgcontainer starts Postgres in a Docker container with
+// testcontainers, for running migrations end to end, e.g. with the e2etest
+// conformance suite:
+//
+//	container, err := pgcontainer.Start(ctx)
+//	...
+//	defer container.Terminate(context.Background())
+//
+//	suite := e2etest.Suite{DBFactory: container.DBFactory(), Opts: opts}
+//	err = suite.Run(ctx)
+//
+// It is a module of its own, so that the migrations module does not depend
+// on testcontainers.
+package pgcontainer
+
+import (
+	"context"
+
+	"github.com/chainql/migrations"
+	"github.com/pkg/errors"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+// DefaultImage is the Docker image started unless set with WithImage.
+const DefaultImage = "postgres:16-alpine"
+
+// The database, user and password of the started server.
+const (
+	Database = "migrations"
+	User     = "migrations"
+	Password = "migrations"
+)
+
+// Opt configures the container started by Start.
+type Opt func(x *options)
+
+// options are the settings of Start.
+type options struct {
+	image         string
+	customizers   []testcontainers.ContainerCustomizer
+	dbFactoryOpts []migrations.DBOpt
+}
+
+// WithImage starts the given Docker image instead of DefaultImage, e.g. to
+// test against the major version of Postgres used in production.
+//
+// Intended for use with Start.
+func WithImage(image string) Opt {
+	return func(x *options) {
+		x.image = image
+	}
+}
+
+// WithCustomizers applies testcontainers options to the container, e.g.
+// postgres.WithInitScripts to create extensions or roles first.
+//
+// Intended for use with Start.
+func WithCustomizers(customizers ...testcontainers.ContainerCustomizer) Opt {
+	return func(x *options) {
+		x.customizers = append(x.customizers, customizers...)
+	}
+}
+
+// WithDBOpts applies opts to the DBFactory returned by Container.DBFactory.
+//
+// Intended for use with Start.
+func WithDBOpts(opts ...migrations.DBOpt) Opt {
+	return func(x *options) {
+		x.dbFactoryOpts = append(x.dbFactoryOpts, opts...)
+	}
+}
+
+// Container is a running Postgres server.
+type Container struct {
+	container *postgres.PostgresContainer
+	dbFactory migrations.DBFactory
+
+	// DSN is the connection URL of the server.
+	DSN string
+}
+
+// Start starts a Postgres server in a Docker container, and waits until it
+// accepts connections. The container should be stopped with Terminate.
+func Start(ctx context.Context, opts ...Opt) (*Container, error) {
+	settings := options{image: DefaultImage}
+	for _, opt := range opts {
+		opt(&settings)
+	}
+
+	customizers := []testcontainers.ContainerCustomizer{
+		postgres.WithDatabase(Database),
+		postgres.WithUsername(User),
+		postgres.WithPassword(Password),
+		postgres.BasicWaitStrategies(),
+	}
+	customizers = append(customizers, settings.customizers...)
+	container, err := postgres.Run(ctx, settings.image, customizers...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not start postgres")
+	}
+
+	dsn, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		_ = container.Terminate(context.Background())
+		return nil, err
+	}
+
+	dbFactory, err := migrations.NewDBFactoryFromDSN(dsn, settings.dbFactoryOpts...)
+	if err != nil {
+		_ = container.Terminate(context.Background())
+		return nil, err
+	}
+
+	return &Container{container: container, dbFactory: dbFactory, DSN: dsn}, nil
+}
+
+// DBFactory returns a DBFactory which connects to the server. Every call
+// of the factory returns the same DB, which is closed by Terminate.
+func (x *Container) DBFactory() migrations.DBFactory {
+	return x.dbFactory
+}
+
+// Terminate closes the DB and stops and removes the container.
+func (x *Container) Terminate(ctx context.Context) error {
+	_ = x.dbFactory().Close()
+	return x.container.Terminate(ctx)
+}
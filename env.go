@@ -0,0 +1,148 @@
+package migrations
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMissingEnv indicates that a required environment variable is not
+	// set.
+	ErrMissingEnv = errors.New("missing environment variable")
+
+	// ErrInvalidEnv indicates that an environment variable has a value
+	// which could not be parsed.
+	ErrInvalidEnv = errors.New("invalid environment variable")
+)
+
+// Names of the environment variables read by NewMigratorFromEnv, without
+// their prefix.
+const (
+	// EnvDSN holds the connection string of the DB. Required.
+	EnvDSN = "DSN"
+
+	// EnvStateDSN holds the connection string of a separate DB in which
+	// the migration table is kept. See WithStateDB.
+	EnvStateDSN = "STATE_DSN"
+
+	// EnvTableName holds the name of the migration table.
+	// See WithMigrationTableName.
+	EnvTableName = "TABLE"
+
+	// EnvMigrationDir holds the directory in which migrations are
+	// created. See WithMigrationDir.
+	EnvMigrationDir = "MIGRATION_DIR"
+
+	// EnvFlavour holds the name of the Postgres flavour, "postgres" or
+	// "cockroachdb". See WithPostgresFlavour.
+	EnvFlavour = "FLAVOUR"
+
+	// EnvLock holds the locking mode, "explicit", "advisory" or "none".
+	// See WithExplicitLock, AdvisoryLocker and WithoutExplicitLock.
+	EnvLock = "LOCK"
+
+	// EnvLogLevel holds the log level, e.g. "debug". See WithLogLevel.
+	EnvLogLevel = "LOG_LEVEL"
+
+	// EnvRequireDown holds whether migrations must have a down function,
+	// e.g. "true". See WithRequireDown.
+	EnvRequireDown = "REQUIRE_DOWN"
+
+	// EnvProfile holds the name of a predefined profile, "dev", "staging"
+	// or "prod", which is applied before the other variables, so that they
+	// take precedence over it. See WithProfile.
+	EnvProfile = "PROFILE"
+)
+
+// NewMigratorFromEnv creates a Migrator configured from environment
+// variables, so that it can be configured without code changes, e.g. in
+// a container. The variables are named by joining prefix and the Env*
+// names with an underscore, e.g. "MIGRATIONS_DSN" for the prefix
+// "MIGRATIONS". Only the DSN is required.
+//
+// opts are applied first, so settings from the environment take
+// precedence over them.
+func NewMigratorFromEnv(prefix string, opts ...MigratorOpt) (*Migrator, error) {
+	envName := func(name string) string {
+		if prefix != "" && !strings.HasSuffix(prefix, "_") {
+			return prefix + "_" + name
+		}
+		return prefix + name
+	}
+	lookup := func(name string) (string, bool) {
+		value := strings.TrimSpace(os.Getenv(envName(name)))
+		return value, value != ""
+	}
+	invalid := func(name string, value string, err error) error {
+		return errors.Wrapf(ErrInvalidEnv, "%s=%q: %v", envName(name), value, err)
+	}
+
+	dsn, exists := lookup(EnvDSN)
+	if !exists {
+		return nil, errors.Wrap(ErrMissingEnv, envName(EnvDSN))
+	}
+	dbFactory, err := NewDBFactoryFromDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	envOpts := append([]MigratorOpt(nil), opts...)
+	if value, exists := lookup(EnvProfile); exists {
+		profile, err := ParseProfile(value)
+		if err != nil {
+			return nil, invalid(EnvProfile, value, err)
+		}
+		envOpts = append(envOpts, WithProfile(profile))
+	}
+	if stateDSN, exists := lookup(EnvStateDSN); exists {
+		stateDBFactory, err := NewDBFactoryFromDSN(stateDSN)
+		if err != nil {
+			return nil, err
+		}
+		envOpts = append(envOpts, WithStateDB(stateDBFactory))
+	}
+	if tableName, exists := lookup(EnvTableName); exists {
+		envOpts = append(envOpts, WithMigrationTableName(tableName))
+	}
+	if migrationDir, exists := lookup(EnvMigrationDir); exists {
+		envOpts = append(envOpts, WithMigrationDir(migrationDir))
+	}
+	if value, exists := lookup(EnvFlavour); exists {
+		flavour, err := ParseFlavour(value)
+		if err != nil {
+			return nil, invalid(EnvFlavour, value, err)
+		}
+		envOpts = append(envOpts, WithPostgresFlavour(flavour))
+	}
+	if value, exists := lookup(EnvLock); exists {
+		switch strings.ToLower(value) {
+		case "explicit":
+			envOpts = append(envOpts, WithExplicitLock())
+		case "advisory":
+			envOpts = append(envOpts, WithLocker(NewAdvisoryLocker()))
+		case "none":
+			envOpts = append(envOpts, WithoutExplicitLock())
+		default:
+			return nil, invalid(EnvLock, value, errors.New(`expected "explicit", "advisory" or "none"`))
+		}
+	}
+	if value, exists := lookup(EnvLogLevel); exists {
+		level, err := ParseLogLevel(value)
+		if err != nil {
+			return nil, invalid(EnvLogLevel, value, err)
+		}
+		envOpts = append(envOpts, WithLogLevel(level))
+	}
+	if value, exists := lookup(EnvRequireDown); exists {
+		require, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalid(EnvRequireDown, value, err)
+		}
+		envOpts = append(envOpts, WithRequireDown(require))
+	}
+
+	return NewMigrator(dbFactory, envOpts...)
+}
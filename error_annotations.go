@@ -0,0 +1,101 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// DefaultErrorAnnotations are the hints given by WithErrorAnnotations for
+// common failures, by SQLSTATE.
+var DefaultErrorAnnotations = map[string]string{
+	// undefined_table
+	"42P01": "a table used by the migration does not exist; check that the migrations creating it have been applied, and that its name, schema and the search_path are right",
+	// duplicate_column
+	"42701": "the column already exists, e.g. because it was added by hand or an earlier rollback did not drop it; use ADD COLUMN IF NOT EXISTS, or drop the column first",
+	// duplicate_table
+	"42P07": "the table already exists, e.g. because it was created by hand or an earlier rollback did not drop it; use CREATE TABLE IF NOT EXISTS, or drop the table first",
+	// lock_not_available
+	"55P03": "a lock could not be acquired before lock_timeout expired; look for long-running transactions holding locks in pg_stat_activity, or retry with a longer timeout",
+	// query_canceled
+	"57014": "the statement was cancelled, usually because statement_timeout expired or the run was interrupted",
+}
+
+// WithErrorAnnotations initialises a Migrator which adds a hint to errors
+// caused by DB errors with well-known SQLSTATEs, both in the returned
+// error and in logs, telling operators how to resolve them. The hints of
+// DefaultErrorAnnotations are used, overridden by annotations, which maps
+// SQLSTATEs to hints. A hint of "" removes the default for its SQLSTATE.
+//
+// Hints are added to the MigrationError of a failed migration, and to
+// errors taking locks. They can be retrieved with ErrorHint.
+//
+// Intended for use with NewMigrator.
+func WithErrorAnnotations(annotations map[string]string) MigratorOpt {
+	return func(x *Migrator) error {
+		x.errorAnnotations = make(map[string]string, len(DefaultErrorAnnotations)+len(annotations))
+		for code, hint := range DefaultErrorAnnotations {
+			x.errorAnnotations[code] = hint
+		}
+		for code, hint := range annotations {
+			if hint == "" {
+				delete(x.errorAnnotations, code)
+				continue
+			}
+			x.errorAnnotations[code] = hint
+		}
+		return nil
+	}
+}
+
+// hintedError adds a hint to an error which is not a MigrationError.
+type hintedError struct {
+	err  error
+	hint string
+}
+
+// Error returns the message of the error followed by the hint.
+func (x *hintedError) Error() string {
+	return x.err.Error() + " (hint: " + x.hint + ")"
+}
+
+// Unwrap returns the error the hint was added to.
+func (x *hintedError) Unwrap() error {
+	return x.err
+}
+
+// ErrorHint returns the hint added to err by a Migrator created with
+// WithErrorAnnotations, or "" if there is none.
+func ErrorHint(err error) string {
+	var hinted *hintedError
+	if errors.As(err, &hinted) {
+		return hinted.hint
+	}
+	var migrationErr *MigrationError
+	if errors.As(err, &migrationErr) {
+		return migrationErr.Hint
+	}
+	return ""
+}
+
+// errorHint returns the hint for the SQLSTATE of the DB error in err, if
+// error annotations are enabled.
+func (x *Migrator) errorHint(err error) string {
+	if len(x.errorAnnotations) == 0 {
+		return ""
+	}
+	var pgErr pg.Error
+	if !errors.As(err, &pgErr) {
+		return ""
+	}
+	return x.errorAnnotations[pgErr.Field('C')]
+}
+
+// annotateError adds a hint to err, if there is one for its SQLSTATE and it
+// has none yet.
+func (x *Migrator) annotateError(err error) error {
+	hint := x.errorHint(err)
+	if hint == "" || ErrorHint(err) != "" {
+		return err
+	}
+	return &hintedError{err: err, hint: hint}
+}
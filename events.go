@@ -0,0 +1,164 @@
+package migrations
+
+import (
+	"time"
+)
+
+// Direction indicates whether a migration is being applied or rolled back.
+type Direction byte
+
+const (
+	// Up indicates that a migration is being applied.
+	Up Direction = iota
+
+	// Down indicates that a migration is being rolled back.
+	Down
+)
+
+// String returns "up" or "down".
+func (x Direction) String() string {
+	if x == Down {
+		return "down"
+	}
+	return "up"
+}
+
+// EventType indicates what happened to cause an Event.
+type EventType byte
+
+const (
+	// BatchStarted indicates that a batch of migrations is about to be
+	// run. Count holds the number of migrations in the batch.
+	BatchStarted EventType = iota
+
+	// MigrationStarted indicates that a single migration is about to
+	// be run.
+	MigrationStarted
+
+	// MigrationCompleted indicates that a single migration has run
+	// successfully. When a batch runs in a single transaction, the
+	// migration is not committed until the batch has completed.
+	MigrationCompleted
+
+	// BatchCompleted indicates that a batch of migrations has been
+	// committed.
+	BatchCompleted
+
+	// ErrorOccurred indicates that a run failed. Err holds the error
+	// which will be returned to the caller.
+	ErrorOccurred
+
+	// MigrationProgress indicates that a running migration reported its
+	// progress with Context.Progress. Current, Total and Message hold
+	// the reported progress.
+	MigrationProgress
+)
+
+// String returns a human-readable name for the event type.
+func (x EventType) String() string {
+	switch x {
+	case BatchStarted:
+		return "batch started"
+	case MigrationStarted:
+		return "migration started"
+	case MigrationCompleted:
+		return "migration completed"
+	case BatchCompleted:
+		return "batch completed"
+	case ErrorOccurred:
+		return "error"
+	case MigrationProgress:
+		return "migration progress"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes the progress of a Migrator while it runs migrations.
+// Fields which are not relevant to the event type are left empty.
+type Event struct {
+	Type      EventType
+	Direction Direction
+	Time      time.Time
+
+	// Migration is the name of the migration the event relates to.
+	Migration string
+
+	// Batch is the batch number the event relates to.
+	Batch int
+
+	// Count is the number of migrations in the batch.
+	Count int
+
+	// Remaining is the number of pending migrations which were left out
+	// of the batch, e.g. because of the maximum batch size.
+	Remaining int
+
+	// Duration is the time taken to run a migration.
+	Duration time.Duration
+
+	// RowsAffected is the number of rows a migration affected, if counted.
+	// See WithRowCounting.
+	RowsAffected int64
+
+	// Plans are the plans of the statements a migration ran, if captured.
+	// See WithExplain.
+	Plans []StatementPlan
+
+	// Skipped indicates that a migration was recorded as applied without
+	// running it, because its ShouldRun predicate returned false.
+	Skipped bool
+
+	// Memo is the note given to the run, if any. See WithMemo.
+	Memo string
+
+	// Err is the error which caused the run to fail.
+	Err error
+
+	// Current and Total are the progress reported by a migration. Total
+	// is zero or less if the total amount of work is unknown.
+	Current int64
+	Total   int64
+
+	// Message describes the progress reported by a migration.
+	Message string
+}
+
+// EventHandler receives events from a Migrator. Handlers are called
+// synchronously from the goroutine running the migrations, so they
+// should return quickly. Handlers may be called concurrently by
+// MigrateParallel.
+type EventHandler func(Event)
+
+// WithEventHandler initialises a Migrator which will call handler as
+// migrations are run. May be used multiple times to add several handlers.
+//
+// Intended for use with NewMigrator.
+func WithEventHandler(handler EventHandler) MigratorOpt {
+	return func(x *Migrator) error {
+		x.eventHandlers = append(x.eventHandlers, handler)
+		return nil
+	}
+}
+
+// ChannelEventHandler returns an EventHandler which sends every event to
+// ch. Sending blocks, so ch should be buffered or drained promptly.
+func ChannelEventHandler(ch chan<- Event) EventHandler {
+	return func(event Event) {
+		ch <- event
+	}
+}
+
+// emit sends an event to every registered handler.
+func (x *Migrator) emit(event Event) {
+	if len(x.eventHandlers) == 0 {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, handler := range x.eventHandlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,59 @@
+// Command e2e runs the migrations of the example project end to end with
+// the e2etest conformance suite, against Postgres started in a Docker
+// container:
+//
+//	go run ./cmd/e2e
+//
+// It exits with status 1 if a step of the suite fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/chainql/migrations"
+	"github.com/chainql/migrations/e2etest"
+	"github.com/chainql/migrations/e2etest/pgcontainer"
+	"github.com/chainql/migrations/examples/schema"
+)
+
+func main() {
+	image := flag.String("image", pgcontainer.DefaultImage, "Docker image of Postgres to run against.")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, *image)
+	stop()
+	if err != nil {
+		log.Printf("End-to-end run failed: %v", err)
+		os.Exit(1)
+	}
+	log.Print("End-to-end run passed")
+}
+
+// run starts Postgres and runs the suite against it.
+func run(ctx context.Context, image string) error {
+	container, err := pgcontainer.Start(ctx, pgcontainer.WithImage(image))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := container.Terminate(context.Background())
+		if err != nil {
+			log.Printf("Could not stop container: %v", err)
+		}
+	}()
+
+	suite := e2etest.Suite{
+		DBFactory: container.DBFactory(),
+		Opts: []migrations.MigratorOpt{
+			migrations.WithMigrations(schema.Registry()),
+			migrations.WithInitialName(schema.InitialMigration),
+		},
+		Logf: log.Printf,
+	}
+	return suite.Run(ctx)
+}
@@ -0,0 +1,28 @@
+package schema
+
+import (
+	"github.com/chainql/migrations"
+	"github.com/go-pg/pg/v10"
+)
+
+func init() {
+	err := registry.Register(
+		"20240101000000_initial",
+		up20240101000000Initial,
+		down20240101000000Initial,
+		migrations.Description("Create the app schema"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func up20240101000000Initial(tx *pg.Tx, cont *migrations.Context) error {
+	_, err := tx.Exec(`CREATE SCHEMA IF NOT EXISTS app`)
+	return err
+}
+
+func down20240101000000Initial(tx *pg.Tx, cont *migrations.Context) error {
+	_, err := tx.Exec(`DROP SCHEMA IF EXISTS app`)
+	return err
+}
@@ -0,0 +1,34 @@
+package schema
+
+import (
+	"github.com/chainql/migrations"
+	"github.com/go-pg/pg/v10"
+)
+
+func init() {
+	err := registry.Register(
+		"20240102000000_create_users",
+		up20240102000000CreateUsers,
+		down20240102000000CreateUsers,
+		migrations.Description("Create the users table"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func up20240102000000CreateUsers(tx *pg.Tx, cont *migrations.Context) error {
+	_, err := tx.Exec(`
+		CREATE TABLE app.users (
+			id bigserial PRIMARY KEY,
+			name text NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		)
+	`)
+	return err
+}
+
+func down20240102000000CreateUsers(tx *pg.Tx, cont *migrations.Context) error {
+	_, err := tx.Exec(`DROP TABLE app.users`)
+	return err
+}
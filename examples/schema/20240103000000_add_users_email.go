@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"github.com/chainql/migrations"
+	"github.com/go-pg/pg/v10"
+)
+
+func init() {
+	err := registry.Register(
+		"20240103000000_add_users_email",
+		up20240103000000AddUsersEmail,
+		down20240103000000AddUsersEmail,
+		migrations.Description("Add a unique email to users"),
+		migrations.DependsOn("20240102000000_create_users"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func up20240103000000AddUsersEmail(tx *pg.Tx, cont *migrations.Context) error {
+	_, err := tx.Exec(`
+		ALTER TABLE app.users ADD COLUMN email text;
+		CREATE UNIQUE INDEX users_email_key ON app.users (email);
+	`)
+	return err
+}
+
+func down20240103000000AddUsersEmail(tx *pg.Tx, cont *migrations.Context) error {
+	_, err := tx.Exec(`
+		DROP INDEX app.users_email_key;
+		ALTER TABLE app.users DROP COLUMN email;
+	`)
+	return err
+}
@@ -0,0 +1,19 @@
+// Package schema holds the migrations of the example project. Each
+// migration registers itself with the registry in an init function, as
+// the files generated by Create do.
+package schema
+
+import (
+	"github.com/chainql/migrations"
+)
+
+// InitialMigration is run by the init command, before any other migration.
+const InitialMigration = "20240101000000_initial"
+
+// registry holds the migrations of this package.
+var registry migrations.Registry
+
+// Registry returns the migrations of this package.
+func Registry() *migrations.Registry {
+	return &registry
+}
@@ -0,0 +1,243 @@
+package migrations
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// explainedStatements are the statements whose plans are captured by
+// WithExplain. Other statements, such as DDL, cannot be explained.
+var explainedStatements = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "MERGE", "WITH"}
+
+// StatementPlan is the plan of a statement run by a migration, as captured
+// by WithExplain.
+type StatementPlan struct {
+	// Query is the statement which was explained. Secrets resolved by
+	// the Migrator are redacted, as they are in Plan and Err.
+	Query string
+
+	// Plan is the output of EXPLAIN for the statement.
+	Plan string
+
+	// Err is the error returned by EXPLAIN, if it failed, e.g. because the
+	// statement uses a table created earlier in the same query.
+	Err error
+}
+
+// WithExplain initialises a Migrator which captures the plan of each
+// SELECT, INSERT, UPDATE, DELETE, MERGE and WITH statement run by a
+// migration with one of the given tags, or by every migration if no tags
+// are given. See Tags. This helps to find out why a migration is slower in
+// one environment than in another.
+//
+// Plans are captured with EXPLAIN, using a query hook added as by
+// WithQueryHook, just before each statement runs in the transaction of the
+// migration. EXPLAIN ANALYZE is not used, since it would run the statement
+// twice. The EXPLAIN runs in a savepoint, so a failure does not abort the
+// migration. Plans are logged at LogLevelDebug and reported in the
+// MigrationCompleted event. A query holding several statements, as SQL
+// migrations often do, is split, and each statement is explained on its
+// own. Secrets resolved with WithSecretsProvider are redacted from the
+// statements and plans which are logged and reported.
+//
+// Capturing plans adds a round trip for every statement, so this is
+// intended for debugging.
+//
+// Intended for use with NewMigrator.
+func WithExplain(tags ...string) MigratorOpt {
+	return func(x *Migrator) error {
+		if x.explainer == nil {
+			x.explainer = &explainer{
+				plans:  make(map[*pg.Tx][]StatementPlan),
+				redact: x.redactSecrets,
+			}
+			x.queryHooks = append(x.queryHooks, x.explainer)
+		}
+		x.explainer.tags = append(x.explainer.tags, tags...)
+		return nil
+	}
+}
+
+// explainer is a query hook which captures the plans of the statements
+// run in the transactions of running migrations.
+type explainer struct {
+	tags []string
+
+	// redact removes resolved secrets from captured statements and
+	// plans.
+	redact func(string) string
+
+	mtx   sync.Mutex
+	plans map[*pg.Tx][]StatementPlan
+}
+
+// Interface Compliance
+var _ pg.QueryHook = (*explainer)(nil)
+
+// BeforeQuery explains the statements of a query which is about to run,
+// if it was issued in a tracked transaction, which can be explained.
+func (x *explainer) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	tx, ok := event.DB.(*pg.Tx)
+	if !ok {
+		return ctx, nil
+	}
+
+	x.mtx.Lock()
+	_, tracked := x.plans[tx]
+	x.mtx.Unlock()
+	if !tracked {
+		return ctx, nil
+	}
+
+	query, err := event.FormattedQuery()
+	if err != nil {
+		return ctx, nil
+	}
+
+	for _, statement := range splitSQLStatements(string(query)) {
+		if !isExplainedStatement(statement) {
+			continue
+		}
+
+		plan := explainStatement(ctx, tx, statement)
+		plan.Query = x.redact(plan.Query)
+		plan.Plan = x.redact(plan.Plan)
+		if plan.Err != nil {
+			if message := x.redact(plan.Err.Error()); message != plan.Err.Error() {
+				plan.Err = errors.New(message)
+			}
+		}
+		x.mtx.Lock()
+		x.plans[tx] = append(x.plans[tx], plan)
+		x.mtx.Unlock()
+	}
+	return ctx, nil
+}
+
+// AfterQuery does nothing.
+func (x *explainer) AfterQuery(context.Context, *pg.QueryEvent) error {
+	return nil
+}
+
+// explains reports whether the statements of a migration should be
+// explained.
+func (x *explainer) explains(m migration) bool {
+	if len(x.tags) == 0 {
+		return true
+	}
+	for _, tag := range x.tags {
+		if m.hasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// start begins capturing the plans of the statements run in tx.
+func (x *explainer) start(tx *pg.Tx) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.plans[tx] = []StatementPlan{}
+}
+
+// stop ends capturing the plans of the statements run in tx, returning
+// them in the order the statements ran.
+func (x *explainer) stop(tx *pg.Tx) []StatementPlan {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	plans := x.plans[tx]
+	delete(x.plans, tx)
+	return plans
+}
+
+// logPlans logs the plans captured for a migration at LogLevelDebug.
+func (x *Migrator) logPlans(migrationName string, plans []StatementPlan) {
+	for _, plan := range plans {
+		if plan.Err != nil {
+			x.logAtLevel(LogLevelDebug, "Could not explain statement of %s: %v\n%s\n", migrationName, plan.Err, plan.Query)
+			continue
+		}
+		x.logAtLevel(LogLevelDebug, "Plan of statement of %s:\n%s\n%s\n", migrationName, plan.Query, plan.Plan)
+	}
+}
+
+// explainStatement runs EXPLAIN for a statement in a savepoint of tx. The
+// EXPLAIN itself is not explained, since it does not match
+// explainedStatements.
+func explainStatement(ctx context.Context, tx *pg.Tx, query string) StatementPlan {
+	plan := StatementPlan{Query: query}
+	_, err := tx.ExecContext(ctx, "SAVEPOINT migrations_explain")
+	if err != nil {
+		plan.Err = err
+		return plan
+	}
+
+	var lines []string
+	// The query has already been formatted, so it is passed as a param
+	// to be sent verbatim rather than formatted again.
+	_, err = tx.QueryContext(ctx, &lines, "EXPLAIN ?", pg.Safe(query))
+	if err != nil {
+		plan.Err = err
+		_, _ = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT migrations_explain")
+		return plan
+	}
+
+	plan.Plan = strings.Join(lines, "\n")
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT migrations_explain")
+	if err != nil {
+		plan.Err = err
+	}
+	return plan
+}
+
+// splitSQLStatements splits a query into its statements at semicolons
+// outside of quotes, dollar-quoted strings and comments. Empty statements
+// are dropped.
+func splitSQLStatements(query string) []string {
+	var statements []string
+	start := 0
+	for i := 0; i < len(query); {
+		end := skipSQLLiteral(query, i)
+		if end > i {
+			i = end
+			continue
+		}
+		if query[i] == ';' {
+			if statement := strings.TrimSpace(query[start:i]); statement != "" {
+				statements = append(statements, statement)
+			}
+			start = i + 1
+		}
+		i++
+	}
+	if statement := strings.TrimSpace(query[start:]); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+// isExplainedStatement reports whether a query starts with one of the
+// explainedStatements, after any comments.
+func isExplainedStatement(query string) bool {
+	query = strings.TrimSpace(query)
+	for strings.HasPrefix(query, "--") || strings.HasPrefix(query, "/*") {
+		query = strings.TrimSpace(query[skipSQLLiteral(query, 0):])
+	}
+	for _, statement := range explainedStatements {
+		if len(query) > len(statement) &&
+			strings.EqualFold(query[:len(statement)], statement) &&
+			!isIdentifierChar(query[len(statement)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdentifierChar reports whether c may appear in an unquoted identifier.
+func isIdentifierChar(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
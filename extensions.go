@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+)
+
+// WithExtensions initialises a Migrator which ensures that the given
+// Postgres extensions, e.g. "uuid-ossp" and "pgcrypto", are installed
+// before any migrations are applied, in the same transaction. This replaces
+// bootstrap SQL which would otherwise have to be kept in the initial
+// migration. May be used multiple times to add more extensions.
+//
+// Extensions are created with CREATE EXTENSION IF NOT EXISTS, in the
+// order given, and only if they are not installed already. Nothing is done
+// when the Postgres flavour is CockroachDB, which does not support
+// extensions. See WithPostgresFlavour.
+//
+// Intended for use with NewMigrator.
+func WithExtensions(names ...string) MigratorOpt {
+	return func(x *Migrator) error {
+		x.extensions = append(x.extensions, names...)
+		return nil
+	}
+}
+
+// EnsureExtensions creates the given Postgres extensions in tx if they are
+// not installed already, unless the Postgres flavour of the Migrator is
+// CockroachDB. This is intended for migrations which need an extension
+// which is not declared for every run with WithExtensions.
+func (x *Context) EnsureExtensions(tx *pg.Tx, names ...string) error {
+	if x != nil && x.Flavour == CockroachDB {
+		return nil
+	}
+
+	var migrator *Migrator
+	if x != nil {
+		migrator = x.migrator
+	}
+	return ensureExtensions(tx, migrator, names)
+}
+
+// ensureExtensions creates the extensions declared with WithExtensions, if
+// any.
+func (x *Migrator) ensureExtensions(db Querier) error {
+	if len(x.extensions) == 0 {
+		return nil
+	}
+	if x.context.Flavour == CockroachDB {
+		x.logAtLevel(LogLevelDebug, "Skipping extensions on %s\n", x.context.Flavour)
+		return nil
+	}
+	return ensureExtensions(db, x, x.extensions)
+}
+
+// ensureExtensions creates those of the named extensions which are not
+// installed, logging to migrator if it is not nil.
+func ensureExtensions(db Querier, migrator *Migrator, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	var installed []string
+	_, err := db.Query(&installed, "SELECT extname FROM pg_extension WHERE extname IN (?)", pg.In(names))
+	if err != nil {
+		return err
+	}
+
+	_, _, missing := difference(installed, names)
+	for _, name := range missing {
+		if migrator != nil {
+			migrator.logAtLevel(LogLevelInfo, "Creating extension %s\n", name)
+		}
+		_, err = db.Exec("CREATE EXTENSION IF NOT EXISTS ?", pg.Ident(name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoFlavourVariant indicates that a flavour-specific migration was
+	// run against a flavour for which no variant was registered.
+	ErrNoFlavourVariant = errors.New("no migration variant for flavour")
+
+	// ErrInvalidFlavour indicates that a flavour name could not be parsed.
+	ErrInvalidFlavour = errors.New("invalid postgres flavour")
+)
+
+// String returns the name of the flavour.
+func (x PostgresFlavour) String() string {
+	switch x {
+	case Postgres:
+		return "postgres"
+	case CockroachDB:
+		return "cockroachdb"
+	default:
+		return fmt.Sprintf("flavour(%d)", byte(x))
+	}
+}
+
+// ParseFlavour returns the flavour with the given name, as returned by
+// PostgresFlavour.String, ignoring case.
+func ParseFlavour(name string) (PostgresFlavour, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "postgres":
+		return Postgres, nil
+	case "cockroachdb":
+		return CockroachDB, nil
+	default:
+		return 0, errors.Wrapf(ErrInvalidFlavour, "%q", name)
+	}
+}
+
+// Funcs holds the up and down functions of a migration. See Register for
+// the valid function signatures.
+type Funcs struct {
+	Up   interface{}
+	Down interface{}
+}
+
+// RegisterFlavoured adds a migration with separate implementations for
+// each Postgres flavour to the list of known migrations. When the
+// migration is run, the variant matching the Flavour of the migration
+// Context is used. Running the migration against a flavour without a
+// variant fails with ErrNoFlavourVariant.
+//
+// If a migration by the given name is already known, this will
+// return ErrMigrationAlreadyExists.
+func (x *Registry) RegisterFlavoured(name string, variants map[PostgresFlavour]Funcs, opts ...MigrationOpt) error {
+	ups := make(map[PostgresFlavour]interface{}, len(variants))
+	downs := make(map[PostgresFlavour]interface{}, len(variants))
+	for flavour, funcs := range variants {
+		err := checkAllowedMigrationFunctions(funcs.Up)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s up migration", flavour)
+		}
+
+		err = checkAllowedMigrationFunctions(funcs.Down)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s down migration", flavour)
+		}
+
+		ups[flavour] = funcs.Up
+		downs[flavour] = funcs.Down
+	}
+
+	return x.register(migration{
+		Name: name,
+		Up:   flavouredMigrationFunc(name, ups),
+		Down: flavouredMigrationFunc(name, downs),
+	}, opts)
+}
+
+// flavouredMigrationFunc returns a migration function which calls the
+// variant for the flavour of the migration context.
+func flavouredMigrationFunc(name string, variants map[PostgresFlavour]interface{}) func(*pg.Tx, *Context) error {
+	return func(tx *pg.Tx, cont *Context) error {
+		fn, exists := variants[cont.Flavour]
+		if !exists {
+			return errors.Wrapf(ErrNoFlavourVariant, "migration %s, flavour %s", name, cont.Flavour)
+		}
+		return callMigrationFunc(tx, cont, fn)
+	}
+}
+
+// RegisterFlavoured adds a migration with flavour-specific implementations
+// to the list of known migrations. See Registry.RegisterFlavoured.
+func (x *Migrator) RegisterFlavoured(name string, variants map[PostgresFlavour]Funcs, opts ...MigrationOpt) error {
+	return x.registry.RegisterFlavoured(name, variants, opts...)
+}
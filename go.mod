@@ -4,12 +4,12 @@ go 1.22.4
 
 require (
 	github.com/go-pg/pg/v10 v10.13.0
+	github.com/jinzhu/inflection v1.0.0
 	github.com/pkg/errors v0.9.1
 )
 
 require (
 	github.com/go-pg/zerochecker v0.2.0 // indirect
-	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/tmthrgd/go-hex v0.0.0-20190904060850-447a3041c3bc // indirect
 	github.com/vmihailenco/bufpool v0.1.11 // indirect
 	github.com/vmihailenco/msgpack/v5 v5.3.4 // indirect
@@ -0,0 +1,170 @@
+package migrations
+
+import (
+	"sort"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// Grant gives a role privileges on the tables and sequences created by
+// migrations. See WithGrants.
+type Grant struct {
+	Role string
+
+	// TablePrivileges are granted on new tables, views, materialized
+	// views and foreign tables, e.g. "SELECT, INSERT, UPDATE, DELETE".
+	// They are not escaped.
+	TablePrivileges string
+
+	// SequencePrivileges are granted on new sequences, e.g. "USAGE,
+	// SELECT". They are not escaped.
+	SequencePrivileges string
+}
+
+// WithGrants initialises a Migrator which grants privileges on the tables
+// and sequences each migration creates, so that application roles are not
+// left without access to them. May be used multiple times to add several
+// grants.
+//
+// New objects are found by comparing the relations in the system catalogs
+// before and after the up function of each migration runs, in its
+// transaction, so objects created by DO blocks or functions are included.
+// Grants on existing objects may be applied with Context.ApplyGrants.
+//
+// Intended for use with NewMigrator.
+func WithGrants(grants ...Grant) MigratorOpt {
+	return func(x *Migrator) error {
+		x.grants = append(x.grants, grants...)
+		return nil
+	}
+}
+
+// relation is a table, view or sequence in the system catalogs.
+type relation struct {
+	Oid    int64
+	Schema string
+	Name   string
+	Kind   string
+}
+
+// relationsQuery lists the relations which may need grants, outside of
+// the system schemas.
+const relationsQuery = `
+	SELECT c.oid::bigint AS oid, n.nspname AS schema, c.relname AS name, c.relkind::text AS kind
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S')
+		AND n.nspname NOT IN (?)
+		AND n.nspname NOT LIKE 'pg\_%'
+`
+
+// listRelations returns the relations which may need grants, by OID.
+func listRelations(db Querier) (map[int64]relation, error) {
+	var relations []relation
+	_, err := db.Query(&relations, relationsQuery, pg.In(systemSchemas))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list relations")
+	}
+
+	byOid := make(map[int64]relation, len(relations))
+	for _, rel := range relations {
+		byOid[rel.Oid] = rel
+	}
+	return byOid, nil
+}
+
+// relationsBeforeMigration returns the relations which exist before a
+// migration runs, if the Migrator has grants to apply to new ones.
+func (x *Migrator) relationsBeforeMigration(tx *pg.Tx) (map[int64]relation, error) {
+	if len(x.grants) == 0 {
+		return nil, nil
+	}
+	return listRelations(tx)
+}
+
+// grantNewRelations applies the grants of the Migrator to the relations
+// which did not exist before a migration ran.
+func (x *Migrator) grantNewRelations(tx *pg.Tx, before map[int64]relation) error {
+	if len(x.grants) == 0 {
+		return nil
+	}
+
+	after, err := listRelations(tx)
+	if err != nil {
+		return err
+	}
+
+	var created []relation
+	for oid, rel := range after {
+		if _, existed := before[oid]; !existed {
+			created = append(created, rel)
+		}
+	}
+	sort.Slice(created, func(i, j int) bool {
+		if created[i].Schema != created[j].Schema {
+			return created[i].Schema < created[j].Schema
+		}
+		return created[i].Name < created[j].Name
+	})
+	return x.applyGrants(tx, created)
+}
+
+// applyGrants applies the grants of the Migrator to relations.
+func (x *Migrator) applyGrants(db Querier, relations []relation) error {
+	for _, rel := range relations {
+		name := rel.Schema + "." + rel.Name
+		for _, grant := range x.grants {
+			objectType, privileges := "TABLE", grant.TablePrivileges
+			if rel.Kind == "S" {
+				objectType, privileges = "SEQUENCE", grant.SequencePrivileges
+			}
+			if privileges == "" {
+				continue
+			}
+
+			x.logAtLevel(LogLevelDebug, "Granting %s on %s to %s\n", privileges, name, grant.Role)
+			_, err := db.Exec(
+				"GRANT ? ON ? ? TO ?",
+				pg.Safe(privileges),
+				pg.Safe(objectType),
+				pg.Ident(name),
+				pg.Ident(grant.Role),
+			)
+			if err != nil {
+				return errors.Wrapf(err, "grant %s on %s to %s", privileges, name, grant.Role)
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyGrants applies the grants of the Migrator running the migration to
+// the named tables, views or sequences, which may be schema-qualified.
+// This is intended for objects which were not created by the migration,
+// since those are granted automatically. See WithGrants.
+func (x *Context) ApplyGrants(db Querier, objects ...string) error {
+	if x == nil || x.migrator == nil || len(x.migrator.grants) == 0 || len(objects) == 0 {
+		return nil
+	}
+
+	relations := make([]relation, 0, len(objects))
+	for _, object := range objects {
+		var rel relation
+		_, err := db.QueryOne(
+			&rel,
+			`
+				SELECT c.oid::bigint AS oid, n.nspname AS schema, c.relname AS name, c.relkind::text AS kind
+				FROM pg_class c
+				JOIN pg_namespace n ON n.oid = c.relnamespace
+				WHERE c.oid = ?::regclass
+			`,
+			object,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "find %s", object)
+		}
+		relations = append(relations, rel)
+	}
+	return x.migrator.applyGrants(db, relations)
+}
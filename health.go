@@ -0,0 +1,43 @@
+package migrations
+
+// IsUpToDate reports whether every registered migration has been applied
+// to the DB, along with the number of migrations which are pending. It is
+// intended for health and readiness checks, so it only reads the migration
+// table: the table is neither created nor locked, and a missing table
+// means that every registered migration is pending.
+//
+// Migrations which have been applied but are not registered, e.g. because
+// a newer release has already migrated the DB, do not count against being
+// up to date.
+func (x *Migrator) IsUpToDate() (bool, int, error) {
+	db := x.stateDB().WithContext(x.ctx)
+	pendingCount, err := x.countPendingMigrations(db)
+	if err != nil {
+		return false, 0, err
+	}
+	return pendingCount == 0, pendingCount, nil
+}
+
+// countPendingMigrations returns the number of registered migrations which
+// have not been applied, without creating or locking the migration table.
+func (x *Migrator) countPendingMigrations(db Querier) (int, error) {
+	exists, err := x.migrationTableExists(db)
+	if err != nil {
+		return 0, err
+	}
+
+	var completedMigrations []string
+	if exists {
+		completedMigrations, err = x.getCompletedMigrations(db)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	unknownMigrations, _, pendingMigrations := difference(completedMigrations, x.registry.List())
+	if len(unknownMigrations) > 0 {
+		x.logAtLevel(LogLevelDebug, "Applied migrations not registered: %+v\n", unknownMigrations)
+	}
+
+	return len(pendingMigrations), nil
+}
@@ -0,0 +1,365 @@
+package migrations
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// HeartbeatTableSuffix is appended to the name of the migration table to
+// get the name of the table which holds the heartbeats of running
+// migrations.
+const HeartbeatTableSuffix = "_heartbeat"
+
+// staleHeartbeatIntervals is the number of heartbeat intervals after which
+// a heartbeat which has not been updated is considered stale.
+const staleHeartbeatIntervals = 3
+
+// Heartbeat describes a migration which is running, or was running when
+// its Migrator stopped without finishing it. See WithHeartbeat.
+type Heartbeat struct {
+	// Migration is the name of the running migration, and Direction
+	// whether it is being applied or rolled back.
+	Migration string
+	Direction Direction
+
+	// Batch is the batch the migration is being run in.
+	Batch int
+
+	// Holder identifies the process running the migration, by default its
+	// host name and process ID.
+	Holder string
+
+	// Step, Current and Total are the progress most recently reported by
+	// the migration with Context.Progress, if any.
+	Step    string
+	Current int64
+	Total   int64
+
+	// StartedAt is when the migration started, and UpdatedAt when the
+	// heartbeat was last updated. Elapsed is the time between them.
+	StartedAt time.Time
+	UpdatedAt time.Time
+	Elapsed   time.Duration
+
+	// Interval is how often the heartbeat is updated.
+	Interval time.Duration
+
+	// Stale indicates that the heartbeat has not been updated for several
+	// intervals, so the process running the migration has probably hung
+	// or died.
+	Stale bool
+}
+
+// createHeartbeatTableQuery creates the table which holds the heartbeats
+// of running migrations. Expects the table name as its only parameter.
+const createHeartbeatTableQuery = `
+	CREATE TABLE IF NOT EXISTS ? (
+		migration varchar PRIMARY KEY,
+		direction varchar NOT NULL,
+		batch integer NOT NULL,
+		holder varchar NOT NULL,
+		step text,
+		current bigint NOT NULL DEFAULT 0,
+		total bigint NOT NULL DEFAULT 0,
+		started_at timestamptz NOT NULL,
+		updated_at timestamptz NOT NULL,
+		elapsed_ms bigint NOT NULL DEFAULT 0,
+		interval_ms bigint NOT NULL
+	)
+`
+
+// startHeartbeatQuery records that a migration has started. Expects the
+// table name followed by the migration, direction, batch, holder and
+// interval in milliseconds.
+const startHeartbeatQuery = `
+	INSERT INTO ? (migration, direction, batch, holder, started_at, updated_at, interval_ms)
+	VALUES (?, ?, ?, ?, now(), now(), ?)
+	ON CONFLICT (migration) DO UPDATE SET
+		direction = excluded.direction,
+		batch = excluded.batch,
+		holder = excluded.holder,
+		step = NULL,
+		current = 0,
+		total = 0,
+		started_at = excluded.started_at,
+		updated_at = excluded.updated_at,
+		elapsed_ms = 0,
+		interval_ms = excluded.interval_ms
+`
+
+// beatHeartbeatQuery updates the heartbeat of a running migration. Expects
+// the table name followed by the step, current, total, migration and
+// holder.
+const beatHeartbeatQuery = `
+	UPDATE ? SET
+		step = ?,
+		current = ?,
+		total = ?,
+		updated_at = now(),
+		elapsed_ms = (extract(epoch FROM now() - started_at) * 1000)::bigint
+	WHERE migration = ? AND holder = ?
+`
+
+// WithHeartbeat initialises a Migrator which updates a heartbeat every
+// interval while each migration runs, so that operators can tell a
+// long-running migration which is still working from one which has hung.
+// The heartbeat is a row per running migration in a table named after the
+// migration table with HeartbeatTableSuffix, recording the elapsed time
+// and the progress last reported with Context.Progress. The row is
+// deleted when the migration finishes, so a row which is no longer
+// updated belongs to a process which hung or died. See Heartbeats.
+//
+// Heartbeats are written outside the transaction of the migration, on a
+// separate connection to the DB which holds the migration table, so they
+// are visible while the migration runs. A failure to write one is logged,
+// and does not fail the migration.
+//
+// Intended for use with NewMigrator.
+func WithHeartbeat(interval time.Duration) MigratorOpt {
+	return func(x *Migrator) error {
+		x.heartbeatInterval = interval
+		return nil
+	}
+}
+
+// heartbeat tracks the heartbeat of a running migration.
+type heartbeat struct {
+	migrator  *Migrator
+	migration string
+	holder    string
+
+	mtx     sync.Mutex
+	step    string
+	current int64
+	total   int64
+
+	done     chan struct{}
+	finished chan struct{}
+	failed   bool
+}
+
+// heartbeatTableName returns the name of the table which holds the
+// heartbeats of running migrations.
+func (x *Migrator) heartbeatTableName() string {
+	return x.migrationTableName + HeartbeatTableSuffix
+}
+
+// heartbeatHolder returns the identity recorded in heartbeats: the
+// identity the lease is held under if there is one, including its random
+// suffix, so that both can be matched, or else the host name and process
+// ID.
+func (x *Migrator) heartbeatHolder() string {
+	if x.lease != nil {
+		return x.lease.heldBy(x.migrationTableName)
+	}
+	holder, err := defaultHolder()
+	if err != nil {
+		return "unknown"
+	}
+	return holder
+}
+
+// startHeartbeat records that a migration has started, and updates its
+// heartbeat until stop is called on the result, if enabled with
+// WithHeartbeat. Nothing is recorded in read-only or dry-run mode, and
+// nil is returned.
+func (x *Migrator) startHeartbeat(migrationName string, direction Direction, batch int) *heartbeat {
+	if x.heartbeatInterval <= 0 || x.readOnly || x.dryRun {
+		return nil
+	}
+
+	beat := &heartbeat{
+		migrator:  x,
+		migration: migrationName,
+		holder:    x.heartbeatHolder(),
+		done:      make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+
+	// The heartbeat continues if the context of the Migrator is
+	// cancelled, since the migration may still be running.
+	db := x.stateDB().WithContext(context.WithoutCancel(x.ctx))
+	table := pg.Ident(x.heartbeatTableName())
+	_, err := db.Exec(createHeartbeatTableQuery, table)
+	if err == nil {
+		_, err = db.Exec(
+			startHeartbeatQuery,
+			table,
+			migrationName,
+			direction.String(),
+			batch,
+			beat.holder,
+			x.heartbeatInterval.Milliseconds(),
+		)
+	}
+	beat.logFailure(err)
+
+	x.heartbeatMtx.Lock()
+	if x.heartbeats == nil {
+		x.heartbeats = make(map[string]*heartbeat)
+	}
+	x.heartbeats[migrationName] = beat
+	x.heartbeatMtx.Unlock()
+
+	go func() {
+		defer close(beat.finished)
+		ticker := time.NewTicker(x.heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-beat.done:
+				return
+			case <-ticker.C:
+			}
+
+			beat.mtx.Lock()
+			step, current, total := beat.step, beat.current, beat.total
+			beat.mtx.Unlock()
+
+			var stepValue interface{}
+			if step != "" {
+				stepValue = step
+			}
+			_, err := db.Exec(beatHeartbeatQuery, table, stepValue, current, total, migrationName, beat.holder)
+			beat.logFailure(err)
+		}
+	}()
+	return beat
+}
+
+// progress records the progress reported by a migration, to be written
+// with the next heartbeat.
+func (x *heartbeat) progress(current int64, total int64, msg string) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.step = msg
+	x.current = current
+	x.total = total
+}
+
+// logFailure logs err, if not nil, unless a failure has already been
+// logged, so that a DB which cannot be written does not flood the log.
+func (x *heartbeat) logFailure(err error) {
+	if err == nil || x.failed {
+		return
+	}
+	x.failed = true
+	x.migrator.logAtLevel(LogLevelError, "Failed to update the heartbeat of %s: %v\n", x.migration, err)
+}
+
+// stop stops updating the heartbeat and deletes it, since the migration
+// has finished. Does nothing if x is nil.
+func (x *heartbeat) stop() {
+	if x == nil {
+		return
+	}
+
+	close(x.done)
+	<-x.finished
+
+	m := x.migrator
+	m.heartbeatMtx.Lock()
+	if m.heartbeats[x.migration] == x {
+		delete(m.heartbeats, x.migration)
+	}
+	m.heartbeatMtx.Unlock()
+
+	_, err := m.stateDB().WithContext(context.WithoutCancel(m.ctx)).Exec(
+		"DELETE FROM ? WHERE migration = ? AND holder = ?",
+		pg.Ident(m.heartbeatTableName()),
+		x.migration,
+		x.holder,
+	)
+	x.logFailure(err)
+}
+
+// heartbeatProgress records the progress reported by a running migration
+// in its heartbeat, if it has one.
+func (x *Migrator) heartbeatProgress(migrationName string, current int64, total int64, msg string) {
+	x.heartbeatMtx.Lock()
+	beat := x.heartbeats[migrationName]
+	x.heartbeatMtx.Unlock()
+	if beat != nil {
+		beat.progress(current, total, msg)
+	}
+}
+
+// Heartbeats returns the heartbeats of the migrations which are running,
+// as recorded by Migrators created with WithHeartbeat, which may be other
+// processes. A heartbeat which is Stale belongs to a process which has
+// probably hung or died, so any lock or lease it holds is stale too. The
+// table is not created if it does not exist.
+func (x *Migrator) Heartbeats() ([]Heartbeat, error) {
+	db := x.stateDB().WithContext(x.ctx)
+	var exists bool
+	_, err := db.QueryOne(pg.Scan(&exists), "SELECT to_regclass(?) IS NOT NULL", x.heartbeatTableName())
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var rows []struct {
+		Migration  string
+		Direction  string
+		Batch      int
+		Holder     string
+		Step       string
+		Current    int64
+		Total      int64
+		StartedAt  time.Time
+		UpdatedAt  time.Time
+		ElapsedMs  int64
+		IntervalMs int64
+		Stale      bool
+	}
+	_, err = db.Query(
+		&rows,
+		`
+			SELECT
+				migration,
+				direction,
+				batch,
+				holder,
+				coalesce(step, '') AS step,
+				current,
+				total,
+				started_at,
+				updated_at,
+				elapsed_ms,
+				interval_ms,
+				updated_at < now() - ? * interval_ms * interval '1 millisecond' AS stale
+			FROM ?
+			ORDER BY started_at, migration
+		`,
+		staleHeartbeatIntervals,
+		pg.Ident(x.heartbeatTableName()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	heartbeats := make([]Heartbeat, 0, len(rows))
+	for _, row := range rows {
+		direction := Up
+		if row.Direction == Down.String() {
+			direction = Down
+		}
+		heartbeats = append(heartbeats, Heartbeat{
+			Migration: row.Migration,
+			Direction: direction,
+			Batch:     row.Batch,
+			Holder:    row.Holder,
+			Step:      row.Step,
+			Current:   row.Current,
+			Total:     row.Total,
+			StartedAt: row.StartedAt,
+			UpdatedAt: row.UpdatedAt,
+			Elapsed:   time.Duration(row.ElapsedMs) * time.Millisecond,
+			Interval:  time.Duration(row.IntervalMs) * time.Millisecond,
+			Stale:     row.Stale,
+		})
+	}
+	return heartbeats, nil
+}
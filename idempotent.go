@@ -0,0 +1,133 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+)
+
+// WithIdempotentRuns initialises a Migrator which checks whether anything
+// is pending before running migrations with MigrateBatch, MigrateStepByStep
+// or MigrateParallel. If nothing is pending, the run returns immediately,
+// without creating or locking the migration table.
+//
+// Once the DB has been found to be up to date, further runs skip the check
+// entirely until another migration is registered or a migration is rolled
+// back by the same Migrator. Changes made to the DB by other processes are
+// not noticed until then.
+//
+// Intended for use with NewMigrator.
+func WithIdempotentRuns() MigratorOpt {
+	return func(x *Migrator) error {
+		x.idempotentRuns = true
+		return nil
+	}
+}
+
+// EnsureMigrated runs any pending migrations as a single batch, as with
+// MigrateBatch, returning immediately if nothing is pending. It is cheap
+// enough to be called every time a service is constructed, regardless of
+// whether WithIdempotentRuns was used.
+func (x *Migrator) EnsureMigrated() error {
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+
+	db := x.dbFactory()
+	upToDate, err := x.checkUpToDate(x.stateDB())
+	if err != nil || upToDate {
+		return err
+	}
+
+	err = x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+	return x.migrateBatch(db, nil)
+}
+
+// skipIfUpToDate reports whether a run can be skipped because idempotent
+// runs are enabled and nothing is pending. Expects the run mutex to be held.
+func (x *Migrator) skipIfUpToDate(db *pg.DB) (bool, error) {
+	if !x.idempotentRuns {
+		return false, nil
+	}
+	return x.checkUpToDate(db)
+}
+
+// checkUpToDate reports whether no migrations or repeatable migrations are
+// pending, using the cached result if the registry has not changed since
+// the DB was last found to be up to date. Expects the run mutex to be held.
+func (x *Migrator) checkUpToDate(db *pg.DB) (bool, error) {
+	generation := x.registry.getGeneration()
+	if x.upToDate && x.upToDateGeneration == generation {
+		return true, nil
+	}
+
+	db = db.WithContext(x.ctx)
+	pendingCount, err := x.countPendingMigrations(db)
+	if err != nil || pendingCount > 0 {
+		return false, err
+	}
+
+	pendingRepeatables, err := x.countPendingRepeatables(db)
+	if err != nil || pendingRepeatables > 0 {
+		return false, err
+	}
+
+	x.logAtLevel(LogLevelDebug, "No migrations pending\n")
+	x.upToDate = true
+	x.upToDateGeneration = generation
+	return true, nil
+}
+
+// invalidateUpToDate discards the cached result of checkUpToDate. Expects
+// the run mutex to be held.
+func (x *Migrator) invalidateUpToDate() {
+	x.upToDate = false
+}
+
+// countPendingRepeatables returns the number of repeatable migrations whose
+// checksums differ from those recorded, without creating the table which
+// records them.
+func (x *Migrator) countPendingRepeatables(db Querier) (int, error) {
+	repeatables := x.registry.listRepeatables()
+	if len(repeatables) == 0 {
+		return 0, nil
+	}
+
+	var exists bool
+	_, err := db.QueryOne(
+		pg.Scan(&exists),
+		"SELECT to_regclass(?) IS NOT NULL",
+		x.repeatableTableName(),
+	)
+	if err != nil || !exists {
+		return len(repeatables), err
+	}
+
+	var applied []struct {
+		Name     string
+		Checksum string
+	}
+	_, err = db.Query(&applied, "SELECT name, checksum FROM ?", pg.Ident(x.repeatableTableName()))
+	if err != nil {
+		return 0, err
+	}
+
+	checksums := make(map[string]string, len(applied))
+	for _, row := range applied {
+		checksums[row.Name] = row.Checksum
+	}
+
+	pendingCount := 0
+	for _, repeatable := range repeatables {
+		if checksums[repeatable.Name] != repeatable.Checksum {
+			pendingCount++
+		}
+	}
+	return pendingCount, nil
+}
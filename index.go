@@ -0,0 +1,243 @@
+package migrations
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrRegistrationMismatch indicates that a Go file in the migration
+	// directory does not register a migration named after the file, or
+	// registers others.
+	ErrRegistrationMismatch = errors.New("migration file does not register its own name")
+
+	// ErrIndexMismatch indicates that the registered migrations do not
+	// match a migration index. See CheckIndex.
+	ErrIndexMismatch = errors.New("registered migrations do not match index")
+)
+
+// MigrationIndexFile is the name of the file generated in the migration
+// directory by GenerateMigrationIndex.
+const MigrationIndexFile = "migrations_index.go"
+
+// migrationFilePattern matches the names of migration files, which start
+// with a timestamp or sequence number, as generated by Create.
+var migrationFilePattern = regexp.MustCompile(`^[0-9]+_`)
+
+// migrationIndexTemplate is the template of MigrationIndexFile.
+var migrationIndexTemplate = template.Must(template.New("index").Parse(`// Code generated by migrations-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+// migrationIndex lists the migrations in this directory, in order. Pass it
+// to Migrator.CheckIndex to check that every migration was registered.
+var migrationIndex = []string{
+{{- range .Names}}
+	{{printf "%q" .}},
+{{- end}}
+}
+`))
+
+// ScanMigrationDir lists the migrations in dir, sorted by name, checking
+// that each Go migration file registers a migration named after the file,
+// and nothing else. Migration files are those whose names start with
+// digits followed by an underscore, as generated by Create: Go files,
+// other than tests, and pairs of SQL files as loaded by LoadSQLFiles.
+//
+// Registrations are found by looking for calls to functions and methods
+// whose names begin with Register, such as Registry.Register and
+// RegisterTyped, which are passed the migration name as a string literal.
+// An error wrapping ErrRegistrationMismatch is returned for the first file
+// which fails the check.
+func ScanMigrationDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read migration directory")
+	}
+
+	var names []string
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		filename := entry.Name()
+		if entry.IsDir() || !migrationFilePattern.MatchString(filename) {
+			continue
+		}
+
+		switch {
+		case strings.HasSuffix(filename, UpSQLSuffix):
+			names = append(names, strings.TrimSuffix(filename, UpSQLSuffix))
+		case strings.HasSuffix(filename, "_test.go"):
+		case strings.HasSuffix(filename, ".go"):
+			name := strings.TrimSuffix(filename, ".go")
+			file, err := parser.ParseFile(fset, filepath.Join(dir, filename), nil, parser.SkipObjectResolution)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not parse %s", filename)
+			}
+			err = checkRegistrations(fset, file, name)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// checkRegistrations checks that file registers the named migration, and
+// no other.
+func checkRegistrations(fset *token.FileSet, file *ast.File, name string) error {
+	var err error
+	registered := false
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || err != nil {
+			return err == nil
+		}
+
+		var funcName string
+		fun := call.Fun
+		if index, ok := fun.(*ast.IndexExpr); ok {
+			fun = index.X
+		}
+		switch fun := fun.(type) {
+		case *ast.Ident:
+			funcName = fun.Name
+		case *ast.SelectorExpr:
+			funcName = fun.Sel.Name
+		}
+		if !strings.HasPrefix(funcName, "Register") {
+			return true
+		}
+
+		// RegisterTyped takes the registry first, and the name second.
+		arg := 0
+		if funcName == "RegisterTyped" {
+			arg = 1
+		}
+		if len(call.Args) <= arg {
+			return true
+		}
+		literal, ok := call.Args[arg].(*ast.BasicLit)
+		if !ok || literal.Kind != token.STRING {
+			err = errors.Wrapf(
+				ErrRegistrationMismatch,
+				"%s: name passed to %s is not a string literal",
+				fset.Position(call.Pos()),
+				funcName,
+			)
+			return false
+		}
+		registeredName, _ := strconv.Unquote(literal.Value)
+		if registeredName != name {
+			err = errors.Wrapf(
+				ErrRegistrationMismatch,
+				"%s: registers %s, expected %s",
+				fset.Position(call.Pos()),
+				registeredName,
+				name,
+			)
+			return false
+		}
+		registered = true
+		return true
+	})
+	if err == nil && !registered {
+		err = errors.Wrapf(
+			ErrRegistrationMismatch,
+			"%s: %s is not registered",
+			fset.Position(file.Package).Filename,
+			name,
+		)
+	}
+	return err
+}
+
+// GenerateMigrationIndex scans dir as by ScanMigrationDir, and writes
+// MigrationIndexFile to it, declaring the variable migrationIndex with the
+// names of the migrations. The package of the file is taken from the other
+// Go files in dir, defaulting to main. This is intended to be run with
+// go:generate, using the migrations-gen command:
+//
+//	//go:generate go run github.com/chainql/migrations/cmd/migrations-gen
+func GenerateMigrationIndex(dir string) error {
+	names, err := ScanMigrationDir(dir)
+	if err != nil {
+		return err
+	}
+	packageName, err := dirPackageName(dir)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	err = migrationIndexTemplate.Execute(buf, map[string]interface{}{
+		"Package": packageName,
+		"Names":   names,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to render index")
+	}
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "failed to format index")
+	}
+	return os.WriteFile(filepath.Join(dir, MigrationIndexFile), source, 0644)
+}
+
+// dirPackageName returns the package of the Go files in dir, other than
+// tests and the index itself, or main if there are none.
+func dirPackageName(dir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", err
+	}
+
+	fset := token.NewFileSet()
+	for _, match := range matches {
+		base := filepath.Base(match)
+		if base == MigrationIndexFile || strings.HasSuffix(base, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, match, nil, parser.PackageClauseOnly)
+		if err != nil {
+			return "", errors.Wrapf(err, "could not parse %s", base)
+		}
+		return file.Name.Name, nil
+	}
+	return "main", nil
+}
+
+// CheckIndex checks that the registered migrations are exactly those in
+// index, such as the migrationIndex generated by GenerateMigrationIndex,
+// e.g. to catch a migration file left out of a build. An error wrapping
+// ErrIndexMismatch lists any differences.
+func (x *Migrator) CheckIndex(index []string) error {
+	unindexed, _, missing := difference(x.registry.List(), index)
+	sort.Strings(missing)
+	sort.Strings(unindexed)
+
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "not registered: "+strings.Join(missing, ", "))
+	}
+	if len(unindexed) > 0 {
+		problems = append(problems, "not in index: "+strings.Join(unindexed, ", "))
+	}
+	if len(problems) > 0 {
+		return errors.Wrap(ErrIndexMismatch, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// InitialMigrationState describes one of the initial migrations which Init
+// runs. See WithInitialMigrations.
+type InitialMigrationState struct {
+	Name       string
+	Registered bool
+	Applied    bool
+}
+
+// InitPlan describes what Init would do, as worked out by PlanInit.
+type InitPlan struct {
+	// TableExists reports whether the migration table exists.
+	TableExists bool
+
+	// TableVersion is the version of the schema of the migration table,
+	// or 0 if it does not exist or was created by a version of this
+	// package which did not record it.
+	TableVersion int
+
+	// Initial lists the initial migrations, in order.
+	Initial []InitialMigrationState
+
+	// Pending lists the initial migrations which Init would apply, in
+	// order.
+	Pending []string
+
+	// Statements lists the statements which Init would execute before
+	// applying the pending migrations, in order: creating or upgrading
+	// the migration table and recording its version, and creating missing
+	// extensions.
+	Statements []string
+
+	// Err is the error which Init would return without applying any
+	// migrations, such as one wrapping ErrInitialMigrationNotKnown or
+	// ErrAlreadyInitialized. The statements are rolled back in that case.
+	Err error
+}
+
+// String returns a report of the plan, listing the initial migrations and
+// the statements Init would execute.
+func (x InitPlan) String() string {
+	builder := &strings.Builder{}
+	switch {
+	case !x.TableExists:
+		fmt.Fprint(builder, "Migration table does not exist")
+	case x.TableVersion < latestMetaVersion():
+		fmt.Fprintf(builder, "Migration table exists at version %d of %d", x.TableVersion, latestMetaVersion())
+	default:
+		fmt.Fprint(builder, "Migration table exists")
+	}
+	fmt.Fprintf(builder, "\n%d of %d initial migrations pending", len(x.Pending), len(x.Initial))
+	for _, initial := range x.Initial {
+		state := "pending"
+		if initial.Applied {
+			state = "applied"
+		}
+		if !initial.Registered {
+			state += " (unknown)"
+		}
+		fmt.Fprintf(builder, "\n  %s: %s", initial.Name, state)
+	}
+	if len(x.Statements) > 0 {
+		fmt.Fprint(builder, "\nStatements:")
+		for _, statement := range x.Statements {
+			fmt.Fprintf(builder, "\n  %s;", statement)
+		}
+	}
+	if x.Err != nil {
+		fmt.Fprintf(builder, "\nInit would fail: %v", x.Err)
+	}
+	return builder.String()
+}
+
+// PlanInit works out what Init would do, without creating, changing or
+// locking anything, so that bootstrap automation can check and record the
+// effect of Init before running it. opts are interpreted as by Init, e.g.
+// with WithSkipIfInitialized.
+func (x *Migrator) PlanInit(opts ...RunOpt) (*InitPlan, error) {
+	options := x.newRunOptions(opts)
+	db := x.stateDB().WithContext(x.ctx)
+	formatter := db.Formatter()
+	plan := &InitPlan{}
+	addStatement := func(query string, params ...interface{}) {
+		formatted := string(formatter.FormatQuery(nil, query, params...))
+		plan.Statements = append(plan.Statements, strings.Join(strings.Fields(formatted), " "))
+	}
+
+	var err error
+	plan.TableExists, err = x.migrationTableExists(db)
+	if err != nil {
+		return nil, err
+	}
+	plan.TableVersion, err = x.migrationTableVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.TableVersion < latestMetaVersion() {
+		metaTable := pg.Ident(x.metaTableName())
+		addStatement(createMetaTableQuery, metaTable)
+		for _, metaMigration := range metaMigrations {
+			if metaMigration.version > plan.TableVersion {
+				addStatement(metaMigration.query, pg.Ident(x.migrationTableName))
+			}
+		}
+		addStatement(setMetaVersionQuery, metaTable, latestMetaVersion())
+	}
+
+	if x.readOnly {
+		plan.Err = errors.Wrap(ErrReadOnly, "cannot create migration table")
+	}
+
+	var applied []string
+	if plan.TableExists {
+		_, err = db.Query(
+			&applied,
+			"SELECT name FROM ? WHERE name IN (?)",
+			pg.Ident(x.migrationTableName),
+			pg.In(x.initialMigrations),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	isApplied := make(map[string]struct{}, len(applied))
+	for _, name := range applied {
+		isApplied[name] = struct{}{}
+	}
+
+	for _, name := range x.initialMigrations {
+		_, registered := x.registry.Get(name)
+		_, done := isApplied[name]
+		plan.Initial = append(plan.Initial, InitialMigrationState{
+			Name:       name,
+			Registered: registered,
+			Applied:    done,
+		})
+		if !registered && plan.Err == nil {
+			plan.Err = errors.Wrapf(ErrInitialMigrationNotKnown, "migration %s not found", name)
+		}
+		if !done {
+			plan.Pending = append(plan.Pending, name)
+		}
+	}
+	if plan.Err != nil {
+		plan.Pending = nil
+		return plan, nil
+	}
+	if len(plan.Pending) == 0 {
+		if !options.skipIfInitialized {
+			plan.Err = errors.Wrapf(ErrAlreadyInitialized, "migrations %+v", x.initialMigrations)
+		}
+		return plan, nil
+	}
+
+	if len(x.extensions) > 0 && x.context.Flavour != CockroachDB {
+		var installed []string
+		_, err = db.Query(&installed, "SELECT extname FROM pg_extension WHERE extname IN (?)", pg.In(x.extensions))
+		if err != nil {
+			return nil, err
+		}
+		_, _, missing := difference(installed, x.extensions)
+		for _, name := range missing {
+			addStatement("CREATE EXTENSION IF NOT EXISTS ?", pg.Ident(name))
+		}
+	}
+	return plan, nil
+}
+
+// migrationTableVersion returns the version of the schema of the migration
+// table recorded in the meta table, or 0 if none is recorded, without
+// creating the meta table.
+func (x *Migrator) migrationTableVersion(db Querier) (int, error) {
+	var exists bool
+	_, err := db.QueryOne(pg.Scan(&exists), "SELECT to_regclass(?) IS NOT NULL", x.metaTableName())
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var versions []int
+	_, err = db.Query(&versions, "SELECT version FROM ? WHERE id = 1", pg.Ident(x.metaTableName()))
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[0], nil
+}
@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrAlreadyInitialized indicates that Init was called after the
+	// initial migrations had already been applied.
+	ErrAlreadyInitialized = errors.New("already initialized")
+)
+
+// WithSkipIfInitialized makes Init return nil, rather than
+// ErrAlreadyInitialized, if the initial migrations have already been
+// applied.
+//
+// Intended for use with Init.
+func WithSkipIfInitialized() RunOpt {
+	return func(x *runOptions) {
+		x.skipIfInitialized = true
+	}
+}
+
+// MigrateWithInit runs the initial migrations if they have not been
+// applied yet, followed by any other pending migrations as a single batch,
+// as MigrateBatch would. No other run can start between the two steps, so
+// this is suitable for bootstrapping a DB from automation.
+//
+// The migrations run after the initial migrations may be limited with
+// opts, such as WithTags.
+func (x *Migrator) MigrateWithInit(opts ...RunOpt) error {
+	options := x.newRunOptions(opts)
+
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+	defer x.applyOverrides(options)()
+
+	db := x.dbFactory()
+	skip, err := x.skipIfUpToDate(x.stateDB())
+	if err != nil || skip {
+		return err
+	}
+
+	err = x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = x.init(db, true)
+	if err != nil {
+		return err
+	}
+	return x.migrateBatch(db, options.tagSelector(&x.registry))
+}
+
+// pendingInitialMigrations returns the initial migrations which have not
+// been applied, in order.
+func (x *Migrator) pendingInitialMigrations(db Querier) ([]string, error) {
+	var applied []string
+	_, err := db.Query(
+		&applied,
+		"SELECT name FROM ? WHERE name IN (?)",
+		pg.Ident(x.migrationTableName),
+		pg.In(x.initialMigrations),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, pending := difference(applied, x.initialMigrations)
+	return pending, nil
+}
@@ -0,0 +1,306 @@
+package migrations
+
+import (
+	"sort"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// Schema is a structured model of the tables in a DB, as read by
+// Introspect. It may be used to snapshot a schema, detect drift, or
+// generate documentation.
+type Schema struct {
+	// Tables are sorted by schema and name.
+	Tables []SchemaTable `json:"tables"`
+
+	// Partial indicates that the schema only describes some of the
+	// tables, indexes and constraints of a DB, as for SchemaFromModels.
+	// See DiffSchemas.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// SchemaTable describes a table, along with its columns, indexes and
+// constraints.
+type SchemaTable struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+
+	// Columns are sorted by their position in the table.
+	Columns []SchemaColumn `json:"columns"`
+
+	// Indexes are sorted by name.
+	Indexes []SchemaIndex `json:"indexes"`
+
+	// Constraints are sorted by name.
+	Constraints []SchemaConstraint `json:"constraints"`
+}
+
+// SchemaColumn describes a column of a table.
+type SchemaColumn struct {
+	Name string `json:"name"`
+
+	// Type is the type of the column as it would be written in SQL,
+	// including any modifiers, e.g. "character varying(255)".
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+
+	// Default is the default expression of the column, or empty if it
+	// has none.
+	Default string `json:"default"`
+}
+
+// SchemaIndex describes an index on a table.
+type SchemaIndex struct {
+	Name string `json:"name"`
+
+	// Definition is the CREATE INDEX statement which would recreate the
+	// index.
+	Definition string `json:"definition"`
+	Unique     bool   `json:"unique"`
+	Primary    bool   `json:"primary"`
+}
+
+// SchemaConstraint describes a constraint on a table.
+type SchemaConstraint struct {
+	Name string `json:"name"`
+
+	// Type is one of "PRIMARY KEY", "FOREIGN KEY", "UNIQUE", "CHECK" or
+	// "EXCLUDE".
+	Type string `json:"type"`
+
+	// Definition is the constraint as it would be written in CREATE
+	// TABLE, e.g. "FOREIGN KEY (user_id) REFERENCES users(id)".
+	Definition string `json:"definition"`
+}
+
+// Table returns the table with the given schema and name, and whether it
+// exists.
+func (x *Schema) Table(schema string, name string) (*SchemaTable, bool) {
+	for i := range x.Tables {
+		if x.Tables[i].Schema == schema && x.Tables[i].Name == name {
+			return &x.Tables[i], true
+		}
+	}
+	return nil, false
+}
+
+// Column returns the column with the given name, and whether it exists.
+func (x *SchemaTable) Column(name string) (*SchemaColumn, bool) {
+	for i := range x.Columns {
+		if x.Columns[i].Name == name {
+			return &x.Columns[i], true
+		}
+	}
+	return nil, false
+}
+
+type introspectedTable struct {
+	TableSchema string
+	TableName   string
+}
+
+type introspectedColumn struct {
+	introspectedTable
+	ColumnName    string
+	DataType      string
+	Nullable      bool
+	ColumnDefault string
+}
+
+type introspectedIndex struct {
+	introspectedTable
+	IndexName  string
+	Definition string
+	IsUnique   bool
+	IsPrimary  bool
+}
+
+type introspectedConstraint struct {
+	introspectedTable
+	ConstraintName string
+	ConstraintType string
+	Definition     string
+}
+
+// introspectTablesQuery lists the user tables of a DB. Schemas beginning
+// with pg_ are reserved for the system, e.g. pg_toast and pg_temp_1.
+const introspectTablesQuery = `
+	SELECT n.nspname AS table_schema, c.relname AS table_name
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p')
+		AND n.nspname NOT IN (?)
+		AND n.nspname NOT LIKE 'pg\_%'
+	ORDER BY 1, 2
+`
+
+// introspectColumnsQuery lists the columns of the user tables of a DB.
+const introspectColumnsQuery = `
+	SELECT n.nspname AS table_schema, c.relname AS table_name,
+		a.attname AS column_name,
+		format_type(a.atttypid, a.atttypmod) AS data_type,
+		NOT a.attnotnull AS nullable,
+		coalesce(pg_get_expr(d.adbin, d.adrelid), '') AS column_default
+	FROM pg_attribute a
+	JOIN pg_class c ON c.oid = a.attrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	WHERE c.relkind IN ('r', 'p')
+		AND a.attnum > 0
+		AND NOT a.attisdropped
+		AND n.nspname NOT IN (?)
+		AND n.nspname NOT LIKE 'pg\_%'
+	ORDER BY 1, 2, a.attnum
+`
+
+// introspectIndexesQuery lists the indexes of the user tables of a DB.
+const introspectIndexesQuery = `
+	SELECT n.nspname AS table_schema, t.relname AS table_name,
+		i.relname AS index_name,
+		pg_get_indexdef(i.oid) AS definition,
+		x.indisunique AS is_unique,
+		x.indisprimary AS is_primary
+	FROM pg_index x
+	JOIN pg_class i ON i.oid = x.indexrelid
+	JOIN pg_class t ON t.oid = x.indrelid
+	JOIN pg_namespace n ON n.oid = t.relnamespace
+	WHERE t.relkind IN ('r', 'p')
+		AND n.nspname NOT IN (?)
+		AND n.nspname NOT LIKE 'pg\_%'
+	ORDER BY 1, 2, 3
+`
+
+// introspectConstraintsQuery lists the constraints of the user tables of
+// a DB.
+const introspectConstraintsQuery = `
+	SELECT n.nspname AS table_schema, t.relname AS table_name,
+		con.conname AS constraint_name,
+		CASE con.contype
+			WHEN 'p' THEN 'PRIMARY KEY'
+			WHEN 'f' THEN 'FOREIGN KEY'
+			WHEN 'u' THEN 'UNIQUE'
+			WHEN 'c' THEN 'CHECK'
+			WHEN 'x' THEN 'EXCLUDE'
+			ELSE con.contype::text
+		END AS constraint_type,
+		pg_get_constraintdef(con.oid) AS definition
+	FROM pg_constraint con
+	JOIN pg_class t ON t.oid = con.conrelid
+	JOIN pg_namespace n ON n.oid = t.relnamespace
+	WHERE t.relkind IN ('r', 'p')
+		AND n.nspname NOT IN (?)
+		AND n.nspname NOT LIKE 'pg\_%'
+	ORDER BY 1, 2, 3
+`
+
+// Introspect reads the tables of a DB, along with their columns, indexes
+// and constraints, from the system catalogs. System schemas are excluded.
+// Each catalog is read with a separate query, so db should be a
+// transaction if the schema may change while it is read.
+func Introspect(db Querier) (*Schema, error) {
+	var tables []introspectedTable
+	_, err := db.Query(&tables, introspectTablesQuery, pg.In(systemSchemas))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read tables")
+	}
+
+	schema := &Schema{Tables: make([]SchemaTable, 0, len(tables))}
+	byName := make(map[introspectedTable]int, len(tables))
+	for _, table := range tables {
+		byName[table] = len(schema.Tables)
+		schema.Tables = append(schema.Tables, SchemaTable{
+			Schema: table.TableSchema,
+			Name:   table.TableName,
+		})
+	}
+
+	var columns []introspectedColumn
+	_, err = db.Query(&columns, introspectColumnsQuery, pg.In(systemSchemas))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read columns")
+	}
+	for _, column := range columns {
+		i, ok := byName[column.introspectedTable]
+		if !ok {
+			continue
+		}
+		schema.Tables[i].Columns = append(schema.Tables[i].Columns, SchemaColumn{
+			Name:     column.ColumnName,
+			Type:     column.DataType,
+			Nullable: column.Nullable,
+			Default:  column.ColumnDefault,
+		})
+	}
+
+	var indexes []introspectedIndex
+	_, err = db.Query(&indexes, introspectIndexesQuery, pg.In(systemSchemas))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read indexes")
+	}
+	for _, index := range indexes {
+		i, ok := byName[index.introspectedTable]
+		if !ok {
+			continue
+		}
+		schema.Tables[i].Indexes = append(schema.Tables[i].Indexes, SchemaIndex{
+			Name:       index.IndexName,
+			Definition: index.Definition,
+			Unique:     index.IsUnique,
+			Primary:    index.IsPrimary,
+		})
+	}
+
+	var constraints []introspectedConstraint
+	_, err = db.Query(&constraints, introspectConstraintsQuery, pg.In(systemSchemas))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read constraints")
+	}
+	for _, constraint := range constraints {
+		i, ok := byName[constraint.introspectedTable]
+		if !ok {
+			continue
+		}
+		schema.Tables[i].Constraints = append(schema.Tables[i].Constraints, SchemaConstraint{
+			Name:       constraint.ConstraintName,
+			Type:       constraint.ConstraintType,
+			Definition: constraint.Definition,
+		})
+	}
+
+	// The catalogs are sorted by the collation of the DB, which may not
+	// match the byte order used by Go.
+	sort.SliceStable(schema.Tables, func(i, j int) bool {
+		if schema.Tables[i].Schema != schema.Tables[j].Schema {
+			return schema.Tables[i].Schema < schema.Tables[j].Schema
+		}
+		return schema.Tables[i].Name < schema.Tables[j].Name
+	})
+	for _, table := range schema.Tables {
+		sort.SliceStable(table.Indexes, func(i, j int) bool {
+			return table.Indexes[i].Name < table.Indexes[j].Name
+		})
+		sort.SliceStable(table.Constraints, func(i, j int) bool {
+			return table.Constraints[i].Name < table.Constraints[j].Name
+		})
+	}
+	return schema, nil
+}
+
+// Introspect reads the tables of the DB the Migrator runs migrations
+// against, as by Introspect, leaving out the migration table.
+func (x *Migrator) Introspect() (*Schema, error) {
+	schema, err := Introspect(x.dbFactory().WithContext(x.ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	tables := schema.Tables[:0]
+	for _, table := range schema.Tables {
+		if !isMigrationTable(x.migrationTableName, table.Schema, table.Name) {
+			tables = append(tables, table)
+		}
+	}
+	schema.Tables = tables
+	return schema, nil
+}
@@ -0,0 +1,195 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoLastRun indicates that no run has been recorded. See
+	// WithLastRunRecording.
+	ErrNoLastRun = errors.New("no run recorded")
+)
+
+// LastRunTableSuffix is appended to the name of the migration table to get
+// the name of the table which records the outcome of the last run.
+const LastRunTableSuffix = "_last_run"
+
+// LastRun describes the outcome of the most recent run which applied or
+// rolled back migrations, or failed. See WithLastRunRecording.
+type LastRun struct {
+	// Direction indicates whether migrations were being applied or
+	// rolled back.
+	Direction Direction
+
+	// Succeeded indicates that the run completed without an error.
+	Succeeded bool
+
+	// Batch is the batch the run applied or rolled back, if known, and
+	// Count is the number of migrations in it.
+	Batch int
+	Count int
+
+	// Migration is the name of the migration which failed, and Error is
+	// the error which stopped the run. Both are empty if the run
+	// succeeded, and Migration is empty if the run failed outside of a
+	// migration, e.g. in a preflight check.
+	Migration string
+	Error     string
+
+	// FinishedAt is when the run ended.
+	FinishedAt time.Time
+}
+
+// createLastRunTableQuery creates the table which records the outcome of
+// the last run. Expects the table name as its only parameter.
+const createLastRunTableQuery = `
+	CREATE TABLE IF NOT EXISTS ? (
+		id integer PRIMARY KEY,
+		direction varchar NOT NULL,
+		succeeded boolean NOT NULL,
+		batch integer NOT NULL,
+		count integer NOT NULL,
+		migration varchar,
+		error text,
+		finished_at timestamptz NOT NULL
+	)
+`
+
+// setLastRunQuery records the outcome of the last run. Expects the table
+// name followed by the columns of the row.
+const setLastRunQuery = `
+	INSERT INTO ? (id, direction, succeeded, batch, count, migration, error, finished_at)
+	VALUES (1, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET
+		direction = excluded.direction,
+		succeeded = excluded.succeeded,
+		batch = excluded.batch,
+		count = excluded.count,
+		migration = excluded.migration,
+		error = excluded.error,
+		finished_at = excluded.finished_at
+`
+
+// WithLastRunRecording initialises a Migrator which records the outcome of
+// each run which applies or rolls back migrations, or fails, in a single
+// row of a table named after the migration table with LastRunTableSuffix.
+// Health dashboards can then show it with LastRun, or by reading the
+// table, without scraping logs.
+//
+// The outcome is recorded once the run has committed or rolled back, on
+// the DB which holds the migration table. A failure to record it is
+// logged, and does not change the result of the run.
+//
+// Intended for use with NewMigrator.
+func WithLastRunRecording() MigratorOpt {
+	return func(x *Migrator) error {
+		x.lastRunRecording = true
+		return nil
+	}
+}
+
+// lastRunTableName returns the name of the table which records the
+// outcome of the last run.
+func (x *Migrator) lastRunTableName() string {
+	return x.migrationTableName + LastRunTableSuffix
+}
+
+// recordLastRun records the outcome of a run which ended with err, if
+// enabled with WithLastRunRecording. Nothing is recorded in read-only or
+// dry-run mode, since nothing is changed.
+func (x *Migrator) recordLastRun(err error, direction Direction, batch int, count int) {
+	if !x.lastRunRecording || x.readOnly || x.dryRun {
+		return
+	}
+
+	var migration, errText interface{}
+	if err != nil {
+		errText = err.Error()
+		var migrationErr *MigrationError
+		if errors.As(err, &migrationErr) {
+			migration = migrationErr.Name
+			if batch == 0 {
+				batch = migrationErr.Batch
+			}
+		}
+	}
+
+	// The run may have been interrupted by cancelling the context of the
+	// Migrator, which should not stop its outcome being recorded.
+	db := x.stateDB().WithContext(context.WithoutCancel(x.ctx))
+	table := pg.Ident(x.lastRunTableName())
+	_, recordErr := db.Exec(createLastRunTableQuery, table)
+	if recordErr == nil {
+		_, recordErr = db.Exec(
+			setLastRunQuery,
+			table,
+			direction.String(),
+			err == nil,
+			batch,
+			count,
+			migration,
+			errText,
+			time.Now(),
+		)
+	}
+	if recordErr != nil {
+		x.logAtLevel(LogLevelError, "Failed to record the last run: %v\n", recordErr)
+	}
+}
+
+// LastRun returns the outcome of the most recent run recorded by a
+// Migrator created with WithLastRunRecording, which may have been another
+// process. If no run has been recorded, ErrNoLastRun is returned. The
+// table is not created if it does not exist.
+func (x *Migrator) LastRun() (*LastRun, error) {
+	db := x.stateDB().WithContext(x.ctx)
+
+	var exists bool
+	_, err := db.QueryOne(pg.Scan(&exists), "SELECT to_regclass(?) IS NOT NULL", x.lastRunTableName())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoLastRun
+	}
+
+	var rows []struct {
+		Direction  string
+		Succeeded  bool
+		Batch      int
+		Count      int
+		Migration  string
+		Error      string
+		FinishedAt time.Time
+	}
+	_, err = db.Query(
+		&rows,
+		"SELECT direction, succeeded, batch, count, migration, error, finished_at FROM ? WHERE id = 1",
+		pg.Ident(x.lastRunTableName()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrNoLastRun
+	}
+
+	row := rows[0]
+	direction := Up
+	if row.Direction == Down.String() {
+		direction = Down
+	}
+	return &LastRun{
+		Direction:  direction,
+		Succeeded:  row.Succeeded,
+		Batch:      row.Batch,
+		Count:      row.Count,
+		Migration:  row.Migration,
+		Error:      row.Error,
+		FinishedAt: row.FinishedAt,
+	}, nil
+}
@@ -0,0 +1,323 @@
+package migrations
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrLeaseHeld indicates that the migration lease is held by another
+	// holder whose lease has not expired.
+	ErrLeaseHeld = errors.New("migration lease held by another holder")
+
+	// ErrLeaseLost indicates that the migration lease could not be renewed
+	// while a run was in progress, so another holder may have taken it
+	// over.
+	ErrLeaseLost = errors.New("migration lease lost")
+
+	// ErrInvalidLeaseTTL indicates that a lease was configured with a TTL
+	// which is not positive.
+	ErrInvalidLeaseTTL = errors.New("invalid lease TTL")
+)
+
+// LeaseTableSuffix is appended to the name of the migration table to get
+// the name of the table which holds the migration lease.
+const LeaseTableSuffix = "_lease"
+
+// WithLease initialises a Migrator which holds a lease while running or
+// rolling back migrations, as is useful when migrations are run by a
+// Kubernetes Job. The lease is held in addition to the lock of the Locker
+// of the Migrator. See LeaseRowLocker.
+//
+// Intended for use with NewMigrator.
+func WithLease(ttl time.Duration, holder string) MigratorOpt {
+	return func(x *Migrator) error {
+		lease, err := NewLeaseRowLocker(ttl, holder)
+		if err != nil {
+			return err
+		}
+		x.lease = lease
+		return nil
+	}
+}
+
+// LeaseRowLocker holds a lease for the duration of each run. The lease is
+// a row in a separate table, named by appending LeaseTableSuffix to the
+// name of the migration table, recording the holder and when the lease
+// expires. It is renewed periodically while a run is in progress, and
+// released when the run finishes.
+//
+// Each Acquire holds the lease under its own identity, the holder followed
+// by a random suffix, so that two Migrators in the same process, or two
+// processes given the same holder, cannot both hold it. If the lease is
+// held, Acquire fails immediately with an error wrapping ErrLeaseHeld. If
+// a holder crashes without releasing the lease, it expires after the TTL
+// and may then be taken over, so retried Jobs do not get stuck.
+//
+// If the lease could not be renewed, the Migrator holding it fails the
+// transaction it is about to commit with an error wrapping ErrLeaseLost,
+// and Release returns such an error.
+type LeaseRowLocker struct {
+	ttl    time.Duration
+	holder string
+
+	// leases are keyed by the name of the migration table rather than by
+	// DB, since a DBFactory may return a new *pg.DB on each call.
+	mtx    sync.Mutex
+	leases map[string]*heldLease
+}
+
+// heldLease tracks the renewal of an acquired lease.
+type heldLease struct {
+	holder   string
+	done     chan struct{}
+	finished chan struct{}
+
+	mtx sync.Mutex
+	err error
+}
+
+// fail records the first error which means the lease may have been lost.
+func (x *heldLease) fail(err error) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	if x.err == nil {
+		x.err = err
+	}
+}
+
+// lost returns an error wrapping ErrLeaseLost if renewing the lease
+// failed.
+func (x *heldLease) lost() error {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	return x.err
+}
+
+// Interface Compliance
+var _ Locker = (*LeaseRowLocker)(nil)
+
+// NewLeaseRowLocker creates a LeaseRowLocker holding leases for ttl, which
+// must be positive. The holder identifies this process in the lease table.
+// If it is empty, the host name and process ID are used, which identify
+// the pod when running in Kubernetes.
+func NewLeaseRowLocker(ttl time.Duration, holder string) (*LeaseRowLocker, error) {
+	if ttl <= 0 {
+		return nil, errors.Wrapf(ErrInvalidLeaseTTL, "%s", ttl)
+	}
+	if holder == "" {
+		var err error
+		holder, err = defaultHolder()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not determine lease holder")
+		}
+	}
+	return &LeaseRowLocker{
+		ttl:    ttl,
+		holder: holder,
+		leases: make(map[string]*heldLease),
+	}, nil
+}
+
+// defaultHolder identifies this process by its host name and process ID.
+func defaultHolder() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid()), nil
+}
+
+// Acquire takes the lease of table, and renews it until Release is called.
+func (x *LeaseRowLocker) Acquire(ctx context.Context, db *pg.DB, table string) error {
+	x.mtx.Lock()
+	current, held := x.leases[table]
+	x.mtx.Unlock()
+	if held {
+		return errors.Wrapf(ErrLeaseHeld, "held by %s in this process", current.holder)
+	}
+
+	holder, err := x.uniqueHolder()
+	if err != nil {
+		return err
+	}
+
+	leaseTable := pg.Ident(table + LeaseTableSuffix)
+	_, err = db.ExecContext(
+		ctx,
+		`
+			CREATE TABLE IF NOT EXISTS ? (
+				id integer PRIMARY KEY,
+				holder varchar NOT NULL,
+				acquired_at timestamptz NOT NULL,
+				expires_at timestamptz NOT NULL
+			)
+		`,
+		leaseTable,
+	)
+	if err != nil {
+		return err
+	}
+
+	// The lease is taken if there is none, or it has expired.
+	var holders []string
+	_, err = db.QueryContext(
+		ctx,
+		&holders,
+		`
+			INSERT INTO ? AS lease (id, holder, acquired_at, expires_at)
+			VALUES (1, ?, now(), now() + ? * interval '1 millisecond')
+			ON CONFLICT (id) DO UPDATE
+			SET holder = excluded.holder, acquired_at = excluded.acquired_at, expires_at = excluded.expires_at
+			WHERE lease.expires_at < now()
+			RETURNING holder
+		`,
+		leaseTable,
+		holder,
+		x.ttl.Milliseconds(),
+	)
+	if err != nil {
+		return err
+	}
+	if len(holders) == 0 {
+		var current struct {
+			Holder    string
+			ExpiresAt time.Time
+		}
+		_, err = db.QueryOneContext(ctx, &current, "SELECT holder, expires_at FROM ? WHERE id = 1", leaseTable)
+		if err != nil {
+			return errors.Wrap(ErrLeaseHeld, "holder unknown")
+		}
+		return errors.Wrapf(
+			ErrLeaseHeld,
+			"held by %s until %s",
+			current.Holder,
+			current.ExpiresAt.Format(time.RFC3339),
+		)
+	}
+
+	// The lease is renewed even if ctx is cancelled, since a started run
+	// is allowed to finish.
+	renewCtx := context.WithoutCancel(ctx)
+	lease := &heldLease{holder: holder, done: make(chan struct{}), finished: make(chan struct{})}
+	go func() {
+		defer close(lease.finished)
+		ticker := time.NewTicker(max(x.ttl/3, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lease.done:
+				return
+			case <-ticker.C:
+			}
+
+			result, err := db.ExecContext(
+				renewCtx,
+				"UPDATE ? SET expires_at = now() + ? * interval '1 millisecond' WHERE id = 1 AND holder = ?",
+				leaseTable,
+				x.ttl.Milliseconds(),
+				holder,
+			)
+			switch {
+			case err != nil:
+				lease.fail(errors.Wrapf(ErrLeaseLost, "renewal failed: %v", err))
+			case result.RowsAffected() == 0:
+				lease.fail(errors.Wrapf(ErrLeaseLost, "no longer held by %s", holder))
+			}
+		}
+	}()
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.leases[table] = lease
+	return nil
+}
+
+// uniqueHolder returns the identity of a single Acquire: the holder
+// followed by a random suffix.
+func (x *LeaseRowLocker) uniqueHolder() (string, error) {
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate lease holder")
+	}
+	return fmt.Sprintf("%s#%x", x.holder, suffix), nil
+}
+
+// lost returns an error wrapping ErrLeaseLost if the lease of table could
+// not be renewed. Nothing is returned if the lease is not held.
+func (x *LeaseRowLocker) lost(table string) error {
+	x.mtx.Lock()
+	lease, ok := x.leases[table]
+	x.mtx.Unlock()
+	if !ok {
+		return nil
+	}
+	return lease.lost()
+}
+
+// heldBy returns the identity the lease of table is held under, including
+// its random suffix, or the holder if the lease is not held.
+func (x *LeaseRowLocker) heldBy(table string) string {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	lease, ok := x.leases[table]
+	if !ok {
+		return x.holder
+	}
+	return lease.holder
+}
+
+// Release stops renewing the lease of table and releases it. An error
+// wrapping ErrLeaseLost is returned if a renewal failed.
+func (x *LeaseRowLocker) Release(ctx context.Context, db *pg.DB, table string) error {
+	x.mtx.Lock()
+	lease, ok := x.leases[table]
+	delete(x.leases, table)
+	x.mtx.Unlock()
+	if !ok {
+		return nil
+	}
+
+	close(lease.done)
+	<-lease.finished
+	_, err := db.ExecContext(
+		ctx,
+		"DELETE FROM ? WHERE id = 1 AND holder = ?",
+		pg.Ident(table+LeaseTableSuffix),
+		lease.holder,
+	)
+	if err != nil {
+		return err
+	}
+	return lease.lost()
+}
+
+// checkLeases returns an error wrapping ErrLeaseLost if a lease held by
+// the Migrator could not be renewed, so that a transaction is not
+// committed once another holder may have taken the lease over.
+func (x *Migrator) checkLeases() error {
+	for _, locker := range x.runLockers() {
+		lease, ok := locker.(*LeaseRowLocker)
+		if !ok {
+			continue
+		}
+		err := lease.lost(x.migrationTableName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String describes the lease, e.g. "lease of pod-1/7 for 1m0s".
+func (x *LeaseRowLocker) String() string {
+	return fmt.Sprintf("lease of %s for %s", x.holder, x.ttl)
+}
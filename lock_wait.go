@@ -0,0 +1,141 @@
+package migrations
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// LockWait describes a lock which a Migrator has been waiting for. See
+// WithOnLockWait.
+type LockWait struct {
+	// Lock names the lock being waited for, e.g. "migration table lock".
+	Lock string
+
+	// Waited is how long the Migrator has been waiting.
+	Waited time.Duration
+
+	// Blockers are the sessions holding or queued for locks which the
+	// Migrator is waiting on, as reported by pg_blocking_pids.
+	Blockers []LockBlocker
+
+	// Heartbeats are the migrations being run, as recorded by Migrators
+	// created with WithHeartbeat, e.g. the one holding the lock. A stale
+	// heartbeat suggests that the holder has hung or died rather than
+	// still working. See Migrator.Heartbeats.
+	Heartbeats []Heartbeat
+}
+
+// LockBlocker describes a session which is blocking a Migrator from
+// acquiring a lock, from pg_stat_activity.
+type LockBlocker struct {
+	PID             int
+	User            string
+	ApplicationName string
+	State           string
+
+	// Query is the most recent query of the session, which may have
+	// finished if the session is idle in a transaction.
+	Query      string
+	QueryStart time.Time
+}
+
+// lockWaitQuery lists the sessions blocking the given backend.
+const lockWaitQuery = `
+	SELECT
+		pid,
+		coalesce(usename, '') AS "user",
+		application_name,
+		coalesce(state, '') AS state,
+		coalesce(query, '') AS query,
+		query_start
+	FROM pg_stat_activity
+	WHERE pid = ANY(pg_blocking_pids(?))
+	ORDER BY pid
+`
+
+// WithOnLockWait initialises a Migrator which calls callback when the
+// explicit lock on the migration table, the lock of any other TxLocker, or
+// the advisory lock taken by MigrateParallel, has not been acquired after
+// the given duration, and again each time that duration passes while it is
+// still waiting. The callback is given the sessions which are blocking the
+// lock, so that operators can see what is holding up a deploy.
+//
+// The blocking sessions are found using pg_blocking_pids on a separate
+// connection to the DB which holds the migration table. The callback is
+// called from another goroutine, but never after the lock is acquired or
+// the attempt fails.
+//
+// Intended for use with NewMigrator.
+func WithOnLockWait(after time.Duration, callback func(LockWait)) MigratorOpt {
+	return func(x *Migrator) error {
+		x.lockWaitAfter = after
+		x.onLockWait = callback
+		return nil
+	}
+}
+
+// waitForLock calls acquire, which should take the named lock on the
+// session of db, reporting to the lock wait callback while it blocks.
+func (x *Migrator) waitForLock(db Querier, lock string, acquire func() error) error {
+	if x.onLockWait == nil || x.lockWaitAfter <= 0 {
+		return acquire()
+	}
+
+	var pid int
+	_, err := db.QueryOne(pg.Scan(&pid), "SELECT pg_backend_pid()")
+	if err != nil {
+		x.logAtLevel(LogLevelDebug, "Could not get backend PID for lock diagnostics: %v\n", err)
+		return acquire()
+	}
+
+	start := time.Now()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(x.lockWaitAfter)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			blockers, err := x.lockBlockers(pid)
+			if err != nil {
+				x.logAtLevel(LogLevelDebug, "Could not query lock blockers: %v\n", err)
+			}
+			heartbeats, err := x.Heartbeats()
+			if err != nil {
+				x.logAtLevel(LogLevelDebug, "Could not query heartbeats: %v\n", err)
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+			x.onLockWait(LockWait{
+				Lock:       lock,
+				Waited:     time.Since(start),
+				Blockers:   blockers,
+				Heartbeats: heartbeats,
+			})
+		}
+	}()
+
+	err = acquire()
+	close(done)
+	wg.Wait()
+	return err
+}
+
+// lockBlockers returns the sessions blocking the given backend.
+func (x *Migrator) lockBlockers(pid int) ([]LockBlocker, error) {
+	var blockers []LockBlocker
+	_, err := x.stateDB().WithContext(x.ctx).Query(&blockers, lockWaitQuery, pid)
+	return blockers, err
+}
@@ -0,0 +1,234 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// Locker coordinates Migrators so that only one at a time runs or rolls
+// back migrations against a DB. The built-in Lockers are TableLocker, the
+// default, AdvisoryLocker and LeaseRowLocker. Other Lockers can coordinate
+// through an external service, such as a distributed lock service. See
+// WithLocker.
+//
+// A Locker may be shared by several Migrators, so implementations which
+// keep state between Acquire and Release should key it by db and table.
+type Locker interface {
+	// Acquire takes the lock before a run starts, blocking until it is
+	// available or ctx is done, or failing if it is held elsewhere. db
+	// holds the migration table named table, which identifies the
+	// migrations being coordinated.
+	Acquire(ctx context.Context, db *pg.DB, table string) error
+
+	// Release releases the lock once the run has finished, whether or not
+	// it succeeded. It is only called if Acquire succeeded.
+	Release(ctx context.Context, db *pg.DB, table string) error
+}
+
+// TxLocker is implemented by Lockers which also lock within each
+// transaction which reads or changes the migration table. Such locks are
+// released when the transaction ends.
+type TxLocker interface {
+	Locker
+
+	// LockTx takes the lock in tx, blocking until it is available.
+	LockTx(ctx context.Context, tx *pg.Tx, table string) error
+}
+
+// WithLocker initialises a Migrator which coordinates with other Migrators
+// using locker, instead of locking the migration table, e.g.
+//
+//	NewMigrator(db, WithLocker(NewAdvisoryLocker()))
+//
+// Passing nil disables locking, as does WithoutExplicitLock. A lease taken
+// with WithLease is held in addition to the lock.
+//
+// Intended for use with NewMigrator.
+func WithLocker(locker Locker) MigratorOpt {
+	return func(x *Migrator) error {
+		x.locker = locker
+		return nil
+	}
+}
+
+// lockKey identifies the lock of a migration table in a DB, for Lockers
+// which keep state between Acquire and Release.
+type lockKey struct {
+	db    *pg.DB
+	table string
+}
+
+// TableLocker locks the migration table in SHARE ROW EXCLUSIVE mode in each
+// transaction which reads or changes it. This is the default Locker. See
+// WithExplicitLock.
+type TableLocker struct{}
+
+// Interface Compliance
+var _ TxLocker = TableLocker{}
+
+// Acquire does nothing, since the table is locked in each transaction.
+func (x TableLocker) Acquire(context.Context, *pg.DB, string) error {
+	return nil
+}
+
+// Release does nothing, since the lock is released with the transaction.
+func (x TableLocker) Release(context.Context, *pg.DB, string) error {
+	return nil
+}
+
+// LockTx locks the migration table in tx.
+//
+// https://www.postgresql.org/docs/current/explicit-locking.html
+// This mode protects a table against concurrent data changes, and is
+// self-exclusive so that only one session can hold it at a time. This means
+// only one migration can run at a time, but pg_dump can still COPY from the
+// table (since it acquires a ACCESS SHARE lock).
+func (x TableLocker) LockTx(ctx context.Context, tx *pg.Tx, table string) error {
+	_, err := tx.ExecContext(ctx, "LOCK ? in SHARE ROW EXCLUSIVE MODE", pg.Ident(table))
+	return err
+}
+
+// String returns "migration table lock".
+func (x TableLocker) String() string {
+	return "migration table lock"
+}
+
+// AdvisoryLocker holds a session-level advisory lock, keyed by the name of
+// the migration table, for the duration of each run. Unlike TableLocker,
+// it does not block reads of the migration table, such as Status, by
+// other sessions. The lock is held on a connection reserved for it, so the
+// pool of the DB needs room for one more connection.
+type AdvisoryLocker struct {
+	mtx   sync.Mutex
+	conns map[lockKey]*pg.Conn
+}
+
+// Interface Compliance
+var _ Locker = (*AdvisoryLocker)(nil)
+
+// NewAdvisoryLocker creates an AdvisoryLocker.
+func NewAdvisoryLocker() *AdvisoryLocker {
+	return &AdvisoryLocker{conns: make(map[lockKey]*pg.Conn)}
+}
+
+// Acquire takes the advisory lock of table on a new connection to db.
+func (x *AdvisoryLocker) Acquire(ctx context.Context, db *pg.DB, table string) error {
+	conn := db.Conn()
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext(?))", table)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.conns[lockKey{db: db, table: table}] = conn
+	return nil
+}
+
+// Release releases the advisory lock of table and closes its connection.
+func (x *AdvisoryLocker) Release(ctx context.Context, db *pg.DB, table string) error {
+	key := lockKey{db: db, table: table}
+	x.mtx.Lock()
+	conn, ok := x.conns[key]
+	delete(x.conns, key)
+	x.mtx.Unlock()
+	if !ok {
+		return nil
+	}
+
+	defer conn.Close()
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext(?))", table)
+	return err
+}
+
+// String returns "advisory lock".
+func (x *AdvisoryLocker) String() string {
+	return "advisory lock"
+}
+
+// lockerName describes a Locker in logs.
+func lockerName(locker Locker) string {
+	if stringer, ok := locker.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", locker)
+}
+
+// runLockers returns the Lockers which are acquired for each run: the
+// lease, if any, followed by the Locker.
+func (x *Migrator) runLockers() []Locker {
+	var lockers []Locker
+	if x.lease != nil {
+		lockers = append(lockers, x.lease)
+	}
+	if x.locker != nil {
+		lockers = append(lockers, x.locker)
+	}
+	return lockers
+}
+
+// acquireLocks acquires the lease and the Locker of the Migrator, in that
+// order, until the returned function is called to release them. Once they
+// are held, the migration table is created or upgraded if necessary.
+func (x *Migrator) acquireLocks(db *pg.DB) (release func(), err error) {
+	lockers := x.runLockers()
+	if x.readOnly {
+		for _, locker := range lockers {
+			if _, ok := locker.(TableLocker); !ok {
+				return nil, errors.Wrapf(ErrReadOnly, "cannot acquire %s", lockerName(locker))
+			}
+		}
+	}
+
+	// Locks are released even if the context of the Migrator is
+	// cancelled, since a started run is allowed to finish.
+	releaseCtx := context.WithoutCancel(x.ctx)
+	releaseAll := func(acquired []Locker) {
+		for i := len(acquired) - 1; i >= 0; i-- {
+			err := acquired[i].Release(releaseCtx, db, x.migrationTableName)
+			if err != nil {
+				x.logAtLevel(LogLevelError, "Could not release %s: %v\n", lockerName(acquired[i]), err)
+				continue
+			}
+			x.logAtLevel(LogLevelTrace, "Released %s\n", lockerName(acquired[i]))
+		}
+	}
+
+	for i, locker := range lockers {
+		err = locker.Acquire(x.ctx, db, x.migrationTableName)
+		if err != nil {
+			releaseAll(lockers[:i])
+			return nil, x.annotateError(err)
+		}
+		x.logAtLevel(LogLevelTrace, "Acquired %s\n", lockerName(locker))
+	}
+
+	err = x.upgradeMigrationTableLocked(db)
+	if err != nil {
+		releaseAll(lockers)
+		return nil, errors.Wrap(err, "could not upgrade migration table")
+	}
+	return func() { releaseAll(lockers) }, nil
+}
+
+// maybeLockTable takes the lock of the Locker in tx, if it is a TxLocker,
+// as TableLocker is. If not, this does nothing.
+func (x *Migrator) maybeLockTable(tx *pg.Tx) error {
+	if x.readOnly {
+		return errors.Wrap(ErrReadOnly, "cannot lock migration table")
+	}
+	txLocker, ok := x.locker.(TxLocker)
+	if !ok {
+		return nil
+	}
+
+	err := x.waitForLock(tx, lockerName(txLocker), func() error {
+		return txLocker.LockTx(x.ctx, tx, x.migrationTableName)
+	})
+	return x.annotateError(err)
+}
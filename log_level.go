@@ -0,0 +1,91 @@
+package migrations
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidLogLevel indicates that a log level could not be parsed.
+	ErrInvalidLogLevel = errors.New("invalid log level")
+)
+
+// LogLevel controls how much a Migrator logs. Each level includes the
+// messages of the levels before it.
+type LogLevel int
+
+const (
+	// LogLevelError logs only failed runs.
+	LogLevelError LogLevel = iota
+
+	// LogLevelInfo additionally logs batches, migrations and generated
+	// files. This is the default.
+	LogLevelInfo
+
+	// LogLevelDebug additionally logs configuration decisions and the
+	// outcome of individual checks.
+	LogLevelDebug
+
+	// LogLevelTrace additionally logs every migration function as it is
+	// called.
+	LogLevelTrace
+)
+
+// DefaultLogLevel is the log level of a Migrator unless otherwise
+// specified.
+const DefaultLogLevel = LogLevelInfo
+
+// String returns the name of the log level, as accepted by ParseLogLevel.
+func (x LogLevel) String() string {
+	switch x {
+	case LogLevelError:
+		return "error"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelTrace:
+		return "trace"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseLogLevel returns the log level with the given name, ignoring case.
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "error":
+		return LogLevelError, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "debug":
+		return LogLevelDebug, nil
+	case "trace":
+		return LogLevelTrace, nil
+	default:
+		return 0, errors.Wrapf(ErrInvalidLogLevel, "%q", name)
+	}
+}
+
+// WithLogLevel initialises a Migrator which logs messages at the given
+// level and below (default: LogLevelInfo).
+//
+// Intended for use with NewMigrator.
+func WithLogLevel(level LogLevel) MigratorOpt {
+	return func(x *Migrator) error {
+		if level < LogLevelError || level > LogLevelTrace {
+			return errors.Wrapf(ErrInvalidLogLevel, "%d", level)
+		}
+		x.logLevel = level
+		return nil
+	}
+}
+
+// logAtLevel will log the provided format string if the log level of
+// the Migrator includes the given level.
+func (x *Migrator) logAtLevel(level LogLevel, format string, v ...any) {
+	if x.logLevel >= level {
+		x.logger.Printf(format, v...)
+	}
+}
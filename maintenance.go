@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMaintenanceFailed indicates that migrations were applied, but the
+	// maintenance they declared with AnalyzeTables or VacuumTables failed
+	// afterwards.
+	ErrMaintenanceFailed = errors.New("post-migration maintenance failed")
+)
+
+// AnalyzeTables declares tables which should be analyzed once the migration
+// has been applied, such as those changed by a large backfill, so that the
+// query planner does not work from stale statistics. Table names may be
+// schema-qualified.
+//
+// ANALYZE is run outside the migration's transaction, after it commits.
+func AnalyzeTables(tables ...string) MigrationOpt {
+	return func(x *migration) error {
+		x.AnalyzeTables = append(x.AnalyzeTables, tables...)
+		return nil
+	}
+}
+
+// VacuumTables declares tables which should be vacuumed once the migration
+// has been applied, such as those with many rows updated or deleted. Table
+// names may be schema-qualified. Tables which are also declared with
+// AnalyzeTables are vacuumed and analyzed together.
+//
+// VACUUM cannot run within a transaction, so it is run after the
+// migration's transaction commits.
+func VacuumTables(tables ...string) MigrationOpt {
+	return func(x *migration) error {
+		x.VacuumTables = append(x.VacuumTables, tables...)
+		return nil
+	}
+}
+
+// runMaintenance vacuums and analyzes the tables declared by the given
+// migrations, each once, in the order they were declared. Expects the
+// migrations to have been committed.
+func (x *Migrator) runMaintenance(db *pg.DB, migrationNames []string) error {
+	var vacuum, analyze []string
+	seen := make(map[string]struct{})
+	analyzed := make(map[string]struct{})
+	for _, migrationName := range migrationNames {
+		migration, exists := x.registry.Get(migrationName)
+		if !exists {
+			continue
+		}
+
+		for _, table := range migration.AnalyzeTables {
+			analyzed[table] = struct{}{}
+		}
+		for _, table := range migration.VacuumTables {
+			if _, ok := seen[table]; !ok {
+				seen[table] = struct{}{}
+				vacuum = append(vacuum, table)
+			}
+		}
+		for _, table := range migration.AnalyzeTables {
+			if _, ok := seen[table]; !ok {
+				seen[table] = struct{}{}
+				analyze = append(analyze, table)
+			}
+		}
+	}
+
+	db = db.WithContext(x.ctx)
+	for _, table := range vacuum {
+		query := "VACUUM ?"
+		if _, ok := analyzed[table]; ok {
+			query = "VACUUM (ANALYZE) ?"
+		}
+
+		x.logAtLevel(LogLevelInfo, "Vacuuming %s\n", table)
+		_, err := db.Exec(query, pg.Ident(table))
+		if err != nil {
+			return errors.Wrapf(ErrMaintenanceFailed, "vacuum %s: %v", table, err)
+		}
+	}
+	for _, table := range analyze {
+		x.logAtLevel(LogLevelInfo, "Analyzing %s\n", table)
+		_, err := db.Exec("ANALYZE ?", pg.Ident(table))
+		if err != nil {
+			return errors.Wrapf(ErrMaintenanceFailed, "analyze %s: %v", table, err)
+		}
+	}
+	return nil
+}
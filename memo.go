@@ -0,0 +1,27 @@
+package migrations
+
+// WithMemo records a free-form note, such as a release ticket or change
+// request ID, with a single run. The memo is stored with each migration
+// the run applies, see AppliedMigration.Memo, and set on the events the
+// run emits, see Event.Memo.
+//
+// Rolling back removes migrations from the migration table, so the memo
+// of a rollback is only reported through its events and the log.
+//
+// Intended for use with the methods of Migrator accepting RunOpts.
+func WithMemo(memo string) RunOpt {
+	return func(x *runOptions) {
+		x.overrides = append(x.overrides, func(m *Migrator) {
+			m.memo = memo
+		})
+	}
+}
+
+// memoValue returns the memo of the current run as a query parameter,
+// which is NULL if the run has no memo.
+func (x *Migrator) memoValue() interface{} {
+	if x.memo == "" {
+		return nil
+	}
+	return x.memo
+}
@@ -0,0 +1,314 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMigrationTableInvalid indicates that the migration table holds
+	// rows which prevent its schema from being upgraded.
+	ErrMigrationTableInvalid = errors.New("invalid migration table")
+)
+
+// MetaTableSuffix is appended to the name of the migration table to get
+// the name of the table which records the version of the migration table
+// itself.
+const MetaTableSuffix = "_meta"
+
+// metaMigration is a change to the schema of the migration table. Each
+// query expects the table name as its only parameter, and must be safe to
+// run again, since tables created by older versions of this package may
+// already have some of the changes. If set, prepare is run first, to make
+// the existing rows fit the change.
+//
+// ScriptPending cannot run prepare in the script it writes, so writes
+// scriptPrepare before query instead. If scriptPrepare is empty, prepare
+// must only read, and ScriptPending runs it before writing the script.
+type metaMigration struct {
+	version       int
+	description   string
+	query         string
+	prepare       func(x *Migrator, db Querier) error
+	scriptPrepare string
+}
+
+// metaMigrations lists the changes to the migration table, in order. New
+// changes must be appended with the next version, and existing changes
+// must never be modified.
+var metaMigrations = []metaMigration{
+	{
+		version:     1,
+		description: "create migration table",
+		query:       createMigrationTableQuery,
+	},
+	{
+		version:     2,
+		description: "add source column",
+		query:       `ALTER TABLE ? ADD COLUMN IF NOT EXISTS source varchar`,
+	},
+	{
+		version:     3,
+		description: "add backup_artifact column",
+		query:       `ALTER TABLE ? ADD COLUMN IF NOT EXISTS backup_artifact varchar`,
+	},
+	{
+		version:     4,
+		description: "add duration_ms column",
+		query:       `ALTER TABLE ? ADD COLUMN IF NOT EXISTS duration_ms bigint`,
+	},
+	{
+		version:     5,
+		description: "add objects column",
+		query:       `ALTER TABLE ? ADD COLUMN IF NOT EXISTS objects varchar[]`,
+	},
+	{
+		version:     6,
+		description: "add rows_affected column",
+		query:       `ALTER TABLE ? ADD COLUMN IF NOT EXISTS rows_affected bigint`,
+	},
+	{
+		version:     7,
+		description: "add skipped column",
+		query:       `ALTER TABLE ? ADD COLUMN IF NOT EXISTS skipped boolean NOT NULL DEFAULT false`,
+	},
+	{
+		version:     8,
+		description: "make name, batch and migration_time NOT NULL",
+		query: `
+			ALTER TABLE ?
+				ALTER COLUMN name SET NOT NULL,
+				ALTER COLUMN batch SET NOT NULL,
+				ALTER COLUMN migration_time SET NOT NULL
+		`,
+		prepare: (*Migrator).checkRequiredColumns,
+	},
+	{
+		version:       9,
+		description:   "add primary key on name",
+		query:         addNamePrimaryKeyQuery,
+		prepare:       (*Migrator).removeDuplicateNames,
+		scriptPrepare: removeDuplicateNamesQuery,
+	},
+	{
+		version:     10,
+		description: "add index on batch",
+		query:       addBatchIndexQuery,
+	},
+	{
+		version:     11,
+		description: "add memo column",
+		query:       `ALTER TABLE ? ADD COLUMN IF NOT EXISTS memo varchar`,
+	},
+}
+
+// addNamePrimaryKeyQuery adds a primary key on the name column, unless the
+// migration table already has a primary key. Expects the migration table
+// name as its only parameter.
+const addNamePrimaryKeyQuery = `
+	DO $$
+	DECLARE
+		rel regclass := '?0'::regclass;
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM pg_index WHERE indrelid = rel AND indisprimary
+		) THEN
+			EXECUTE format('ALTER TABLE %s ADD PRIMARY KEY (name)', rel);
+		END IF;
+	END
+	$$
+`
+
+// removeDuplicateNamesQuery deletes all but the first row recorded for
+// each migration, unless the migration table already has a primary key.
+// Expects the migration table name as its only parameter.
+const removeDuplicateNamesQuery = `
+	DELETE FROM ?0 AS duplicate
+	USING ?0 AS original
+	WHERE duplicate.name = original.name
+		AND duplicate.id > original.id
+		AND NOT EXISTS (
+			SELECT 1 FROM pg_index WHERE indrelid = '?0'::regclass AND indisprimary
+		)
+`
+
+// addBatchIndexQuery adds an index on the batch column, unless the
+// migration table already has an index which starts with it. Expects the
+// migration table name as its only parameter.
+const addBatchIndexQuery = `
+	DO $$
+	DECLARE
+		rel regclass := '?0'::regclass;
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1
+			FROM pg_index i
+			JOIN pg_attribute a
+				ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0]
+			WHERE i.indrelid = rel AND a.attname = 'batch'
+		) THEN
+			EXECUTE format('CREATE INDEX ON %s (batch)', rel);
+		END IF;
+	END
+	$$
+`
+
+// createMetaTableQuery creates the table which records the version of the
+// migration table. Expects the meta table name as its only parameter.
+const createMetaTableQuery = `
+	CREATE TABLE IF NOT EXISTS ? (
+		id integer PRIMARY KEY,
+		version integer NOT NULL
+	)
+`
+
+// setMetaVersionQuery records the version of the migration table. Expects
+// the meta table name and the version as its parameters.
+const setMetaVersionQuery = `
+	INSERT INTO ? (id, version) VALUES (1, ?)
+	ON CONFLICT (id) DO UPDATE SET version = excluded.version
+`
+
+// latestMetaVersion returns the version of the migration table created by
+// this version of the package.
+func latestMetaVersion() int {
+	return metaMigrations[len(metaMigrations)-1].version
+}
+
+// metaTableName returns the name of the table which records the version
+// of the migration table.
+func (x *Migrator) metaTableName() string {
+	return x.migrationTableName + MetaTableSuffix
+}
+
+// checkRequiredColumns returns an error wrapping ErrMigrationTableInvalid
+// if any row of the migration table has no name, batch or migration time,
+// since those columns cannot be made NOT NULL until the rows are fixed.
+func (x *Migrator) checkRequiredColumns(db Querier) error {
+	var ids []int
+	_, err := db.Query(
+		&ids,
+		"SELECT id FROM ? WHERE name IS NULL OR batch IS NULL OR migration_time IS NULL ORDER BY id",
+		pg.Ident(x.migrationTableName),
+	)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return errors.Wrapf(
+		ErrMigrationTableInvalid,
+		"rows with ids %v of %s have no name, batch or migration_time; set or delete them, then run again",
+		ids,
+		x.migrationTableName,
+	)
+}
+
+// removeDuplicateNames deletes all but the first row recorded for each
+// migration, since the name cannot be made the primary key otherwise. A
+// migration recorded more than once has been applied either way.
+func (x *Migrator) removeDuplicateNames(db Querier) error {
+	result, err := db.Exec(removeDuplicateNamesQuery, pg.Ident(x.migrationTableName))
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() > 0 {
+		x.logAtLevel(
+			LogLevelInfo,
+			"Removed %d duplicate rows from %s, keeping the first row of each migration\n",
+			result.RowsAffected(),
+			x.migrationTableName,
+		)
+	}
+	return nil
+}
+
+// upgradeMigrationTableLocked creates or upgrades the migration table in
+// a transaction of its own, holding the lock of the Locker, so that an
+// upgrade is committed before the run starts and concurrent runs do not
+// race to apply it. Nothing is done in read-only or dry-run mode.
+func (x *Migrator) upgradeMigrationTableLocked(db *pg.DB) error {
+	if x.readOnly || x.dryRun {
+		return nil
+	}
+	return db.RunInTransaction(x.ctx, func(tx *pg.Tx) error {
+		err := x.setLockTimeout(tx)
+		if err != nil {
+			return err
+		}
+
+		// The table must exist before it can be locked.
+		_, err = tx.Exec(createMigrationTableQuery, pg.Ident(x.migrationTableName))
+		if err != nil {
+			return err
+		}
+
+		err = x.maybeLockTable(tx)
+		if err != nil {
+			return err
+		}
+		return x.upgradeMigrationTable(tx)
+	})
+}
+
+// metaVersion returns the version of the migration table recorded in the
+// meta table, or 0 if none is recorded. The meta table must exist.
+func (x *Migrator) metaVersion(db Querier) (int, error) {
+	var versions []int
+	_, err := db.Query(&versions, "SELECT version FROM ? WHERE id = 1", pg.Ident(x.metaTableName()))
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return versions[0], nil
+}
+
+// upgradeMigrationTable creates the migration table if necessary, and
+// applies any changes to its schema which have not been applied yet.
+//
+// A migration table which was upgraded by a newer version of this package
+// is left as it is, since changes are only ever additive.
+func (x *Migrator) upgradeMigrationTable(db Querier) error {
+	metaTable := pg.Ident(x.metaTableName())
+	_, err := db.Exec(createMetaTableQuery, metaTable)
+	if err != nil {
+		return err
+	}
+
+	current, err := x.metaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current >= latestMetaVersion() {
+		return nil
+	}
+
+	for _, metaMigration := range metaMigrations {
+		if metaMigration.version <= current {
+			continue
+		}
+
+		x.logAtLevel(
+			LogLevelDebug,
+			"Migration table upgrade %d: %s\n",
+			metaMigration.version,
+			metaMigration.description,
+		)
+		if metaMigration.prepare != nil {
+			err = metaMigration.prepare(x, db)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = db.Exec(metaMigration.query, pg.Ident(x.migrationTableName))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(setMetaVersionQuery, metaTable, latestMetaVersion())
+	return err
+}
@@ -0,0 +1,196 @@
+package migratest
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chainql/migrations"
+)
+
+// FS is an in-memory filesystem to which migrations can be created, for use
+// with migrations.WithCreateFS:
+//
+//	fsys := migratest.NewFS()
+//	migrator, err := migrations.NewMigrator(
+//		db.DBFactory(),
+//		migrations.WithCreateFS(fsys),
+//	)
+//	...
+//	err = migrator.Create("add users")
+//	...
+//	for name, content := range fsys.Files() {
+//		...
+//	}
+//
+// All methods of FS are safe for concurrent use.
+type FS struct {
+	mtx   sync.Mutex
+	files map[string]*file
+}
+
+// Interface Compliance
+var (
+	_ migrations.ExclusiveCreateFS = (*FS)(nil)
+	_ migrations.RemoveFS          = (*FS)(nil)
+	_ migrations.DirReader         = (*FS)(nil)
+)
+
+// file is a file written to an FS.
+type file struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewFS returns an empty in-memory filesystem.
+func NewFS() *FS {
+	return &FS{files: make(map[string]*file)}
+}
+
+// Stat returns information about the named file, or fs.ErrNotExist if it
+// has not been written.
+func (x *FS) Stat(name string) (fs.FileInfo, error) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	f, ok := x.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fileInfo{f}, nil
+}
+
+// WriteFile writes data to the named file, replacing any previous content.
+func (x *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	name = filepath.Clean(name)
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.files[name] = &file{
+		name:    name,
+		data:    append([]byte(nil), data...),
+		mode:    perm,
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// CreateFile writes data to the named file, or returns fs.ErrExist if it
+// has already been written.
+func (x *FS) CreateFile(name string, data []byte, perm fs.FileMode) error {
+	name = filepath.Clean(name)
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	if _, ok := x.files[name]; ok {
+		return &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	x.files[name] = &file{
+		name:    name,
+		data:    append([]byte(nil), data...),
+		mode:    perm,
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// Remove removes the named file, or returns fs.ErrNotExist if it has not
+// been written.
+func (x *FS) Remove(name string) error {
+	name = filepath.Clean(name)
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	if _, ok := x.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(x.files, name)
+	return nil
+}
+
+// Files returns the content of every file written so far, by name.
+func (x *FS) Files() map[string][]byte {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	files := make(map[string][]byte, len(x.files))
+	for name, f := range x.files {
+		files[name] = append([]byte(nil), f.data...)
+	}
+	return files
+}
+
+// ReadDir returns the files written to the named directory, sorted by
+// name. Since FS only holds files, a directory exists once a file has been
+// written to it.
+func (x *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name = filepath.Clean(name)
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	var entries []fs.DirEntry
+	for fileName, f := range x.files {
+		if filepath.Dir(fileName) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(fileInfo{f}))
+		}
+	}
+	if len(entries) == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// Names returns the names of the files written so far, sorted.
+func (x *FS) Names() []string {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	names := make([]string, 0, len(x.files))
+	for name := range x.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileInfo describes a file written to an FS.
+type fileInfo struct {
+	file *file
+}
+
+// Interface Compliance
+var _ fs.FileInfo = fileInfo{}
+
+// Name returns the base name of the file.
+func (x fileInfo) Name() string {
+	return filepath.Base(x.file.name)
+}
+
+// Size returns the length of the file in bytes.
+func (x fileInfo) Size() int64 {
+	return int64(len(x.file.data))
+}
+
+// Mode returns the permissions the file was written with.
+func (x fileInfo) Mode() fs.FileMode {
+	return x.file.mode
+}
+
+// ModTime returns the time the file was last written.
+func (x fileInfo) ModTime() time.Time {
+	return x.file.modTime
+}
+
+// IsDir reports false, since FS only holds files.
+func (x fileInfo) IsDir() bool {
+	return false
+}
+
+// Sys returns nil.
+func (x fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,388 @@
+// Package migratest provides a fake DB for testing code which runs
+// migrations, without a Postgres server:
+//
+//	db := migratest.New()
+//	defer db.Close()
+//	db.On(`SELECT to_regclass`, migratest.Result{
+//		Columns: []string{"exists"},
+//		Rows:    [][]interface{}{{true}},
+//	})
+//
+//	migrator, err := migrations.NewMigrator(db.DBFactory(), opts...)
+//	...
+//	err = migrator.MigrateBatch()
+//	...
+//	for _, query := range db.Queries() {
+//		...
+//	}
+//
+// Migration functions are given a *pg.Tx, so the fake is a real *pg.DB
+// connected to an in-memory server which speaks enough of the Postgres
+// protocol to answer simple queries. The server records every statement
+// it receives, including BEGIN and COMMIT, so that the order in which
+// migrations run, how they are batched into transactions, and whether the
+// migration table is locked can all be checked.
+package migratest
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainql/migrations"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Codes sent in place of the protocol version by clients which are
+	// not starting a normal session.
+	sslRequestCode    = 80877103
+	cancelRequestCode = 80877102
+
+	// textOID is the type reported for every column. go-pg parses text
+	// columns into whatever type they are scanned into.
+	textOID = 25
+)
+
+// Result is the response of the fake DB to a query.
+type Result struct {
+	// Columns are the names of the columns returned. If there are no
+	// columns, no rows are returned.
+	Columns []string
+
+	// Rows are the rows returned, with a value for each column. A nil
+	// value is returned as NULL, and other values are formatted as
+	// Postgres would format them as text.
+	Rows [][]interface{}
+
+	// RowsAffected is the number of rows reported as affected by a query
+	// which returns no columns.
+	RowsAffected int
+
+	// Err, if set, is returned instead of any rows.
+	Err *Error
+}
+
+// Error is an error returned by the fake DB. It is received by the client
+// as a pg.Error.
+type Error struct {
+	// SQLState is the SQLSTATE code of the error, e.g. "42P07".
+	SQLState string
+
+	// Message is the primary error message.
+	Message string
+}
+
+// response is a result returned for queries matching a pattern.
+type response struct {
+	pattern *regexp.Regexp
+	result  Result
+}
+
+// DB is a fake DB which records the statements it receives and answers
+// them with the results added with On. Queries which match no result
+// return no rows.
+//
+// All methods of DB are safe for concurrent use.
+type DB struct {
+	mtx       sync.Mutex
+	queries   []string
+	responses []response
+
+	once sync.Once
+	db   *pg.DB
+}
+
+// New returns an empty fake DB.
+func New() *DB {
+	return &DB{}
+}
+
+// DBFactory returns a DBFactory which always returns the same *pg.DB,
+// connected to the fake DB.
+func (x *DB) DBFactory() migrations.DBFactory {
+	return x.pgDB
+}
+
+// pgDB returns the *pg.DB connected to the fake DB, creating it on the
+// first call.
+func (x *DB) pgDB() *pg.DB {
+	x.once.Do(func() {
+		x.db = pg.Connect(&pg.Options{
+			Addr:     "migratest",
+			User:     "migratest",
+			Database: "migratest",
+			Dialer: func(context.Context, string, string) (net.Conn, error) {
+				client, server := net.Pipe()
+				go x.serve(server)
+				return client, nil
+			},
+		})
+	})
+	return x.db
+}
+
+// Close closes the *pg.DB returned by the DBFactory, if it was created.
+func (x *DB) Close() error {
+	// Waits for the *pg.DB to be created if that is in progress, and
+	// prevents it being created afterwards.
+	x.once.Do(func() {})
+	if x.db == nil {
+		return nil
+	}
+	return x.db.Close()
+}
+
+// On sets the result of queries which match pattern, a regular expression.
+// Queries are matched after runs of whitespace have been collapsed to
+// single spaces.
+//
+// Patterns are checked in the order they were added, and the result of
+// the first pattern which matches is returned. On panics if pattern is
+// not a valid regular expression.
+func (x *DB) On(pattern string, result Result) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.responses = append(x.responses, response{
+		pattern: regexp.MustCompile(pattern),
+		result:  result,
+	})
+}
+
+// Queries returns the statements received so far, in order, with runs of
+// whitespace collapsed to single spaces.
+func (x *DB) Queries() []string {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	return append(make([]string, 0, len(x.queries)), x.queries...)
+}
+
+// Reset forgets the statements received so far. Results added with On are
+// kept.
+func (x *DB) Reset() {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.queries = nil
+}
+
+// handle records a query and returns its result.
+func (x *DB) handle(query string) Result {
+	query = strings.Join(strings.Fields(query), " ")
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.queries = append(x.queries, query)
+	for _, response := range x.responses {
+		if response.pattern.MatchString(query) {
+			return response.result
+		}
+	}
+	return Result{}
+}
+
+// serve answers the messages sent over a single connection until it is
+// closed.
+func (x *DB) serve(conn net.Conn) {
+	defer conn.Close()
+
+	rd := bufio.NewReader(conn)
+	w := &messageWriter{w: bufio.NewWriter(conn)}
+	for {
+		body, err := readStartupMessage(rd)
+		if err != nil {
+			return
+		}
+
+		code := binary.BigEndian.Uint32(body)
+		if code == sslRequestCode {
+			// TLS is not supported.
+			_, err = conn.Write([]byte{'N'})
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if code == cancelRequestCode {
+			return
+		}
+		break
+	}
+
+	w.message('R', int32(0))
+	w.readyForQuery()
+	if w.flush() != nil {
+		return
+	}
+
+	for {
+		typ, body, err := readMessage(rd)
+		if err != nil {
+			return
+		}
+
+		switch typ {
+		case 'Q':
+			query := strings.TrimSuffix(string(body), "\x00")
+			w.result(x.handle(query))
+		case 'X':
+			return
+		default:
+			w.error(&Error{
+				SQLState: "0A000",
+				Message:  fmt.Sprintf("migratest: unsupported message %q", typ),
+			})
+		}
+		w.readyForQuery()
+		if w.flush() != nil {
+			return
+		}
+	}
+}
+
+// readStartupMessage reads a message sent before the session has started,
+// which has no type.
+func readStartupMessage(rd io.Reader) ([]byte, error) {
+	var length int32
+	err := binary.Read(rd, binary.BigEndian, &length)
+	if err != nil {
+		return nil, err
+	}
+	if length < 8 {
+		return nil, errors.Errorf("migratest: invalid startup message length %d", length)
+	}
+
+	body := make([]byte, length-4)
+	_, err = io.ReadFull(rd, body)
+	return body, err
+}
+
+// readMessage reads a typed message sent by the client.
+func readMessage(rd *bufio.Reader) (byte, []byte, error) {
+	typ, err := rd.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var length int32
+	err = binary.Read(rd, binary.BigEndian, &length)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length < 4 {
+		return 0, nil, errors.Errorf("migratest: invalid message length %d", length)
+	}
+
+	body := make([]byte, length-4)
+	_, err = io.ReadFull(rd, body)
+	return typ, body, err
+}
+
+// messageWriter writes messages to the client. Errors are kept until the
+// messages are flushed.
+type messageWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// message writes a message made up of the given fields, which may be
+// int16, int32, strings, which are NUL-terminated, or raw bytes.
+func (x *messageWriter) message(typ byte, fields ...interface{}) {
+	var body []byte
+	for _, field := range fields {
+		switch field := field.(type) {
+		case int16:
+			body = binary.BigEndian.AppendUint16(body, uint16(field))
+		case int32:
+			body = binary.BigEndian.AppendUint32(body, uint32(field))
+		case string:
+			body = append(body, field...)
+			body = append(body, 0)
+		case []byte:
+			body = append(body, field...)
+		}
+	}
+
+	if x.err != nil {
+		return
+	}
+	header := binary.BigEndian.AppendUint32([]byte{typ}, uint32(len(body)+4))
+	_, x.err = x.w.Write(append(header, body...))
+}
+
+// result writes the messages describing a result.
+func (x *messageWriter) result(result Result) {
+	if result.Err != nil {
+		x.error(result.Err)
+		return
+	}
+	if len(result.Columns) == 0 {
+		x.message('C', "OK "+strconv.Itoa(result.RowsAffected))
+		return
+	}
+
+	fields := []interface{}{int16(len(result.Columns))}
+	for _, column := range result.Columns {
+		fields = append(fields, column, int32(0), int16(0), int32(textOID), int16(-1), int32(-1), int16(0))
+	}
+	x.message('T', fields...)
+
+	for _, row := range result.Rows {
+		fields := []interface{}{int16(len(row))}
+		for _, value := range row {
+			if value == nil {
+				fields = append(fields, int32(-1))
+				continue
+			}
+			text := formatValue(value)
+			fields = append(fields, int32(len(text)), []byte(text))
+		}
+		x.message('D', fields...)
+	}
+	x.message('C', "SELECT "+strconv.Itoa(len(result.Rows)))
+}
+
+// error writes an error response.
+func (x *messageWriter) error(err *Error) {
+	x.message('E', []byte{'S'}, "ERROR", []byte{'C'}, err.SQLState, []byte{'M'}, err.Message, []byte{0})
+}
+
+// readyForQuery writes the message which ends every response.
+func (x *messageWriter) readyForQuery() {
+	x.message('Z', []byte{'I'})
+}
+
+// flush sends the messages written so far, returning the first error.
+func (x *messageWriter) flush() error {
+	if x.err != nil {
+		return x.err
+	}
+	return x.w.Flush()
+}
+
+// formatValue formats a value as Postgres would in a text result.
+func formatValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case bool:
+		if value {
+			return "t"
+		}
+		return "f"
+	case time.Time:
+		return value.Format("2006-01-02 15:04:05.999999-07:00")
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,452 @@
+package migratest_test
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chainql/migrations"
+	"github.com/chainql/migrations/migratest"
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// newMigrator returns a Migrator running against db, with a migration for
+// each of names which selects its own name.
+func newMigrator(t *testing.T, db *migratest.DB, names []string, opts ...migrations.MigratorOpt) *migrations.Migrator {
+	t.Helper()
+	opts = append([]migrations.MigratorOpt{migrations.WithLogger(log.New(io.Discard, "", 0))}, opts...)
+	migrator, err := migrations.NewMigrator(db.DBFactory(), opts...)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	for _, name := range names {
+		name := name
+		err = migrator.Register(
+			name,
+			func(tx *pg.Tx) error {
+				_, err := tx.Exec("SELECT ?", name)
+				return err
+			},
+			func(tx *pg.Tx) error {
+				return nil
+			},
+		)
+		if err != nil {
+			t.Fatalf("Register %s: %v", name, err)
+		}
+	}
+	return migrator
+}
+
+// indexOf returns the index of the first query from start onwards which
+// matches pattern, or -1 if there is none.
+func indexOf(queries []string, start int, pattern string) int {
+	re := regexp.MustCompile(pattern)
+	for i := start; i < len(queries); i++ {
+		if re.MatchString(queries[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// requireOrder fails t unless a query matches each of patterns, in order.
+func requireOrder(t *testing.T, queries []string, patterns ...string) {
+	t.Helper()
+	next := 0
+	for _, pattern := range patterns {
+		i := indexOf(queries, next, pattern)
+		if i < 0 {
+			t.Fatalf("no query matching %q after query %d in:\n%s", pattern, next, strings.Join(queries, "\n"))
+		}
+		next = i + 1
+	}
+}
+
+// metaVersion returns the result of reading the version of the migration
+// table.
+func metaVersion(version int) (string, migratest.Result) {
+	return `^SELECT version FROM \S+_meta`, migratest.Result{
+		Columns: []string{"version"},
+		Rows:    [][]interface{}{{version}},
+	}
+}
+
+func TestMigrateBatchRunsMigrationsInOrder(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	db.On(`^SELECT batch FROM`, migratest.Result{
+		Columns: []string{"batch"},
+		Rows:    [][]interface{}{{4}},
+	})
+
+	migrator := newMigrator(t, db, []string{
+		"20240103000000_c",
+		"20240101000000_a",
+		"20240102000000_b",
+	})
+	err := migrator.MigrateBatch()
+	if err != nil {
+		t.Fatalf("MigrateBatch: %v", err)
+	}
+
+	queries := db.Queries()
+	requireOrder(
+		t,
+		queries,
+		`^BEGIN$`,
+		`^LOCK "public"."x_migrations" in SHARE ROW EXCLUSIVE MODE$`,
+		`^SELECT '20240101000000_a'$`,
+		`^insert into "public"."x_migrations" .* values \('20240101000000_a', 5,`,
+		`^SELECT '20240102000000_b'$`,
+		`^insert into "public"."x_migrations" .* values \('20240102000000_b', 5,`,
+		`^SELECT '20240103000000_c'$`,
+		`^insert into "public"."x_migrations" .* values \('20240103000000_c', 5,`,
+		`^COMMIT$`,
+	)
+
+	// The batch runs in a single transaction.
+	first := indexOf(queries, 0, `^SELECT '20240101000000_a'$`)
+	last := indexOf(queries, 0, `^SELECT '20240103000000_c'$`)
+	if i := indexOf(queries[:last], first, `^(BEGIN|COMMIT|ROLLBACK)$`); i >= 0 {
+		t.Fatalf("batch split by %s at query %d", queries[i], i)
+	}
+}
+
+func TestLocksAreAcquiredInOrder(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	db.On(`^INSERT INTO \S+_lease`, migratest.Result{
+		Columns: []string{"holder"},
+		Rows:    [][]interface{}{{"test"}},
+	})
+
+	migrator := newMigrator(
+		t,
+		db,
+		[]string{"20240101000000_a"},
+		migrations.WithLease(time.Minute, "test"),
+		migrations.WithLocker(migrations.NewAdvisoryLocker()),
+	)
+	err := migrator.MigrateBatch()
+	if err != nil {
+		t.Fatalf("MigrateBatch: %v", err)
+	}
+
+	requireOrder(
+		t,
+		db.Queries(),
+		`^INSERT INTO "public"."x_migrations_lease" .* VALUES \(1, 'test#[0-9a-f]+'`,
+		`^SELECT pg_advisory_lock\(hashtext\('public.x_migrations'\)\)$`,
+		`^SELECT version FROM "public"."x_migrations_meta"`,
+		`^SELECT '20240101000000_a'$`,
+		`^COMMIT$`,
+		`^SELECT pg_advisory_unlock\(hashtext\('public.x_migrations'\)\)$`,
+		`^DELETE FROM "public"."x_migrations_lease" WHERE id = 1 AND holder = 'test#[0-9a-f]+'$`,
+	)
+}
+
+func TestMigrateParallelRequiresAdvisoryLock(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+
+	migrator := newMigrator(t, db, []string{"20240101000000_a"})
+	err := migrator.MigrateParallel()
+	if !errors.Is(err, migrations.ErrParallelLocking) {
+		t.Fatalf("MigrateParallel: got %v, want ErrParallelLocking", err)
+	}
+	if queries := db.Queries(); len(queries) > 0 {
+		t.Fatalf("queries sent: %v", queries)
+	}
+}
+
+func TestMetaUpgradeRunsLockedBeforeRun(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	db.On(metaVersion(7))
+
+	migrator := newMigrator(t, db, []string{"20240101000000_a"})
+	err := migrator.MigrateBatch()
+	if err != nil {
+		t.Fatalf("MigrateBatch: %v", err)
+	}
+
+	queries := db.Queries()
+	requireOrder(
+		t,
+		queries,
+		`^BEGIN$`,
+		`^LOCK "public"."x_migrations"`,
+		`^SELECT version FROM "public"."x_migrations_meta"`,
+		`^SELECT id FROM "public"."x_migrations" WHERE name IS NULL`,
+		`^ALTER TABLE "public"."x_migrations" ALTER COLUMN name SET NOT NULL`,
+		`^DELETE FROM "public"."x_migrations" AS duplicate`,
+		`ADD PRIMARY KEY \(name\)`,
+		`^ALTER TABLE "public"."x_migrations" ADD COLUMN IF NOT EXISTS memo`,
+		`^INSERT INTO "public"."x_migrations_meta" \(id, version\) VALUES \(1, 11\)`,
+		`^COMMIT$`,
+		`^BEGIN$`,
+		`^SELECT '20240101000000_a'$`,
+		`^COMMIT$`,
+	)
+
+	// Changes up to the recorded version are not applied again.
+	if i := indexOf(queries, 0, `ADD COLUMN IF NOT EXISTS (source|skipped)`); i >= 0 {
+		t.Fatalf("applied change older than the table: %s", queries[i])
+	}
+}
+
+func TestMetaUpgradeFailsOnRowsMissingColumns(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	db.On(metaVersion(7))
+	db.On(`^SELECT id FROM \S+ WHERE name IS NULL`, migratest.Result{
+		Columns: []string{"id"},
+		Rows:    [][]interface{}{{3}},
+	})
+
+	migrator := newMigrator(t, db, []string{"20240101000000_a"})
+	err := migrator.MigrateBatch()
+	if !errors.Is(err, migrations.ErrMigrationTableInvalid) {
+		t.Fatalf("MigrateBatch: got %v, want ErrMigrationTableInvalid", err)
+	}
+	if !strings.Contains(err.Error(), "[3]") {
+		t.Fatalf("error does not name the invalid rows: %v", err)
+	}
+
+	queries := db.Queries()
+	for _, pattern := range []string{`SET NOT NULL`, `^SELECT '20240101000000_a'$`} {
+		if i := indexOf(queries, 0, pattern); i >= 0 {
+			t.Fatalf("unexpected query after failed check: %s", queries[i])
+		}
+	}
+	requireOrder(t, queries, `^ROLLBACK$`)
+}
+
+func TestMetaUpgradeSkippedWhenCurrent(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	db.On(metaVersion(11))
+
+	migrator := newMigrator(t, db, []string{"20240101000000_a"})
+	err := migrator.MigrateBatch()
+	if err != nil {
+		t.Fatalf("MigrateBatch: %v", err)
+	}
+
+	queries := db.Queries()
+	if i := indexOf(queries, 0, `^(ALTER TABLE|DO \$\$|INSERT INTO \S+_meta)`); i >= 0 {
+		t.Fatalf("upgraded a current table: %s", queries[i])
+	}
+}
+
+func TestLostLeaseFailsCommitWithNewDBPerCall(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	db.On(`^INSERT INTO \S+_lease`, migratest.Result{
+		Columns: []string{"holder"},
+		Rows:    [][]interface{}{{"test"}},
+	})
+
+	// Renewals update no rows, as if another Job had taken the lease over.
+	db.On(`^UPDATE \S+_lease`, migratest.Result{RowsAffected: 0})
+
+	// The factory returns a new *pg.DB on each call, as one which looks up
+	// the DB for a context might.
+	base := db.DBFactory()()
+	var mtx sync.Mutex
+	var dbs []*pg.DB
+	defer func() {
+		for _, db := range dbs {
+			_ = db.Close()
+		}
+	}()
+	factory := func() *pg.DB {
+		options := *base.Options()
+		db := pg.Connect(&options)
+		mtx.Lock()
+		dbs = append(dbs, db)
+		mtx.Unlock()
+		return db
+	}
+
+	migrator, err := migrations.NewMigrator(
+		factory,
+		migrations.WithLogger(log.New(io.Discard, "", 0)),
+		migrations.WithLease(30*time.Millisecond, "test"),
+	)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	err = migrator.Register(
+		"20240101000000_a",
+		func(tx *pg.Tx) error {
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		},
+		func(tx *pg.Tx) error {
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	err = migrator.MigrateBatch()
+	if !errors.Is(err, migrations.ErrLeaseLost) {
+		t.Fatalf("MigrateBatch: got %v, want ErrLeaseLost", err)
+	}
+
+	queries := db.Queries()
+	insert := indexOf(queries, 0, `^insert into "public"."x_migrations" .* values \('20240101000000_a'`)
+	if insert < 0 {
+		t.Fatalf("migration not recorded in:\n%s", strings.Join(queries, "\n"))
+	}
+	if i := indexOf(queries, insert, `^COMMIT$`); i >= 0 {
+		t.Fatalf("committed at query %d after losing the lease", i)
+	}
+}
+
+// newSQLMigrator returns a Migrator running against db, with a SQL
+// migration for each of ups, keyed by name.
+func newSQLMigrator(t *testing.T, db *migratest.DB, ups map[string]string) *migrations.Migrator {
+	t.Helper()
+	migrator := newMigrator(t, db, nil)
+	for name, up := range ups {
+		err := migrator.RegisterSQL(name, up, "SELECT 1")
+		if err != nil {
+			t.Fatalf("RegisterSQL %s: %v", name, err)
+		}
+	}
+	return migrator
+}
+
+func TestScriptPendingUpgradesFromRecordedVersion(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	db.On(`^SELECT to_regclass`, migratest.Result{
+		Columns: []string{"exists"},
+		Rows:    [][]interface{}{{true}},
+	})
+	db.On(metaVersion(7))
+
+	migrator := newSQLMigrator(t, db, map[string]string{
+		"20240101000000_a": "CREATE TABLE a (id int)",
+	})
+	var script strings.Builder
+	err := migrator.ScriptPending(&script)
+	if err != nil {
+		t.Fatalf("ScriptPending: %v", err)
+	}
+
+	lines := strings.Split(script.String(), "\n\n")
+	requireOrder(
+		t,
+		lines,
+		`^BEGIN;$`,
+		`SET NOT NULL`,
+		`^DELETE FROM "public"."x_migrations" AS duplicate`,
+		`ADD PRIMARY KEY \(name\)`,
+		`(?m)^CREATE TABLE a \(id int\);$`,
+		`^COMMIT;$`,
+	)
+	if i := indexOf(lines, 0, `ADD COLUMN IF NOT EXISTS (source|skipped)`); i >= 0 {
+		t.Fatalf("script applies change older than the table: %s", lines[i])
+	}
+	requireOrder(t, db.Queries(), `^SELECT id FROM "public"."x_migrations" WHERE name IS NULL`)
+}
+
+func TestScriptPendingRejectsRowsMissingColumns(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	db.On(`^SELECT to_regclass`, migratest.Result{
+		Columns: []string{"exists"},
+		Rows:    [][]interface{}{{true}},
+	})
+	db.On(metaVersion(7))
+	db.On(`^SELECT id FROM \S+ WHERE name IS NULL`, migratest.Result{
+		Columns: []string{"id"},
+		Rows:    [][]interface{}{{3}},
+	})
+
+	migrator := newSQLMigrator(t, db, map[string]string{
+		"20240101000000_a": "CREATE TABLE a (id int)",
+	})
+	var script strings.Builder
+	err := migrator.ScriptPending(&script)
+	if !errors.Is(err, migrations.ErrMigrationTableInvalid) {
+		t.Fatalf("ScriptPending: got %v, want ErrMigrationTableInvalid", err)
+	}
+	if script.Len() > 0 {
+		t.Fatalf("script written:\n%s", script.String())
+	}
+}
+
+func TestScriptPendingRejectsSecrets(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	db.On(`^SELECT to_regclass`, migratest.Result{
+		Columns: []string{"exists"},
+		Rows:    [][]interface{}{{false}},
+	})
+
+	migrator := newSQLMigrator(t, db, map[string]string{
+		"20240101000000_a": "CREATE ROLE app PASSWORD '${secret:app_pw}'",
+	})
+	var script strings.Builder
+	err := migrator.ScriptPending(&script)
+	if !errors.Is(err, migrations.ErrMigrationNotScriptable) {
+		t.Fatalf("ScriptPending: got %v, want ErrMigrationNotScriptable", err)
+	}
+	if script.Len() > 0 {
+		t.Fatalf("script written:\n%s", script.String())
+	}
+}
+
+// racingFS is an FS on which the down file of a migration is created by
+// someone else as soon as its up file has been created.
+type racingFS struct {
+	*migratest.FS
+}
+
+// CreateFile creates the named file, and then the matching down file if
+// it is an up file.
+func (x racingFS) CreateFile(name string, data []byte, perm fs.FileMode) error {
+	err := x.FS.CreateFile(name, data, perm)
+	if err == nil && strings.HasSuffix(name, migrations.UpSQLSuffix) {
+		down := strings.TrimSuffix(name, migrations.UpSQLSuffix) + migrations.DownSQLSuffix
+		_ = x.FS.WriteFile(down, []byte("-- someone else's\n"), perm)
+	}
+	return err
+}
+
+func TestCreateRemovesUpFileWhenDownFileExists(t *testing.T) {
+	db := migratest.New()
+	defer db.Close()
+	fsys := migratest.NewFS()
+
+	migrator := newMigrator(
+		t,
+		db,
+		nil,
+		migrations.WithMigrationDir("migrations"),
+		migrations.WithCreateFS(racingFS{fsys}),
+		migrations.WithCreateFormat(migrations.SQLPair),
+	)
+	err := migrator.Create("add users")
+	if !errors.Is(err, migrations.ErrFileAlreadyExists) {
+		t.Fatalf("Create: got %v, want ErrFileAlreadyExists", err)
+	}
+
+	for _, name := range fsys.Names() {
+		if strings.HasSuffix(name, migrations.UpSQLSuffix) {
+			t.Fatalf("up file %s left behind", name)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// MigrationError is returned when an up or down migration function fails.
+// It can be retrieved from the error returned by a run with errors.As.
+type MigrationError struct {
+	// Name is the name of the migration which failed.
+	Name string
+
+	// Direction indicates whether the migration was being applied or
+	// rolled back.
+	Direction Direction
+
+	// Batch is the batch the migration was run in. It is zero for
+	// repeatable migrations.
+	Batch int
+
+	// SQLState is the SQLSTATE code reported by the DB, if the failure
+	// was caused by a DB error, e.g. "42P07" for a duplicate table.
+	SQLState string
+
+	// Position is the 1-based character position in the statement at
+	// which the DB reported the error, or zero if none was reported.
+	Position int
+
+	// Hint suggests how to resolve the failure, if the Migrator was
+	// created with WithErrorAnnotations and the SQLSTATE is annotated.
+	Hint string
+
+	// Underlying is the error returned by the migration function.
+	Underlying error
+}
+
+// newMigrationError creates a MigrationError, extracting the details of
+// any DB error from err, and adding a hint for it. See
+// WithErrorAnnotations.
+func (x *Migrator) newMigrationError(name string, direction Direction, batch int, err error) *MigrationError {
+	migrationErr := &MigrationError{
+		Name:       name,
+		Direction:  direction,
+		Batch:      batch,
+		Underlying: err,
+	}
+
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) {
+		migrationErr.SQLState = pgErr.Field('C')
+		migrationErr.Position, _ = strconv.Atoi(pgErr.Field('P'))
+	}
+	migrationErr.Hint = x.errorHint(err)
+	return migrationErr
+}
+
+// Error returns a message naming the migration and its underlying error.
+func (x *MigrationError) Error() string {
+	action := "migrate"
+	if x.Direction == Down {
+		action = "rollback"
+	}
+	if x.Hint != "" {
+		return fmt.Sprintf("%s failed to %s: %v (hint: %s)", x.Name, action, x.Underlying, x.Hint)
+	}
+	return fmt.Sprintf("%s failed to %s: %v", x.Name, action, x.Underlying)
+}
+
+// Unwrap returns the error returned by the migration function.
+func (x *MigrationError) Unwrap() error {
+	return x.Underlying
+}
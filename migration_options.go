@@ -0,0 +1,56 @@
+package migrations
+
+// MigrationOpt represents an option which can be applied to a migration
+// during registration. See Registry.Register.
+type MigrationOpt func(*migration) error
+
+// DependsOn declares the migrations which must be run before this one
+// when running migrations with MigrateParallel. Calling DependsOn without
+// any names declares that the migration is independent of all others.
+//
+// Migrations which do not declare their dependencies are assumed to depend
+// on every migration sorted before them.
+func DependsOn(names ...string) MigrationOpt {
+	return func(x *migration) error {
+		x.DependsOn = append(make([]string, 0, len(names)), names...)
+		return nil
+	}
+}
+
+// Tags attaches tags to a migration, which can be used to treat groups
+// of migrations differently. Tags are case-sensitive.
+func Tags(tags ...string) MigrationOpt {
+	return func(x *migration) error {
+		x.Tags = append(x.Tags, tags...)
+		return nil
+	}
+}
+
+// Description attaches a human-readable description of a migration, which
+// is returned by Status and included in exported scripts, so that the
+// change log says more than the migration name.
+func Description(description string) MigrationOpt {
+	return func(x *migration) error {
+		x.Description = description
+		return nil
+	}
+}
+
+// Author records who wrote a migration, which is returned by Status along
+// with its description.
+func Author(author string) MigrationOpt {
+	return func(x *migration) error {
+		x.Author = author
+		return nil
+	}
+}
+
+// hasTag reports whether the migration has been tagged with tag.
+func (x migration) hasTag(tag string) bool {
+	for _, t := range x.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -3,11 +3,13 @@ package migrations
 import (
 	"bytes"
 	"context"
-	"html/template"
+	"database/sql"
+	"fmt"
 	"log"
 	"os"
-	"path"
-	"sort"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-pg/pg/v10"
@@ -25,7 +27,7 @@ var (
 	ErrMigrationNotKnown = errors.New("no migration by name")
 
 	// ErrInitialMigrationNotKnown indicates that no migration was
-	// found with the name of the initial migration.
+	// found with the name of an initial migration.
 	ErrInitialMigrationNotKnown = errors.New("initial migration not known")
 
 	// ErrNoMigrationName indicates that an attempt was made to
@@ -33,9 +35,13 @@ var (
 	ErrNoMigrationName = errors.New("no migration name specified")
 
 	// ErrFileAlreadyExists indicates that an attempt was made to
-	// create a migration, without specifying a name.
+	// create a migration file which already exists. See WithForceCreate.
 	ErrFileAlreadyExists = errors.New("migration file already exists")
 
+	// ErrRunInterrupted indicates that a run was stopped early because
+	// the migrator's context was cancelled.
+	ErrRunInterrupted = errors.New("migration run interrupted")
+
 	// ErrInvalidMigrationFuncRun indicates that a migration is being
 	// run with a function with invalid function signature.
 	ErrInvalidMigrationFuncRun = errors.New("invalid migration function run")
@@ -55,6 +61,10 @@ const (
 	// in the Migrator.
 	DefaultMigrationNameConvention = SnakeCase
 
+	// DefaultParallelism is the maximum number of migrations which will be
+	// run concurrently by MigrateParallel, if not overridden in the Migrator.
+	DefaultParallelism = 4
+
 	// DefaultMigrationTemplate is the template which will be used for Create,
 	// when using Create without a template.
 	//
@@ -62,7 +72,7 @@ const (
 	// 	package main
 	//
 	// 	import (
-	// 		"github.com/padm-io/migrations"
+	// 		"github.com/chainql/migrations"
 	// 	)
 	//
 	// 	var (
@@ -74,11 +84,14 @@ const (
 	// 		migrator, err := migrations.NewMigrator(dbFactory, migrations.WithMigrations(&registry))
 	// 		// Do things.
 	// 	}
+	//
+	// Create generates such a file from DefaultBootstrapTemplate when
+	// creating the first migration in a directory.
 	DefaultMigrationTemplate = `package main
 
 	import (
 		"github.com/go-pg/pg/v10"
-		"github.com/padm-io/migrations"
+		"github.com/chainql/migrations"
 	)
 	
 	func init() {
@@ -86,6 +99,7 @@ const (
 			"{{.Filename}}",
 			up{{.FuncName}},
 			down{{.FuncName}},
+			migrations.Source("{{.Source}}"),
 		)
 		if err != nil {
 			panic(err)
@@ -104,10 +118,114 @@ const (
 	`
 )
 
+// createMigrationTableQuery creates the migration table, if it does not
+// already exist. Expects the table name as its only parameter.
+const createMigrationTableQuery = `
+	CREATE TABLE IF NOT EXISTS ? (
+		id serial,
+		name varchar,
+		batch integer,
+		migration_time timestamptz
+	)
+`
+
+// RunInterruptedError is returned when a run is stopped early because the
+// migrator's context was cancelled. It matches ErrRunInterrupted when used
+// with errors.Is.
+type RunInterruptedError struct {
+	// Completed lists the migrations which were run before the
+	// interruption.
+	Completed []string
+
+	// Remaining lists the migrations which were not run.
+	Remaining []string
+
+	// Cause is the error returned by the cancelled context.
+	Cause error
+}
+
+// Error implements error.
+func (x *RunInterruptedError) Error() string {
+	return fmt.Sprintf(
+		"%s: %v (remaining migrations: %+v)",
+		ErrRunInterrupted,
+		x.Cause,
+		x.Remaining,
+	)
+}
+
+// Unwrap returns the error returned by the cancelled context.
+func (x *RunInterruptedError) Unwrap() error {
+	return x.Cause
+}
+
+// Is reports whether target is ErrRunInterrupted.
+func (x *RunInterruptedError) Is(target error) bool {
+	return target == ErrRunInterrupted
+}
+
 type migration struct {
 	Name string
 	Up   interface{}
 	Down interface{}
+
+	// UpSQL and DownSQL hold the statements of migrations which were
+	// registered from SQL rather than Go functions.
+	UpSQL   string
+	DownSQL string
+
+	// DependsOn lists the migrations which must be run before this one
+	// when running migrations in parallel. A nil slice means that no
+	// dependencies were declared.
+	DependsOn []string
+
+	// Tags lists the tags attached to the migration.
+	Tags []string
+
+	// Checksum identifies the content of a repeatable migration. The
+	// migration is run again whenever its checksum changes.
+	Checksum string
+
+	// Version is the application release which the migration belongs
+	// to, if declared.
+	Version string
+
+	// Source is a reference to the code which defines the migration.
+	Source string
+
+	// Description and Author describe the migration for people reading
+	// the change log, if declared.
+	Description string
+	Author      string
+
+	// AnalyzeTables and VacuumTables list the tables to be analyzed and
+	// vacuumed once the migration has been committed.
+	AnalyzeTables []string
+	VacuumTables  []string
+
+	// DownRisk is the declared risk of rolling back the migration, if
+	// any. See PlanRollback.
+	DownRisk *RollbackRisk
+
+	// RequiresApproval indicates that the migration is only run by
+	// ApproveAndRun.
+	RequiresApproval bool
+
+	// VerifyDown, if not nil, checks the DB after the down function has
+	// run. See VerifyDown.
+	VerifyDown interface{}
+
+	// ShouldRun, if not nil, decides whether the up function is run. See
+	// ShouldRun.
+	ShouldRun ShouldRunFunc
+
+	// Phase is the phase of a deploy the migration belongs to, if
+	// declared. See Phase.
+	Phase string
+
+	// RequiredPrivileges are the privileges the migration needs of the
+	// role which runs it. See RequiresPrivileges.
+	RequiredPrivileges []Privilege
 }
 
 // DBFactory returns a DB instance which will house both the migration table
@@ -118,19 +236,81 @@ type DBFactory func() *pg.DB
 // Migrator can create or manage migrations as indicated by
 // options during construction.
 //
-// Should not be considered thread-safe.
+// Migrations may be registered from several goroutines at once, including
+// while migrations are being run. Runs which apply or roll back migrations
+// are serialised, so a Migrator never runs two of them at the same time.
+// Migrations registered during a run may or may not be seen by that run.
 type Migrator struct {
+	runMtx                  sync.Mutex
 	dbFactory               DBFactory
+	stateDBFactory          DBFactory
+	renames                 map[string]string
+	continueOnError         bool
 	ctx                     context.Context
 	logger                  *log.Logger
 	registry                Registry
 	migrationTableName      string
-	initialMigration        string
+	initialMigrations       []string
 	migrationDir            string
 	templateDir             string
 	migrationNameConvention MigrationNameConvention
-	explicitLock            bool
-	verbosity               int
+	createFormat            CreateFormat
+	createFS                CreateFS
+	forceCreate             bool
+	requireDown             bool
+	irreversibleAllowed     map[string]bool
+	createTests             bool
+	locker                  Locker
+	preflightMaxTxAge       time.Duration
+	preflightAction         PreflightAction
+	connectionCheck         bool
+	minServerVersion        int
+	extensions              []string
+	lockWaitAfter           time.Duration
+	onLockWait              func(LockWait)
+	readOnly                bool
+	lease                   *LeaseRowLocker
+	parallelism             int
+	idempotentRuns          bool
+	upToDate                bool
+	upToDateGeneration      uint64
+	ordering                Ordering
+	maxBatchSize            int
+	lockTimeout             time.Duration
+	batchTxMode             BatchTxMode
+	memo                    string
+	interMigrationDelay     time.Duration
+	pacing                  PacingFunc
+	replicationLagCheck     *ReplicationLagCheck
+	phases                  []string
+	grants                  []Grant
+	lastRunRecording        bool
+	heartbeatInterval       time.Duration
+	heartbeatMtx            sync.Mutex
+	heartbeats              map[string]*heartbeat
+	transactionPooling      bool
+	rollbackSafetyCheck     bool
+	rollbackRiskCheck       bool
+	maxRollbackRisk         RollbackRisk
+	runWindow               *RunWindow
+	eventHandlers           []EventHandler
+	queryHooks              []pg.QueryHook
+	objectTracker           *objectTracker
+	explainer               *explainer
+	rowCounter              *rowCounter
+	backupRunner            BackupRunner
+	nameValidator           NameValidator
+	timeSource              *timeSource
+	secretsProvider         SecretsProvider
+	secretsMtx              sync.Mutex
+	resolvedSecrets         map[string]struct{}
+	dryRun                  bool
+	defaultRunOpts          []RunOpt
+	collisionCheck          bool
+	collisionMode           CollisionMode
+	schemaVersionComment    bool
+	errorAnnotations        map[string]string
+	logLevel                LogLevel
 	context                 Context
 }
 
@@ -138,9 +318,12 @@ type Migrator struct {
 func DefaultMigrator() *Migrator {
 	return &Migrator{
 		migrationTableName:      DefaultMigrationTableName,
-		initialMigration:        DefaultInitialMigrationName,
+		initialMigrations:       []string{DefaultInitialMigrationName},
 		migrationNameConvention: DefaultMigrationNameConvention,
-		explicitLock:            true,
+		locker:                  TableLocker{},
+		parallelism:             DefaultParallelism,
+		ordering:                ByName,
+		logLevel:                DefaultLogLevel,
 	}
 }
 
@@ -167,7 +350,7 @@ func NewMigrator(dbFactory DBFactory, opts ...MigratorOpt) (*Migrator, error) {
 		migrator.logger = log.Default()
 	}
 	if migrator.ctx == nil {
-		migrator.logWithMinVerbosity(1, "Using TODO context")
+		migrator.logAtLevel(LogLevelDebug, "Using TODO context")
 		migrator.ctx = context.TODO()
 	}
 	if migrator.migrationDir == "" {
@@ -175,9 +358,34 @@ func NewMigrator(dbFactory DBFactory, opts ...MigratorOpt) (*Migrator, error) {
 		if err != nil {
 			return nil, err
 		}
-		migrator.logWithMinVerbosity(1, "Setting migration directory: %s", workingDir)
+		migrator.logAtLevel(LogLevelDebug, "Setting migration directory: %s", workingDir)
 		migrator.migrationDir = workingDir
 	}
+	if migrator.nameValidator != nil {
+		migrator.registry.nameValidator = migrator.validateName
+		err = migrator.validateRegisteredNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if migrator.collisionCheck {
+		migrator.registry.collisionChecker = migrator.checkNameCollisions
+		err = migrator.checkRegisteredNameCollisions()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if migrator.requireDown {
+		migrator.registry.downChecker = migrator.checkDown
+		err = migrator.checkRegisteredDowns()
+		if err != nil {
+			return nil, err
+		}
+	}
+	migrator.useTransactionScopedLocker()
+	if len(migrator.queryHooks) > 0 {
+		dbFactory = hookedDBFactory(dbFactory, migrator.queryHooks)
+	}
 	migrator.dbFactory = dbFactory
 	return migrator, nil
 }
@@ -198,8 +406,22 @@ func WithMigrationTableName(tableName string) MigratorOpt {
 //
 // Intended for use with NewMigrator.
 func WithInitialName(migrationName string) MigratorOpt {
+	return WithInitialNames(migrationName)
+}
+
+// WithInitialNames sets the names of several initial migrations, which
+// will be run in the given order, as a single batch, by a Migrator when
+// running the init command. This allows a bootstrap, such as extensions,
+// a base schema and grants, to be split into separately reviewable
+// migrations.
+//
+// Intended for use with NewMigrator.
+func WithInitialNames(migrationNames ...string) MigratorOpt {
 	return func(x *Migrator) error {
-		x.initialMigration = migrationName
+		if len(migrationNames) == 0 {
+			return errors.Wrap(ErrNoMigrationName, "no initial migrations")
+		}
+		x.initialMigrations = append(make([]string, 0, len(migrationNames)), migrationNames...)
 		return nil
 	}
 }
@@ -239,26 +461,26 @@ func WithMigrations(registry *Registry) MigratorOpt {
 	}
 }
 
-// WithoutExplicitLock initialises a Migrator which will
+// WithExplicitLock initialises a Migrator which will
 // try to explicitly lock the migrations table for each
-// transaction. Currently the default behaviour.
+// transaction, using TableLocker. Currently the default behaviour.
 //
 // Intended for use with NewMigrator.
 func WithExplicitLock() MigratorOpt {
 	return func(x *Migrator) error {
-		x.explicitLock = true
+		x.locker = TableLocker{}
 		return nil
 	}
 }
 
 // WithoutExplicitLock initialises a Migrator which will not
 // try to explicitly lock the migrations table for each
-// transaction.
+// transaction, or use any other Locker. See WithLocker.
 //
 // Intended for use with NewMigrator.
 func WithoutExplicitLock() MigratorOpt {
 	return func(x *Migrator) error {
-		x.explicitLock = false
+		x.locker = nil
 		return nil
 	}
 }
@@ -277,44 +499,51 @@ func WithLogger(logger *log.Logger) MigratorOpt {
 
 // WithVerbosity initialises a Migrator with verbosity level
 // (default: 0). Non-zero values will increase the amount
-// of logging.
+// of logging: 1 is equivalent to LogLevelDebug and 2 or more
+// to LogLevelTrace.
 //
 // It is an error to set both verbosity and quiet to a
 // non-zero value.
 //
 // Intended for use with NewMigrator.
+//
+// Deprecated: Use WithLogLevel.
 func WithVerbosity(verbosity uint) MigratorOpt {
 	return func(x *Migrator) error {
-		if x.verbosity < 0 {
+		if x.logLevel < DefaultLogLevel {
 			return errors.Wrapf(
 				ErrInvalidVerbosity,
-				"current verbosity %d",
-				x.verbosity,
+				"current log level %s",
+				x.logLevel,
 			)
 		}
-		x.verbosity = int(verbosity)
+		x.logLevel = DefaultLogLevel + LogLevel(min(verbosity, 2))
 		return nil
 	}
 }
 
 // WithQuiet initialises a Migrator with quiet level
 // (default: 0). Non-zero values will decrease the amount
-// of logging.
+// of logging, and are equivalent to LogLevelError.
 //
 // It is an error to set both verbosity and quiet to a
 // non-zero value.
 //
 // Intended for use with NewMigrator.
+//
+// Deprecated: Use WithLogLevel.
 func WithQuiet(quiet uint) MigratorOpt {
 	return func(x *Migrator) error {
-		if x.verbosity > 0 {
+		if x.logLevel > DefaultLogLevel {
 			return errors.Wrapf(
 				ErrInvalidVerbosity,
-				"current verbosity %d",
-				x.verbosity,
+				"current log level %s",
+				x.logLevel,
 			)
 		}
-		x.verbosity = int(quiet)
+		if quiet > 0 {
+			x.logLevel = LogLevelError
+		}
 		return nil
 	}
 }
@@ -333,7 +562,8 @@ func WithContext(ctx context.Context) MigratorOpt {
 
 // WithTemplateDir initialises a Migrator with a given
 // template directory. When searching for named templates,
-// this directory will be used.
+// this directory will be used. Partials in the directory are
+// available to every template, see PartialPrefix.
 //
 // Intended for use with NewMigrator.
 func WithTemplateDir(path string) MigratorOpt {
@@ -355,6 +585,20 @@ func WithMigrationDir(path string) MigratorOpt {
 	}
 }
 
+// WithMaxBatchSize initialises a Migrator which applies at most n
+// pending migrations each time MigrateBatch is called. Any remaining
+// migrations are left for subsequent calls, which keeps the size of each
+// transaction bounded when many migrations are pending. Values less than
+// 1 remove the limit (the default).
+//
+// Intended for use with NewMigrator.
+func WithMaxBatchSize(n int) MigratorOpt {
+	return func(x *Migrator) error {
+		x.maxBatchSize = n
+		return nil
+	}
+}
+
 // WithPostgresFlavour initialises a Migrator with a given
 // Postgres flavour. This is not directly used by Migrator
 // and is merely a helper to allow migrations to act
@@ -379,77 +623,158 @@ func WithPostgresFlavour(flavour PostgresFlavour) MigratorOpt {
 //
 //	func(*pg.Tx) error
 //	func(*pg.Tx, *Context) error
+//	func(*sql.Tx) error
+//	func(*sql.DB) error
+//
+// Functions taking a *sql.Tx or *sql.DB allow migrations written against
+// database/sql, or libraries built on it such as sqlx and bun, to be run
+// in the transaction of the migration. Transactions begun on the *sql.DB
+// are no-ops. Queries use $1, $2, ... placeholders, which are formatted by
+// go-pg before being sent.
+//
+// Additional information about the migration may be provided with opts.
 func (x *Migrator) Register(
 	name string,
 	up interface{},
 	down interface{},
+	opts ...MigrationOpt,
 ) error {
-	return x.registry.Register(name, up, down)
+	return x.registry.Register(name, up, down, opts...)
 }
 
-// logWithMinVerbosity will log the provided format string if
-// a verbosity threshold is met.
-//
-// Quiet level is considered negative verbosity.
-func (x *Migrator) logWithMinVerbosity(requiredVerbosity int, format string, v ...any) {
-	currentVerbosity := x.verbosity
-	if currentVerbosity >= requiredVerbosity {
-		x.logger.Printf(format, v...)
+// runMigrationFunc runs an up or down migration function within the
+// given transaction, passing the migration context if the function
+// accepts it.
+func (x *Migrator) runMigrationFunc(tx *pg.Tx, name string, direction Direction, fn interface{}) error {
+	return x.runCountedMigrationFunc(tx, name, direction, fn, nil)
+}
+
+// runCountedMigrationFunc runs a migration function as runMigrationFunc
+// does, adding the rows it affects to rowsAffected, if not nil. See
+// WithRowCounting.
+func (x *Migrator) runCountedMigrationFunc(
+	tx *pg.Tx,
+	name string,
+	direction Direction,
+	fn interface{},
+	rowsAffected *atomic.Int64,
+) error {
+	cont := x.migrationContext(name, direction)
+	cont.rowsAffected = rowsAffected
+	if x.rowCounter != nil && rowsAffected != nil {
+		x.rowCounter.start(tx, rowsAffected)
+		defer x.rowCounter.stop(tx)
+	}
+	return callMigrationFunc(tx, &cont, fn)
+}
+
+// migrationContext returns the context passed to the functions of a
+// migration. Each call gets its own copy of the context, so that progress
+// is attributed to the right migration when running in parallel.
+func (x *Migrator) migrationContext(name string, direction Direction) Context {
+	cont := x.context
+	cont.migrator = x
+	cont.migration = name
+	cont.direction = direction
+	return cont
+}
+
+// callMigrationFunc calls a migration function with the given transaction,
+// and with cont if the function accepts it.
+func callMigrationFunc(tx *pg.Tx, cont *Context, fn interface{}) error {
+	switch migrationFunc := fn.(type) {
+	case func(*pg.Tx) error:
+		return migrationFunc(tx)
+	case func(*pg.Tx, *Context) error:
+		return migrationFunc(tx, cont)
+	case func(*sql.Tx) error:
+		return runSQLTxFunc(tx, migrationFunc)
+	case func(*sql.DB) error:
+		return withSQLDB(tx, migrationFunc)
+	default:
+		return errors.Wrapf(
+			ErrInvalidMigrationFuncRun,
+			"invalid migration function %T",
+			migrationFunc,
+		)
 	}
 }
 
 // ensureMigrationTable will ensure initial migration table exists
-func (x *Migrator) ensureMigrationTable(db pg.DBI) error {
-	_, err := db.Exec(
-		`
-			CREATE TABLE IF NOT EXISTS ? (
-				id serial,
-				name varchar,
-				batch integer,
-				migration_time timestamptz
-			)
-		`,
-		pg.Ident(x.migrationTableName),
-	)
-	return err
+func (x *Migrator) ensureMigrationTable(db Querier) error {
+	if x.readOnly {
+		return errors.Wrap(ErrReadOnly, "cannot create migration table")
+	}
+	return x.createMigrationTable(db)
 }
 
-// maybeLockTable will try to lock the table if explicit locking is
-// enabled. If not, this does nothing.
-func (x *Migrator) maybeLockTable(tx *pg.Tx) error {
-	if !x.explicitLock {
-		return nil
+// createMigrationTable creates the migration table if it does not exist,
+// or upgrades its schema if it was created by an older version of this
+// package, regardless of whether the Migrator is read-only. Migrations
+// renamed with WithRenames are recorded under their new names.
+func (x *Migrator) createMigrationTable(db Querier) error {
+	err := x.upgradeMigrationTable(db)
+	if err != nil {
+		return err
 	}
+	return x.applyRenames(db)
+}
 
-	// https://www.postgresql.org/docs/current/explicit-locking.html
-	// This mode protects a table against concurrent data changes, and is self-exclusive so that only one session can hold it at a time.
-	// This means only one migration can run at a time, but pg_dump can still COPY from the table (since it acquires a ACCESS SHARE lock)
-	_, err := tx.Exec(
-		"LOCK ? in SHARE ROW EXCLUSIVE MODE",
-		pg.Ident(x.migrationTableName),
+// migrationTableExists reports whether the migration table has been
+// created, without attempting to create it.
+func (x *Migrator) migrationTableExists(db Querier) (bool, error) {
+	var exists bool
+	_, err := db.QueryOne(
+		pg.Scan(&exists),
+		"SELECT to_regclass(?) IS NOT NULL",
+		x.migrationTableName,
 	)
-	return err
+	return exists, err
 }
 
 // insertCompletedMigration inserts migration at migrations table
 // to keep track of migrations.
-func (x *Migrator) insertCompletedMigration(db pg.DBI, name string, batch int) error {
+func (x *Migrator) insertCompletedMigration(
+	db Querier,
+	name string,
+	batch int,
+	duration time.Duration,
+	objects []string,
+	rowsAffected int64,
+	skipped bool,
+) error {
+	var source interface{}
+	if migration, _ := x.registry.Get(name); migration.Source != "" {
+		source = migration.Source
+	}
+
 	_, err := db.Exec(
-		"insert into ? (name, batch, migration_time) values (?, ?, now())",
+		"insert into ? (name, batch, migration_time, source, duration_ms, objects, rows_affected, skipped, memo) values (?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		pg.Ident(x.migrationTableName),
 		name,
 		batch,
+		x.migrationTime(),
+		source,
+		duration.Milliseconds(),
+		pg.Array(objects),
+		rowsAffected,
+		skipped,
+		x.memoValue(),
 	)
 	return err
 }
 
-// getCompletedMigrations returns list of all completed migrations
-func (x *Migrator) getCompletedMigrations(db pg.DBI) ([]string, error) {
+// getCompletedMigrations returns list of all completed migrations. See
+// History for the details of each completed migration.
+func (x *Migrator) getCompletedMigrations(db Querier) ([]string, error) {
 	var results []string
 	_, err := db.Query(&results, "select name from ?", pg.Ident(x.migrationTableName))
 	if err != nil {
 		return nil, err
 	}
+	for i, name := range results {
+		results[i] = x.currentName(name)
+	}
 	return results, nil
 }
 
@@ -500,7 +825,7 @@ func difference(
 }
 
 // getMigrationsToRun returns list of new migrations to run by migrator
-func (x *Migrator) getMigrationsToRun(db pg.DBI) ([]string, error) {
+func (x *Migrator) getMigrationsToRun(db Querier) ([]string, error) {
 	var completedMigrations []string
 
 	completedMigrations, err := x.getCompletedMigrations(db)
@@ -513,18 +838,20 @@ func (x *Migrator) getMigrationsToRun(db pg.DBI) ([]string, error) {
 		return nil, errors.Wrapf(ErrMigrationNotKnown, "unknown migrations: %+v", missingMigrations)
 	}
 	if len(migrationsToRun) > 0 {
-		sort.Strings(migrationsToRun)
+		x.sortMigrations(migrationsToRun)
 	}
 
 	return migrationsToRun, nil
 }
 
-// getBatchNumber returns latest batch number of migration
-func (x *Migrator) getBatchNumber(db pg.DBI) (int, error) {
+// getBatchNumber returns latest batch number of migration, or 0 if no
+// migrations have been applied. The query is answered from the index on
+// batch.
+func (x *Migrator) getBatchNumber(db Querier) (int, error) {
 	var result int
 	_, err := db.Query(
 		pg.Scan(&result),
-		"select max(batch) from ?",
+		"SELECT batch FROM ? ORDER BY batch DESC LIMIT 1",
 		pg.Ident(x.migrationTableName),
 	)
 	if err != nil {
@@ -534,74 +861,153 @@ func (x *Migrator) getBatchNumber(db pg.DBI) (int, error) {
 	return result, nil
 }
 
-// Init runs the initial migration against the configured DB. Attempting to
-// run this without registering the initial migration is an error.
-func (x *Migrator) Init() error {
+// Init runs the initial migrations against the configured DB, in the order
+// they were given to WithInitialNames, as a single batch. Attempting to run
+// this without registering every initial migration is an error.
+//
+// Initial migrations which have already been applied are skipped, so that
+// one can be added to an initialized DB. If every initial migration has
+// already been applied, nothing is run and ErrAlreadyInitialized is
+// returned, or nil with WithSkipIfInitialized.
+func (x *Migrator) Init(opts ...RunOpt) error {
+	options := x.newRunOptions(opts)
+
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+	defer x.applyOverrides(options)()
+
 	db := x.dbFactory()
-	return db.RunInTransaction(
+	err := x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return x.init(db, options.skipIfInitialized)
+}
+
+// init runs the initial migrations, as described by Init. Expects the run
+// mutex to be held.
+func (x *Migrator) init(db *pg.DB, skipIfInitialized bool) error {
+	var batch, count int
+	var migrationsToRun []string
+	err := x.runInTransactions(
 		x.ctx,
-		func(tx *pg.Tx) (err error) {
-			err = x.ensureMigrationTable(tx)
+		db,
+		func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+			err = x.ensureMigrationTable(stateTx)
 			if err != nil {
 				return
 			}
 
-			err = x.maybeLockTable(tx)
+			err = x.maybeLockTable(stateTx)
 			if err != nil {
 				return
 			}
 
-			batch, err := x.getBatchNumber(tx)
-			if err != nil {
-				return err
+			for _, migrationName := range x.initialMigrations {
+				if _, ok := x.registry.Get(migrationName); !ok {
+					err = errors.Wrapf(ErrInitialMigrationNotKnown, "migration %s not found", migrationName)
+					return err
+				}
 			}
 
-			batch++
-
-			x.logWithMinVerbosity(0, "Batch %d run: %d migrations\n", batch, 1)
-			migrationName := x.initialMigration
-			migration, ok := x.registry.Get(migrationName)
-			if !ok {
-				err = errors.Wrap(ErrInitialMigrationNotKnown, "not found")
+			migrationsToRun, err = x.pendingInitialMigrations(stateTx)
+			if err != nil {
 				return err
 			}
-
-			switch migrationFunc := migration.Up.(type) {
-			case func(*pg.Tx) error:
-				err = migrationFunc(tx)
-			case func(*pg.Tx, *Context) error:
-				err = migrationFunc(tx, &x.context)
-			default:
-				err = errors.Wrapf(
-					ErrInvalidMigrationFuncRun,
-					"invalid migration function %T",
-					migrationFunc,
-				)
+			if len(migrationsToRun) == 0 {
+				if skipIfInitialized {
+					x.logAtLevel(LogLevelDebug, "Initial migrations %+v already applied\n", x.initialMigrations)
+					return nil
+				}
+				return errors.Wrapf(ErrAlreadyInitialized, "migrations %+v", x.initialMigrations)
 			}
+
+			err = x.ensureExtensions(tx)
 			if err != nil {
-				err = errors.Wrapf(err, "%s failed to migrate", migrationName)
 				return err
 			}
 
-			err = x.insertCompletedMigration(tx, migrationName, batch)
+			batch, err = x.getBatchNumber(stateTx)
 			if err != nil {
 				return err
 			}
 
+			batch++
+
+			count = len(migrationsToRun)
+			x.logAtLevel(LogLevelInfo, "Batch %d run: %d migrations\n", batch, count)
+			x.startBatch(Event{Type: BatchStarted, Direction: Up, Batch: batch, Count: count})
+			for _, migrationName := range migrationsToRun {
+				err = x.applyMigration(tx, stateTx, migrationName, batch)
+				if err != nil {
+					return err
+				}
+			}
 			return nil
 		},
 	)
+	if count > 0 || err != nil {
+		x.finishRun(err, Up, batch, count)
+	}
+	if err == nil && count > 0 {
+		err = x.runMaintenance(db, migrationsToRun)
+	}
+	return err
 }
 
 // MigrateStepByStep runs any migrations against the DB which have not been
 // run yet. Each migration is run in its own transaction and marked as
 // belonging to a separate batch.
-func (x *Migrator) MigrateStepByStep() error {
+//
+// If the migrator's context is cancelled, the migration which is currently
+// running is allowed to finish and is recorded as completed, after which a
+// *RunInterruptedError listing the remaining migrations is returned.
+//
+// The migrations run may be limited with opts, such as WithTags or
+// WithMaxMigrations, and progress reported with WithCheckpoint. Since each
+// migration is recorded as it completes, an interrupted or limited run is
+// resumed by calling MigrateStepByStep again.
+func (x *Migrator) MigrateStepByStep(opts ...RunOpt) error {
+	options := x.newRunOptions(opts)
+
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+	defer x.applyOverrides(options)()
+
 	db := x.dbFactory()
+	skip, err := x.skipIfUpToDate(x.stateDB())
+	if err != nil || skip {
+		return err
+	}
+
+	err = x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	var migrationsToRun []string
-	err := db.RunInTransaction(
+	var remaining int
+	err = x.stateDB().RunInTransaction(
 		x.ctx,
 		func(tx *pg.Tx) (err error) {
+			err = x.setLockTimeout(tx)
+			if err != nil {
+				return err
+			}
+
 			err = x.ensureMigrationTable(tx)
 			if err != nil {
 				return
@@ -612,146 +1018,466 @@ func (x *Migrator) MigrateStepByStep() error {
 				return err
 			}
 
-			migrationsToRun, err = x.getMigrationsToRun(tx)
-			return err
+			pendingMigrations, err := x.getMigrationsToRun(tx)
+			if err != nil {
+				return err
+			}
+
+			migrationsToRun = options.selectTagged(&x.registry, pendingMigrations)
+			migrationsToRun, _ = x.withholdUnapproved(migrationsToRun)
+			remaining = len(pendingMigrations) - len(migrationsToRun)
+			err = x.checkRunWindow(migrationsToRun)
+			if err != nil {
+				return err
+			}
+			return x.checkRequiredPrivileges(db.WithContext(x.ctx), migrationsToRun)
 		},
 	)
 
 	if err != nil {
+		x.finishRun(err, Up, 0, 0)
 		return err
 	}
 
+	migrationsToRun, deferred := options.limitMigrations(migrationsToRun)
+	remaining += deferred
+
 	if len(migrationsToRun) == 0 {
-		return nil
+		if remaining > 0 {
+			return nil
+		}
+		return x.runRepeatables(db)
 	}
 
-	for _, migrationName := range migrationsToRun {
-		err := db.RunInTransaction(
-			x.ctx,
-			func(tx *pg.Tx) (err error) {
-				err = x.maybeLockTable(tx)
+	artifact, err := x.maybeBackup(db, migrationsToRun)
+	if err != nil {
+		x.finishRun(err, Up, 0, 0)
+		return err
+	}
+
+	for i, migrationName := range migrationsToRun {
+		if i > 0 {
+			err = x.pace(migrationsToRun[i-1], migrationName)
+			if err != nil {
+				x.finishRun(err, Up, 0, 0)
+				return err
+			}
+		}
+
+		if ctxErr := x.ctx.Err(); ctxErr != nil {
+			x.logAtLevel(LogLevelInfo, "Run interrupted: %d of %d migrations completed\n", i, len(migrationsToRun))
+			err = &RunInterruptedError{
+				Completed: migrationsToRun[:i],
+				Remaining: migrationsToRun[i:],
+				Cause:     ctxErr,
+			}
+			x.finishRun(err, Up, 0, 0)
+			return err
+		}
+
+		// Once started, a migration is not cancelled along with the
+		// context, so that it is never left half-applied.
+		var batch int
+		err = x.runInTransactions(
+			context.WithoutCancel(x.ctx),
+			db,
+			func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+				err = x.maybeLockTable(stateTx)
 				if err != nil {
 					return err
 				}
 
-				batch, err := x.getBatchNumber(tx)
+				// The window may have closed since the run started.
+				err = x.checkRunWindow([]string{migrationName})
 				if err != nil {
 					return err
 				}
 
-				batch++
-
-				x.logWithMinVerbosity(0, "Batch %d run: 1 migration - %s\n", batch, migrationName)
-				migration, exists := x.registry.Get(migrationName)
-				if !exists {
-					return errors.Wrapf(ErrMigrationNotKnown, "migration %s", migrationName)
+				err = x.ensureExtensions(tx)
+				if err != nil {
+					return err
 				}
 
-				switch migrationFunc := migration.Up.(type) {
-				case func(*pg.Tx) error:
-					err = migrationFunc(tx)
-				case func(*pg.Tx, *Context) error:
-					err = migrationFunc(tx, &x.context)
-				default:
-					err = errors.Wrapf(
-						ErrInvalidMigrationFuncRun,
-						"invalid migration function %T",
-						migrationFunc,
-					)
+				batch, err = x.getBatchNumber(stateTx)
+				if err != nil {
+					return err
 				}
+
+				batch++
+
+				x.logAtLevel(LogLevelInfo, "Batch %d run: 1 migration - %s\n", batch, migrationName)
+				x.startBatch(Event{Type: BatchStarted, Direction: Up, Batch: batch, Count: 1})
+				err = x.applyMigration(tx, stateTx, migrationName, batch)
 				if err != nil {
-					err = errors.Wrapf(err, "%s failed to migrate", migrationName)
 					return err
 				}
 
-				err = x.insertCompletedMigration(tx, migrationName, batch)
-				return err
+				return x.recordBackup(stateTx, batch, artifact)
 			},
 		)
+		x.finishRun(err, Up, batch, 1)
+		if err != nil {
+			return err
+		}
+
+		err = x.runMaintenance(db, []string{migrationName})
 		if err != nil {
 			return err
 		}
+
+		if options.checkpoint != nil {
+			options.checkpoint(Checkpoint{
+				Migration: migrationName,
+				Batch:     batch,
+				Completed: i + 1,
+				Remaining: len(migrationsToRun) - i - 1 + remaining,
+			})
+		}
 	}
 
-	return nil
+	if deferred > 0 {
+		x.logAtLevel(LogLevelInfo, "Paused after %d migrations: %d left for the next run\n", len(migrationsToRun), deferred)
+	}
+	if remaining > 0 {
+		return nil
+	}
+	return x.runRepeatables(db)
 }
 
 // MigrateBatch runs any migrations against the DB which have not been
 // run yet. All migrations are run in a single migration and marked as
-// belonging to the same batch.
-func (x *Migrator) MigrateBatch() error {
+// belonging to the same batch. See WithBatchTxMode to run each migration
+// in its own transaction instead.
+//
+// If a maximum batch size was set with WithMaxBatchSize, only that many
+// migrations are run. The number of migrations left pending is logged
+// and reported in the Remaining field of the BatchStarted event.
+//
+// Repeatable migrations which have changed are run after any pending
+// migrations, in the same transaction.
+//
+// The migrations run may be limited with opts, such as WithTags.
+func (x *Migrator) MigrateBatch(opts ...RunOpt) error {
+	options := x.newRunOptions(opts)
+
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+	defer x.applyOverrides(options)()
+
 	db := x.dbFactory()
-	return db.RunInTransaction(
+	skip, err := x.skipIfUpToDate(x.stateDB())
+	if err != nil || skip {
+		return err
+	}
+
+	err = x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+	return x.migrateBatch(db, options.tagSelector(&x.registry))
+}
+
+// migrateBatch runs pending migrations in a single batch, as described by
+// MigrateBatch. If selectMigrations is not nil, only the pending
+// migrations it returns are run. Expects the run mutex to be held.
+func (x *Migrator) migrateBatch(db *pg.DB, selectMigrations func(pending []string) ([]string, error)) error {
+	var batch, count int
+	var deferredMigrations []string
+	var deferredArtifact string
+	var deferredRemaining int
+	var batchErr *BatchError
+	var applied []string
+	err := x.runInTransactions(
 		x.ctx,
-		func(tx *pg.Tx) (err error) {
-			err = x.ensureMigrationTable(tx)
+		db,
+		func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+			err = x.ensureMigrationTable(stateTx)
 			if err != nil {
 				return
 			}
 
-			err = x.maybeLockTable(tx)
+			err = x.maybeLockTable(stateTx)
+			if err != nil {
+				return err
+			}
+
+			pendingMigrations, err := x.getMigrationsToRun(stateTx)
+			if err != nil {
+				return err
+			}
+
+			migrationsToRun := pendingMigrations
+			if selectMigrations != nil {
+				migrationsToRun, err = selectMigrations(pendingMigrations)
+				if err != nil {
+					return err
+				}
+			}
+			migrationsToRun, _ = x.withholdUnapproved(migrationsToRun)
+			if x.maxBatchSize > 0 && len(migrationsToRun) > x.maxBatchSize {
+				migrationsToRun = migrationsToRun[:x.maxBatchSize]
+			}
+			remaining := len(pendingMigrations) - len(migrationsToRun)
+
+			err = x.checkRunWindow(migrationsToRun)
 			if err != nil {
 				return err
 			}
 
-			migrationsToRun, err := x.getMigrationsToRun(tx)
+			err = x.checkRequiredPrivileges(tx, migrationsToRun)
 			if err != nil {
 				return err
 			}
 
 			if len(migrationsToRun) == 0 {
-				return nil
+				if remaining > 0 {
+					return nil
+				}
+				return x.applyRepeatables(tx, stateTx)
 			}
 
-			batch, err := x.getBatchNumber(tx)
+			err = x.ensureExtensions(tx)
+			if err != nil {
+				return err
+			}
+
+			batch, err = x.getBatchNumber(stateTx)
 			if err != nil {
 				return err
 			}
 
 			batch++
+			count = len(migrationsToRun)
 
-			x.logWithMinVerbosity(0, "Batch %d run: %d migrations\n", batch, len(migrationsToRun))
-			for _, migrationName := range migrationsToRun {
-				migration, exists := x.registry.Get(migrationName)
-				if !exists {
-					return errors.Wrapf(ErrMigrationNotKnown, "migration %s", migrationName)
-				}
+			artifact, err := x.maybeBackup(db, migrationsToRun)
+			if err != nil {
+				return err
+			}
 
-				switch migrationFunc := migration.Up.(type) {
-				case func(*pg.Tx) error:
-					err = migrationFunc(tx)
-				case func(*pg.Tx, *Context) error:
-					err = migrationFunc(tx, &x.context)
-				default:
-					err = errors.Wrapf(
-						ErrInvalidMigrationFuncRun,
-						"invalid migration function %T",
-						migrationFunc,
-					)
-				}
-				if err != nil {
-					err = errors.Wrapf(err, "%s failed to migrate", migrationName)
-					return err
-				}
+			x.logAtLevel(LogLevelInfo, "Batch %d run: %d migrations\n", batch, count)
+			if remaining > 0 {
+				x.logAtLevel(LogLevelInfo, "Batch %d limited: %d migrations remain pending\n", batch, remaining)
+			}
+			x.startBatch(Event{
+				Type:      BatchStarted,
+				Direction: Up,
+				Batch:     batch,
+				Count:     count,
+				Remaining: remaining,
+			})
+
+			// With a transaction per migration, the migrations are run
+			// once this transaction has finished.
+			if x.batchTxMode == TxPerMigration {
+				deferredMigrations = migrationsToRun
+				deferredArtifact = artifact
+				deferredRemaining = remaining
+				return nil
+			}
 
-				err = x.insertCompletedMigration(tx, migrationName, batch)
+			if x.continueOnError {
+				batchErr, err = x.applyWithSavepoints(tx, stateTx, migrationsToRun, batch)
 				if err != nil {
 					return err
 				}
+				count = len(batchErr.Applied)
+				remaining += len(batchErr.Failed)
+				applied = batchErr.Applied
+			} else {
+				for _, migrationName := range migrationsToRun {
+					err = x.applyMigration(tx, stateTx, migrationName, batch)
+					if err != nil {
+						return err
+					}
+				}
+				applied = migrationsToRun
 			}
 
-			return err
+			err = x.recordBackup(stateTx, batch, artifact)
+			if err != nil {
+				return err
+			}
+
+			// Repeatable migrations may rely on the pending migrations,
+			// so they wait until no versioned migrations are pending.
+			if remaining > 0 {
+				return nil
+			}
+			return x.applyRepeatables(tx, stateTx)
 		},
 	)
+	if err == nil && len(deferredMigrations) > 0 {
+		err = x.applyInSeparateTransactions(db, deferredMigrations, batch, deferredArtifact, deferredRemaining)
+		applied = deferredMigrations
+	}
+	if err == nil && batchErr != nil && len(batchErr.Failed) > 0 {
+		err = batchErr
+	}
+	x.finishRun(err, Up, batch, count)
+	if err == nil || err == batchErr {
+		// A failure to vacuum or analyze does not hide which migrations
+		// failed.
+		maintenanceErr := x.runMaintenance(db, applied)
+		if maintenanceErr != nil && err == nil {
+			err = maintenanceErr
+		}
+	}
+	return err
 }
 
-func (x *Migrator) removeRolledbackMigration(db pg.DBI, name string) error {
-	x.logWithMinVerbosity(0, "Rolled back %s\n", name)
+// applyMigration runs the up function of a registered migration in tx and
+// records it in stateTx as completed in the given batch.
+func (x *Migrator) applyMigration(tx *pg.Tx, stateTx *pg.Tx, migrationName string, batch int) error {
+	migration, exists := x.registry.Get(migrationName)
+	if !exists {
+		return errors.Wrapf(ErrMigrationNotKnown, "migration %s", migrationName)
+	}
+
+	start := time.Now()
+	x.emit(Event{Type: MigrationStarted, Direction: Up, Migration: migrationName, Batch: batch, Memo: x.memo})
+	beat := x.startHeartbeat(migrationName, Up, batch)
+	defer beat.stop()
+	run, err := x.shouldRun(tx, migration)
+	if err != nil {
+		return x.newMigrationError(migrationName, Up, batch, err)
+	}
+	if !run {
+		return x.skipMigration(stateTx, migrationName, batch, start)
+	}
+
+	relations, err := x.relationsBeforeMigration(tx)
+	if err != nil {
+		return x.newMigrationError(migrationName, Up, batch, err)
+	}
+
+	x.logAtLevel(LogLevelTrace, "Calling up function of %s\n", migrationName)
+	var objects []string
+	if x.objectTracker != nil {
+		x.objectTracker.start(tx)
+	}
+	explain := x.explainer != nil && x.explainer.explains(migration)
+	if explain {
+		x.explainer.start(tx)
+	}
+	var rowsAffected atomic.Int64
+	err = x.runCountedMigrationFunc(tx, migrationName, Up, migration.Up, &rowsAffected)
+	if x.objectTracker != nil {
+		objects = x.objectTracker.stop(tx)
+	}
+	var plans []StatementPlan
+	if explain {
+		plans = x.explainer.stop(tx)
+		x.logPlans(migrationName, plans)
+	}
+	if err == nil {
+		err = x.grantNewRelations(tx, relations)
+	}
+	if err != nil {
+		return x.newMigrationError(migrationName, Up, batch, err)
+	}
+
+	duration := time.Since(start)
+	err = x.insertCompletedMigration(stateTx, migrationName, batch, duration, objects, rowsAffected.Load(), false)
+	if err != nil {
+		return err
+	}
+
+	if rows := rowsAffected.Load(); rows > 0 {
+		x.logAtLevel(LogLevelInfo, "Migrated %s: %d rows affected\n", migrationName, rows)
+	}
+	x.emit(Event{
+		Type:         MigrationCompleted,
+		Direction:    Up,
+		Migration:    migrationName,
+		Batch:        batch,
+		Duration:     duration,
+		RowsAffected: rowsAffected.Load(),
+		Plans:        plans,
+		Memo:         x.memo,
+	})
+	return nil
+}
+
+// skipMigration records a migration whose ShouldRun predicate returned
+// false as applied, but skipped.
+func (x *Migrator) skipMigration(stateTx *pg.Tx, migrationName string, batch int, start time.Time) error {
+	x.logAtLevel(LogLevelInfo, "Skipped %s: ShouldRun returned false\n", migrationName)
+	duration := time.Since(start)
+	err := x.insertCompletedMigration(stateTx, migrationName, batch, duration, nil, 0, true)
+	if err != nil {
+		return err
+	}
+
+	x.emit(Event{
+		Type:      MigrationCompleted,
+		Direction: Up,
+		Migration: migrationName,
+		Batch:     batch,
+		Duration:  duration,
+		Skipped:   true,
+		Memo:      x.memo,
+	})
+	return nil
+}
+
+// revertMigration runs the down function of a registered migration in tx
+// and removes it from the completed migrations in stateTx.
+func (x *Migrator) revertMigration(tx *pg.Tx, stateTx *pg.Tx, migrationName string, batch int) error {
+	migration, exists := x.registry.Get(migrationName)
+	if !exists {
+		return errors.Wrapf(ErrMigrationNotKnown, "migration %s", migrationName)
+	}
+
+	start := time.Now()
+	x.emit(Event{Type: MigrationStarted, Direction: Down, Migration: migrationName, Batch: batch, Memo: x.memo})
+	beat := x.startHeartbeat(migrationName, Down, batch)
+	defer beat.stop()
+	skipped, err := x.wasSkipped(stateTx, migration)
+	if err != nil {
+		return err
+	}
+	if skipped {
+		x.logAtLevel(LogLevelTrace, "Not calling down function of skipped %s\n", migrationName)
+	} else {
+		x.logAtLevel(LogLevelTrace, "Calling down function of %s\n", migrationName)
+		err = x.runMigrationFunc(tx, migrationName, Down, migration.Down)
+		if err == nil {
+			err = x.verifyDown(tx, migration)
+		}
+		if err != nil {
+			return x.newMigrationError(migrationName, Down, batch, err)
+		}
+	}
+
+	err = x.removeRolledbackMigration(stateTx, migrationName)
+	if err != nil {
+		return err
+	}
+
+	x.emit(Event{
+		Type:      MigrationCompleted,
+		Direction: Down,
+		Migration: migrationName,
+		Batch:     batch,
+		Duration:  time.Since(start),
+		Memo:      x.memo,
+	})
+	return nil
+}
+
+func (x *Migrator) removeRolledbackMigration(db Querier, name string) error {
+	x.logAtLevel(LogLevelInfo, "Rolled back %s\n", name)
 	_, err := db.Exec("delete from ? where name = ?", pg.Ident(x.migrationTableName), name)
 	return err
 }
 
-func (x *Migrator) getMigrationsInBatch(db pg.DBI, batch int) ([]string, error) {
+func (x *Migrator) getMigrationsInBatch(db Querier, batch int) ([]string, error) {
 	var results []string
 	_, err := db.Query(
 		&results,
@@ -769,22 +1495,45 @@ func (x *Migrator) getMigrationsInBatch(db pg.DBI, batch int) ([]string, error)
 // Rollback rolls back all migrations in the most recent batch.
 // If the most recent group of migrations was run with MigrateStepByStep,
 // this will only roll back the most recent migration.
-func (x *Migrator) Rollback() error {
+//
+// If the Migrator was created with WithRollbackRiskCheck, the rollback is
+// planned first, and refused if too risky unless WithForce is passed.
+func (x *Migrator) Rollback(opts ...RunOpt) error {
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+	x.invalidateUpToDate()
+
+	options := x.newRunOptions(opts)
+	defer x.applyOverrides(options)()
+
+	var batch, count int
 	db := x.dbFactory()
-	return db.RunInTransaction(
+	err := x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = x.runInTransactions(
 		x.ctx,
-		func(tx *pg.Tx) (err error) {
-			err = x.ensureMigrationTable(tx)
+		db,
+		func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+			err = x.ensureMigrationTable(stateTx)
 			if err != nil {
 				return
 			}
 
-			err = x.maybeLockTable(tx)
+			err = x.maybeLockTable(stateTx)
 			if err != nil {
 				return err
 			}
 
-			completedMigrations, err := x.getCompletedMigrations(tx)
+			completedMigrations, err := x.getCompletedMigrations(stateTx)
 			if err != nil {
 				return err
 			}
@@ -794,12 +1543,19 @@ func (x *Migrator) Rollback() error {
 				return errors.Wrapf(ErrMigrationNotKnown, "unknown migrations: %+v", missingMigrations)
 			}
 
-			batch, err := x.getBatchNumber(tx)
+			if x.rollbackRiskCheck && !options.force {
+				err = x.checkRollbackRisk(stateTx)
+				if err != nil {
+					return err
+				}
+			}
+
+			batch, err = x.getBatchNumber(stateTx)
 			if err != nil {
 				return err
 			}
 
-			migrationsToRun, err := x.getMigrationsInBatch(tx, batch)
+			migrationsToRun, err := x.getMigrationsInBatch(stateTx, batch)
 			if err != nil {
 				return err
 			}
@@ -808,32 +1564,12 @@ func (x *Migrator) Rollback() error {
 				return nil
 			}
 
-			sort.Strings(migrationsToRun)
-			x.logWithMinVerbosity(0, "Batch %d rollback: %d migrations\n", batch, len(migrationsToRun))
+			x.sortMigrations(migrationsToRun)
+			count = len(migrationsToRun)
+			x.logAtLevel(LogLevelInfo, "Batch %d rollback: %d migrations\n", batch, count)
+			x.startBatch(Event{Type: BatchStarted, Direction: Down, Batch: batch, Count: count})
 			for _, migrationName := range migrationsToRun {
-				migration, exists := x.registry.Get(migrationName)
-				if !exists {
-					return errors.Wrapf(ErrMigrationNotKnown, "migration %s", migrationName)
-				}
-
-				switch migrationFunc := migration.Down.(type) {
-				case func(*pg.Tx) error:
-					err = migrationFunc(tx)
-				case func(*pg.Tx, *Context) error:
-					err = migrationFunc(tx, &x.context)
-				default:
-					err = errors.Wrapf(
-						ErrInvalidMigrationFuncRun,
-						"invalid migration function %T",
-						migrationFunc,
-					)
-				}
-				if err != nil {
-					err = errors.Wrapf(err, "%s failed to rollback", migrationName)
-					return err
-				}
-
-				err = x.removeRolledbackMigration(tx, migrationName)
+				err = x.revertMigration(tx, stateTx, migrationName, batch)
 				if err != nil {
 					return err
 				}
@@ -841,44 +1577,113 @@ func (x *Migrator) Rollback() error {
 			return nil
 		},
 	)
+	x.finishRun(err, Down, batch, count)
+	return err
 }
 
 // Create renders the default migration template to the configured migration
 // directory.
 func (x *Migrator) Create(description string) error {
+	return x.CreateAt(time.Now(), description)
+}
+
+// CreateAt renders the default migration template to the configured migration
+// directory, using the given timestamp rather than the current time when
+// generating the migration name.
+//
+// This is useful for tooling which needs to regenerate or back-date
+// migrations, for example when cherry-picking migrations across branches.
+func (x *Migrator) CreateAt(timestamp time.Time, description string) error {
+	return x.createAt(timestamp, description, DefaultMigrationTemplate, nil)
+}
+
+// CreateNamed renders a migration template to the configured migration
+// directory, using fullName verbatim as the migration and file name. No
+// timestamp is added and the naming convention is not applied to the
+// name, although the function name is still derived from it.
+//
+// If template is empty, the default migration template is used.
+func (x *Migrator) CreateNamed(fullName string, template string) error {
+	if len(fullName) == 0 {
+		return ErrNoMigrationName
+	}
+
+	filePath, err := x.createMigrationFile(
+		fullName,
+		convertNameToFuncCase(fullName),
+		template,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	x.logAtLevel(LogLevelInfo, "Created migration %s", filePath)
+	return nil
+}
+
+// createAt renders the given template for a migration with the given
+// timestamp and description, passing params to the template.
+func (x *Migrator) createAt(timestamp time.Time, description string, template string, params map[string]string) error {
 	caser, err := GetCaser(x.migrationNameConvention)
 	if err != nil {
 		return err
 	}
 
-	now := time.Now()
-	filename := caser.ToFileCase(now, description)
-	funcName := caser.ToFuncCase(now, description)
+	filename := caser.ToFileCase(timestamp, description)
+	funcName := caser.ToFuncCase(timestamp, description)
 	filePath, err := x.createMigrationFile(
 		filename,
 		funcName,
-		DefaultMigrationTemplate,
+		template,
+		params,
 	)
 	if err != nil {
 		return err
 	}
 
-	x.logWithMinVerbosity(0, "Created migration %s", filePath)
+	x.logAtLevel(LogLevelInfo, "Created migration %s", filePath)
 	return nil
 }
 
-func (x *Migrator) createMigrationFile(filename, funcName, templateString string) (string, error) {
-	var err error
-	filePath := path.Join(x.migrationDir, filename+".go")
-
-	_, err = os.Stat(filePath)
-	if !os.IsNotExist(err) {
-		err := errors.Wrapf(
-			ErrFileAlreadyExists,
-			"file %s (%v)",
-			filename,
-			err,
-		)
+// createMigrationFile creates the files of a migration in the configured
+// format, along with a test file if enabled with WithCreateTests, and
+// returns the path of the main file.
+func (x *Migrator) createMigrationFile(filename, funcName, templateString string, params map[string]string) (string, error) {
+	err := x.validateName(filename)
+	if err != nil {
+		return "", err
+	}
+
+	if x.createTests {
+		err = x.checkFileNotExists(x.testFilePath(filename))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var filePath string
+	if x.createFormat == SQLPair {
+		filePath, err = x.createSQLMigrationFiles(filename, templateString)
+	} else {
+		filePath, err = x.createGoMigrationFile(filename, funcName, templateString, params)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if x.createTests {
+		err = x.createTestFile(filename, funcName)
+	}
+	return filePath, err
+}
+
+// createGoMigrationFile renders a migration template to a Go file,
+// returning its path.
+func (x *Migrator) createGoMigrationFile(filename, funcName, templateString string, params map[string]string) (string, error) {
+	filePath := filepath.Join(x.migrationDir, filename+".go")
+	err := x.checkFileNotExists(filePath)
+	if err != nil {
 		return "", err
 	}
 
@@ -889,9 +1694,14 @@ func (x *Migrator) createMigrationFile(filename, funcName, templateString string
 	data := map[string]interface{}{
 		"Filename": filename,
 		"FuncName": funcName,
+		"Source":   sourceReference(filePath),
+		"Params":   params,
 	}
 
-	t := template.Must(template.New("template").Parse(templateString))
+	t, err := x.parseMigrationTemplate(templateString)
+	if err != nil {
+		return "", err
+	}
 
 	buf := &bytes.Buffer{}
 	if err := t.Execute(buf, data); err != nil {
@@ -900,9 +1710,19 @@ func (x *Migrator) createMigrationFile(filename, funcName, templateString string
 
 	templateString = buf.String()
 
-	err = os.WriteFile(filePath, []byte(templateString), 0644)
+	bootstrapPath, err := x.maybeCreateBootstrapFile(templateString)
+	if err != nil {
+		return "", err
+	}
+
+	err = x.createFile(filePath, []byte(templateString))
 	if err != nil {
-		return "", errors.Wrap(err, "could not write file")
+		// The bootstrap file is only created for an empty directory, so
+		// it cannot have replaced an existing file.
+		if bootstrapPath != "" {
+			x.removeCreatedFile(bootstrapPath)
+		}
+		return "", err
 	}
 	return filePath, nil
 }
@@ -910,23 +1730,5 @@ func (x *Migrator) createMigrationFile(filename, funcName, templateString string
 // CreateFromTemplate renders a migration template to the configured migration
 // directory.
 func (x *Migrator) CreateFromTemplate(description string, template string) error {
-	caser, err := GetCaser(x.migrationNameConvention)
-	if err != nil {
-		return err
-	}
-
-	now := time.Now()
-	filename := caser.ToFileCase(now, description)
-	funcName := caser.ToFuncCase(now, description)
-	filePath, err := x.createMigrationFile(
-		filename,
-		funcName,
-		template,
-	)
-	if err != nil {
-		return err
-	}
-
-	x.logWithMinVerbosity(0, "Created migration %s", filePath)
-	return nil
+	return x.createAt(time.Now(), description, template, nil)
 }
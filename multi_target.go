@@ -0,0 +1,266 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoTargets indicates that a MultiTargetMigrator was created
+	// without any targets.
+	ErrNoTargets = errors.New("no targets")
+
+	// ErrTargetSkipped indicates that a target was not migrated because
+	// an earlier target failed. See StopOnFailure.
+	ErrTargetSkipped = errors.New("target skipped")
+)
+
+// Target is a DB which a MultiTargetMigrator runs migrations against, such
+// as the DB of one region.
+type Target struct {
+	// Name identifies the target in logs and reports.
+	Name string
+
+	DBFactory DBFactory
+}
+
+// TargetPolicy determines what a MultiTargetMigrator does when a target
+// fails.
+type TargetPolicy byte
+
+const (
+	// StopOnFailure leaves the targets after a failed target untouched.
+	// This is the default.
+	StopOnFailure TargetPolicy = iota
+
+	// ContinueOnFailure runs migrations against every target, whether or
+	// not earlier targets failed.
+	ContinueOnFailure
+)
+
+// TargetResult describes the outcome of a run against one target.
+type TargetResult struct {
+	Target string
+
+	// Applied lists the migrations which were applied, in order, if they
+	// could be worked out.
+	Applied []string
+
+	Duration time.Duration
+
+	// Err is the error returned by the run, or an error wrapping
+	// ErrTargetSkipped if the target was not run.
+	Err error
+}
+
+// MultiTargetReport combines the results of a run against every target, in
+// the order of the targets.
+type MultiTargetReport struct {
+	Results []TargetResult
+}
+
+// Failed returns the results of the targets which failed or were skipped.
+func (x *MultiTargetReport) Failed() []TargetResult {
+	var failed []TargetResult
+	for _, result := range x.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err returns an error listing the targets which failed or were skipped,
+// or nil if every target succeeded. The errors of the targets can be
+// retrieved with errors.Is and errors.As.
+func (x *MultiTargetReport) Err() error {
+	if len(x.Failed()) == 0 {
+		return nil
+	}
+	return &MultiTargetError{Report: x}
+}
+
+// String returns a report of the run, with a line for each target.
+func (x *MultiTargetReport) String() string {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "%d targets, %d failed", len(x.Results), len(x.Failed()))
+	for _, result := range x.Results {
+		switch {
+		case errors.Is(result.Err, ErrTargetSkipped):
+			fmt.Fprintf(builder, "\n  %s: skipped", result.Target)
+		case result.Err != nil:
+			fmt.Fprintf(builder, "\n  %s: failed after %s: %v", result.Target, result.Duration, result.Err)
+		default:
+			fmt.Fprintf(builder, "\n  %s: %d migrations applied in %s", result.Target, len(result.Applied), result.Duration)
+		}
+	}
+	return builder.String()
+}
+
+// MultiTargetError is returned when a run failed against some targets.
+type MultiTargetError struct {
+	Report *MultiTargetReport
+}
+
+// Error returns a message listing the failed targets.
+func (x *MultiTargetError) Error() string {
+	failed := x.Report.Failed()
+	messages := make([]string, 0, len(failed))
+	for _, result := range failed {
+		messages = append(messages, result.Target+": "+result.Err.Error())
+	}
+	return fmt.Sprintf(
+		"%d of %d targets failed: %s",
+		len(failed),
+		len(x.Report.Results),
+		strings.Join(messages, "; "),
+	)
+}
+
+// Unwrap returns the errors of the failed targets.
+func (x *MultiTargetError) Unwrap() []error {
+	failed := x.Report.Failed()
+	errs := make([]error, 0, len(failed))
+	for _, result := range failed {
+		errs = append(errs, result.Err)
+	}
+	return errs
+}
+
+// TargetStatus holds the status of the migrations of one target.
+type TargetStatus struct {
+	Target     string
+	Migrations []MigrationStatus
+
+	// Err is the error which prevented the status being read, if any.
+	Err error
+}
+
+// MultiTargetMigrator runs the same migrations against several DBs in
+// sequence, e.g. one per region, using a Migrator for each.
+type MultiTargetMigrator struct {
+	targets   []Target
+	migrators []*Migrator
+	policy    TargetPolicy
+}
+
+// NewMultiTargetMigrator creates a Migrator for each target with opts, as
+// by NewMigrator, so that the same registry, e.g. given with
+// WithMigrations, is applied to each. policy determines what happens when a
+// target fails.
+func NewMultiTargetMigrator(targets []Target, policy TargetPolicy, opts ...MigratorOpt) (*MultiTargetMigrator, error) {
+	if len(targets) == 0 {
+		return nil, ErrNoTargets
+	}
+
+	migrators := make([]*Migrator, 0, len(targets))
+	for _, target := range targets {
+		migrator, err := NewMigrator(target.DBFactory, opts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "target %s", target.Name)
+		}
+		migrators = append(migrators, migrator)
+	}
+
+	return &MultiTargetMigrator{
+		targets:   targets,
+		migrators: migrators,
+		policy:    policy,
+	}, nil
+}
+
+// Migrator returns the Migrator of the named target, and whether the
+// target exists.
+func (x *MultiTargetMigrator) Migrator(target string) (*Migrator, bool) {
+	for i := range x.targets {
+		if x.targets[i].Name == target {
+			return x.migrators[i], true
+		}
+	}
+	return nil, false
+}
+
+// MigrateBatch runs the pending migrations of each target as a batch, as
+// by Migrator.MigrateBatch, in the order of the targets. The combined
+// report is returned, along with a *MultiTargetError if any target failed.
+func (x *MultiTargetMigrator) MigrateBatch(opts ...RunOpt) (*MultiTargetReport, error) {
+	return x.Run(func(m *Migrator) error {
+		return m.MigrateBatch(opts...)
+	})
+}
+
+// Run calls fn with the Migrator of each target in turn, following the
+// policy of the MultiTargetMigrator when fn fails. The combined report is
+// returned, along with a *MultiTargetError if any target failed.
+func (x *MultiTargetMigrator) Run(fn func(*Migrator) error) (*MultiTargetReport, error) {
+	report := &MultiTargetReport{Results: make([]TargetResult, 0, len(x.targets))}
+	failedTarget := ""
+	for i, target := range x.targets {
+		if failedTarget != "" && x.policy == StopOnFailure {
+			report.Results = append(report.Results, TargetResult{
+				Target: target.Name,
+				Err:    errors.Wrapf(ErrTargetSkipped, "%s failed", failedTarget),
+			})
+			continue
+		}
+
+		result := x.runTarget(target, x.migrators[i], fn)
+		if result.Err != nil && failedTarget == "" {
+			failedTarget = target.Name
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, report.Err()
+}
+
+// runTarget calls fn with the Migrator of a target. The migrations it
+// applied are worked out from the pending migrations before and after.
+func (x *MultiTargetMigrator) runTarget(target Target, migrator *Migrator, fn func(*Migrator) error) TargetResult {
+	migrator.logAtLevel(LogLevelInfo, "Target %s\n", target.Name)
+	result := TargetResult{Target: target.Name}
+
+	before, pendingErr := migrator.Pending()
+	start := time.Now()
+	result.Err = fn(migrator)
+	result.Duration = time.Since(start)
+	if result.Err != nil {
+		migrator.logAtLevel(LogLevelInfo, "Target %s failed: %v\n", target.Name, result.Err)
+	}
+	if pendingErr != nil {
+		return result
+	}
+
+	after, err := migrator.Pending()
+	if err != nil {
+		return result
+	}
+	stillPending := make(map[string]struct{}, len(after))
+	for _, name := range after {
+		stillPending[name] = struct{}{}
+	}
+	for _, name := range before {
+		if _, ok := stillPending[name]; !ok {
+			result.Applied = append(result.Applied, name)
+		}
+	}
+	return result
+}
+
+// Status returns the status of the migrations of every target, in the
+// order of the targets, as by Migrator.Status. Targets whose status could
+// not be read are included with their error.
+func (x *MultiTargetMigrator) Status(opts ...StatusOpt) []TargetStatus {
+	statuses := make([]TargetStatus, 0, len(x.targets))
+	for i, target := range x.targets {
+		migrations, err := x.migrators[i].Status(opts...)
+		statuses = append(statuses, TargetStatus{
+			Target:     target.Name,
+			Migrations: migrations,
+			Err:        err,
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,121 @@
+package migrations
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNameCollision indicates that a migration name shares its
+	// timestamp with another migration, or differs from it only in case or
+	// underscores. See WithCollisionCheck.
+	ErrNameCollision = errors.New("migration name collision")
+)
+
+// CollisionMode determines what a Migrator does when it finds colliding
+// migration names. See WithCollisionCheck.
+type CollisionMode byte
+
+const (
+	// CollisionWarn logs colliding names, and registers the migration as
+	// usual.
+	CollisionWarn CollisionMode = iota
+
+	// CollisionError rejects a migration whose name collides with that of
+	// a registered migration.
+	CollisionError
+)
+
+// timestampPrefixPattern matches the 14 digit timestamp generated by
+// Create at the start of a migration name.
+var timestampPrefixPattern = regexp.MustCompile(`^[0-9]{14}`)
+
+// WithCollisionCheck initialises a Migrator which checks the names of
+// registered migrations for collisions: names which begin with the same
+// timestamp, or which differ only in case or underscores. Such names are
+// usually the result of migrations created on separate branches, and are
+// run in an order which depends on the rest of their names rather than on
+// when they were written.
+//
+// Migrations registered with the Migrator are checked against those
+// already registered, and migrations registered before NewMigrator is
+// called, or copied from another registry, are checked when the Migrator
+// is created. With CollisionWarn, collisions are logged. With
+// CollisionError, they are reported in an error wrapping ErrNameCollision,
+// and the migration is not registered.
+//
+// Intended for use with NewMigrator.
+func WithCollisionCheck(mode CollisionMode) MigratorOpt {
+	return func(x *Migrator) error {
+		x.collisionCheck = true
+		x.collisionMode = mode
+		return nil
+	}
+}
+
+// nameCollision describes how two distinct migration names collide, or
+// returns an empty string if they do not.
+func nameCollision(name string, other string) string {
+	if name == other {
+		return ""
+	}
+	timestamp := timestampPrefixPattern.FindString(name)
+	if timestamp != "" && strings.HasPrefix(other, timestamp) {
+		return "shares timestamp " + timestamp + " with " + other
+	}
+	if foldName(name) == foldName(other) {
+		return "differs only in case or underscores from " + other
+	}
+	return ""
+}
+
+// foldName lowercases a migration name and removes its underscores, so
+// that names differing only in these respects compare equal.
+func foldName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "")
+}
+
+// checkNameCollisions checks name against the names of other migrations,
+// logging or returning the collisions according to the collision mode of
+// the Migrator.
+func (x *Migrator) checkNameCollisions(name string, others []string) error {
+	var collisions []string
+	for _, other := range others {
+		collision := nameCollision(name, other)
+		if collision != "" {
+			collisions = append(collisions, collision)
+		}
+	}
+	if len(collisions) == 0 {
+		return nil
+	}
+
+	if x.collisionMode == CollisionError {
+		return errors.Wrapf(
+			ErrNameCollision,
+			"%s %s",
+			name,
+			strings.Join(collisions, ", "),
+		)
+	}
+	for _, collision := range collisions {
+		x.logAtLevel(LogLevelInfo, "Warning: migration %s %s\n", name, collision)
+	}
+	return nil
+}
+
+// checkRegisteredNameCollisions checks the names of all migrations which
+// are already registered for collisions with each other. Each pair of
+// colliding names is reported once.
+func (x *Migrator) checkRegisteredNameCollisions() error {
+	names := x.registry.List()
+	for i, name := range names {
+		err := x.checkNameCollisions(name, names[i+1:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
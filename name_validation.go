@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidMigrationName indicates that a migration name was rejected
+	// by the name validation of a Migrator.
+	ErrInvalidMigrationName = errors.New("invalid migration name")
+)
+
+// TimestampNamePattern matches names which begin with the 14 digit
+// timestamp generated by Create, followed by the end of the name, an
+// underscore or an uppercase letter. Names which match will sort in the
+// order they were created.
+var TimestampNamePattern = regexp.MustCompile(`^[0-9]{14}(?:$|_|[A-Z])`)
+
+// NameValidator checks whether a migration name is acceptable, returning
+// an error if it is not.
+type NameValidator func(name string) error
+
+// MatchNamePattern returns a NameValidator which accepts names matching
+// pattern.
+func MatchNamePattern(pattern *regexp.Regexp) NameValidator {
+	return func(name string) error {
+		if !pattern.MatchString(name) {
+			return errors.Wrapf(
+				ErrInvalidMigrationName,
+				"%s does not match %s",
+				name,
+				pattern,
+			)
+		}
+		return nil
+	}
+}
+
+// WithNameValidation initialises a Migrator which checks the names of
+// migrations with validator. Migrations registered with the Migrator or
+// copied from another registry, and migration files generated by Create,
+// are rejected if their names are not accepted. The initial migrations are
+// exempt from validation.
+//
+// Use MatchNamePattern(TimestampNamePattern) to enforce the names generated
+// by Create, since lexical sorting silently misorders other names.
+//
+// Intended for use with NewMigrator.
+func WithNameValidation(validator NameValidator) MigratorOpt {
+	return func(x *Migrator) error {
+		x.nameValidator = validator
+		return nil
+	}
+}
+
+// validateName checks a migration name against the name validation of
+// the Migrator, if any.
+func (x *Migrator) validateName(name string) error {
+	if x.nameValidator == nil {
+		return nil
+	}
+	for _, initialMigration := range x.initialMigrations {
+		if name == initialMigration {
+			return nil
+		}
+	}
+	return x.nameValidator(name)
+}
+
+// validateRegisteredNames checks the names of all migrations which are
+// already registered.
+func (x *Migrator) validateRegisteredNames() error {
+	for _, name := range x.registry.List() {
+		err := x.validateName(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
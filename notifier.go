@@ -0,0 +1,269 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrWebhookFailed indicates that a webhook did not accept a
+	// notification.
+	ErrWebhookFailed = errors.New("webhook failed")
+)
+
+// DefaultWebhookTimeout is the time allowed for a webhook request when the
+// WebhookNotifier has no client of its own.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// NotificationType indicates which stage of a batch a Notification
+// describes.
+type NotificationType byte
+
+const (
+	// BatchStartedNotification is sent when a batch is about to run.
+	BatchStartedNotification NotificationType = iota
+
+	// BatchSucceededNotification is sent once a batch has been committed.
+	BatchSucceededNotification
+
+	// BatchFailedNotification is sent when a run fails.
+	BatchFailedNotification
+)
+
+// String returns "started", "succeeded" or "failed".
+func (x NotificationType) String() string {
+	switch x {
+	case BatchStartedNotification:
+		return "started"
+	case BatchSucceededNotification:
+		return "succeeded"
+	case BatchFailedNotification:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Notification summarises a batch of migrations for a Notifier.
+type Notification struct {
+	Type      NotificationType
+	Direction Direction
+	Batch     int
+	Time      time.Time
+
+	// Count is the number of migrations in the batch.
+	Count int
+
+	// Migrations lists the migrations which completed, in order. It is
+	// empty when the batch starts.
+	Migrations []string
+
+	// Duration is the time since the batch started, if it started.
+	Duration time.Duration
+
+	// Err is the error which caused the run to fail.
+	Err error
+}
+
+// Summary returns a one-line description of the notification, e.g.
+// "Batch 3 up succeeded: 2 migrations in 1.5s (a, b)".
+func (x Notification) Summary() string {
+	summary := fmt.Sprintf("Batch %d %s %s", x.Batch, x.Direction, x.Type)
+	switch x.Type {
+	case BatchStartedNotification:
+		summary += fmt.Sprintf(": %d migrations", x.Count)
+	case BatchSucceededNotification:
+		summary += fmt.Sprintf(": %d migrations in %s", len(x.Migrations), x.Duration.Round(time.Millisecond))
+	case BatchFailedNotification:
+		summary += fmt.Sprintf(": %v", x.Err)
+	}
+	if len(x.Migrations) > 0 {
+		summary += " (" + strings.Join(x.Migrations, ", ") + ")"
+	}
+	return summary
+}
+
+// Notifier is told when batches of migrations start, succeed and fail,
+// e.g. to annotate dashboards or post to chat. See WithNotifier.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// NotifierFunc adapts a function to a Notifier.
+type NotifierFunc func(ctx context.Context, notification Notification) error
+
+// Interface Compliance
+var _ Notifier = NotifierFunc(nil)
+
+// Notify calls the function.
+func (x NotifierFunc) Notify(ctx context.Context, notification Notification) error {
+	return x(ctx, notification)
+}
+
+// WithNotifier initialises a Migrator which calls notifier when each batch
+// of migrations starts, succeeds or fails. The notifier is called from the
+// goroutine running the migrations, as for event handlers. Errors returned
+// by the notifier are logged, and do not affect the run. May be used
+// multiple times to add several notifiers.
+//
+// Intended for use with NewMigrator.
+func WithNotifier(notifier Notifier) MigratorOpt {
+	return func(x *Migrator) error {
+		x.eventHandlers = append(x.eventHandlers, x.notifierHandler(notifier))
+		return nil
+	}
+}
+
+// notifierHandler returns an EventHandler which sends notifications to
+// notifier, collecting the migrations completed in each batch.
+func (x *Migrator) notifierHandler(notifier Notifier) EventHandler {
+	var mtx sync.Mutex
+	var started time.Time
+	var completed []string
+	return func(event Event) {
+		notification := Notification{
+			Direction: event.Direction,
+			Batch:     event.Batch,
+			Time:      event.Time,
+			Count:     event.Count,
+		}
+
+		mtx.Lock()
+		switch event.Type {
+		case BatchStarted:
+			started = event.Time
+			completed = nil
+			notification.Type = BatchStartedNotification
+		case MigrationCompleted:
+			completed = append(completed, event.Migration)
+			mtx.Unlock()
+			return
+		case BatchCompleted, ErrorOccurred:
+			notification.Type = BatchSucceededNotification
+			if event.Type == ErrorOccurred {
+				notification.Type = BatchFailedNotification
+				notification.Err = event.Err
+			}
+			notification.Migrations = append([]string(nil), completed...)
+			if !started.IsZero() {
+				notification.Duration = event.Time.Sub(started)
+			}
+			started = time.Time{}
+			completed = nil
+		default:
+			mtx.Unlock()
+			return
+		}
+		mtx.Unlock()
+
+		// Failures are still reported after the run has been cancelled.
+		err := notifier.Notify(context.WithoutCancel(x.ctx), notification)
+		if err != nil {
+			x.logAtLevel(LogLevelError, "Notification failed: %v\n", err)
+		}
+	}
+}
+
+// WebhookNotifier is a Notifier which posts each notification as JSON to
+// a URL. The payload includes a "text" field holding the summary of the
+// notification, so it can be sent directly to a Slack incoming webhook, as
+// well as the details of the notification for other receivers:
+//
+//	{
+//		"text": "Batch 3 up succeeded: 2 migrations in 1.5s (a, b)",
+//		"type": "succeeded",
+//		"direction": "up",
+//		"batch": 3,
+//		"count": 2,
+//		"migrations": ["a", "b"],
+//		"duration_ms": 1500,
+//		"error": "",
+//		"time": "2024-01-02T15:04:05Z"
+//	}
+type WebhookNotifier struct {
+	// URL is the URL to post to.
+	URL string
+
+	// Headers are added to each request, e.g. for authorization.
+	Headers http.Header
+
+	// Client is used to send requests. If nil, a client with a timeout
+	// of DefaultWebhookTimeout is used.
+	Client *http.Client
+}
+
+// Interface Compliance
+var _ Notifier = (*WebhookNotifier)(nil)
+
+// webhookPayload is the JSON posted by a WebhookNotifier.
+type webhookPayload struct {
+	Text       string    `json:"text"`
+	Type       string    `json:"type"`
+	Direction  string    `json:"direction"`
+	Batch      int       `json:"batch"`
+	Count      int       `json:"count"`
+	Migrations []string  `json:"migrations"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error"`
+	Time       time.Time `json:"time"`
+}
+
+// Notify posts the notification to the URL, returning an error wrapping
+// ErrWebhookFailed if the response status is not 2xx.
+func (x *WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	payload := webhookPayload{
+		Text:       notification.Summary(),
+		Type:       notification.Type.String(),
+		Direction:  notification.Direction.String(),
+		Batch:      notification.Batch,
+		Count:      notification.Count,
+		Migrations: notification.Migrations,
+		DurationMs: notification.Duration.Milliseconds(),
+		Time:       notification.Time,
+	}
+	if payload.Migrations == nil {
+		payload.Migrations = []string{}
+	}
+	if notification.Err != nil {
+		payload.Error = notification.Err.Error()
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, x.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range x.Headers {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := x.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return errors.Wrapf(ErrWebhookFailed, "%v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.Wrapf(ErrWebhookFailed, "status %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package migrations
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithObjectTracking initialises a Migrator which records the tables and
+// other objects each migration touched, in the objects column of the
+// migration table. They are returned by History, and are used by
+// WithRollbackSafetyCheck for migrations registered as Go functions, whose
+// SQL cannot otherwise be analysed.
+//
+// Objects are found by observing the statements each migration runs in
+// its transaction, with a query hook added as by WithQueryHook, and
+// extracting the names which follow keywords such as TABLE, INTO and ON.
+// Names are lowercased and stripped of the public schema. Statements built
+// dynamically in the DB, such as in DO blocks, are not seen.
+//
+// Intended for use with NewMigrator.
+func WithObjectTracking() MigratorOpt {
+	return func(x *Migrator) error {
+		if x.objectTracker == nil {
+			x.objectTracker = &objectTracker{objects: make(map[*pg.Tx]map[string]struct{})}
+			x.queryHooks = append(x.queryHooks, x.objectTracker)
+		}
+		return nil
+	}
+}
+
+// objectTracker is a query hook which collects the objects referenced by
+// the statements run in the transactions of running migrations.
+type objectTracker struct {
+	mtx     sync.Mutex
+	objects map[*pg.Tx]map[string]struct{}
+}
+
+// Interface Compliance
+var _ pg.QueryHook = (*objectTracker)(nil)
+
+// BeforeQuery does nothing.
+func (x *objectTracker) BeforeQuery(ctx context.Context, _ *pg.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+// AfterQuery records the objects referenced by a successful statement, if
+// it was run in a tracked transaction.
+func (x *objectTracker) AfterQuery(_ context.Context, event *pg.QueryEvent) error {
+	tx, ok := event.DB.(*pg.Tx)
+	if !ok || event.Err != nil {
+		return nil
+	}
+
+	x.mtx.Lock()
+	objects, tracked := x.objects[tx]
+	x.mtx.Unlock()
+	if !tracked {
+		return nil
+	}
+
+	query, err := event.FormattedQuery()
+	if err != nil {
+		return nil
+	}
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	for object := range referencedObjects(string(query)) {
+		objects[object] = struct{}{}
+	}
+	return nil
+}
+
+// start begins collecting the objects referenced in tx.
+func (x *objectTracker) start(tx *pg.Tx) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.objects[tx] = make(map[string]struct{})
+}
+
+// stop ends collecting the objects referenced in tx, returning them
+// sorted.
+func (x *objectTracker) stop(tx *pg.Tx) []string {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	objects := make([]string, 0, len(x.objects[tx]))
+	for object := range x.objects[tx] {
+		objects = append(objects, object)
+	}
+	delete(x.objects, tx)
+	sort.Strings(objects)
+	return objects
+}
@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"sort"
+	"strings"
+)
+
+// Ordering determines the order in which migrations are run. It reports
+// whether the migration named a should run before the one named b.
+type Ordering func(a string, b string) bool
+
+// ByName orders migrations lexicographically by name. This is the default.
+func ByName(a string, b string) bool {
+	return a < b
+}
+
+// ByTimestampPrefix orders migrations by the number formed by the leading
+// digits of their names, falling back to their names when the numbers are
+// equal. Unlike ByName, this is unaffected by prefixes of different
+// lengths, or by whatever follows the prefix. Names without a numeric
+// prefix are run after all names with one.
+func ByTimestampPrefix(a string, b string) bool {
+	aPrefix, aFound := timestampPrefix(a)
+	bPrefix, bFound := timestampPrefix(b)
+	switch {
+	case aFound != bFound:
+		return aFound
+	case len(aPrefix) != len(bPrefix):
+		return len(aPrefix) < len(bPrefix)
+	case aPrefix != bPrefix:
+		return aPrefix < bPrefix
+	default:
+		return a < b
+	}
+}
+
+// Custom orders migrations using the given function, which reports whether
+// the migration named a should run before the one named b. It must define
+// a strict weak ordering, as required by sort.Slice.
+func Custom(less func(a string, b string) bool) Ordering {
+	return Ordering(less)
+}
+
+// WithOrdering initialises a Migrator which runs pending migrations in
+// the given order (default: ByName).
+//
+// Intended for use with NewMigrator.
+func WithOrdering(ordering Ordering) MigratorOpt {
+	return func(x *Migrator) error {
+		x.ordering = ordering
+		return nil
+	}
+}
+
+// sortMigrations sorts migration names using the ordering of the Migrator.
+func (x *Migrator) sortMigrations(names []string) {
+	ordering := x.ordering
+	if ordering == nil {
+		ordering = ByName
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		return ordering(names[i], names[j])
+	})
+}
+
+// timestampPrefix returns the leading digits of name without any leading
+// zeros, so that prefixes can be compared by length and then lexically,
+// and whether there were any leading digits.
+func timestampPrefix(name string) (string, bool) {
+	end := 0
+	for end < len(name) && name[end] >= '0' && name[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return "", false
+	}
+	return strings.TrimLeft(name[:end], "0"), true
+}
@@ -0,0 +1,75 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PacingFunc is called between two migrations of a run which commits each
+// migration separately, once the previous migration has been committed.
+// It may block, e.g. until replicas have caught up, and should return
+// promptly once ctx is done. Returning an error stops the run before the
+// next migration.
+type PacingFunc func(ctx context.Context, previous string, next string) error
+
+// WithInterMigrationDelay initialises a Migrator which waits for the given
+// duration between migrations run by MigrateStepByStep, or by MigrateBatch
+// with TxPerMigration, e.g. to let replication catch up on a busy cluster
+// before the next DDL. The wait ends early if the context of the Migrator
+// is cancelled, interrupting the run.
+//
+// Intended for use with NewMigrator.
+func WithInterMigrationDelay(delay time.Duration) MigratorOpt {
+	return func(x *Migrator) error {
+		x.interMigrationDelay = delay
+		return nil
+	}
+}
+
+// WithPacing initialises a Migrator which calls fn between migrations run
+// by MigrateStepByStep, or by MigrateBatch with TxPerMigration, after any
+// delay set with WithInterMigrationDelay and the replication lag check
+// enabled with WithReplicationLagCheck.
+//
+// Intended for use with NewMigrator.
+func WithPacing(fn PacingFunc) MigratorOpt {
+	return func(x *Migrator) error {
+		x.pacing = fn
+		return nil
+	}
+}
+
+// pace waits between two migrations which are committed separately. If
+// the context of the Migrator is cancelled while waiting, nil is returned
+// so that the run is interrupted as usual.
+func (x *Migrator) pace(previous string, next string) error {
+	if x.interMigrationDelay > 0 {
+		x.logAtLevel(LogLevelDebug, "Waiting %s before %s\n", x.interMigrationDelay, next)
+		timer := time.NewTimer(x.interMigrationDelay)
+		select {
+		case <-x.ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+
+	err := x.checkReplicationLag()
+	if err != nil {
+		if x.ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	if x.pacing == nil {
+		return nil
+	}
+	err = x.pacing(x.ctx, previous, next)
+	if err != nil && x.ctx.Err() == nil {
+		return errors.Wrapf(err, "pacing before %s", next)
+	}
+	return nil
+}
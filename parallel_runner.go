@@ -0,0 +1,311 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrDependencyCycle indicates that the dependencies declared by
+	// pending migrations can never be satisfied.
+	ErrDependencyCycle = errors.New("migration dependency cycle")
+
+	// ErrParallelLocking indicates that MigrateParallel was called on a
+	// Migrator which does not lock runs with an advisory lock.
+	ErrParallelLocking = errors.New("parallel runs require an advisory lock")
+)
+
+// WithParallelism sets the maximum number of migrations which will be
+// run concurrently by MigrateParallel. The DB pool should allow at least
+// one more connection than this.
+//
+// Intended for use with NewMigrator.
+func WithParallelism(workers uint) MigratorOpt {
+	return func(x *Migrator) error {
+		x.parallelism = int(workers)
+		return nil
+	}
+}
+
+// MigrateParallel runs any migrations against the DB which have not been
+// run yet, running migrations which are independent of each other
+// concurrently. All migrations are marked as belonging to the same batch,
+// but each is run in its own transaction on its own connection.
+//
+// Migrations declare their dependencies with DependsOn. A migration without
+// declared dependencies waits for every migration sorted before it, and
+// every migration sorted after it waits for it.
+//
+// As the migration table cannot be locked by concurrent transactions, the
+// Migrator must lock runs with an AdvisoryLocker, or a TxAdvisoryLocker
+// with WithTransactionPooling, which is then held for the duration of the
+// run. Every other process running migrations against the DB must use one
+// too, since locking the migration table in each transaction does not
+// exclude a parallel run. An error wrapping ErrParallelLocking is returned
+// otherwise. If any migration fails, no further migrations are started, and the first
+// error is returned once running migrations have finished.
+func (x *Migrator) MigrateParallel() error {
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+
+	switch x.locker.(type) {
+	case *AdvisoryLocker, TxAdvisoryLocker:
+	case nil:
+		return errors.Wrap(ErrParallelLocking, "no locker is configured")
+	default:
+		return errors.Wrapf(ErrParallelLocking, "locked with %s", lockerName(x.locker))
+	}
+
+	db := x.dbFactory()
+	skip, err := x.skipIfUpToDate(x.stateDB())
+	if err != nil || skip {
+		return err
+	}
+
+	err = x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// An AdvisoryLocker already holds the advisory lock for the run, but
+	// a TxAdvisoryLocker only takes it in each transaction.
+	if _, ok := x.locker.(TxAdvisoryLocker); ok {
+		releaseAdvisoryLock, err := x.holdRunAdvisoryLock()
+		if err != nil {
+			return errors.Wrap(x.annotateError(err), "could not acquire advisory lock")
+		}
+		defer releaseAdvisoryLock()
+	}
+
+	var migrationsToRun, awaiting []string
+	var batch int
+	err = x.stateDB().RunInTransaction(
+		x.ctx,
+		func(tx *pg.Tx) (err error) {
+			err = x.setLockTimeout(tx)
+			if err != nil {
+				return err
+			}
+
+			err = x.ensureMigrationTable(tx)
+			if err != nil {
+				return
+			}
+
+			// The migration table is not locked, since the advisory lock
+			// is already held for the run, and a TxAdvisoryLocker taking
+			// it again would block forever.
+			migrationsToRun, err = x.getMigrationsToRun(tx)
+			if err != nil {
+				return err
+			}
+			migrationsToRun, awaiting = x.withholdUnapproved(migrationsToRun)
+
+			err = x.checkRunWindow(migrationsToRun)
+			if err != nil {
+				return err
+			}
+
+			err = x.checkRequiredPrivileges(db.WithContext(x.ctx), migrationsToRun)
+			if err != nil {
+				return err
+			}
+
+			batch, err = x.getBatchNumber(tx)
+			return err
+		},
+	)
+	if err != nil {
+		x.finishRun(err, Up, 0, 0)
+		return err
+	}
+
+	if len(migrationsToRun) == 0 {
+		if len(awaiting) > 0 {
+			return nil
+		}
+		return x.runRepeatables(db)
+	}
+
+	batch++
+	dependencies, err := x.buildDependencyGraph(migrationsToRun)
+	if err != nil {
+		x.finishRun(err, Up, batch, 0)
+		return err
+	}
+
+	artifact, err := x.maybeBackup(db, migrationsToRun)
+	if err != nil {
+		x.finishRun(err, Up, batch, 0)
+		return err
+	}
+
+	err = x.ensureExtensions(db.WithContext(x.ctx))
+	if err != nil {
+		x.finishRun(err, Up, batch, 0)
+		return err
+	}
+
+	workers := x.parallelism
+	if workers < 1 {
+		workers = 1
+	}
+
+	x.logAtLevel(LogLevelInfo, "Batch %d run: %d migrations, %d workers\n", batch, len(migrationsToRun), workers)
+	x.startBatch(Event{Type: BatchStarted, Direction: Up, Batch: batch, Count: len(migrationsToRun)})
+
+	// dependents maps each migration to the migrations waiting on it, and
+	// waitingOn counts the unfinished dependencies of each migration.
+	dependents := make(map[string][]string, len(migrationsToRun))
+	waitingOn := make(map[string]int, len(migrationsToRun))
+	var ready []string
+	for _, migrationName := range migrationsToRun {
+		waitingOn[migrationName] = len(dependencies[migrationName])
+		for _, dependency := range dependencies[migrationName] {
+			dependents[dependency] = append(dependents[dependency], migrationName)
+		}
+		if waitingOn[migrationName] == 0 {
+			ready = append(ready, migrationName)
+		}
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result)
+	var inFlight, completed int
+	var firstErr error
+	for {
+		for firstErr == nil && len(ready) > 0 && inFlight < workers {
+			migrationName := ready[0]
+			ready = ready[1:]
+			inFlight++
+			go func() {
+				results <- result{
+					name: migrationName,
+					err:  x.runParallelMigration(db, migrationName, batch),
+				}
+			}()
+		}
+
+		if inFlight == 0 {
+			break
+		}
+
+		r := <-results
+		inFlight--
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+
+		completed++
+		for _, dependent := range dependents[r.name] {
+			waitingOn[dependent]--
+			if waitingOn[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+		x.sortMigrations(ready)
+	}
+
+	if completed > 0 {
+		err = x.recordBackup(x.stateDB(), batch, artifact)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil && completed < len(migrationsToRun) {
+		firstErr = errors.Wrapf(
+			ErrDependencyCycle,
+			"%d migrations could not be scheduled",
+			len(migrationsToRun)-completed,
+		)
+	}
+	x.finishRun(firstErr, Up, batch, completed)
+	if firstErr != nil {
+		return firstErr
+	}
+
+	err = x.runMaintenance(db, migrationsToRun)
+	if err != nil || len(awaiting) > 0 {
+		return err
+	}
+	return x.runRepeatables(db)
+}
+
+// runParallelMigration runs a single up migration in its own transaction
+// and records it as part of the given batch.
+func (x *Migrator) runParallelMigration(db *pg.DB, migrationName string, batch int) error {
+	return x.runInTransactions(
+		x.ctx,
+		db,
+		func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+			x.logAtLevel(LogLevelDebug, "Batch %d run: migration %s\n", batch, migrationName)
+			return x.applyMigration(tx, stateTx, migrationName, batch)
+		},
+	)
+}
+
+// buildDependencyGraph returns the pending migrations which each pending
+// migration must wait for. Dependencies which have already been run are
+// omitted.
+//
+// Migrations without declared dependencies act as barriers: they wait for
+// every migration sorted before them, and every migration sorted after them
+// waits for them.
+func (x *Migrator) buildDependencyGraph(migrationsToRun []string) (map[string][]string, error) {
+	pending := make(map[string]struct{}, len(migrationsToRun))
+	for _, migrationName := range migrationsToRun {
+		pending[migrationName] = struct{}{}
+	}
+
+	dependencies := make(map[string][]string, len(migrationsToRun))
+	var barrier string
+	var sinceBarrier []string
+	for _, migrationName := range migrationsToRun {
+		migration, exists := x.registry.Get(migrationName)
+		if !exists {
+			return nil, errors.Wrapf(ErrMigrationNotKnown, "migration %s", migrationName)
+		}
+
+		var waitFor []string
+		if barrier != "" {
+			waitFor = append(waitFor, barrier)
+		}
+
+		if migration.DependsOn == nil {
+			waitFor = append(waitFor, sinceBarrier...)
+			barrier = migrationName
+			sinceBarrier = nil
+		} else {
+			for _, dependency := range migration.DependsOn {
+				if _, exists := x.registry.Get(dependency); !exists {
+					return nil, errors.Wrapf(
+						ErrMigrationNotKnown,
+						"dependency %s of %s",
+						dependency,
+						migrationName,
+					)
+				}
+				if _, ok := pending[dependency]; ok && dependency != barrier {
+					waitFor = append(waitFor, dependency)
+				}
+			}
+			sinceBarrier = append(sinceBarrier, migrationName)
+		}
+
+		dependencies[migrationName] = waitFor
+	}
+
+	return dependencies, nil
+}
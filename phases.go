@@ -0,0 +1,141 @@
+package migrations
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnknownPhase indicates that MigratePhase was given a phase which
+	// the Migrator does not know. See WithPhases.
+	ErrUnknownPhase = errors.New("unknown phase")
+
+	// ErrEarlierPhasePending indicates that MigratePhase was not run
+	// because migrations in an earlier phase are still pending.
+	ErrEarlierPhasePending = errors.New("migrations in an earlier phase are pending")
+)
+
+// The phases of a deploy known to a Migrator by default, in order. See
+// WithPhases.
+const (
+	PhasePreDeploy  = "pre-deploy"
+	PhaseDeploy     = "deploy"
+	PhasePostDeploy = "post-deploy"
+)
+
+// DefaultPhase is the phase of migrations registered without Phase.
+const DefaultPhase = PhaseDeploy
+
+// DefaultPhases are the phases known to a Migrator unless set with
+// WithPhases.
+var DefaultPhases = []string{PhasePreDeploy, PhaseDeploy, PhasePostDeploy}
+
+// Phase places a migration in the named phase of a deploy, so that it is
+// run by MigratePhase for that phase, e.g. PhasePreDeploy for changes
+// which the old and new versions of an application both work with, and
+// PhasePostDeploy for those which only the new version works with.
+// Migrations registered without Phase belong to DefaultPhase.
+//
+// Phases only limit MigratePhase. MigrateBatch and the other runs apply
+// pending migrations of every phase.
+func Phase(name string) MigrationOpt {
+	return func(x *migration) error {
+		x.Phase = name
+		return nil
+	}
+}
+
+// phase returns the phase the migration belongs to.
+func (x migration) phase() string {
+	if x.Phase == "" {
+		return DefaultPhase
+	}
+	return x.Phase
+}
+
+// WithPhases initialises a Migrator which knows the given phases, in the
+// order they are deployed (default: DefaultPhases). See Phase and
+// MigratePhase.
+//
+// Intended for use with NewMigrator.
+func WithPhases(phases ...string) MigratorOpt {
+	return func(x *Migrator) error {
+		x.phases = phases
+		return nil
+	}
+}
+
+// knownPhases returns the phases known to the Migrator, in order.
+func (x *Migrator) knownPhases() []string {
+	if x.phases == nil {
+		return DefaultPhases
+	}
+	return x.phases
+}
+
+// MigratePhase runs the pending migrations in the given phase as a single
+// batch, as MigrateBatch would. Each phase may be run on its own, e.g.
+// pre-deploy migrations before a blue/green switch and post-deploy
+// migrations once the old version has been retired.
+//
+// If migrations in an earlier phase are pending, nothing is run and an
+// error wrapping ErrEarlierPhasePending is returned. Migrations in later
+// phases are left pending, as are repeatable migrations until no
+// migrations are pending.
+//
+// The migrations run may be limited further with opts, such as WithTags.
+func (x *Migrator) MigratePhase(phase string, opts ...RunOpt) error {
+	phases := x.knownPhases()
+	index := -1
+	for i, name := range phases {
+		if name == phase {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return errors.Wrapf(ErrUnknownPhase, "%q, expected one of %s", phase, strings.Join(phases, ", "))
+	}
+
+	earlierPhases := phases[:index]
+	return x.MigrateBatch(append(opts, func(options *runOptions) {
+		options.phase = phase
+		options.earlierPhases = earlierPhases
+	})...)
+}
+
+// isEarlierPhase reports whether phase comes before the phase of the run.
+func (x runOptions) isEarlierPhase(phase string) bool {
+	for _, earlier := range x.earlierPhases {
+		if earlier == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// selectPhase returns the pending migrations in the phase of the run,
+// keeping their order, or an error if any are in an earlier phase.
+func (x runOptions) selectPhase(registry *Registry, pending []string) ([]string, error) {
+	var selected, earlier []string
+	for _, name := range pending {
+		migration, _ := registry.Get(name)
+		switch phase := migration.phase(); {
+		case phase == x.phase:
+			selected = append(selected, name)
+		case x.isEarlierPhase(phase):
+			earlier = append(earlier, name)
+		}
+	}
+
+	if len(earlier) > 0 {
+		return nil, errors.Wrapf(
+			ErrEarlierPhasePending,
+			"cannot run phase %s: %s",
+			x.phase,
+			strings.Join(earlier, ", "),
+		)
+	}
+	return selected, nil
+}
@@ -0,0 +1,184 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+// Manifest lists the migrations in a registry, so that the migrations of
+// two builds can be compared with DiffManifests without loading both into
+// one binary. It is intended to be stored as JSON alongside each build.
+type Manifest struct {
+	Migrations []ManifestEntry `json:"migrations"`
+}
+
+// ManifestEntry describes a migration in a Manifest.
+type ManifestEntry struct {
+	Name string `json:"name"`
+
+	// Repeatable indicates a repeatable migration.
+	Repeatable bool `json:"repeatable,omitempty"`
+
+	// Checksum identifies the content of the migration: a hash of the
+	// SQL of migrations registered from SQL, or the declared checksum of
+	// a repeatable migration. It is empty for migrations registered as Go
+	// functions, whose content cannot be compared.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Tags lists the tags attached to the migration. See Tags.
+	Tags []string `json:"tags,omitempty"`
+
+	// DependsOn lists the declared dependencies of the migration. See
+	// DependsOn.
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// Version is the release the migration belongs to, if declared. See
+	// Version.
+	Version string `json:"version,omitempty"`
+
+	// RequiresApproval indicates that the migration is only run by
+	// ApproveAndRun. See RequiresApproval.
+	RequiresApproval bool `json:"requires_approval,omitempty"`
+
+	// Conditional indicates that the migration is only run if its
+	// predicate allows it. See ShouldRun.
+	Conditional bool `json:"conditional,omitempty"`
+
+	// Phase is the phase of a deploy the migration belongs to, if
+	// declared. See Phase.
+	Phase string `json:"phase,omitempty"`
+}
+
+// PlanDiff describes the differences between the migrations of two
+// registries or manifests. Each list is sorted by name.
+type PlanDiff struct {
+	// Added lists the migrations which are only in the new registry.
+	// Those not yet applied to a DB will be run when it is migrated.
+	Added []string
+
+	// Removed lists the migrations which are only in the old registry.
+	// A DB to which they were applied cannot be migrated by the new
+	// registry until they are registered again.
+	Removed []string
+
+	// Changed lists the migrations in both registries whose content has
+	// changed. Changed repeatable migrations are run again, but changes
+	// to other migrations are not applied to DBs which already ran them.
+	Changed []string
+}
+
+// IsEmpty reports whether the registries have the same migrations.
+func (x PlanDiff) IsEmpty() bool {
+	return len(x.Added) == 0 && len(x.Removed) == 0 && len(x.Changed) == 0
+}
+
+// Manifest returns a Manifest of the migrations in the registry, sorted by
+// name with repeatable migrations last.
+func (x *Registry) Manifest() Manifest {
+	x.mtx.RLock()
+	defer x.mtx.RUnlock()
+
+	entries := make([]ManifestEntry, 0, len(x.allMigrations)+len(x.repeatables))
+	for _, m := range x.allMigrations {
+		entry := newManifestEntry(m)
+		entry.Checksum = sqlChecksum(m)
+		entries = append(entries, entry)
+	}
+	repeatables := make([]ManifestEntry, 0, len(x.repeatables))
+	for _, m := range x.repeatables {
+		entry := newManifestEntry(m)
+		entry.Repeatable = true
+		entry.Checksum = m.Checksum
+		repeatables = append(repeatables, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	sort.Slice(repeatables, func(i, j int) bool {
+		return repeatables[i].Name < repeatables[j].Name
+	})
+	return Manifest{Migrations: append(entries, repeatables...)}
+}
+
+// newManifestEntry returns the entry describing a migration, without its
+// checksum.
+func newManifestEntry(m migration) ManifestEntry {
+	return ManifestEntry{
+		Name:             m.Name,
+		Tags:             append([]string(nil), m.Tags...),
+		DependsOn:        append([]string(nil), m.DependsOn...),
+		Version:          m.Version,
+		RequiresApproval: m.RequiresApproval,
+		Conditional:      m.ShouldRun != nil,
+		Phase:            m.Phase,
+	}
+}
+
+// MarshalJSON encodes the registry as its Manifest, so that tools in
+// other processes can read the registered migrations. Functions are not
+// encoded, so a registry cannot be decoded again.
+func (x *Registry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(x.Manifest())
+}
+
+// Manifest returns a Manifest of the migrations registered with the
+// Migrator. See Registry.Manifest.
+func (x *Migrator) Manifest() Manifest {
+	return x.registry.Manifest()
+}
+
+// DiffRegistries returns the migrations which were added, removed or
+// changed in newRegistry compared to oldRegistry. This can be used to show
+// what a deploy will change before it is rolled out.
+func DiffRegistries(oldRegistry *Registry, newRegistry *Registry) PlanDiff {
+	return DiffManifests(oldRegistry.Manifest(), newRegistry.Manifest())
+}
+
+// DiffManifests returns the migrations which were added, removed or
+// changed in newManifest compared to oldManifest. See DiffRegistries.
+//
+// A migration is changed if its checksum differs, or if it became or
+// stopped being repeatable. Migrations registered as Go functions are only
+// reported as changed if they were, or now are, registered from SQL.
+func DiffManifests(oldManifest Manifest, newManifest Manifest) PlanDiff {
+	oldEntries := make(map[string]ManifestEntry, len(oldManifest.Migrations))
+	for _, entry := range oldManifest.Migrations {
+		oldEntries[entry.Name] = entry
+	}
+
+	var diff PlanDiff
+	newNames := make(map[string]struct{}, len(newManifest.Migrations))
+	for _, entry := range newManifest.Migrations {
+		newNames[entry.Name] = struct{}{}
+		oldEntry, exists := oldEntries[entry.Name]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, entry.Name)
+		case oldEntry.Checksum != entry.Checksum || oldEntry.Repeatable != entry.Repeatable:
+			diff.Changed = append(diff.Changed, entry.Name)
+		}
+	}
+	for _, entry := range oldManifest.Migrations {
+		if _, exists := newNames[entry.Name]; !exists {
+			diff.Removed = append(diff.Removed, entry.Name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// sqlChecksum returns a hash of the SQL of a migration registered from SQL,
+// or nothing for a migration registered as Go functions.
+func sqlChecksum(m migration) string {
+	if m.UpSQL == "" && m.DownSQL == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(m.UpSQL + "\x00" + m.DownSQL))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,178 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrPreflightFailed indicates that a run was not started because a
+	// preflight check failed. See WithPreflightChecks.
+	ErrPreflightFailed = errors.New("preflight check failed")
+)
+
+// PreflightAction determines what happens when a preflight check finds a
+// problem.
+type PreflightAction byte
+
+const (
+	// PreflightWarn logs the problem and runs the migrations anyway.
+	PreflightWarn PreflightAction = iota
+
+	// PreflightFail returns an error wrapping ErrPreflightFailed, and no
+	// migrations are run.
+	PreflightFail
+)
+
+// LongTransaction describes a session with a transaction which has been
+// open for longer than allowed by WithPreflightChecks.
+type LongTransaction struct {
+	PID             int
+	User            string
+	ApplicationName string
+	State           string
+
+	// Query is the most recent query of the session, which may have
+	// finished if the session is idle in a transaction.
+	Query string
+
+	// TransactionStart is when the transaction began, and Age is how long
+	// it had been open when checked.
+	TransactionStart time.Time
+	Age              time.Duration
+}
+
+// longTransactionsQuery lists the transactions on the current database,
+// other than that of the session itself, which have been open for longer
+// than the given number of milliseconds.
+const longTransactionsQuery = `
+	SELECT
+		pid,
+		coalesce(usename, '') AS "user",
+		application_name,
+		coalesce(state, '') AS state,
+		coalesce(query, '') AS query,
+		xact_start AS transaction_start,
+		(extract(epoch FROM now() - xact_start) * 1000)::bigint AS age_ms
+	FROM pg_stat_activity
+	WHERE datname = current_database()
+		AND pid <> pg_backend_pid()
+		AND xact_start < now() - ? * interval '1 millisecond'
+	ORDER BY xact_start
+`
+
+// WithPreflightChecks initialises a Migrator which checks the target DB
+// for transactions which have been open for longer than maxTransactionAge
+// before each run which applies or rolls back migrations, and before any
+// locks are taken. Such transactions, often sessions left idle in a
+// transaction, hold locks which block DDL, so a migration would otherwise
+// stall behind them along with every query queued after it.
+//
+// With PreflightWarn, each such transaction is logged and the run goes
+// ahead. With PreflightFail, an error wrapping ErrPreflightFailed is
+// returned instead.
+//
+// Intended for use with NewMigrator.
+func WithPreflightChecks(maxTransactionAge time.Duration, action PreflightAction) MigratorOpt {
+	return func(x *Migrator) error {
+		x.preflightMaxTxAge = maxTransactionAge
+		x.preflightAction = action
+		return nil
+	}
+}
+
+// LongTransactions returns the transactions on the target DB which have
+// been open for longer than maxAge, oldest first.
+func (x *Migrator) LongTransactions(maxAge time.Duration) ([]LongTransaction, error) {
+	var rows []struct {
+		LongTransaction
+		AgeMs int64
+	}
+	_, err := x.dbFactory().WithContext(x.ctx).Query(&rows, longTransactionsQuery, maxAge.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]LongTransaction, 0, len(rows))
+	for _, row := range rows {
+		transaction := row.LongTransaction
+		transaction.Age = time.Duration(row.AgeMs) * time.Millisecond
+		transactions = append(transactions, transaction)
+	}
+	return transactions, nil
+}
+
+// runPreflightChecks runs the checks enabled with WithConnectionCheck,
+// WithReplicationLagCheck and WithPreflightChecks, then stops the run if
+// the Migrator is in dry-run mode. See WithDryRun.
+func (x *Migrator) runPreflightChecks() error {
+	err := x.checkTarget()
+	if err != nil {
+		return err
+	}
+	return x.stopDryRun()
+}
+
+// checkTarget runs the checks enabled with WithConnectionCheck,
+// WithReplicationLagCheck and WithPreflightChecks.
+func (x *Migrator) checkTarget() error {
+	if x.connectionCheck {
+		err := x.CheckConnection()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := x.checkReplicationLag()
+	if err != nil {
+		return err
+	}
+	if x.preflightMaxTxAge <= 0 {
+		return nil
+	}
+
+	transactions, err := x.LongTransactions(x.preflightMaxTxAge)
+	if err != nil {
+		return err
+	}
+	if len(transactions) == 0 {
+		x.logAtLevel(LogLevelDebug, "Preflight: no transactions older than %s\n", x.preflightMaxTxAge)
+		return nil
+	}
+
+	descriptions := make([]string, 0, len(transactions))
+	for _, transaction := range transactions {
+		descriptions = append(descriptions, describeLongTransaction(transaction))
+	}
+	if x.preflightAction == PreflightFail {
+		return errors.Wrapf(
+			ErrPreflightFailed,
+			"%d transactions open for longer than %s: %s",
+			len(transactions),
+			x.preflightMaxTxAge,
+			strings.Join(descriptions, "; "),
+		)
+	}
+
+	for _, description := range descriptions {
+		x.logAtLevel(LogLevelError, "Preflight: long-running transaction: %s\n", description)
+	}
+	return nil
+}
+
+// describeLongTransaction summarises a long-running transaction for logs
+// and errors.
+func describeLongTransaction(transaction LongTransaction) string {
+	return fmt.Sprintf(
+		"pid %d (%s, %s) %s for %s: %s",
+		transaction.PID,
+		transaction.User,
+		transaction.ApplicationName,
+		transaction.State,
+		transaction.Age.Round(time.Second),
+		strings.Join(strings.Fields(transaction.Query), " "),
+	)
+}
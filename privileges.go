@@ -0,0 +1,172 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMissingPrivileges indicates that a run was not started because
+	// the role connected to the DB lacks privileges which migrations in
+	// the batch require. See RequiresPrivileges.
+	ErrMissingPrivileges = errors.New("missing privileges")
+)
+
+// Privilege is a privilege which a migration requires of the role which
+// runs it. See RequiresPrivileges.
+type Privilege struct {
+	// Description names the privilege in errors, e.g. "SUPERUSER" or
+	// "ownership of table users".
+	Description string
+
+	// query selects a single boolean which is true if the current role
+	// holds the privilege, given params.
+	query  string
+	params []interface{}
+}
+
+// String returns the description of the privilege.
+func (x Privilege) String() string {
+	return x.Description
+}
+
+// SuperuserPrivilege requires the role to be a superuser.
+func SuperuserPrivilege() Privilege {
+	return Privilege{
+		Description: "SUPERUSER",
+		query:       "SELECT rolsuper FROM pg_roles WHERE rolname = current_user",
+	}
+}
+
+// CreateRolePrivilege requires the role to have CREATEROLE, or to be a
+// superuser.
+func CreateRolePrivilege() Privilege {
+	return Privilege{
+		Description: "CREATEROLE",
+		query:       "SELECT rolsuper OR rolcreaterole FROM pg_roles WHERE rolname = current_user",
+	}
+}
+
+// CreateDBPrivilege requires the role to have CREATEDB, or to be a
+// superuser.
+func CreateDBPrivilege() Privilege {
+	return Privilege{
+		Description: "CREATEDB",
+		query:       "SELECT rolsuper OR rolcreatedb FROM pg_roles WHERE rolname = current_user",
+	}
+}
+
+// TableOwnerPrivilege requires the role to own table, which may be
+// schema-qualified, or to be a member of the role which owns it, as is
+// needed to alter or drop it. A table which does not exist yet is assumed
+// to be created by an earlier migration, so satisfies the requirement.
+func TableOwnerPrivilege(table string) Privilege {
+	return Privilege{
+		Description: fmt.Sprintf("ownership of table %s", table),
+		query: `
+			SELECT coalesce(
+				(SELECT pg_has_role(current_user, relowner, 'USAGE') FROM pg_class WHERE oid = to_regclass(?0)),
+				true
+			)
+		`,
+		params: []interface{}{table},
+	}
+}
+
+// TablePrivilege requires the role to hold privilege, e.g. "SELECT" or
+// "TRIGGER", on table, which may be schema-qualified. A table which does
+// not exist yet satisfies the requirement, as for TableOwnerPrivilege.
+func TablePrivilege(table string, privilege string) Privilege {
+	return Privilege{
+		Description: fmt.Sprintf("%s on table %s", privilege, table),
+		query: `
+			SELECT coalesce(
+				(SELECT has_table_privilege(oid, ?1) FROM pg_class WHERE oid = to_regclass(?0)),
+				true
+			)
+		`,
+		params: []interface{}{table, privilege},
+	}
+}
+
+// SchemaPrivilege requires the role to hold privilege, "CREATE" or
+// "USAGE", on schema. A schema which does not exist yet satisfies the
+// requirement, as for TableOwnerPrivilege.
+func SchemaPrivilege(schema string, privilege string) Privilege {
+	return Privilege{
+		Description: fmt.Sprintf("%s on schema %s", privilege, schema),
+		query: `
+			SELECT coalesce(
+				(SELECT has_schema_privilege(oid, ?1) FROM pg_namespace WHERE nspname = ?0),
+				true
+			)
+		`,
+		params: []interface{}{schema, privilege},
+	}
+}
+
+// DatabasePrivilege requires the role to hold privilege, e.g. "CREATE" or
+// "TEMPORARY", on the current database.
+func DatabasePrivilege(privilege string) Privilege {
+	return Privilege{
+		Description: fmt.Sprintf("%s on the database", privilege),
+		query:       "SELECT has_database_privilege(current_database(), ?)",
+		params:      []interface{}{privilege},
+	}
+}
+
+// RequiresPrivileges declares privileges which a migration needs, such as
+// SuperuserPrivilege to create an extension or TableOwnerPrivilege to
+// alter a table. Before a batch is applied, the privileges required by
+// its migrations are checked against the role connected to the DB, and
+// the run fails with an error wrapping ErrMissingPrivileges, listing what
+// is missing, before any migration is run.
+func RequiresPrivileges(privileges ...Privilege) MigrationOpt {
+	return func(x *migration) error {
+		x.RequiredPrivileges = append(x.RequiredPrivileges, privileges...)
+		return nil
+	}
+}
+
+// checkRequiredPrivileges returns an error wrapping ErrMissingPrivileges
+// if the role connected to db lacks any privilege required by the given
+// migrations.
+func (x *Migrator) checkRequiredPrivileges(db Querier, migrationNames []string) error {
+	held := make(map[string]bool)
+	var missing []string
+	for _, migrationName := range migrationNames {
+		migration, exists := x.registry.Get(migrationName)
+		if !exists {
+			continue
+		}
+
+		for _, privilege := range migration.RequiredPrivileges {
+			ok, checked := held[privilege.Description]
+			if !checked {
+				_, err := db.QueryOne(pg.Scan(&ok), privilege.query, privilege.params...)
+				if errors.Is(err, pg.ErrNoRows) {
+					ok = false
+				} else if err != nil {
+					return errors.Wrapf(err, "check %s", privilege)
+				}
+				held[privilege.Description] = ok
+			}
+			if !ok {
+				missing = append(missing, fmt.Sprintf("%s (required by %s)", privilege, migrationName))
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var role string
+	_, err := db.QueryOne(pg.Scan(&role), "SELECT current_user")
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(ErrMissingPrivileges, "role %s lacks %s", role, strings.Join(missing, ", "))
+}
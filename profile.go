@@ -0,0 +1,161 @@
+package migrations
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnknownProfile indicates that a profile name is not one of the
+	// names of the predefined profiles.
+	ErrUnknownProfile = errors.New("unknown profile")
+)
+
+// Profile bundles the settings a Migrator should use in one kind of
+// environment, so that each service does not have to derive them itself.
+// The predefined profiles DevProfile, StagingProfile and ProdProfile may be
+// used as they are, or copied and adjusted.
+type Profile struct {
+	// Name identifies the profile, e.g. in logs.
+	Name string
+
+	// LogLevel is the verbosity of the Migrator. See WithLogLevel.
+	LogLevel LogLevel
+
+	// ExplicitLock locks the migration table while migrations run. See
+	// WithExplicitLock.
+	ExplicitLock bool
+
+	// LockTimeout limits how long migrations wait for locks, if greater
+	// than 0. See WithLockTimeout.
+	LockTimeout time.Duration
+
+	// ConnectionCheck checks the connection before each run. See
+	// WithConnectionCheck.
+	ConnectionCheck bool
+
+	// MaxTransactionAge enables the preflight check for long-running
+	// transactions, if greater than 0, with PreflightAction. See
+	// WithPreflightChecks.
+	MaxTransactionAge time.Duration
+	PreflightAction   PreflightAction
+
+	// RollbackRiskCheck refuses rollbacks with a risk above
+	// MaxRollbackRisk, unless forced. See WithRollbackRiskCheck.
+	RollbackRiskCheck bool
+	MaxRollbackRisk   RollbackRisk
+
+	// DryRun stops runs before they apply or roll back migrations. See
+	// WithDryRun.
+	DryRun bool
+
+	// AllowedTags, if not empty, limits every run to migrations with one
+	// of the tags. See WithTags and WithDefaultRunOpts.
+	AllowedTags []string
+}
+
+var (
+	// DevProfile suits local development: verbose logs, and no locking or
+	// guard rails getting in the way.
+	DevProfile = Profile{
+		Name:     "dev",
+		LogLevel: LogLevelDebug,
+	}
+
+	// StagingProfile suits shared test environments: the guard rails of
+	// ProdProfile, but long-running transactions only cause a warning and
+	// rollbacks of unknown risk are allowed.
+	StagingProfile = Profile{
+		Name:              "staging",
+		LogLevel:          LogLevelInfo,
+		ExplicitLock:      true,
+		LockTimeout:       30 * time.Second,
+		ConnectionCheck:   true,
+		MaxTransactionAge: 5 * time.Minute,
+		PreflightAction:   PreflightWarn,
+		RollbackRiskCheck: true,
+		MaxRollbackRisk:   RiskUnknown,
+	}
+
+	// ProdProfile suits production: the migration table is locked,
+	// migrations give up on locks after 10 seconds rather than blocking
+	// other queries, and runs are refused if the connection check fails,
+	// if transactions have been open for 5 minutes or more, or if a
+	// rollback is riskier than RiskLow.
+	ProdProfile = Profile{
+		Name:              "prod",
+		LogLevel:          LogLevelInfo,
+		ExplicitLock:      true,
+		LockTimeout:       10 * time.Second,
+		ConnectionCheck:   true,
+		MaxTransactionAge: 5 * time.Minute,
+		PreflightAction:   PreflightFail,
+		RollbackRiskCheck: true,
+		MaxRollbackRisk:   RiskLow,
+	}
+)
+
+// ParseProfile returns the predefined profile with the given name:
+// "dev", "staging" or "prod". Matching is case-insensitive.
+func ParseProfile(name string) (Profile, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case DevProfile.Name:
+		return DevProfile, nil
+	case StagingProfile.Name:
+		return StagingProfile, nil
+	case ProdProfile.Name:
+		return ProdProfile, nil
+	default:
+		return Profile{}, errors.Wrapf(ErrUnknownProfile, "%q", name)
+	}
+}
+
+// Options returns the options applying the settings of the profile.
+func (x Profile) Options() []MigratorOpt {
+	opts := []MigratorOpt{
+		WithLogLevel(x.LogLevel),
+		WithLockTimeout(x.LockTimeout),
+	}
+	if x.ExplicitLock {
+		opts = append(opts, WithExplicitLock())
+	} else {
+		opts = append(opts, WithoutExplicitLock())
+	}
+	if x.ConnectionCheck {
+		opts = append(opts, WithConnectionCheck(0))
+	}
+	if x.MaxTransactionAge > 0 {
+		opts = append(opts, WithPreflightChecks(x.MaxTransactionAge, x.PreflightAction))
+	}
+	if x.RollbackRiskCheck {
+		opts = append(opts, WithRollbackRiskCheck(x.MaxRollbackRisk))
+	}
+	if x.DryRun {
+		opts = append(opts, WithDryRun())
+	}
+	if len(x.AllowedTags) > 0 {
+		opts = append(opts, WithDefaultRunOpts(WithTags(x.AllowedTags...)))
+	}
+	return opts
+}
+
+// WithProfile initialises a Migrator with the settings of profile. Options
+// given after WithProfile take precedence over the settings of the
+// profile, e.g.
+//
+//	NewMigrator(db, WithProfile(ProdProfile), WithLockTimeout(time.Minute))
+//
+// Intended for use with NewMigrator.
+func WithProfile(profile Profile) MigratorOpt {
+	return func(x *Migrator) error {
+		for _, opt := range profile.Options() {
+			err := opt(x)
+			if err != nil {
+				return errors.Wrapf(err, "profile %s", profile.Name)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,40 @@
+package migrations
+
+// Progress reports the progress of a long-running migration, such as a
+// backfill, so that it does not appear to have hung. current and total
+// count units of work of the caller's choosing; if the total is unknown,
+// total should be zero. The progress is logged and sent to event handlers
+// as a MigrationProgress event, and recorded in the heartbeat of the
+// migration if enabled with WithHeartbeat.
+//
+// Progress does nothing if the context was not provided by a Migrator.
+func (x *Context) Progress(current int64, total int64, msg string) {
+	if x == nil || x.migrator == nil {
+		return
+	}
+
+	if total > 0 {
+		x.migrator.logAtLevel(
+			LogLevelInfo,
+			"Progress %s: %d/%d (%.1f%%) %s\n",
+			x.migration,
+			current,
+			total,
+			float64(current)*100/float64(total),
+			msg,
+		)
+	} else {
+		x.migrator.logAtLevel(LogLevelInfo, "Progress %s: %d %s\n", x.migration, current, msg)
+	}
+
+	x.migrator.heartbeatProgress(x.migration, current, total, msg)
+	x.migrator.emit(Event{
+		Type:      MigrationProgress,
+		Direction: x.direction,
+		Migration: x.migration,
+		Current:   current,
+		Total:     total,
+		Message:   msg,
+		Memo:      x.migrator.memo,
+	})
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// Querier is the subset of pg.DBI which the Migrator uses to read and
+// update its own tables. Both *pg.DB and *pg.Tx implement it.
+type Querier interface {
+	Exec(query interface{}, params ...interface{}) (pg.Result, error)
+	ExecContext(c context.Context, query interface{}, params ...interface{}) (pg.Result, error)
+	Query(model, query interface{}, params ...interface{}) (pg.Result, error)
+	QueryContext(c context.Context, model, query interface{}, params ...interface{}) (pg.Result, error)
+	QueryOne(model, query interface{}, params ...interface{}) (pg.Result, error)
+	QueryOneContext(c context.Context, model, query interface{}, params ...interface{}) (pg.Result, error)
+}
+
+// TxRunner is a Querier which can also run functions in a transaction.
+// *pg.DB implements it.
+//
+// Migration functions are given the *pg.Tx started by RunInTransaction,
+// so a fake TxRunner must still be backed by a *pg.DB. See the migratest
+// package for a fake which records the SQL sent to it.
+type TxRunner interface {
+	Querier
+	RunInTransaction(ctx context.Context, fn func(*pg.Tx) error) error
+}
+
+// Interface Compliance: This ensures compile-time checks
+// that the go-pg types implement the interfaces used by the Migrator.
+var (
+	_ Querier  = (*pg.Tx)(nil)
+	_ TxRunner = (*pg.DB)(nil)
+)
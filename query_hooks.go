@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithQueryHook initialises a Migrator which adds hook to the DB returned
+// by its DBFactory, the first time each DB is used. This allows every
+// statement run by migrations to be observed, e.g. for progress displays.
+// May be used multiple times to add several hooks.
+//
+// Note that the hook is added to the DB itself, so it will also observe
+// statements which are not run by the Migrator.
+//
+// Intended for use with NewMigrator.
+func WithQueryHook(hook pg.QueryHook) MigratorOpt {
+	return func(x *Migrator) error {
+		x.queryHooks = append(x.queryHooks, hook)
+		return nil
+	}
+}
+
+// hookedDBFactory wraps factory so that hooks are added to every DB it
+// returns, exactly once per DB.
+func hookedDBFactory(factory DBFactory, hooks []pg.QueryHook) DBFactory {
+	var mtx sync.Mutex
+	hooked := make(map[*pg.DB]struct{})
+	return func() *pg.DB {
+		db := factory()
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		if _, ok := hooked[db]; !ok {
+			for _, hook := range hooks {
+				db.AddQueryHook(hook)
+			}
+			hooked[db] = struct{}{}
+		}
+		return db
+	}
+}
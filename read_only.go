@@ -0,0 +1,86 @@
+package migrations
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrReadOnly indicates that an operation would have written to the
+	// DB or locked the migration table, but the Migrator is read-only.
+	ErrReadOnly = errors.New("migrator is read-only")
+
+	// ErrPendingMigrations indicates that registered migrations have not
+	// been applied to the DB.
+	ErrPendingMigrations = errors.New("migrations pending")
+)
+
+// WithReadOnly initialises a Migrator which never creates, alters or locks
+// the migration table, so that it can be used with a DB role which is only
+// allowed to read. Status, History, Applied, Pending, Verify and IsUpToDate
+// work as usual. Operations which would run migrations return an error
+// wrapping ErrReadOnly.
+//
+// Intended for use with NewMigrator.
+func WithReadOnly() MigratorOpt {
+	return func(x *Migrator) error {
+		x.readOnly = true
+		return nil
+	}
+}
+
+// Pending returns the names of the registered migrations which have not
+// been applied, in the order they would be run: sorted by the Migrator's
+// ordering, ByName unless WithOrdering was used. The migration table is
+// neither created nor locked.
+//
+// If any applied migrations are not registered, this returns an error
+// wrapping ErrMigrationNotKnown.
+func (x *Migrator) Pending() ([]string, error) {
+	db := x.stateDB().WithContext(x.ctx)
+	return x.getPendingMigrations(db)
+}
+
+// Applied returns the migrations recorded in the migration table, ordered
+// by batch and then, within each batch, by the Migrator's ordering. This is
+// the order in which the migrations were run, except for batches applied
+// with MigrateParallel, so the result followed by that of Pending lists
+// every migration in run order. See History for the order in which the
+// migrations were recorded. The migration table is neither created nor
+// locked.
+func (x *Migrator) Applied() ([]AppliedMigration, error) {
+	applied, err := x.History()
+	if err != nil {
+		return nil, err
+	}
+
+	ordering := x.ordering
+	if ordering == nil {
+		ordering = ByName
+	}
+	sort.SliceStable(applied, func(i, j int) bool {
+		if applied[i].Batch != applied[j].Batch {
+			return applied[i].Batch < applied[j].Batch
+		}
+		return ordering(applied[i].Name, applied[j].Name)
+	})
+	return applied, nil
+}
+
+// Verify checks that the DB matches the registered migrations: every
+// applied migration must be registered, and every registered migration must
+// have been applied. Otherwise, an error wrapping ErrMigrationNotKnown or
+// ErrPendingMigrations is returned. The migration table is neither created
+// nor locked.
+func (x *Migrator) Verify() error {
+	migrationsToRun, err := x.Pending()
+	if err != nil {
+		return err
+	}
+
+	if len(migrationsToRun) > 0 {
+		return errors.Wrapf(ErrPendingMigrations, "pending migrations: %+v", migrationsToRun)
+	}
+	return nil
+}
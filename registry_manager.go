@@ -1,8 +1,10 @@
 package migrations
 
 import (
+	"database/sql"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/pkg/errors"
@@ -42,21 +44,49 @@ const (
 type Context struct {
 	// Flavour indicates which Postgres-like API can be expected.
 	Flavour PostgresFlavour
+
+	migrator  *Migrator
+	migration string
+	direction Direction
+
+	// rowsAffected counts the rows the migration reports having affected.
+	// See AddRowsAffected.
+	rowsAffected *atomic.Int64
 }
 
 // Registry holds a set of known migrations. Migrations can be registered
-// individually with Register, or in bulk by using From to copy from
-// another registry.
+// individually with Register, or in bulk by using From or Merge to copy
+// from another registry.
 //
 // Registered migrations may be retrieved all at once with List, or
 // individually with Get.
 //
 // When it is necessary to register individual migrations in init functions,
 // From makes it easy to copy these migrations to a registry in a Migrator.
+//
+// All methods of Registry are safe for concurrent use.
 type Registry struct {
 	mtx            sync.RWMutex
 	allMigrations  map[string]migration
 	migrationNames []string
+	repeatables    map[string]migration
+
+	// generation is incremented whenever migrations are added or
+	// replaced, so that information derived from the registry can be
+	// invalidated.
+	generation uint64
+
+	// nameValidator, if set, is used to check the names of newly
+	// registered migrations.
+	nameValidator NameValidator
+
+	// collisionChecker, if set, is used to check the names of newly
+	// registered migrations against those already registered.
+	collisionChecker func(name string, others []string) error
+
+	// downChecker, if set, is used to check the down functions of newly
+	// registered migrations.
+	downChecker func(name string, down interface{}) error
 }
 
 // Register adds a migration to the list of known migrations.
@@ -68,8 +98,35 @@ type Registry struct {
 //
 //	func(*pg.Tx) error
 //	func(*pg.Tx, *Context) error
-func (x *Registry) Register(name string, up interface{}, down interface{}) error {
+//	func(*sql.Tx) error
+//	func(*sql.DB) error
+//
+// Functions taking a *sql.Tx or *sql.DB allow migrations written against
+// database/sql, or libraries built on it such as sqlx and bun, to be run
+// in the transaction of the migration. Transactions begun on the *sql.DB
+// are no-ops. Queries use $1, $2, ... placeholders, which are formatted by
+// go-pg before being sent.
+//
+// Additional information about the migration may be provided with opts.
+func (x *Registry) Register(name string, up interface{}, down interface{}, opts ...MigrationOpt) error {
+	return x.register(migration{
+		Name: name,
+		Up:   up,
+		Down: down,
+	}, opts)
+}
+
+// register adds a fully constructed migration to the list of known
+// migrations, after applying opts and validating its functions.
+func (x *Registry) register(m migration, opts []MigrationOpt) error {
 	var err error
+	for _, opt := range opts {
+		err = opt(&m)
+		if err != nil {
+			return errors.Wrapf(err, "migration %s", m.Name)
+		}
+	}
+
 	x.mtx.Lock()
 	defer x.mtx.Unlock()
 
@@ -77,25 +134,41 @@ func (x *Registry) Register(name string, up interface{}, down interface{}) error
 		x.allMigrations = make(map[string]migration)
 	}
 
-	err = checkAllowedMigrationFunctions(up)
+	if x.nameValidator != nil {
+		err = x.nameValidator(m.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkAllowedMigrationFunctions(m.Up)
 	if err != nil {
 		return errors.Wrap(err, "invalid up migration")
 	}
 
-	err = checkAllowedMigrationFunctions(down)
+	err = checkAllowedMigrationFunctions(m.Down)
 	if err != nil {
 		return errors.Wrap(err, "invalid down migration")
 	}
 
-	if _, exists := x.allMigrations[name]; exists {
-		return errors.Wrapf(ErrMigrationAlreadyExists, "migrations %s", name)
+	if _, exists := x.allMigrations[m.Name]; exists {
+		return errors.Wrapf(ErrMigrationAlreadyExists, "migrations %s", m.Name)
 	}
-	x.migrationNames = append(x.migrationNames, name)
-	x.allMigrations[name] = migration{
-		Name: name,
-		Up:   up,
-		Down: down,
+	if x.collisionChecker != nil {
+		err = x.collisionChecker(m.Name, x.migrationNames)
+		if err != nil {
+			return err
+		}
 	}
+	if x.downChecker != nil {
+		err = x.downChecker(m.Name, m.Down)
+		if err != nil {
+			return err
+		}
+	}
+	x.migrationNames = append(x.migrationNames, m.Name)
+	x.allMigrations[m.Name] = m
+	x.generation++
 	return nil
 }
 
@@ -110,6 +183,10 @@ func checkAllowedMigrationFunctions(fn interface{}) error {
 		return nil
 	case func(*pg.Tx, *Context) error:
 		return nil
+	case func(*sql.Tx) error:
+		return nil
+	case func(*sql.DB) error:
+		return nil
 	default:
 		return errors.Wrapf(
 			ErrInvalidMigrationFuncRegistered,
@@ -140,28 +217,122 @@ func (x *Registry) Get(name string) (migration, bool) {
 //
 // This is a shallow copy. It is fine to add or remove items in other,
 // as long as the items themselves are not modified after the copy.
+//
+// It is safe to call From while other goroutines register migrations
+// with either registry.
 func (x *Registry) From(other *Registry) {
+	if x == other {
+		return
+	}
+
+	// Take a snapshot of the other registry first, so that the two
+	// locks are never held at the same time. Holding both would allow
+	// concurrent copies in opposite directions to deadlock.
+	other.mtx.RLock()
+	migrationNames := append([]string(nil), other.migrationNames...)
+	allMigrations := make(map[string]migration, len(other.allMigrations))
+	for name, migration := range other.allMigrations {
+		allMigrations[name] = migration
+	}
+	var repeatables map[string]migration
+	if len(other.repeatables) > 0 {
+		repeatables = make(map[string]migration, len(other.repeatables))
+		for name, migration := range other.repeatables {
+			repeatables[name] = migration
+		}
+	}
+	other.mtx.RUnlock()
+
+	sort.Strings(migrationNames)
+
 	x.mtx.Lock()
 	defer x.mtx.Unlock()
-	if x.allMigrations == nil {
-		x.allMigrations = make(map[string]migration)
+	if cap(x.migrationNames) >= len(migrationNames) {
+		migrationNames = append(x.migrationNames[:0], migrationNames...)
 	}
+	x.migrationNames = migrationNames
+	x.allMigrations = allMigrations
+	x.repeatables = repeatables
+	x.generation++
+}
 
-	// The other registry also needs to be locked for the duration
-	// of the copy.
-	other.mtx.Lock()
-	defer other.mtx.Unlock()
-	if len(other.allMigrations) == 0 {
-		return
+// Merge copies registered migrations from another registry, keeping the
+// migrations already in the registry. This allows migrations registered
+// by several modules to be combined.
+//
+// Migrations whose names are already registered are not copied. Their
+// names are returned, sorted, as conflicts, along with an error wrapping
+// ErrMigrationAlreadyExists. The remaining migrations are still copied.
+//
+// Like From, this is a shallow copy, and it is safe to call Merge while
+// other goroutines register migrations with either registry.
+func (x *Registry) Merge(other *Registry) (conflicts []string, err error) {
+	if x == other {
+		return nil, nil
 	}
 
-	ensureCapacity(x, len(other.allMigrations))
-	x.migrationNames = other.migrationNames[:]
+	// As with From, snapshot the other registry so that the two locks
+	// are never held at the same time.
+	other.mtx.RLock()
+	migrationNames := append([]string(nil), other.migrationNames...)
+	allMigrations := make(map[string]migration, len(other.allMigrations))
 	for name, migration := range other.allMigrations {
-		x.allMigrations[name] = migration
+		allMigrations[name] = migration
+	}
+	repeatables := make(map[string]migration, len(other.repeatables))
+	for name, migration := range other.repeatables {
+		repeatables[name] = migration
 	}
+	other.mtx.RUnlock()
 
-	sort.Strings(x.migrationNames)
+	sort.Strings(migrationNames)
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	if x.allMigrations == nil {
+		x.allMigrations = make(map[string]migration, len(allMigrations))
+	}
+
+	merged := false
+	for _, name := range migrationNames {
+		if _, exists := x.allMigrations[name]; exists {
+			conflicts = append(conflicts, name)
+			continue
+		}
+		x.migrationNames = append(x.migrationNames, name)
+		x.allMigrations[name] = allMigrations[name]
+		merged = true
+	}
+
+	repeatableNames := make([]string, 0, len(repeatables))
+	for name := range repeatables {
+		repeatableNames = append(repeatableNames, name)
+	}
+	sort.Strings(repeatableNames)
+	for _, name := range repeatableNames {
+		if _, exists := x.repeatables[name]; exists {
+			conflicts = append(conflicts, name)
+			continue
+		}
+		if x.repeatables == nil {
+			x.repeatables = make(map[string]migration, len(repeatables))
+		}
+		x.repeatables[name] = repeatables[name]
+		merged = true
+	}
+
+	if merged {
+		x.generation++
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return conflicts, errors.Wrapf(
+			ErrMigrationAlreadyExists,
+			"migrations %+v",
+			conflicts,
+		)
+	}
+	return nil, nil
 }
 
 // Sort sorts migrations in the registry by name, lexicographically.
@@ -181,9 +352,8 @@ func ensureCapacity(x *Registry, capacity int) {
 
 // List returns a slice of all registered migrations.
 //
-// This is a shallow copy. It is fine to add or remove items in the
-// registry, as long as the items themselves are not modified after
-// the copy.
+// The slice is a copy, so it is fine to add or remove items in the
+// registry, or to modify the slice, after calling List.
 func (x *Registry) List() []string {
 	x.mtx.RLock()
 	defer x.mtx.RUnlock()
@@ -191,7 +361,7 @@ func (x *Registry) List() []string {
 		return []string{}
 	}
 
-	return x.migrationNames[:]
+	return append(make([]string, 0, len(x.migrationNames)), x.migrationNames...)
 }
 
 // Sort sorts migrations in the registry by name, lexicographically.
@@ -215,6 +385,15 @@ func (x *Registry) EnsureCapacity(capacity int) {
 	ensureCapacity(x, capacity)
 }
 
+// getGeneration returns the number of times migrations have been added to
+// or replaced in the registry.
+func (x *Registry) getGeneration() uint64 {
+	x.mtx.RLock()
+	defer x.mtx.RUnlock()
+
+	return x.generation
+}
+
 // Count returns the number of migrations in the registry.
 func (x *Registry) Count() int {
 	x.mtx.RLock()
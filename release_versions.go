@@ -0,0 +1,220 @@
+package migrations
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidVersion indicates that a release version could not be
+	// parsed.
+	ErrInvalidVersion = errors.New("invalid release version")
+
+	// ErrNoMigrationVersion indicates that a pending migration does not
+	// declare the release it belongs to.
+	ErrNoMigrationVersion = errors.New("migration has no release version")
+
+	// ErrVersionOrder indicates that a migration belonging to an earlier
+	// release is sorted after a migration belonging to a later release.
+	ErrVersionOrder = errors.New("migration release versions out of order")
+)
+
+// Version declares the application release which a migration belongs to,
+// e.g. "v2.14.0". Versions are compared numerically by their dot-separated
+// components, and a leading "v" is optional. A pre-release suffix, such
+// as "-rc.1", sorts before the release itself.
+//
+// See Migrator.MigrateToVersion.
+func Version(version string) MigrationOpt {
+	return func(x *migration) error {
+		_, err := parseVersion(version)
+		if err != nil {
+			return err
+		}
+		x.Version = version
+		return nil
+	}
+}
+
+// MigrateToVersion runs the pending migrations which belong to releases up
+// to and including version, as a single batch. See MigrateBatch.
+//
+// Every pending migration must declare its release with Version, and
+// migrations must be named so that they sort in release order. Otherwise,
+// ErrNoMigrationVersion or ErrVersionOrder is returned and no migrations
+// are run.
+func (x *Migrator) MigrateToVersion(version string) error {
+	target, err := parseVersion(version)
+	if err != nil {
+		return err
+	}
+
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+
+	db := x.dbFactory()
+	err = x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return x.migrateBatch(db, func(pending []string) ([]string, error) {
+		selected := 0
+		for i, name := range pending {
+			migration, _ := x.registry.Get(name)
+			if migration.Version == "" {
+				return nil, errors.Wrapf(ErrNoMigrationVersion, "migration %s", name)
+			}
+
+			migrationVersion, err := parseVersion(migration.Version)
+			if err != nil {
+				return nil, errors.Wrapf(err, "migration %s", name)
+			}
+
+			if compareVersions(migrationVersion, target) > 0 {
+				continue
+			}
+			if selected < i {
+				return nil, errors.Wrapf(
+					ErrVersionOrder,
+					"%s (%s) sorts after %s (%s)",
+					name,
+					migration.Version,
+					pending[selected],
+					x.versionOf(pending[selected]),
+				)
+			}
+			selected++
+		}
+		return pending[:selected], nil
+	})
+}
+
+// versionOf returns the release version of a registered migration.
+func (x *Migrator) versionOf(name string) string {
+	migration, _ := x.registry.Get(name)
+	return migration.Version
+}
+
+// releaseVersion is a parsed release version.
+type releaseVersion struct {
+	parts      []int
+	preRelease string
+}
+
+// parseVersion parses a version of the form [v]MAJOR[.MINOR...][-PRE].
+func parseVersion(version string) (releaseVersion, error) {
+	var result releaseVersion
+	numbers, preRelease, _ := strings.Cut(strings.TrimPrefix(version, "v"), "-")
+	result.preRelease = preRelease
+	for _, part := range strings.Split(numbers, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return releaseVersion{}, errors.Wrapf(ErrInvalidVersion, "%q", version)
+		}
+		result.parts = append(result.parts, number)
+	}
+	return result, nil
+}
+
+// compareVersions returns -1, 0 or 1 depending on whether a is before,
+// equal to or after b. Missing components are treated as zero.
+func compareVersions(a releaseVersion, b releaseVersion) int {
+	for i := 0; i < len(a.parts) || i < len(b.parts); i++ {
+		var aPart, bPart int
+		if i < len(a.parts) {
+			aPart = a.parts[i]
+		}
+		if i < len(b.parts) {
+			bPart = b.parts[i]
+		}
+		switch {
+		case aPart < bPart:
+			return -1
+		case aPart > bPart:
+			return 1
+		}
+	}
+
+	switch {
+	case a.preRelease == b.preRelease:
+		return 0
+	case a.preRelease == "":
+		return 1
+	case b.preRelease == "":
+		return -1
+	default:
+		return comparePreReleases(a.preRelease, b.preRelease)
+	}
+}
+
+// comparePreReleases compares two pre-release versions as described by
+// SemVer: dot-separated identifiers are compared in turn, numerically if
+// both are numeric, with numeric identifiers before alphanumeric ones, and
+// otherwise in ASCII order. If every identifier is equal, the version with
+// fewer identifiers comes first, e.g. "rc.9" < "rc.10" < "rc.10.1".
+func comparePreReleases(a string, b string) int {
+	aIdentifiers := strings.Split(a, ".")
+	bIdentifiers := strings.Split(b, ".")
+	for i := 0; i < len(aIdentifiers) && i < len(bIdentifiers); i++ {
+		result := comparePreReleaseIdentifiers(aIdentifiers[i], bIdentifiers[i])
+		if result != 0 {
+			return result
+		}
+	}
+
+	switch {
+	case len(aIdentifiers) < len(bIdentifiers):
+		return -1
+	case len(aIdentifiers) > len(bIdentifiers):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// comparePreReleaseIdentifiers compares a single identifier of two
+// pre-release versions. See comparePreReleases.
+func comparePreReleaseIdentifiers(a string, b string) int {
+	aNumeric := isNumeric(a)
+	bNumeric := isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		// Numbers are compared by length first, so that they are not
+		// limited to the size of an int.
+		a = strings.TrimLeft(a, "0")
+		b = strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// isNumeric reports whether s is a non-empty string of digits.
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package migrations
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrRenameConflict indicates that renaming the applied migrations
+	// would give two of them the same name.
+	ErrRenameConflict = errors.New("renamed migrations conflict")
+)
+
+// NameConverter maps the name of an applied migration to the name it should
+// be recorded under. Names which should be left alone are returned as is.
+type NameConverter func(name string) string
+
+// ConventionConverter returns a NameConverter which converts migration
+// names to the given naming convention, as when switching a project between
+// camelCase and snake_case file names.
+func ConventionConverter(convention MigrationNameConvention) (NameConverter, error) {
+	switch convention {
+	case SnakeCase:
+		return ConvertCamelCaseToSnakeCase, nil
+	case CamelCase:
+		return ConvertSnakeCaseToCamelCase, nil
+	default:
+		err := errors.Wrapf(
+			ErrUnknownNamingConvention,
+			"unknown convention %s",
+			convention,
+		)
+		return nil, err
+	}
+}
+
+// TimestampLayoutConverter returns a NameConverter which reformats the
+// timestamp at the start of migration names from oldLayout to newLayout.
+// Names which do not start with a timestamp in oldLayout are left alone.
+func TimestampLayoutConverter(oldLayout string, newLayout string) NameConverter {
+	return func(name string) string {
+		if len(name) < len(oldLayout) {
+			return name
+		}
+		timestamp, err := time.ParseInLocation(oldLayout, name[:len(oldLayout)], time.Local)
+		if err != nil {
+			return name
+		}
+		return timestamp.Format(newLayout) + name[len(oldLayout):]
+	}
+}
+
+// RenameHistory renames the applied migrations recorded in the migration
+// table using convert, so that the history matches the registered names
+// after a change of naming convention or timestamp format. All renames are
+// made in a single transaction, and are returned sorted by old name.
+//
+// If two migrations would end up with the same name, nothing is renamed and
+// an error wrapping ErrRenameConflict is returned.
+//
+// Unlike WithRenames, the migration files are not consulted, so the
+// migrations must be renamed in the migration directory separately.
+func (x *Migrator) RenameHistory(convert NameConverter) ([]Rename, error) {
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+	x.invalidateUpToDate()
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var renames []Rename
+	err = x.stateDB().RunInTransaction(x.ctx, func(tx *pg.Tx) error {
+		exists, err := x.migrationTableExists(tx)
+		if err != nil || !exists {
+			return err
+		}
+
+		err = x.maybeLockTable(tx)
+		if err != nil {
+			return err
+		}
+
+		// The names are read as recorded, without applying WithRenames.
+		var completedMigrations []string
+		_, err = tx.Query(&completedMigrations, "SELECT name FROM ?", pg.Ident(x.migrationTableName))
+		if err != nil {
+			return err
+		}
+
+		renames, err = historyRenames(completedMigrations, convert)
+		if err != nil {
+			return err
+		}
+
+		if len(renames) == 0 {
+			return nil
+		}
+
+		// The renames are made in a single statement, so that a migration
+		// renamed to the old name of another is not renamed twice.
+		oldNames := make([]string, len(renames))
+		newNames := make([]string, len(renames))
+		for i, rename := range renames {
+			oldNames[i] = rename.OldName
+			newNames[i] = rename.NewName
+		}
+		_, err = tx.Exec(
+			`UPDATE ? AS m SET name = r.new_name
+			FROM unnest(?::varchar[], ?::varchar[]) AS r(old_name, new_name)
+			WHERE m.name = r.old_name`,
+			pg.Ident(x.migrationTableName),
+			pg.Array(oldNames),
+			pg.Array(newNames),
+		)
+		if err != nil {
+			return err
+		}
+		for _, rename := range renames {
+			x.logAtLevel(LogLevelInfo, "Renamed %s to %s\n", rename.OldName, rename.NewName)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return renames, nil
+}
+
+// historyRenames works out the renames needed to convert the given names,
+// checking that the converted names are unique.
+func historyRenames(names []string, convert NameConverter) ([]Rename, error) {
+	var renames []Rename
+	owners := make(map[string]string, len(names))
+	for _, name := range names {
+		newName := convert(name)
+		if owner, taken := owners[newName]; taken {
+			return nil, errors.Wrapf(
+				ErrRenameConflict,
+				"%s and %s would both be named %s",
+				owner,
+				name,
+				newName,
+			)
+		}
+		owners[newName] = name
+
+		if newName != name {
+			renames = append(renames, Rename{OldName: name, NewName: newName})
+		}
+	}
+
+	sort.Slice(renames, func(i, j int) bool {
+		return renames[i].OldName < renames[j].OldName
+	})
+	return renames, nil
+}
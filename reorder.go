@@ -0,0 +1,296 @@
+package migrations
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoTimestamp indicates that a migration which needs to be
+	// reordered does not start with a timestamp, so it cannot be renamed.
+	ErrNoTimestamp = errors.New("migration name has no timestamp prefix")
+
+	// ErrInvalidRenames indicates that a renames file could not be parsed.
+	ErrInvalidRenames = errors.New("invalid renames")
+)
+
+// RenamesFile is the name of the file in the migration directory to which
+// Reorder records the migrations it renamed. See ParseRenames.
+const RenamesFile = "renames.txt"
+
+// timestampLayout is the layout of the timestamp at the start of the names
+// of generated migrations.
+const timestampLayout = "20060102150405"
+
+// Rename describes a migration renamed by Reorder.
+type Rename struct {
+	OldName string
+	NewName string
+}
+
+// Reorder gives new timestamps to pending migrations which sort before the
+// latest applied migration, which would otherwise be run out of order, or
+// not at all if the migrations were applied elsewhere. This is typically
+// needed after merging branches which both added migrations.
+//
+// The files of each such migration in the migration directory are renamed,
+// and the old timestamp is replaced throughout them, which updates the name
+// it is registered with along with its function names. References to the
+// old name from other Go files in the migration directory, such as in
+// DependsOn, are updated too.
+//
+// Each rename is appended to RenamesFile in the migration directory. Any
+// DB on which a migration was applied under its old name needs to know of
+// the rename, so the file should be loaded with ParseRenames and passed to
+// WithRenames.
+func (x *Migrator) Reorder() ([]Rename, error) {
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+
+	db := x.stateDB().WithContext(x.ctx)
+	exists, err := x.migrationTableExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	completedMigrations, err := x.getCompletedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(completedMigrations) == 0 {
+		return nil, nil
+	}
+	x.sortMigrations(completedMigrations)
+	latest := completedMigrations[len(completedMigrations)-1]
+
+	ordering := x.ordering
+	if ordering == nil {
+		ordering = ByName
+	}
+	_, _, pending := difference(completedMigrations, x.registry.List())
+	x.sortMigrations(pending)
+
+	next := time.Now().Truncate(time.Second)
+	if latestTimestamp, ok := parseTimestamp(latest); ok && !next.After(latestTimestamp) {
+		next = latestTimestamp.Add(time.Second)
+	}
+
+	var renames []Rename
+	for _, name := range pending {
+		if !ordering(name, latest) {
+			continue
+		}
+		if _, ok := parseTimestamp(name); !ok {
+			return renames, errors.Wrapf(ErrNoTimestamp, "migration %s", name)
+		}
+
+		rename := Rename{
+			OldName: name,
+			NewName: next.Format(timestampLayout) + name[len(timestampLayout):],
+		}
+		err = x.renameMigrationFiles(rename)
+		if err != nil {
+			return renames, err
+		}
+		renames = append(renames, rename)
+		next = next.Add(time.Second)
+	}
+
+	if len(renames) == 0 {
+		return nil, nil
+	}
+	return renames, x.appendRenames(renames)
+}
+
+// renameMigrationFiles renames the files of a migration in the migration
+// directory, and updates references to it from Go files.
+func (x *Migrator) renameMigrationFiles(rename Rename) error {
+	entries, err := os.ReadDir(x.migrationDir)
+	if err != nil {
+		return err
+	}
+
+	oldTimestamp := rename.OldName[:len(timestampLayout)]
+	newTimestamp := rename.NewName[:len(timestampLayout)]
+	renamed := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		fileName := entry.Name()
+		filePath := filepath.Join(x.migrationDir, fileName)
+		for _, suffix := range []string{".go", UpSQLSuffix, DownSQLSuffix} {
+			if fileName != rename.OldName+suffix {
+				continue
+			}
+
+			newPath := filepath.Join(x.migrationDir, rename.NewName+suffix)
+			if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+				return errors.Wrapf(ErrFileAlreadyExists, "file %s (%v)", newPath, err)
+			}
+
+			err = replaceInFile(filePath, oldTimestamp, newTimestamp)
+			if err != nil {
+				return err
+			}
+
+			err = os.Rename(filePath, newPath)
+			if err != nil {
+				return err
+			}
+			x.logAtLevel(LogLevelInfo, "Renamed %s to %s\n", filePath, newPath)
+			renamed++
+		}
+	}
+	if renamed == 0 {
+		x.logAtLevel(LogLevelInfo, "No files found for migration %s\n", rename.OldName)
+	}
+
+	// Update references from other migrations, e.g. in DependsOn.
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".go" {
+			continue
+		}
+
+		filePath := filepath.Join(x.migrationDir, entry.Name())
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			// The file was one of those renamed above.
+			continue
+		}
+
+		err = replaceInFile(filePath, `"`+rename.OldName+`"`, `"`+rename.NewName+`"`)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendRenames records renames in the renames file of the migration
+// directory.
+func (x *Migrator) appendRenames(renames []Rename) error {
+	file, err := os.OpenFile(
+		filepath.Join(x.migrationDir, RenamesFile),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, rename := range renames {
+		_, err = fmt.Fprintf(file, "%s %s\n", rename.OldName, rename.NewName)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// ParseRenames reads renames in the format written by Reorder: one rename
+// per line, with the old and new names separated by whitespace. Blank lines
+// and lines starting with # are ignored.
+//
+// The result is intended for use with WithRenames. The renames file may be
+// embedded in the migrations binary with go:embed.
+func ParseRenames(r io.Reader) (map[string]string, error) {
+	renames := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Wrapf(ErrInvalidRenames, "line %d: expected old and new names", lineNumber)
+		}
+		renames[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return renames, nil
+}
+
+// WithRenames initialises a Migrator which knows that migrations have been
+// renamed, as by Reorder, so that migrations applied under their old names
+// are not reported as unknown. The renames map old names to new names.
+//
+// Applied migrations are recorded under their new names the next time the
+// migration table is written to.
+//
+// Intended for use with NewMigrator.
+func WithRenames(renames map[string]string) MigratorOpt {
+	return func(x *Migrator) error {
+		if x.renames == nil {
+			x.renames = make(map[string]string, len(renames))
+		}
+		for oldName, newName := range renames {
+			x.renames[oldName] = newName
+		}
+		return nil
+	}
+}
+
+// currentName returns the name a migration has been renamed to, or its
+// name if it has not been renamed.
+func (x *Migrator) currentName(name string) string {
+	if newName, renamed := x.renames[name]; renamed {
+		return newName
+	}
+	return name
+}
+
+// applyRenames records applied migrations under their new names.
+func (x *Migrator) applyRenames(db Querier) error {
+	for oldName, newName := range x.renames {
+		_, err := db.Exec(
+			"UPDATE ? SET name = ? WHERE name = ?",
+			pg.Ident(x.migrationTableName),
+			newName,
+			oldName,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTimestamp parses the timestamp at the start of a migration name.
+func parseTimestamp(name string) (time.Time, bool) {
+	if len(name) < len(timestampLayout) {
+		return time.Time{}, false
+	}
+	timestamp, err := time.ParseInLocation(timestampLayout, name[:len(timestampLayout)], time.Local)
+	return timestamp, err == nil
+}
+
+// replaceInFile replaces every occurrence of old in a file with new.
+func replaceInFile(filePath string, old string, new string) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(content), old) {
+		return nil
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, []byte(strings.ReplaceAll(string(content), old, new)), info.Mode())
+}
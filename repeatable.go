@@ -0,0 +1,210 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoChecksum indicates that a repeatable migration was registered
+	// without a checksum.
+	ErrNoChecksum = errors.New("repeatable migration requires a checksum")
+)
+
+// RepeatableTableSuffix is appended to the name of the migration table to
+// get the name of the table which tracks repeatable migrations.
+const RepeatableTableSuffix = "_repeatable"
+
+// RegisterRepeatable adds a repeatable migration to the registry. Repeatable
+// migrations are intended for objects which are recreated wholesale, such as
+// views, functions and grants.
+//
+// A repeatable migration is run after all pending versioned migrations,
+// whenever the checksum differs from the checksum recorded when it last ran.
+// The checksum should change whenever the content of up changes, e.g. by
+// using a hash of the SQL it runs. Repeatable migrations have no down
+// function, are not part of any batch, and are run in name order.
+//
+// If a repeatable migration by the given name is already known, this will
+// return ErrMigrationAlreadyExists.
+func (x *Registry) RegisterRepeatable(name string, checksum string, up interface{}, opts ...MigrationOpt) error {
+	return x.registerRepeatable(migration{
+		Name:     name,
+		Up:       up,
+		Checksum: checksum,
+	}, opts)
+}
+
+// RegisterRepeatableSQL adds a repeatable migration consisting of plain SQL
+// statements to the registry. The checksum is derived from the SQL, so the
+// migration is run again whenever the SQL changes. See RegisterRepeatable.
+func (x *Registry) RegisterRepeatableSQL(name string, upSQL string, opts ...MigrationOpt) error {
+	checksum := sha256.Sum256([]byte(upSQL))
+	return x.registerRepeatable(migration{
+		Name:     name,
+		Up:       sqlMigrationFunc(upSQL),
+		UpSQL:    upSQL,
+		Checksum: hex.EncodeToString(checksum[:]),
+	}, opts)
+}
+
+// registerRepeatable adds a fully constructed repeatable migration to the
+// registry, after applying opts and validating its function.
+func (x *Registry) registerRepeatable(m migration, opts []MigrationOpt) error {
+	var err error
+	for _, opt := range opts {
+		err = opt(&m)
+		if err != nil {
+			return errors.Wrapf(err, "migration %s", m.Name)
+		}
+	}
+
+	if m.Checksum == "" {
+		return errors.Wrapf(ErrNoChecksum, "migration %s", m.Name)
+	}
+
+	err = checkAllowedMigrationFunctions(m.Up)
+	if err != nil {
+		return errors.Wrap(err, "invalid up migration")
+	}
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	if x.repeatables == nil {
+		x.repeatables = make(map[string]migration)
+	}
+
+	if _, exists := x.repeatables[m.Name]; exists {
+		return errors.Wrapf(ErrMigrationAlreadyExists, "repeatable migration %s", m.Name)
+	}
+	x.repeatables[m.Name] = m
+	x.generation++
+	return nil
+}
+
+// listRepeatables returns all repeatable migrations, sorted by name.
+func (x *Registry) listRepeatables() []migration {
+	x.mtx.RLock()
+	defer x.mtx.RUnlock()
+
+	repeatables := make([]migration, 0, len(x.repeatables))
+	for _, m := range x.repeatables {
+		repeatables = append(repeatables, m)
+	}
+	sort.Slice(repeatables, func(i, j int) bool {
+		return repeatables[i].Name < repeatables[j].Name
+	})
+	return repeatables
+}
+
+// RegisterRepeatable adds a repeatable migration to the list of known
+// migrations. See Registry.RegisterRepeatable.
+func (x *Migrator) RegisterRepeatable(name string, checksum string, up interface{}, opts ...MigrationOpt) error {
+	return x.registry.RegisterRepeatable(name, checksum, up, opts...)
+}
+
+// RegisterRepeatableSQL adds a repeatable SQL migration to the list of known
+// migrations. See Registry.RegisterRepeatableSQL.
+func (x *Migrator) RegisterRepeatableSQL(name string, upSQL string, opts ...MigrationOpt) error {
+	return x.registry.RegisterRepeatableSQL(name, upSQL, opts...)
+}
+
+// repeatableTableName returns the name of the table which tracks
+// repeatable migrations.
+func (x *Migrator) repeatableTableName() string {
+	return x.migrationTableName + RepeatableTableSuffix
+}
+
+// runRepeatables runs any repeatable migrations which have changed, in
+// their own transaction.
+func (x *Migrator) runRepeatables(db TxRunner) error {
+	if len(x.registry.listRepeatables()) == 0 {
+		return nil
+	}
+
+	return x.runInTransactions(
+		x.ctx,
+		db,
+		func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+			err = x.maybeLockTable(stateTx)
+			if err != nil {
+				return err
+			}
+
+			return x.applyRepeatables(tx, stateTx)
+		},
+	)
+}
+
+// applyRepeatables runs every repeatable migration whose checksum differs
+// from the checksum recorded in stateTx when it last ran.
+func (x *Migrator) applyRepeatables(tx *pg.Tx, stateTx *pg.Tx) error {
+	repeatables := x.registry.listRepeatables()
+	if len(repeatables) == 0 {
+		return nil
+	}
+
+	table := pg.Ident(x.repeatableTableName())
+	_, err := stateTx.Exec(
+		`
+			CREATE TABLE IF NOT EXISTS ? (
+				name varchar PRIMARY KEY,
+				checksum varchar NOT NULL,
+				migration_time timestamptz NOT NULL
+			)
+		`,
+		table,
+	)
+	if err != nil {
+		return err
+	}
+
+	var applied []struct {
+		Name     string
+		Checksum string
+	}
+	_, err = stateTx.Query(&applied, "SELECT name, checksum FROM ?", table)
+	if err != nil {
+		return err
+	}
+
+	checksums := make(map[string]string, len(applied))
+	for _, row := range applied {
+		checksums[row.Name] = row.Checksum
+	}
+
+	for _, repeatable := range repeatables {
+		if checksums[repeatable.Name] == repeatable.Checksum {
+			continue
+		}
+
+		x.logAtLevel(LogLevelInfo, "Repeatable run: %s\n", repeatable.Name)
+		x.emit(Event{Type: MigrationStarted, Direction: Up, Migration: repeatable.Name, Memo: x.memo})
+		err = x.runMigrationFunc(tx, repeatable.Name, Up, repeatable.Up)
+		if err != nil {
+			return x.newMigrationError(repeatable.Name, Up, 0, err)
+		}
+
+		_, err = stateTx.Exec(
+			`
+				INSERT INTO ? (name, checksum, migration_time) VALUES (?, ?, ?)
+				ON CONFLICT (name) DO UPDATE
+				SET checksum = excluded.checksum, migration_time = excluded.migration_time
+			`,
+			table,
+			repeatable.Name,
+			repeatable.Checksum,
+			x.repeatableTime(),
+		)
+		if err != nil {
+			return err
+		}
+		x.emit(Event{Type: MigrationCompleted, Direction: Up, Migration: repeatable.Name, Memo: x.memo})
+	}
+
+	return nil
+}
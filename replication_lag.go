@@ -0,0 +1,138 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultReplicationLagBackoff is how long a Migrator waits before
+	// checking the replication lag again, unless set in the
+	// ReplicationLagCheck. The wait doubles after each check, up to
+	// maxReplicationLagBackoff.
+	DefaultReplicationLagBackoff = time.Second
+
+	maxReplicationLagBackoff = time.Minute
+)
+
+// ReplicationLagFunc returns the current replication lag of the DB which
+// migrations are run against. See PrimaryReplicationLag.
+type ReplicationLagFunc func(ctx context.Context, db Querier) (time.Duration, error)
+
+// ReplicationLagCheck configures the replication lag check enabled with
+// WithReplicationLagCheck.
+type ReplicationLagCheck struct {
+	// MaxLag is the greatest replication lag at which migrations are run.
+	MaxLag time.Duration
+
+	// Retries is how many more times the lag is checked while it exceeds
+	// MaxLag, waiting Backoff before the first retry and twice as long
+	// before each one after it. Backoff defaults to
+	// DefaultReplicationLagBackoff.
+	Retries int
+	Backoff time.Duration
+
+	// Action determines what happens if the lag still exceeds MaxLag
+	// after the last retry.
+	Action PreflightAction
+
+	// Lag measures the replication lag, e.g. by querying a monitoring
+	// system. Defaults to PrimaryReplicationLag.
+	Lag ReplicationLagFunc
+}
+
+// primaryReplicationLagQuery returns the greatest replay lag of the
+// standbys of the current server, in milliseconds. The lag of a standby
+// which has caught up and seen no further changes is NULL.
+const primaryReplicationLagQuery = `
+	SELECT coalesce((extract(epoch FROM max(replay_lag)) * 1000)::bigint, 0)
+	FROM pg_stat_replication
+`
+
+// PrimaryReplicationLag returns the greatest replay lag of the standbys
+// connected to db, from pg_stat_replication, or zero if there are none.
+// Seeing the lag of other users' standbys requires the pg_monitor role.
+func PrimaryReplicationLag(ctx context.Context, db Querier) (time.Duration, error) {
+	var lagMs int64
+	_, err := db.QueryOne(pg.Scan(&lagMs), primaryReplicationLagQuery)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(lagMs) * time.Millisecond, nil
+}
+
+// WithReplicationLagCheck initialises a Migrator which checks the
+// replication lag before each run which applies or rolls back migrations,
+// and between migrations committed separately, so that heavy DDL is not
+// piled onto standbys which are already behind. While the lag exceeds
+// check.MaxLag, the check is retried with backoff.
+//
+// If the lag is still too high after the last retry, it is logged and the
+// run goes ahead with PreflightWarn, or an error wrapping
+// ErrPreflightFailed is returned with PreflightFail.
+//
+// Intended for use with NewMigrator.
+func WithReplicationLagCheck(check ReplicationLagCheck) MigratorOpt {
+	return func(x *Migrator) error {
+		x.replicationLagCheck = &check
+		return nil
+	}
+}
+
+// checkReplicationLag runs the check enabled with WithReplicationLagCheck,
+// waiting for the lag to fall below the maximum. The error of the context
+// of the Migrator is returned if it is cancelled while waiting.
+func (x *Migrator) checkReplicationLag() error {
+	check := x.replicationLagCheck
+	if check == nil || check.MaxLag <= 0 {
+		return nil
+	}
+
+	lagFunc := check.Lag
+	if lagFunc == nil {
+		lagFunc = PrimaryReplicationLag
+	}
+	backoff := check.Backoff
+	if backoff <= 0 {
+		backoff = DefaultReplicationLagBackoff
+	}
+
+	var lag time.Duration
+	for attempt := 0; ; attempt++ {
+		var err error
+		lag, err = lagFunc(x.ctx, x.dbFactory().WithContext(x.ctx))
+		if err != nil {
+			return errors.Wrap(err, "failed to check replication lag")
+		}
+		if lag <= check.MaxLag {
+			x.logAtLevel(LogLevelDebug, "Replication lag %s is within %s\n", lag, check.MaxLag)
+			return nil
+		}
+		if attempt >= check.Retries {
+			break
+		}
+
+		x.logAtLevel(LogLevelInfo, "Replication lag %s exceeds %s, checking again in %s\n", lag, check.MaxLag, backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-x.ctx.Done():
+			timer.Stop()
+			return x.ctx.Err()
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > maxReplicationLagBackoff {
+			backoff = maxReplicationLagBackoff
+		}
+	}
+
+	if check.Action == PreflightFail {
+		return errors.Wrapf(ErrPreflightFailed, "replication lag %s exceeds %s", lag, check.MaxLag)
+	}
+	x.logAtLevel(LogLevelError, "Preflight: replication lag %s exceeds %s\n", lag, check.MaxLag)
+	return nil
+}
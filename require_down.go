@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"reflect"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrDownRequired indicates that a migration was registered with
+	// IrreversibleDown by a Migrator which requires down migrations. See
+	// WithRequireDown.
+	ErrDownRequired = errors.New("down migration required")
+)
+
+// IrreversibleDown is the down function of a migration which cannot be
+// rolled back, e.g. because it drops data, so that this is stated rather
+// than hidden in a down function which does nothing:
+//
+//	registry.Register("20240101120000_drop_legacy", upDropLegacy, migrations.IrreversibleDown)
+//
+// Rolling the migration back fails with an error wrapping
+// ErrIrreversibleMigration, and PlanRollback reports it as RiskHigh. Such
+// migrations may be forbidden with WithRequireDown.
+func IrreversibleDown(*pg.Tx) error {
+	return errors.Wrap(ErrIrreversibleMigration, "migration has no down function")
+}
+
+// isIrreversibleDown reports whether fn is IrreversibleDown.
+func isIrreversibleDown(fn interface{}) bool {
+	downFunc, ok := fn.(func(*pg.Tx) error)
+	if !ok || downFunc == nil {
+		return false
+	}
+	return reflect.ValueOf(downFunc).Pointer() == reflect.ValueOf(IrreversibleDown).Pointer()
+}
+
+// WithRequireDown initialises a Migrator which, if require is true,
+// rejects migrations whose down function is IrreversibleDown, so that
+// reversibility can be enforced by policy, e.g. only in production. The
+// named migrations are allowed regardless, e.g. those which predate the
+// policy. If used more than once, the last require wins and the allowed
+// names are combined, so the policy may be set per environment, e.g. with
+// NewMigratorFromEnv, while the allowed names are set in code.
+//
+// NewMigrator fails with an error wrapping ErrDownRequired if a migration
+// it was given is rejected, as do the Register methods of the Migrator for
+// migrations registered later.
+//
+// Intended for use with NewMigrator.
+func WithRequireDown(require bool, allowed ...string) MigratorOpt {
+	return func(x *Migrator) error {
+		x.requireDown = require
+		for _, name := range allowed {
+			if x.irreversibleAllowed == nil {
+				x.irreversibleAllowed = make(map[string]bool, len(allowed))
+			}
+			x.irreversibleAllowed[name] = true
+		}
+		return nil
+	}
+}
+
+// checkDown returns an error wrapping ErrDownRequired if down migrations
+// are required, and the migration is irreversible without being allowed.
+func (x *Migrator) checkDown(name string, down interface{}) error {
+	if !x.requireDown || x.irreversibleAllowed[name] || !isIrreversibleDown(down) {
+		return nil
+	}
+	return errors.Wrapf(ErrDownRequired, "migration %s is irreversible", name)
+}
+
+// checkRegisteredDowns checks the down function of every migration which
+// has already been registered. See checkDown.
+func (x *Migrator) checkRegisteredDowns() error {
+	for _, name := range x.registry.List() {
+		m, _ := x.registry.Get(name)
+		err := x.checkDown(name, m.Down)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package migrations
+
+import (
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// WithSchemaDrop makes Reset drop and recreate the given schemas, rather
+// than rolling back each migration. If no schemas are given, the schema
+// of the migration table is used. This is much faster when there are many
+// migrations, but everything in the schemas is dropped, including objects
+// which were not created by migrations, along with privileges granted on
+// the schemas themselves.
+//
+// Intended for use with Reset.
+func WithSchemaDrop(schemas ...string) RunOpt {
+	return func(x *runOptions) {
+		x.dropSchemas = true
+		x.schemas = append(x.schemas, schemas...)
+	}
+}
+
+// Reset rolls back every applied migration, most recently applied first,
+// in a single transaction. This is intended to give CI and preview
+// environments a clean slate without dropping the whole database.
+//
+// Repeatable migrations have no down function, so the objects they
+// created are left in place, but they are marked as not having run.
+func (x *Migrator) Reset(opts ...RunOpt) error {
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+	x.invalidateUpToDate()
+
+	options := x.newRunOptions(opts)
+	defer x.applyOverrides(options)()
+	var batch, count int
+	db := x.dbFactory()
+	err := x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = x.runInTransactions(
+		x.ctx,
+		db,
+		func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+			err = x.ensureMigrationTable(stateTx)
+			if err != nil {
+				return
+			}
+
+			err = x.maybeLockTable(stateTx)
+			if err != nil {
+				return err
+			}
+
+			if options.dropSchemas {
+				return x.dropSchemas(tx, stateTx, options.schemas)
+			}
+
+			var migrationsToRun []string
+			_, err = stateTx.Query(
+				&migrationsToRun,
+				"select name from ? order by id desc",
+				pg.Ident(x.migrationTableName),
+			)
+			if err != nil {
+				return err
+			}
+
+			missingMigrations, _, _ := difference(migrationsToRun, x.registry.List())
+			if len(missingMigrations) > 0 {
+				return errors.Wrapf(ErrMigrationNotKnown, "unknown migrations: %+v", missingMigrations)
+			}
+
+			if len(migrationsToRun) == 0 {
+				return x.clearRepeatables(stateTx)
+			}
+
+			batch, err = x.getBatchNumber(stateTx)
+			if err != nil {
+				return err
+			}
+
+			count = len(migrationsToRun)
+			x.logAtLevel(LogLevelInfo, "Reset: %d migrations\n", count)
+			x.startBatch(Event{Type: BatchStarted, Direction: Down, Batch: batch, Count: count})
+			for _, migrationName := range migrationsToRun {
+				err = x.revertMigration(tx, stateTx, migrationName, batch)
+				if err != nil {
+					return err
+				}
+			}
+			return x.clearRepeatables(stateTx)
+		},
+	)
+	x.finishRun(err, Down, batch, count)
+	return err
+}
+
+// dropSchemas drops and recreates the given schemas, or the schema of the
+// migration table, in tx, then clears any migration records which remain
+// in stateTx.
+func (x *Migrator) dropSchemas(tx *pg.Tx, stateTx *pg.Tx, schemas []string) error {
+	if len(schemas) == 0 {
+		schemas = []string{tableSchema(x.migrationTableName)}
+	}
+
+	for _, schema := range schemas {
+		x.logAtLevel(LogLevelInfo, "Reset: dropping schema %s\n", schema)
+		_, err := tx.Exec("DROP SCHEMA IF EXISTS ? CASCADE", pg.Ident(schema))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("CREATE SCHEMA ?", pg.Ident(schema))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The migration table may live outside of the dropped schemas.
+	exists, err := x.migrationTableExists(stateTx)
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = stateTx.Exec("DELETE FROM ?", pg.Ident(x.migrationTableName))
+	if err != nil {
+		return err
+	}
+	return x.clearRepeatables(stateTx)
+}
+
+// clearRepeatables marks every repeatable migration as not having run.
+func (x *Migrator) clearRepeatables(tx *pg.Tx) error {
+	var exists bool
+	_, err := tx.QueryOne(
+		pg.Scan(&exists),
+		"SELECT to_regclass(?) IS NOT NULL",
+		x.repeatableTableName(),
+	)
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM ?", pg.Ident(x.repeatableTableName()))
+	return err
+}
+
+// tableSchema returns the schema of a possibly schema-qualified table
+// name, defaulting to public.
+func tableSchema(tableName string) string {
+	schema, _, found := strings.Cut(tableName, ".")
+	if !found {
+		return "public"
+	}
+	return schema
+}
@@ -0,0 +1,135 @@
+package migrations
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrIrreversibleMigration indicates that one or more migrations
+	// could not be rolled back after being applied.
+	ErrIrreversibleMigration = errors.New("irreversible migration")
+)
+
+// ReversibilityResult describes the outcome of checking a single migration
+// with CheckReversibility.
+type ReversibilityResult struct {
+	// Name is the name of the migration.
+	Name string
+
+	// Err is the error returned while applying or rolling back the
+	// migration, or nil if the migration is reversible.
+	Err error
+}
+
+// CheckReversibility checks that every pending migration can be rolled
+// back after being applied. Nothing is changed in the DB: everything runs
+// in a single transaction which is always rolled back.
+//
+// Each migration's up function is run, immediately followed by its down
+// function, and then its up function once more so that later migrations
+// are checked against the schema they expect. A savepoint is used for each
+// migration, so a failing migration does not prevent the remaining
+// migrations from being checked, although migrations which depend on it
+// are likely to fail as well.
+//
+// A result is returned for every pending migration. If any migration
+// failed, an error wrapping ErrIrreversibleMigration is also returned.
+func (x *Migrator) CheckReversibility() ([]ReversibilityResult, error) {
+	db := x.dbFactory()
+	tx, err := db.BeginContext(x.ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The check is always rolled back, so nothing is ever applied.
+	defer tx.Close()
+
+	stateTx := tx
+	if x.stateDBFactory != nil {
+		stateTx, err = x.stateDB().BeginContext(x.ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer stateTx.Close()
+	}
+
+	err = x.ensureMigrationTable(stateTx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = x.maybeLockTable(stateTx)
+	if err != nil {
+		return nil, err
+	}
+
+	migrationsToRun, err := x.getMigrationsToRun(stateTx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = x.ensureExtensions(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	x.logAtLevel(LogLevelInfo, "Reversibility check: %d migrations\n", len(migrationsToRun))
+	results := make([]ReversibilityResult, 0, len(migrationsToRun))
+	var failed []string
+	for _, migrationName := range migrationsToRun {
+		migration, exists := x.registry.Get(migrationName)
+		if !exists {
+			return nil, errors.Wrapf(ErrMigrationNotKnown, "migration %s", migrationName)
+		}
+
+		_, err = tx.Exec("SAVEPOINT reversibility_check")
+		if err != nil {
+			return nil, err
+		}
+
+		checkErr := x.runMigrationFunc(tx, migrationName, Up, migration.Up)
+		if checkErr != nil {
+			checkErr = errors.Wrapf(checkErr, "%s failed to migrate", migrationName)
+		}
+		if checkErr == nil {
+			checkErr = x.runMigrationFunc(tx, migrationName, Down, migration.Down)
+			if checkErr == nil {
+				checkErr = x.verifyDown(tx, migration)
+			}
+			if checkErr != nil {
+				checkErr = errors.Wrapf(checkErr, "%s failed to rollback", migrationName)
+			}
+		}
+		if checkErr == nil {
+			checkErr = x.runMigrationFunc(tx, migrationName, Up, migration.Up)
+			if checkErr != nil {
+				checkErr = errors.Wrapf(checkErr, "%s failed to migrate after rollback", migrationName)
+			}
+		}
+
+		if checkErr != nil {
+			x.logAtLevel(LogLevelInfo, "Irreversible: %v\n", checkErr)
+			failed = append(failed, migrationName)
+			_, err = tx.Exec("ROLLBACK TO SAVEPOINT reversibility_check")
+		} else {
+			x.logAtLevel(LogLevelDebug, "Reversible: %s\n", migrationName)
+			_, err = tx.Exec("RELEASE SAVEPOINT reversibility_check")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, ReversibilityResult{
+			Name: migrationName,
+			Err:  checkErr,
+		})
+	}
+
+	if len(failed) > 0 {
+		return results, errors.Wrapf(
+			ErrIrreversibleMigration,
+			"failed migrations: %+v",
+			failed,
+		)
+	}
+	return results, nil
+}
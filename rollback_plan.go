@@ -0,0 +1,240 @@
+package migrations
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// RollbackRisk estimates how much harm rolling back a migration may do.
+// Risks are ordered, so that they can be compared.
+type RollbackRisk byte
+
+const (
+	// RiskLow indicates that the down migration only changes the schema
+	// without destroying data, e.g. by dropping an index or a view.
+	RiskLow RollbackRisk = iota
+
+	// RiskUnknown indicates that the down migration is a Go function
+	// whose risk was not declared with DownRisk, so it cannot be analysed.
+	RiskUnknown
+
+	// RiskHigh indicates that the down migration destroys data, e.g. by
+	// dropping a table or a column, or deleting rows.
+	RiskHigh
+)
+
+// String returns the name of the risk.
+func (x RollbackRisk) String() string {
+	switch x {
+	case RiskLow:
+		return "low"
+	case RiskUnknown:
+		return "unknown"
+	case RiskHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("risk(%d)", byte(x))
+	}
+}
+
+// DownRisk declares the risk of rolling back a migration, overriding the
+// analysis of its down SQL. This allows migrations registered as Go
+// functions to be planned, and heuristics to be corrected.
+func DownRisk(risk RollbackRisk) MigrationOpt {
+	return func(x *migration) error {
+		x.DownRisk = &risk
+		return nil
+	}
+}
+
+// RollbackStep describes the risk of rolling back one migration.
+type RollbackStep struct {
+	Name string
+	Risk RollbackRisk
+
+	// Reasons lists the statements which made the rollback risky, or the
+	// reason the risk could not be worked out.
+	Reasons []string
+}
+
+// RollbackPlan describes what Rollback would do: the migrations of the
+// most recent batch it would revert, in order, and the risk of each.
+type RollbackPlan struct {
+	Batch int
+	Steps []RollbackStep
+
+	// Risk is the highest risk of any step.
+	Risk RollbackRisk
+}
+
+// String returns a report of the plan, with a line for each migration.
+func (x RollbackPlan) String() string {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "Rollback of batch %d: %d migrations, %s risk", x.Batch, len(x.Steps), x.Risk)
+	for _, step := range x.Steps {
+		fmt.Fprintf(builder, "\n  %s: %s risk", step.Name, step.Risk)
+		if len(step.Reasons) > 0 {
+			fmt.Fprintf(builder, " (%s)", strings.Join(step.Reasons, "; "))
+		}
+	}
+	return builder.String()
+}
+
+// WithRollbackRiskCheck initialises a Migrator which plans each Rollback
+// before running it, as by PlanRollback, and logs the plan. If any
+// migration in the batch has a risk higher than maxRisk, nothing is rolled
+// back and an error wrapping ErrRollbackUnsafe is returned, unless
+// WithForce is passed to Rollback.
+//
+// Intended for use with NewMigrator.
+func WithRollbackRiskCheck(maxRisk RollbackRisk) MigratorOpt {
+	return func(x *Migrator) error {
+		x.rollbackRiskCheck = true
+		x.maxRollbackRisk = maxRisk
+		return nil
+	}
+}
+
+// destructiveStatements are the prefixes of statements which destroy data
+// when run by a down migration.
+var destructiveStatements = []string{
+	"DROP TABLE",
+	"DROP SCHEMA",
+	"DROP DATABASE",
+	"DROP MATERIALIZED VIEW",
+	"TRUNCATE",
+	"DELETE",
+	"UPDATE",
+}
+
+// alterDropPattern matches the things dropped by an ALTER TABLE statement,
+// such as "DROP COLUMN email" or "DROP CONSTRAINT users_pkey".
+var alterDropPattern = regexp.MustCompile(`(?i)\bDROP\s+(\w+)`)
+
+// nonDestructiveDrops are the words following DROP in an ALTER TABLE
+// statement which do not drop a column.
+var nonDestructiveDrops = map[string]struct{}{
+	"constraint": {},
+	"default":    {},
+	"not":        {},
+	"identity":   {},
+	"expression": {},
+}
+
+// isDestructiveStatement reports whether a statement, with its whitespace
+// normalised, destroys data.
+func isDestructiveStatement(statement string) bool {
+	upper := strings.ToUpper(statement)
+	for _, prefix := range destructiveStatements {
+		if strings.HasPrefix(upper, prefix+" ") {
+			return true
+		}
+	}
+	if !strings.HasPrefix(upper, "ALTER TABLE ") {
+		return false
+	}
+	for _, match := range alterDropPattern.FindAllStringSubmatch(statement, -1) {
+		if _, ok := nonDestructiveDrops[strings.ToLower(match[1])]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanRollback works out what Rollback would do, and estimates the risk of
+// each migration it would revert. The migration table is neither created
+// nor locked. A nil plan is returned if no migrations have been applied.
+//
+// Migrations registered from SQL are analysed by looking for statements in
+// their down SQL which destroy data, such as DROP TABLE, DROP COLUMN,
+// TRUNCATE and DELETE. Risks declared with DownRisk take precedence.
+func (x *Migrator) PlanRollback() (*RollbackPlan, error) {
+	return x.planRollback(x.stateDB().WithContext(x.ctx))
+}
+
+// planRollback plans the rollback of the most recent batch.
+func (x *Migrator) planRollback(db Querier) (*RollbackPlan, error) {
+	exists, err := x.migrationTableExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	batch, err := x.getBatchNumber(db)
+	if err != nil || batch == 0 {
+		return nil, err
+	}
+
+	names, err := x.getMigrationsInBatch(db, batch)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	x.sortMigrations(names)
+
+	plan := &RollbackPlan{Batch: batch}
+	for _, name := range names {
+		m, exists := x.registry.Get(x.currentName(name))
+		if !exists {
+			return nil, errors.Wrapf(ErrMigrationNotKnown, "migration %s", name)
+		}
+
+		step := rollbackStep(m)
+		if step.Risk > plan.Risk {
+			plan.Risk = step.Risk
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+// rollbackStep estimates the risk of rolling back a migration.
+func rollbackStep(m migration) RollbackStep {
+	step := RollbackStep{Name: m.Name}
+	switch {
+	case m.DownRisk != nil:
+		step.Risk = *m.DownRisk
+		step.Reasons = []string{"declared"}
+	case isIrreversibleDown(m.Down):
+		step.Risk = RiskHigh
+		step.Reasons = []string{"irreversible"}
+	case m.DownSQL == "" && m.UpSQL == "":
+		step.Risk = RiskUnknown
+		step.Reasons = []string{"Go function"}
+	default:
+		for _, statement := range strings.Split(m.DownSQL, ";") {
+			statement = strings.Join(strings.Fields(statement), " ")
+			if isDestructiveStatement(statement) {
+				step.Risk = RiskHigh
+				step.Reasons = append(step.Reasons, statement)
+			}
+		}
+	}
+	return step
+}
+
+// checkRollbackRisk plans the rollback of the most recent batch, logs the
+// plan and returns an error if it is too risky.
+func (x *Migrator) checkRollbackRisk(db *pg.Tx) error {
+	plan, err := x.planRollback(db)
+	if err != nil || plan == nil {
+		return err
+	}
+
+	x.logAtLevel(LogLevelInfo, "%s\n", plan)
+	if plan.Risk > x.maxRollbackRisk {
+		return errors.Wrapf(
+			ErrRollbackUnsafe,
+			"batch %d has %s risk, above the maximum of %s",
+			plan.Batch,
+			plan.Risk,
+			x.maxRollbackRisk,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package migrations
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMigrationNotApplied indicates that an attempt was made to roll
+	// back a migration which has not been run.
+	ErrMigrationNotApplied = errors.New("migration not applied")
+
+	// ErrRollbackUnsafe indicates that a migration was not rolled back
+	// because migrations applied after it reference the same objects.
+	ErrRollbackUnsafe = errors.New("rollback unsafe")
+)
+
+// WithRollbackSafetyCheck initialises a Migrator which will refuse to
+// roll back an individual migration with RollbackMigration if migrations
+// applied after it reference the same tables, unless forced.
+//
+// Referenced objects are found by scanning the SQL of migrations which
+// were registered from SQL. Migrations registered as Go functions cannot
+// be analysed, so unless the objects they touched were recorded with
+// WithObjectTracking, the objects they reference are unknown, and the
+// check fails if it depends on them.
+//
+// Intended for use with NewMigrator.
+func WithRollbackSafetyCheck() MigratorOpt {
+	return func(x *Migrator) error {
+		x.rollbackSafetyCheck = true
+		return nil
+	}
+}
+
+// RollbackMigration rolls back a single applied migration, regardless of
+// the batch it was applied in.
+//
+// If the Migrator was created with WithRollbackSafetyCheck, this returns an
+// error wrapping ErrRollbackUnsafe when later applied migrations reference
+// the same objects. Pass WithForce to roll back regardless.
+func (x *Migrator) RollbackMigration(name string, opts ...RunOpt) error {
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+	x.invalidateUpToDate()
+
+	options := x.newRunOptions(opts)
+	defer x.applyOverrides(options)()
+	var batch int
+	db := x.dbFactory()
+	err := x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = x.runInTransactions(
+		x.ctx,
+		db,
+		func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+			err = x.ensureMigrationTable(stateTx)
+			if err != nil {
+				return
+			}
+
+			err = x.maybeLockTable(stateTx)
+			if err != nil {
+				return err
+			}
+
+			migration, exists := x.registry.Get(name)
+			if !exists {
+				return errors.Wrapf(ErrMigrationNotKnown, "migration %s", name)
+			}
+
+			var batches []int
+			_, err = stateTx.Query(
+				&batches,
+				"SELECT batch FROM ? WHERE name = ?",
+				pg.Ident(x.migrationTableName),
+				name,
+			)
+			if err != nil {
+				return err
+			}
+			if len(batches) == 0 {
+				return errors.Wrapf(ErrMigrationNotApplied, "migration %s", name)
+			}
+			batch = batches[0]
+
+			var laterMigrations []string
+			_, err = stateTx.Query(
+				&laterMigrations,
+				`
+					SELECT name FROM ?
+					WHERE id > (SELECT max(id) FROM ? WHERE name = ?)
+					ORDER BY id
+				`,
+				pg.Ident(x.migrationTableName),
+				pg.Ident(x.migrationTableName),
+				name,
+			)
+			if err != nil {
+				return err
+			}
+
+			if x.rollbackSafetyCheck && !options.force {
+				var recorded map[string][]string
+				recorded, err = x.getRecordedObjects(stateTx)
+				if err != nil {
+					return err
+				}
+
+				err = x.checkRollbackSafety(migration, laterMigrations, recorded)
+				if err != nil {
+					return err
+				}
+			}
+
+			x.logAtLevel(LogLevelInfo, "Rollback: 1 migration - %s\n", name)
+			x.startBatch(Event{Type: BatchStarted, Direction: Down, Batch: batch, Count: 1})
+			return x.revertMigration(tx, stateTx, name, batch)
+		},
+	)
+	x.finishRun(err, Down, batch, 1)
+	return err
+}
+
+// checkRollbackSafety returns an error if any of the later migrations
+// reference objects which are also referenced by m. The objects recorded
+// for each migration by WithObjectTracking are included along with those
+// found in its SQL. If the objects referenced by m, or by a later
+// migration, are unknown, because it is a Go function whose objects were
+// not recorded, the rollback is reported as unsafe.
+func (x *Migrator) checkRollbackSafety(m migration, laterMigrations []string, recorded map[string][]string) error {
+	if len(laterMigrations) == 0 {
+		return nil
+	}
+
+	objects, known := migrationObjects(m, m.UpSQL+"\n"+m.DownSQL, recorded)
+	if !known {
+		return errors.Wrapf(
+			ErrRollbackUnsafe,
+			"the objects referenced by %s are unknown, and migrations were applied after it",
+			m.Name,
+		)
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+
+	var conflicts []string
+	for _, laterName := range laterMigrations {
+		later, exists := x.registry.Get(laterName)
+		if !exists {
+			return errors.Wrapf(ErrMigrationNotKnown, "migration %s", laterName)
+		}
+
+		laterObjects, known := migrationObjects(later, later.UpSQL, recorded)
+		if !known {
+			conflicts = append(conflicts, laterName+" (unknown)")
+			continue
+		}
+		for object := range laterObjects {
+			if _, ok := objects[object]; ok {
+				conflicts = append(conflicts, laterName+" ("+object+")")
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return errors.Wrapf(
+			ErrRollbackUnsafe,
+			"%s is referenced by later migrations: %+v",
+			m.Name,
+			conflicts,
+		)
+	}
+	return nil
+}
+
+// migrationObjects returns the objects referenced by the given SQL of m,
+// along with the objects recorded for it by WithObjectTracking. known is
+// false if m was not registered from SQL and has no recorded objects, so
+// the objects it references cannot be told.
+func migrationObjects(m migration, sql string, recorded map[string][]string) (objects map[string]struct{}, known bool) {
+	objects = referencedObjects(sql)
+	for _, object := range recorded[m.Name] {
+		objects[object] = struct{}{}
+	}
+	known = m.UpSQL != "" || m.DownSQL != "" || len(recorded[m.Name]) > 0
+	return objects, known
+}
+
+// getRecordedObjects returns the objects recorded for each applied
+// migration by WithObjectTracking.
+func (x *Migrator) getRecordedObjects(db Querier) (map[string][]string, error) {
+	applied, err := x.getAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := make(map[string][]string, len(applied))
+	for _, appliedMigration := range applied {
+		if len(appliedMigration.Objects) > 0 {
+			recorded[appliedMigration.Name] = appliedMigration.Objects
+		}
+	}
+	return recorded, nil
+}
+
+// objectReferencePattern matches the names of objects following DDL
+// keywords, e.g. "ALTER TABLE IF EXISTS public.users", "ON users" or
+// "GRANT SELECT ON TABLE users". The kind of object which may follow ON,
+// as in GRANT, is skipped rather than taken as the name.
+var objectReferencePattern = regexp.MustCompile(
+	`(?i)\b(?:TABLE|VIEW|INDEX|SEQUENCE|REFERENCES|INTO|UPDATE|` +
+		`ON(?:\s+(?:TABLE|MATERIALIZED\s+VIEW|VIEW|SEQUENCE|FOREIGN\s+TABLE))?)\s+` +
+		`(?:IF\s+(?:NOT\s+)?EXISTS\s+)?(?:ONLY\s+)?(?:CONCURRENTLY\s+)?` +
+		`("?[\w]+"?(?:\."?[\w]+"?)?)`,
+)
+
+// nonObjectWords are words which can follow the keywords matched by
+// objectReferencePattern without being object names.
+var nonObjectWords = map[string]struct{}{
+	"conflict": {},
+	"delete":   {},
+	"update":   {},
+	"commit":   {},
+	"set":      {},
+	"if":       {},
+	"all":      {},
+}
+
+// referencedObjects returns the set of object names referenced by DDL
+// statements in query. Names are lowercased, unquoted and stripped of
+// the public schema so that different spellings compare equal.
+func referencedObjects(query string) map[string]struct{} {
+	objects := make(map[string]struct{})
+	for _, match := range objectReferencePattern.FindAllStringSubmatch(query, -1) {
+		object := strings.ToLower(strings.ReplaceAll(match[1], `"`, ""))
+		object = strings.TrimPrefix(object, "public.")
+		if _, ok := nonObjectWords[object]; ok {
+			continue
+		}
+		objects[object] = struct{}{}
+	}
+	return objects
+}
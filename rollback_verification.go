@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrRollbackVerificationFailed indicates that the down function of a
+	// migration ran, but the function declared with VerifyDown found that
+	// the DB was not returned to its prior state.
+	ErrRollbackVerificationFailed = errors.New("rollback verification failed")
+)
+
+// VerifyDown declares a function which asserts that rolling back the
+// migration returned the schema and data to the state they were in before
+// it was applied, e.g. that a table it created no longer exists. fn may
+// have any of the signatures accepted for down functions, and should
+// return an error describing what was left behind.
+//
+// fn runs after the down function, in the same transaction, so a failed
+// verification fails the rollback and nothing is rolled back. It also runs
+// after the down function during CheckReversibility.
+func VerifyDown(fn interface{}) MigrationOpt {
+	return func(x *migration) error {
+		err := checkAllowedMigrationFunctions(fn)
+		if err != nil {
+			return errors.Wrap(err, "invalid down verification")
+		}
+		x.VerifyDown = fn
+		return nil
+	}
+}
+
+// verifyDown runs the down verification of a migration in tx, if one was
+// declared with VerifyDown.
+func (x *Migrator) verifyDown(tx *pg.Tx, m migration) error {
+	if m.VerifyDown == nil {
+		return nil
+	}
+
+	x.logAtLevel(LogLevelTrace, "Verifying rollback of %s\n", m.Name)
+	err := x.runMigrationFunc(tx, m.Name, Down, m.VerifyDown)
+	if err != nil {
+		return errors.Wrapf(ErrRollbackVerificationFailed, "%v", err)
+	}
+	return nil
+}
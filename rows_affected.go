@@ -0,0 +1,130 @@
+package migrations
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// countedStatements are the statements whose affected rows are counted by
+// WithRowCounting.
+var countedStatements = []string{"INSERT", "UPDATE", "DELETE", "MERGE", "COPY"}
+
+// WithRowCounting initialises a Migrator which counts the rows affected by
+// the INSERT, UPDATE, DELETE, MERGE and COPY statements each migration runs
+// in its transaction, using a query hook added as by WithQueryHook. The
+// count is logged when the migration completes, reported in the
+// MigrationCompleted event and recorded in the migration table, which
+// helps to sanity check backfills.
+//
+// Without this option, migrations may still report the rows they affected
+// with Context.AddRowsAffected or Context.CountRows.
+//
+// Intended for use with NewMigrator.
+func WithRowCounting() MigratorOpt {
+	return func(x *Migrator) error {
+		if x.rowCounter == nil {
+			x.rowCounter = &rowCounter{counts: make(map[*pg.Tx]*atomic.Int64)}
+			x.queryHooks = append(x.queryHooks, x.rowCounter)
+		}
+		return nil
+	}
+}
+
+// AddRowsAffected adds n to the number of rows the running migration is
+// reported to have affected, e.g. for rows changed in a DO block, which
+// WithRowCounting cannot see. See WithRowCounting.
+//
+// AddRowsAffected does nothing if the context was not provided by a
+// Migrator.
+func (x *Context) AddRowsAffected(n int) {
+	if x == nil || x.rowsAffected == nil {
+		return
+	}
+	x.rowsAffected.Add(int64(n))
+}
+
+// CountRows adds the rows affected by a statement to those of the running
+// migration, and passes through its result, e.g.
+//
+//	_, err := c.CountRows(tx.Exec(`UPDATE users SET active = true`))
+//
+// Statements which failed are not counted. With WithRowCounting, the
+// statement has already been counted, so CountRows only passes through its
+// result.
+func (x *Context) CountRows(result pg.Result, err error) (pg.Result, error) {
+	if x != nil && x.migrator != nil && x.migrator.rowCounter != nil {
+		return result, err
+	}
+	if err == nil && result != nil {
+		x.AddRowsAffected(result.RowsAffected())
+	}
+	return result, err
+}
+
+// rowCounter is a query hook which counts the rows affected by the
+// statements run in the transactions of running migrations.
+type rowCounter struct {
+	mtx    sync.Mutex
+	counts map[*pg.Tx]*atomic.Int64
+}
+
+// Interface Compliance
+var _ pg.QueryHook = (*rowCounter)(nil)
+
+// BeforeQuery does nothing.
+func (x *rowCounter) BeforeQuery(ctx context.Context, _ *pg.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+// AfterQuery counts the rows affected by a successful statement, if it was
+// run in a tracked transaction and modifies rows.
+func (x *rowCounter) AfterQuery(_ context.Context, event *pg.QueryEvent) error {
+	tx, ok := event.DB.(*pg.Tx)
+	if !ok || event.Err != nil || event.Result == nil {
+		return nil
+	}
+
+	x.mtx.Lock()
+	count, tracked := x.counts[tx]
+	x.mtx.Unlock()
+	if !tracked {
+		return nil
+	}
+
+	query, err := event.UnformattedQuery()
+	if err != nil || !isCountedStatement(string(query)) {
+		return nil
+	}
+	count.Add(int64(event.Result.RowsAffected()))
+	return nil
+}
+
+// start begins counting the rows affected in tx, adding them to count.
+func (x *rowCounter) start(tx *pg.Tx, count *atomic.Int64) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.counts[tx] = count
+}
+
+// stop ends counting the rows affected in tx.
+func (x *rowCounter) stop(tx *pg.Tx) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	delete(x.counts, tx)
+}
+
+// isCountedStatement reports whether a query starts with one of the
+// countedStatements.
+func isCountedStatement(query string) bool {
+	query = strings.TrimSpace(query)
+	for _, statement := range countedStatements {
+		if len(query) >= len(statement) && strings.EqualFold(query[:len(statement)], statement) {
+			return true
+		}
+	}
+	return false
+}
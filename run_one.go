@@ -0,0 +1,132 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// RunOne runs a single registered migration in the given direction,
+// regardless of which migrations are pending or which batch it was applied
+// in. The migration table is locked and updated as for any other run.
+//
+// Running a migration up applies it as a new batch of its own. If it was
+// already applied, it is run again and its record is moved to the new
+// batch, which is useful for re-running a data fix or recreating a view in
+// staging. Running a migration down requires it to have been applied, and
+// otherwise returns an error wrapping ErrMigrationNotApplied.
+//
+// Dependencies between migrations are not checked, so the caller must
+// ensure that running the migration on its own is safe.
+func (x *Migrator) RunOne(name string, direction Direction) error {
+	return x.runOne(name, direction, false)
+}
+
+// runOne runs a single migration as described by RunOne. If pendingOnly is
+// set, running a migration up which was already applied returns an error
+// wrapping ErrMigrationAlreadyApplied rather than running it again.
+func (x *Migrator) runOne(name string, direction Direction, pendingOnly bool) error {
+	x.runMtx.Lock()
+	defer x.runMtx.Unlock()
+	x.invalidateUpToDate()
+
+	var batch int
+	db := x.dbFactory()
+	err := x.runPreflightChecks()
+	if err != nil {
+		return err
+	}
+
+	release, err := x.acquireLocks(x.stateDB())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = x.runInTransactions(
+		x.ctx,
+		db,
+		func(tx *pg.Tx, stateTx *pg.Tx) (err error) {
+			err = x.ensureMigrationTable(stateTx)
+			if err != nil {
+				return
+			}
+
+			err = x.maybeLockTable(stateTx)
+			if err != nil {
+				return err
+			}
+
+			if _, exists := x.registry.Get(name); !exists {
+				return errors.Wrapf(ErrMigrationNotKnown, "migration %s", name)
+			}
+
+			var batches []int
+			_, err = stateTx.Query(
+				&batches,
+				"SELECT batch FROM ? WHERE name = ?",
+				pg.Ident(x.migrationTableName),
+				name,
+			)
+			if err != nil {
+				return err
+			}
+
+			if direction == Down {
+				if len(batches) == 0 {
+					return errors.Wrapf(ErrMigrationNotApplied, "migration %s", name)
+				}
+				batch = batches[0]
+
+				x.logAtLevel(LogLevelInfo, "Rollback: 1 migration - %s\n", name)
+				x.startBatch(Event{Type: BatchStarted, Direction: Down, Batch: batch, Count: 1})
+				return x.revertMigration(tx, stateTx, name, batch)
+			}
+
+			if pendingOnly && len(batches) > 0 {
+				return errors.Wrapf(ErrMigrationAlreadyApplied, "migration %s in batch %d", name, batches[0])
+			}
+
+			err = x.checkRunWindow([]string{name})
+			if err != nil {
+				return err
+			}
+
+			err = x.checkRequiredPrivileges(tx, []string{name})
+			if err != nil {
+				return err
+			}
+
+			err = x.ensureExtensions(tx)
+			if err != nil {
+				return err
+			}
+
+			batch, err = x.getBatchNumber(stateTx)
+			if err != nil {
+				return err
+			}
+			batch++
+
+			if len(batches) > 0 {
+				x.logAtLevel(LogLevelInfo, "Re-applying %s, previously in batch %d\n", name, batches[0])
+				_, err = stateTx.Exec(
+					"DELETE FROM ? WHERE name = ?",
+					pg.Ident(x.migrationTableName),
+					name,
+				)
+				if err != nil {
+					return err
+				}
+			}
+
+			x.logAtLevel(LogLevelInfo, "Batch %d run: 1 migration - %s\n", batch, name)
+			x.startBatch(Event{Type: BatchStarted, Direction: Up, Batch: batch, Count: 1})
+			return x.applyMigration(tx, stateTx, name, batch)
+		},
+	)
+	x.finishRun(err, direction, batch, 1)
+	if err == nil && direction == Up {
+		err = x.runMaintenance(db, []string{name})
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package migrations
+
+// RunOpt represents an option which can be applied to a single run of
+// a Migrator. See the methods of Migrator accepting RunOpts.
+type RunOpt func(*runOptions)
+
+// runOptions holds the options for a single run of a Migrator.
+type runOptions struct {
+	force       bool
+	dropSchemas bool
+	schemas     []string
+	includeTags []string
+	excludeTags []string
+
+	skipIfInitialized bool
+
+	// phase and earlierPhases limit a run to the migrations of a phase.
+	// See MigratePhase.
+	phase         string
+	earlierPhases []string
+
+	// maxMigrations and checkpoint control step-by-step runs. See
+	// WithMaxMigrations and WithCheckpoint.
+	maxMigrations int
+	checkpoint    func(Checkpoint)
+
+	// overrides change the settings of the Migrator for the run only.
+	// See applyOverrides.
+	overrides []func(*Migrator)
+}
+
+// newRunOptions applies opts to a default set of run options.
+func newRunOptions(opts []RunOpt) runOptions {
+	var options runOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// newRunOptions applies the default run options of the Migrator, then
+// opts. See WithDefaultRunOpts.
+func (x *Migrator) newRunOptions(opts []RunOpt) runOptions {
+	return newRunOptions(append(append([]RunOpt(nil), x.defaultRunOpts...), opts...))
+}
+
+// WithDefaultRunOpts initialises a Migrator which applies opts to every
+// run, before the options given to the run itself, e.g. to limit every run
+// to migrations with certain tags with WithTags.
+//
+// Intended for use with NewMigrator.
+func WithDefaultRunOpts(opts ...RunOpt) MigratorOpt {
+	return func(x *Migrator) error {
+		x.defaultRunOpts = append(x.defaultRunOpts, opts...)
+		return nil
+	}
+}
+
+// WithForce skips safety checks which would otherwise refuse to run.
+//
+// Intended for use with Rollback or RollbackMigration.
+func WithForce() RunOpt {
+	return func(x *runOptions) {
+		x.force = true
+	}
+}
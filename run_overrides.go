@@ -0,0 +1,102 @@
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithLockTimeout initialises a Migrator which sets lock_timeout for the
+// transactions in which it runs migrations, so that a migration waiting
+// for a lock, or for the migration table, fails instead of blocking other
+// queries behind it. Values less than or equal to 0 leave the setting of
+// the DB in place (the default).
+//
+// Intended for use with NewMigrator.
+func WithLockTimeout(timeout time.Duration) MigratorOpt {
+	return func(x *Migrator) error {
+		x.lockTimeout = timeout
+		return nil
+	}
+}
+
+// WithRunLockTimeout overrides the lock timeout of the Migrator for a
+// single run. See WithLockTimeout.
+//
+// Intended for use with the methods of Migrator accepting RunOpts.
+func WithRunLockTimeout(timeout time.Duration) RunOpt {
+	return func(x *runOptions) {
+		x.overrides = append(x.overrides, func(m *Migrator) {
+			m.lockTimeout = timeout
+		})
+	}
+}
+
+// WithRunMaxBatchSize overrides the maximum batch size of the Migrator for
+// a single run. See WithMaxBatchSize.
+//
+// Intended for use with MigrateBatch or MigrateWithInit.
+func WithRunMaxBatchSize(n int) RunOpt {
+	return func(x *runOptions) {
+		x.overrides = append(x.overrides, func(m *Migrator) {
+			m.maxBatchSize = n
+		})
+	}
+}
+
+// WithRunBatchTxMode overrides the batch transaction mode of the Migrator
+// for a single run. See WithBatchTxMode.
+//
+// Intended for use with MigrateBatch or MigrateWithInit.
+func WithRunBatchTxMode(mode BatchTxMode) RunOpt {
+	return func(x *runOptions) {
+		x.overrides = append(x.overrides, func(m *Migrator) {
+			m.batchTxMode = mode
+		})
+	}
+}
+
+// runSettings holds the settings of a Migrator which may be overridden
+// for a single run.
+type runSettings struct {
+	lockTimeout  time.Duration
+	maxBatchSize int
+	batchTxMode  BatchTxMode
+	memo         string
+}
+
+// applyOverrides applies the overrides of a run to the Migrator, and
+// returns a function restoring its previous settings. Expects the run
+// mutex to be held until the settings have been restored.
+func (x *Migrator) applyOverrides(options runOptions) (restore func()) {
+	if len(options.overrides) == 0 {
+		return func() {}
+	}
+
+	saved := runSettings{
+		lockTimeout:  x.lockTimeout,
+		maxBatchSize: x.maxBatchSize,
+		batchTxMode:  x.batchTxMode,
+		memo:         x.memo,
+	}
+	for _, override := range options.overrides {
+		override(x)
+	}
+	return func() {
+		x.lockTimeout = saved.lockTimeout
+		x.maxBatchSize = saved.maxBatchSize
+		x.batchTxMode = saved.batchTxMode
+		x.memo = saved.memo
+	}
+}
+
+// setLockTimeout sets lock_timeout for the rest of a transaction, if the
+// Migrator has a lock timeout.
+func (x *Migrator) setLockTimeout(tx *pg.Tx) error {
+	if x.lockTimeout <= 0 {
+		return nil
+	}
+	_, err := tx.Exec("SET LOCAL lock_timeout = ?", fmt.Sprintf("%dms", x.lockTimeout.Milliseconds()))
+	return err
+}
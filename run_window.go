@@ -0,0 +1,252 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrOutsideRunWindow indicates that a migration tagged with
+	// MaintenanceTag was refused because the current time is outside
+	// of the configured run window.
+	ErrOutsideRunWindow = errors.New("outside of maintenance run window")
+
+	// ErrInvalidRunWindow indicates that a run window specification
+	// could not be parsed.
+	ErrInvalidRunWindow = errors.New("invalid run window")
+)
+
+// MaintenanceTag is the tag which marks a migration as only being allowed
+// to run within the run window of a Migrator. See WithRunWindow.
+const MaintenanceTag = "maintenance"
+
+// RunWindow is a recurring window of time during which maintenance
+// migrations are allowed to run.
+//
+// Start and End are times of day in Location, given as offsets from
+// midnight on the wall clock, so that the window opens at the same time of
+// day on days when daylight saving time starts or ends. If End is before
+// Start, the window wraps past midnight. If End equals Start, the window
+// lasts the whole day. If Days is not empty, the window only opens on those
+// days.
+//
+// The window is checked before each batch, and before each migration
+// which is committed separately, e.g. by MigrateStepByStep or with
+// TxPerMigration, so that such a run stops once the window has closed.
+type RunWindow struct {
+	Start    time.Duration
+	End      time.Duration
+	Days     []time.Weekday
+	Location *time.Location
+}
+
+// Contains reports whether t falls within the window.
+func (x RunWindow) Contains(t time.Time) bool {
+	location := x.Location
+	if location == nil {
+		location = time.UTC
+	}
+	t = t.In(location)
+	sinceMidnight := clockOffset(t)
+
+	// When wrapping past midnight, the early hours belong to the window
+	// which opened on the previous day.
+	switch {
+	case x.Start == x.End:
+		return x.opensOn(t.Weekday())
+	case x.Start < x.End:
+		return sinceMidnight >= x.Start && sinceMidnight < x.End && x.opensOn(t.Weekday())
+	case sinceMidnight >= x.Start:
+		return x.opensOn(t.Weekday())
+	case sinceMidnight < x.End:
+		return x.opensOn((t.Weekday() + 6) % 7)
+	default:
+		return false
+	}
+}
+
+// clockOffset returns the time of day of t on the wall clock, as an offset
+// from midnight, ignoring any daylight saving time transition earlier in
+// the day.
+func clockOffset(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second +
+		time.Duration(t.Nanosecond())
+}
+
+// opensOn reports whether the window opens on the given day.
+func (x RunWindow) opensOn(day time.Weekday) bool {
+	if len(x.Days) == 0 {
+		return true
+	}
+	for _, d := range x.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the window in the format accepted by ParseRunWindow.
+func (x RunWindow) String() string {
+	var builder strings.Builder
+	for i, day := range x.Days {
+		if i > 0 {
+			builder.WriteString(",")
+		}
+		builder.WriteString(day.String()[:3])
+	}
+	if len(x.Days) > 0 {
+		builder.WriteString(" ")
+	}
+
+	formatOffset := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	builder.WriteString(formatOffset(x.Start))
+	builder.WriteString("-")
+	builder.WriteString(formatOffset(x.End))
+
+	if x.Location != nil {
+		builder.WriteString(" ")
+		builder.WriteString(x.Location.String())
+	}
+	return builder.String()
+}
+
+// ParseRunWindow parses a run window specification of the form
+//
+//	[days] HH:MM-HH:MM [location]
+//
+// where days is an optional comma-separated list of three letter weekday
+// names and location is an optional IANA time zone name, which defaults
+// to UTC. For example:
+//
+//	02:00-05:00
+//	Sat,Sun 22:00-04:00 Europe/London
+func ParseRunWindow(spec string) (RunWindow, error) {
+	var window RunWindow
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && !strings.Contains(fields[0], ":") {
+		for _, name := range strings.Split(fields[0], ",") {
+			day, ok := weekdaysByName[strings.ToLower(name)]
+			if !ok {
+				return RunWindow{}, errors.Wrapf(ErrInvalidRunWindow, "unknown day %q", name)
+			}
+			window.Days = append(window.Days, day)
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 || len(fields) > 2 {
+		return RunWindow{}, errors.Wrapf(ErrInvalidRunWindow, "spec %q", spec)
+	}
+
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return RunWindow{}, errors.Wrapf(ErrInvalidRunWindow, "range %q", fields[0])
+	}
+
+	var err error
+	window.Start, err = parseClockOffset(start)
+	if err != nil {
+		return RunWindow{}, err
+	}
+	window.End, err = parseClockOffset(end)
+	if err != nil {
+		return RunWindow{}, err
+	}
+
+	window.Location = time.UTC
+	if len(fields) == 2 {
+		window.Location, err = time.LoadLocation(fields[1])
+		if err != nil {
+			return RunWindow{}, errors.Wrapf(ErrInvalidRunWindow, "location %q (%v)", fields[1], err)
+		}
+	}
+
+	return window, nil
+}
+
+var weekdaysByName = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseClockOffset parses a HH:MM time of day as an offset from midnight.
+func parseClockOffset(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, errors.Wrapf(ErrInvalidRunWindow, "time %q", clock)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// WithRunWindow initialises a Migrator which will refuse to run migrations
+// tagged with MaintenanceTag outside of a daily window. Only the time of
+// day of start and end is used, in the location of start. Migrations
+// without the tag may run at any time.
+//
+// Intended for use with NewMigrator.
+func WithRunWindow(start time.Time, end time.Time) MigratorOpt {
+	return func(x *Migrator) error {
+		end = end.In(start.Location())
+		x.runWindow = &RunWindow{
+			Start:    clockOffset(start),
+			End:      clockOffset(end),
+			Location: start.Location(),
+		}
+		return nil
+	}
+}
+
+// WithRunWindowSpec is like WithRunWindow, but accepts a specification
+// in the format accepted by ParseRunWindow, e.g. "Sat,Sun 02:00-05:00 UTC".
+//
+// Intended for use with NewMigrator.
+func WithRunWindowSpec(spec string) MigratorOpt {
+	return func(x *Migrator) error {
+		window, err := ParseRunWindow(spec)
+		if err != nil {
+			return err
+		}
+		x.runWindow = &window
+		return nil
+	}
+}
+
+// checkRunWindow returns an error if any of the given migrations are
+// maintenance migrations and the current time is outside of the run
+// window. If no run window is configured, all migrations are allowed.
+func (x *Migrator) checkRunWindow(migrationNames []string) error {
+	if x.runWindow == nil || x.runWindow.Contains(time.Now()) {
+		return nil
+	}
+
+	var refused []string
+	for _, migrationName := range migrationNames {
+		migration, exists := x.registry.Get(migrationName)
+		if exists && migration.hasTag(MaintenanceTag) {
+			refused = append(refused, migrationName)
+		}
+	}
+
+	if len(refused) > 0 {
+		return errors.Wrapf(
+			ErrOutsideRunWindow,
+			"window %s, maintenance migrations: %+v",
+			x.runWindow,
+			refused,
+		)
+	}
+	return nil
+}
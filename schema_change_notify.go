@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSchemaChangeChannel is the channel notified by
+// WithSchemaChangeNotify if no channel is given.
+const DefaultSchemaChangeChannel = "schema_migrations"
+
+// maxNotifyPayload is the largest payload Postgres accepts for a
+// notification, less one byte for the terminator.
+const maxNotifyPayload = 7999
+
+// schemaChangePayload is the JSON sent by WithSchemaChangeNotify.
+type schemaChangePayload struct {
+	Direction  string    `json:"direction"`
+	Batch      int       `json:"batch"`
+	Count      int       `json:"count"`
+	Migrations []string  `json:"migrations,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// WithSchemaChangeNotify initialises a Migrator which issues a Postgres
+// NOTIFY on channel once each batch of migrations has been committed, so
+// that running services listening on the channel can react, e.g. by
+// refreshing prepared statements or reloading caches. If channel is empty,
+// DefaultSchemaChangeChannel is used. The payload is JSON describing the
+// batch:
+//
+//	{
+//		"direction": "up",
+//		"batch": 3,
+//		"count": 2,
+//		"migrations": ["a", "b"],
+//		"time": "2024-01-02T15:04:05Z"
+//	}
+//
+// The migrations are left out if the payload would be too long for a
+// notification. Nothing is sent for failed runs. The notification is sent
+// on the DB returned by the DBFactory, which the migrations ran against,
+// and a failure to send it is logged without affecting the run.
+//
+// Intended for use with NewMigrator.
+func WithSchemaChangeNotify(channel string) MigratorOpt {
+	return func(x *Migrator) error {
+		if channel == "" {
+			channel = DefaultSchemaChangeChannel
+		}
+		notifier := NotifierFunc(func(ctx context.Context, notification Notification) error {
+			return x.notifySchemaChange(ctx, channel, notification)
+		})
+		x.eventHandlers = append(x.eventHandlers, x.notifierHandler(notifier))
+		return nil
+	}
+}
+
+// notifySchemaChange sends a notification on channel if it describes a
+// batch which succeeded.
+func (x *Migrator) notifySchemaChange(ctx context.Context, channel string, notification Notification) error {
+	if notification.Type != BatchSucceededNotification {
+		return nil
+	}
+
+	payload := schemaChangePayload{
+		Direction:  notification.Direction.String(),
+		Batch:      notification.Batch,
+		Count:      notification.Count,
+		Migrations: notification.Migrations,
+		Time:       notification.Time,
+	}
+	body, err := json.Marshal(payload)
+	if err == nil && len(body) > maxNotifyPayload {
+		payload.Migrations = nil
+		body, err = json.Marshal(payload)
+	}
+	if err != nil {
+		return err
+	}
+
+	x.logAtLevel(LogLevelDebug, "Notifying %s of batch %d\n", channel, notification.Batch)
+	_, err = x.dbFactory().WithContext(ctx).Exec("SELECT pg_notify(?, ?)", channel, string(body))
+	return errors.Wrapf(err, "notify %s", channel)
+}
@@ -0,0 +1,694 @@
+package migrations
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoSchemaChanges indicates that a migration was not generated
+	// from a schema diff, since the DB already matches the target schema.
+	ErrNoSchemaChanges = errors.New("no schema changes")
+
+	// ErrInvalidModel indicates that a value given to SchemaFromModels is
+	// not a struct or a pointer to one.
+	ErrInvalidModel = errors.New("invalid model")
+)
+
+// SchemaChange is a single change needed to turn one schema into another,
+// as found by DiffSchemas.
+type SchemaChange struct {
+	// Description summarises the change, e.g. "add column public.users.email".
+	Description string
+
+	// Up makes the change and Down reverts it. Either may be empty when
+	// the change is made as part of another, e.g. the constraints of a
+	// table which is dropped.
+	Up   string
+	Down string
+
+	// Destructive indicates that the change drops a table or column, so
+	// that data may be lost.
+	Destructive bool
+}
+
+// Phases of a schema diff, in the order their changes are made. Changes
+// are reverted in the opposite order.
+const (
+	diffDropConstraints = iota
+	diffDropIndexes
+	diffCreateTables
+	diffAlterColumns
+	diffDropTables
+	diffAddConstraints
+	diffCreateIndexes
+	diffPhases
+)
+
+// DiffSchemas returns the changes needed to turn the current schema into
+// the target schema, covering tables, columns, indexes and constraints.
+// The changes are a draft for a human to review: renames are seen as a
+// drop and an add, and data is not converted when the type of a column
+// changes.
+//
+// If the target schema is partial, see Schema.Partial, tables, indexes
+// and constraints which are missing from it are left in place.
+func DiffSchemas(current *Schema, target *Schema) []SchemaChange {
+	phases := make([][]SchemaChange, diffPhases)
+	add := func(phase int, change SchemaChange) {
+		phases[phase] = append(phases[phase], change)
+	}
+
+	for _, targetTable := range target.Tables {
+		currentTable, ok := current.Table(targetTable.Schema, targetTable.Name)
+		if !ok {
+			diffNewTable(add, targetTable)
+			continue
+		}
+		diffTable(add, *currentTable, targetTable, target.Partial)
+	}
+
+	if !target.Partial {
+		for _, currentTable := range current.Tables {
+			if _, ok := target.Table(currentTable.Schema, currentTable.Name); !ok {
+				diffDroppedTable(add, currentTable)
+			}
+		}
+	}
+
+	var changes []SchemaChange
+	for _, phase := range phases {
+		changes = append(changes, phase...)
+	}
+	return changes
+}
+
+// diffNewTable adds the changes creating a table.
+func diffNewTable(add func(int, SchemaChange), table SchemaTable) {
+	name := table.qualifiedName()
+	add(diffCreateTables, SchemaChange{
+		Description: "create table " + name,
+		Up:          table.createSQL(),
+		Down:        "DROP TABLE " + quoteIdent(name),
+	})
+	for _, constraint := range table.Constraints {
+		add(diffAddConstraints, SchemaChange{
+			Description: fmt.Sprintf("add constraint %s on %s", constraint.Name, name),
+			Up:          table.addConstraintSQL(constraint),
+		})
+	}
+	for _, index := range table.ownIndexes(nil) {
+		add(diffCreateIndexes, SchemaChange{
+			Description: fmt.Sprintf("create index %s on %s", index.Name, name),
+			Up:          index.Definition,
+		})
+	}
+}
+
+// diffDroppedTable adds the changes dropping a table.
+func diffDroppedTable(add func(int, SchemaChange), table SchemaTable) {
+	name := table.qualifiedName()
+	for _, constraint := range table.Constraints {
+		add(diffDropConstraints, SchemaChange{
+			Description: fmt.Sprintf("drop constraint %s on %s", constraint.Name, name),
+			Down:        table.addConstraintSQL(constraint),
+		})
+	}
+	for _, index := range table.ownIndexes(nil) {
+		add(diffDropIndexes, SchemaChange{
+			Description: fmt.Sprintf("drop index %s on %s", index.Name, name),
+			Down:        index.Definition,
+		})
+	}
+	add(diffDropTables, SchemaChange{
+		Description: "drop table " + name,
+		Up:          "DROP TABLE " + quoteIdent(name),
+		Down:        table.createSQL(),
+		Destructive: true,
+	})
+}
+
+// diffTable adds the changes turning the current definition of a table
+// into the target definition.
+func diffTable(add func(int, SchemaChange), current SchemaTable, target SchemaTable, partial bool) {
+	name := target.qualifiedName()
+	table := quoteIdent(name)
+
+	for _, targetColumn := range target.Columns {
+		currentColumn, ok := current.Column(targetColumn.Name)
+		if !ok {
+			add(diffAlterColumns, SchemaChange{
+				Description: fmt.Sprintf("add column %s.%s", name, targetColumn.Name),
+				Up:          fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, targetColumn.definition()),
+				Down:        fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, quoteIdent(targetColumn.Name)),
+			})
+			continue
+		}
+		diffColumn(add, table, name, *currentColumn, targetColumn)
+	}
+	for _, currentColumn := range current.Columns {
+		if _, ok := target.Column(currentColumn.Name); !ok {
+			add(diffAlterColumns, SchemaChange{
+				Description: fmt.Sprintf("drop column %s.%s", name, currentColumn.Name),
+				Up:          fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, quoteIdent(currentColumn.Name)),
+				Down:        fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, currentColumn.definition()),
+				Destructive: true,
+			})
+		}
+	}
+
+	for _, currentConstraint := range current.Constraints {
+		targetConstraint, ok := target.constraint(currentConstraint.Name)
+		switch {
+		case !ok && partial:
+			continue
+		case ok && targetConstraint.Type == currentConstraint.Type && targetConstraint.Definition == currentConstraint.Definition:
+			continue
+		}
+		add(diffDropConstraints, SchemaChange{
+			Description: fmt.Sprintf("drop constraint %s on %s", currentConstraint.Name, name),
+			Up:          fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table, quoteIdent(currentConstraint.Name)),
+			Down:        current.addConstraintSQL(currentConstraint),
+		})
+	}
+	for _, targetConstraint := range target.Constraints {
+		currentConstraint, ok := current.constraint(targetConstraint.Name)
+		if ok && currentConstraint.Type == targetConstraint.Type && currentConstraint.Definition == targetConstraint.Definition {
+			continue
+		}
+		add(diffAddConstraints, SchemaChange{
+			Description: fmt.Sprintf("add constraint %s on %s", targetConstraint.Name, name),
+			Up:          target.addConstraintSQL(targetConstraint),
+			Down:        fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table, quoteIdent(targetConstraint.Name)),
+		})
+	}
+
+	// Indexes which back a constraint are changed along with it.
+	for _, currentIndex := range current.ownIndexes(&target) {
+		targetIndex, ok := target.index(currentIndex.Name)
+		switch {
+		case !ok && partial:
+			continue
+		case ok && targetIndex.Definition == currentIndex.Definition:
+			continue
+		}
+		add(diffDropIndexes, SchemaChange{
+			Description: fmt.Sprintf("drop index %s on %s", currentIndex.Name, name),
+			Up:          "DROP INDEX " + quoteIdent(qualifiedName(target.Schema, currentIndex.Name)),
+			Down:        currentIndex.Definition,
+		})
+	}
+	for _, targetIndex := range target.ownIndexes(&current) {
+		currentIndex, ok := current.index(targetIndex.Name)
+		if ok && currentIndex.Definition == targetIndex.Definition {
+			continue
+		}
+		add(diffCreateIndexes, SchemaChange{
+			Description: fmt.Sprintf("create index %s on %s", targetIndex.Name, name),
+			Up:          targetIndex.Definition,
+			Down:        "DROP INDEX " + quoteIdent(qualifiedName(target.Schema, targetIndex.Name)),
+		})
+	}
+}
+
+// diffColumn adds the changes turning the current definition of a column
+// into the target definition.
+func diffColumn(add func(int, SchemaChange), table string, tableName string, current SchemaColumn, target SchemaColumn) {
+	column := quoteIdent(target.Name)
+	description := tableName + "." + target.Name
+
+	if normalizeColumnType(current.Type) != normalizeColumnType(target.Type) {
+		add(diffAlterColumns, SchemaChange{
+			Description: "change type of column " + description,
+			Up:          fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", table, column, alterableType(target.Type)),
+			Down:        fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", table, column, alterableType(current.Type)),
+		})
+	}
+
+	if current.Nullable != target.Nullable {
+		setNotNull := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table, column)
+		dropNotNull := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", table, column)
+		change := SchemaChange{Description: "make column " + description + " NOT NULL", Up: setNotNull, Down: dropNotNull}
+		if target.Nullable {
+			change = SchemaChange{Description: "make column " + description + " nullable", Up: dropNotNull, Down: setNotNull}
+		}
+		add(diffAlterColumns, change)
+	}
+
+	if !defaultsEqual(current, target) {
+		add(diffAlterColumns, SchemaChange{
+			Description: "change default of column " + description,
+			Up:          alterDefaultSQL(table, column, target.Default),
+			Down:        alterDefaultSQL(table, column, current.Default),
+		})
+	}
+}
+
+// alterDefaultSQL returns the statement setting the default of a column,
+// or dropping it if def is empty.
+func alterDefaultSQL(table string, column string, def string) string {
+	if def == "" {
+		return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", table, column)
+	}
+	return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", table, column, def)
+}
+
+// qualifiedName returns the schema-qualified name of the table.
+func (x *SchemaTable) qualifiedName() string {
+	return qualifiedName(x.Schema, x.Name)
+}
+
+// qualifiedName returns name qualified by schema, unless schema is empty.
+func qualifiedName(schema string, name string) string {
+	if schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
+
+// createSQL returns the statement creating the table with its columns.
+// Constraints and indexes are created separately.
+func (x *SchemaTable) createSQL() string {
+	columns := make([]string, 0, len(x.Columns))
+	for _, column := range x.Columns {
+		columns = append(columns, "\t"+column.definition())
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n)", quoteIdent(x.qualifiedName()), strings.Join(columns, ",\n"))
+}
+
+// addConstraintSQL returns the statement adding a constraint to the
+// table.
+func (x *SchemaTable) addConstraintSQL(constraint SchemaConstraint) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD CONSTRAINT %s %s",
+		quoteIdent(x.qualifiedName()),
+		quoteIdent(constraint.Name),
+		constraint.Definition,
+	)
+}
+
+// constraint returns the constraint with the given name, and whether it
+// exists.
+func (x *SchemaTable) constraint(name string) (SchemaConstraint, bool) {
+	for _, constraint := range x.Constraints {
+		if constraint.Name == name {
+			return constraint, true
+		}
+	}
+	return SchemaConstraint{}, false
+}
+
+// index returns the index with the given name, and whether it exists.
+func (x *SchemaTable) index(name string) (SchemaIndex, bool) {
+	for _, index := range x.Indexes {
+		if index.Name == name {
+			return index, true
+		}
+	}
+	return SchemaIndex{}, false
+}
+
+// ownIndexes returns the indexes of the table which do not back one of
+// its constraints, or one of the constraints of other.
+func (x *SchemaTable) ownIndexes(other *SchemaTable) []SchemaIndex {
+	var indexes []SchemaIndex
+	for _, index := range x.Indexes {
+		if _, ok := x.constraint(index.Name); ok {
+			continue
+		}
+		if other != nil {
+			if _, ok := other.constraint(index.Name); ok {
+				continue
+			}
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
+
+// definition returns the column as it would be written in CREATE TABLE.
+func (x *SchemaColumn) definition() string {
+	definition := quoteIdent(x.Name) + " " + x.Type
+	if !x.Nullable {
+		definition += " NOT NULL"
+	}
+	if x.Default != "" {
+		definition += " DEFAULT " + x.Default
+	}
+	return definition
+}
+
+// columnTypeAliases maps the abbreviated names of types to the names
+// used by Postgres when describing columns.
+var columnTypeAliases = map[string]string{
+	"int":         "integer",
+	"int2":        "smallint",
+	"int4":        "integer",
+	"int8":        "bigint",
+	"serial":      "integer",
+	"serial4":     "integer",
+	"smallserial": "smallint",
+	"serial2":     "smallint",
+	"bigserial":   "bigint",
+	"serial8":     "bigint",
+	"bool":        "boolean",
+	"float4":      "real",
+	"float8":      "double precision",
+	"decimal":     "numeric",
+	"varchar":     "character varying",
+	"char":        "character",
+	"timestamp":   "timestamp without time zone",
+	"timestamptz": "timestamp with time zone",
+	"time":        "time without time zone",
+	"timetz":      "time with time zone",
+}
+
+// normalizeColumnType returns the name Postgres uses for a column type,
+// so that types written differently can be compared, e.g. "varchar(10)"
+// and "character varying(10)".
+func normalizeColumnType(typ string) string {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	base, rest := typ, ""
+	if i := strings.IndexAny(typ, "(["); i >= 0 {
+		base, rest = strings.TrimSpace(typ[:i]), typ[i:]
+	}
+	if alias, ok := columnTypeAliases[base]; ok {
+		base = alias
+	}
+	return base + rest
+}
+
+// isSerialType reports whether a column type is one of the serial types,
+// which imply a default taken from a sequence.
+func isSerialType(typ string) bool {
+	return strings.Contains(strings.ToLower(typ), "serial")
+}
+
+// alterableType returns a column type as it may be written in ALTER
+// COLUMN ... TYPE, which does not accept the serial types.
+func alterableType(typ string) string {
+	if isSerialType(typ) {
+		return normalizeColumnType(typ)
+	}
+	return typ
+}
+
+// defaultCastPattern matches a literal default with the cast Postgres
+// adds when describing it, e.g. 'active'::character varying.
+var defaultCastPattern = regexp.MustCompile(`^('(?:[^']|'')*')::[a-z ]+$`)
+
+// defaultsEqual reports whether two columns have the same default. The
+// default taken from a sequence by a serial column is ignored, as are
+// the casts Postgres adds to literals.
+func defaultsEqual(a SchemaColumn, b SchemaColumn) bool {
+	normalize := func(column SchemaColumn, other SchemaColumn) string {
+		def := strings.TrimSpace(column.Default)
+		if isSerialType(other.Type) && strings.HasPrefix(def, "nextval(") {
+			return ""
+		}
+		return defaultCastPattern.ReplaceAllString(def, "$1")
+	}
+	return normalize(a, b) == normalize(b, a)
+}
+
+// SchemaFromModels returns the schema declared by go-pg models, for use
+// as the target of DiffSchemas. Each model is a struct, or a pointer to
+// one, with the tags used by go-pg to create tables. The columns of each
+// table, its primary key and its unique constraints are included, named
+// as Postgres would name them.
+//
+// The schema is partial, since models do not declare indexes or other
+// constraints, nor every table of a DB.
+func SchemaFromModels(models ...interface{}) (*Schema, error) {
+	schema := &Schema{Partial: true}
+	for _, model := range models {
+		typ := reflect.TypeOf(model)
+		for typ != nil && typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ == nil || typ.Kind() != reflect.Struct {
+			return nil, errors.Wrapf(ErrInvalidModel, "%T", model)
+		}
+		schema.Tables = append(schema.Tables, schemaTableFromModel(orm.GetTable(typ)))
+	}
+	return schema, nil
+}
+
+// schemaTableFromModel returns the table declared by a go-pg model.
+func schemaTableFromModel(model *orm.Table) SchemaTable {
+	schemaName, tableName := "public", strings.ReplaceAll(string(model.SQLName), `"`, "")
+	if i := strings.LastIndexByte(tableName, '.'); i >= 0 {
+		schemaName, tableName = tableName[:i], tableName[i+1:]
+	}
+	table := SchemaTable{Schema: schemaName, Name: tableName}
+
+	for _, field := range model.Fields {
+		pk := containsField(model.PKs, field)
+		_, notNull := pgTagOptions(field.Field.Tag.Get("pg"))["notnull"]
+		table.Columns = append(table.Columns, SchemaColumn{
+			Name:     field.SQLName,
+			Type:     modelColumnType(field, pk),
+			Nullable: !notNull && !pk,
+			Default:  string(field.Default),
+		})
+	}
+
+	if len(model.PKs) > 0 {
+		table.Constraints = append(table.Constraints, SchemaConstraint{
+			Name:       tableName + "_pkey",
+			Type:       "PRIMARY KEY",
+			Definition: "PRIMARY KEY (" + fieldNames(model.PKs) + ")",
+		})
+	}
+	for group, fields := range model.Unique {
+		// Fields tagged unique without a group are each unique on their
+		// own.
+		sets := [][]*orm.Field{fields}
+		if group == "" {
+			sets = sets[:0]
+			for _, field := range fields {
+				sets = append(sets, []*orm.Field{field})
+			}
+		}
+		for _, set := range sets {
+			names := make([]string, 0, len(set))
+			for _, field := range set {
+				names = append(names, field.SQLName)
+			}
+			table.Constraints = append(table.Constraints, SchemaConstraint{
+				Name:       tableName + "_" + strings.Join(names, "_") + "_key",
+				Type:       "UNIQUE",
+				Definition: "UNIQUE (" + fieldNames(set) + ")",
+			})
+		}
+	}
+	sort.Slice(table.Constraints, func(i, j int) bool {
+		return table.Constraints[i].Name < table.Constraints[j].Name
+	})
+	return table
+}
+
+// modelColumnType returns the type of the column of a model field. As
+// when go-pg creates a table, integer primary keys without an explicit
+// type are serial.
+func modelColumnType(field *orm.Field, pk bool) string {
+	if field.UserSQLType != "" {
+		return field.UserSQLType
+	}
+	if pk {
+		switch field.SQLType {
+		case "smallint":
+			return "smallserial"
+		case "integer":
+			return "serial"
+		case "bigint":
+			return "bigserial"
+		}
+	}
+	return field.SQLType
+}
+
+// pgTagOptions returns the names of the options in a go-pg struct tag,
+// ignoring their values. The first element, the column name, is skipped.
+func pgTagOptions(tag string) map[string]struct{} {
+	options := make(map[string]struct{})
+	quoted := false
+	start := 0
+	for i := 0; i <= len(tag); i++ {
+		if i < len(tag) && tag[i] == '\'' {
+			quoted = !quoted
+		}
+		if i < len(tag) && (tag[i] != ',' || quoted) {
+			continue
+		}
+		if start > 0 {
+			name, _, _ := strings.Cut(tag[start:i], ":")
+			options[strings.TrimSpace(name)] = struct{}{}
+		}
+		start = i + 1
+	}
+	return options
+}
+
+// containsField reports whether fields contains field.
+func containsField(fields []*orm.Field, field *orm.Field) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldNames returns the column names of fields, separated by commas and
+// quoted as by pg_get_constraintdef.
+func fieldNames(fields []*orm.Field) string {
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		name := field.SQLName
+		if !plainIdentPattern.MatchString(name) {
+			name = quoteIdent(name)
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// plainIdentPattern matches the identifiers Postgres writes without
+// quotes.
+var plainIdentPattern = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
+// CreateFromSchemaDiff introspects the DB the Migrator runs migrations
+// against, and creates a draft migration changing it to the target
+// schema, as found by DiffSchemas. The target may be introspected from
+// another DB, or declared with SchemaFromModels. The migration table is
+// left out of the diff.
+//
+// The draft is written in the configured format, with a comment before
+// each statement, and must be reviewed before it is applied. If the DB
+// already matches the target, ErrNoSchemaChanges is returned.
+func (x *Migrator) CreateFromSchemaDiff(description string, target *Schema) error {
+	current, err := x.Introspect()
+	if err != nil {
+		return err
+	}
+
+	filtered := *target
+	filtered.Tables = nil
+	for _, table := range target.Tables {
+		if !isMigrationTable(x.migrationTableName, table.Schema, table.Name) {
+			filtered.Tables = append(filtered.Tables, table)
+		}
+	}
+
+	changes := DiffSchemas(current, &filtered)
+	if len(changes) == 0 {
+		return ErrNoSchemaChanges
+	}
+
+	caser, err := GetCaser(x.migrationNameConvention)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	filename := caser.ToFileCase(now, description)
+	err = x.validateName(filename)
+	if err != nil {
+		return err
+	}
+
+	up := renderSchemaChanges(changes, Up)
+	down := renderSchemaChanges(changes, Down)
+
+	var filePath string
+	if x.createFormat == SQLPair {
+		filePath, err = x.writeSQLMigrationFiles(filename, up, down)
+	} else {
+		filePath, err = x.createGoMigrationFile(
+			filename,
+			caser.ToFuncCase(now, description),
+			schemaDiffMigrationTemplate,
+			map[string]string{"Up": escapeRawString(up), "Down": escapeRawString(down)},
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	x.logAtLevel(LogLevelInfo, "Created draft migration %s with %d changes", filePath, len(changes))
+	return nil
+}
+
+// renderSchemaChanges returns the statements of changes in the given
+// direction, each preceded by a comment describing it. Down statements
+// are returned in the opposite order.
+func renderSchemaChanges(changes []SchemaChange, direction Direction) string {
+	var statements []string
+	for i := range changes {
+		change := changes[i]
+		statement := change.Up
+		if direction == Down {
+			change = changes[len(changes)-1-i]
+			statement = change.Down
+		}
+		if statement == "" {
+			continue
+		}
+
+		comment := "-- " + change.Description
+		if direction == Down {
+			comment = "-- revert " + change.Description
+		}
+		if direction == Up && change.Destructive {
+			comment += "\n-- WARNING: this may lose data."
+		}
+		statements = append(statements, comment+"\n"+statement+";")
+	}
+	return "-- Draft generated from a schema diff. Review before applying.\n\n" + strings.Join(statements, "\n\n") + "\n"
+}
+
+// escapeRawString escapes backquotes in s, so that it can be written
+// within a Go raw string literal.
+func escapeRawString(s string) string {
+	return strings.ReplaceAll(s, "`", "` + \"`\" + `")
+}
+
+// schemaDiffMigrationTemplate is the template used to write a migration
+// generated by CreateFromSchemaDiff as a Go file.
+const schemaDiffMigrationTemplate = `package main
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/chainql/migrations"
+)
+
+func init() {
+	err := registry.Register(
+		"{{.Filename}}",
+		up{{.FuncName}},
+		down{{.FuncName}},
+		migrations.Source("{{.Source}}"),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func up{{.FuncName}}(tx *pg.Tx, cont *migrations.Context) error {
+	_, err := tx.Exec(` + "`" + `{{.Params.Up}}` + "`" + `)
+	return err
+}
+
+func down{{.FuncName}}(tx *pg.Tx, cont *migrations.Context) error {
+	_, err := tx.Exec(` + "`" + `{{.Params.Down}}` + "`" + `)
+	return err
+}
+`
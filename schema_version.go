@@ -0,0 +1,195 @@
+package migrations
+
+import (
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoSchemaVersion indicates that no schema version has been
+	// published for a migration table. See WithSchemaVersionComment.
+	ErrNoSchemaVersion = errors.New("no schema version published")
+
+	// ErrSchemaVersionTooOld indicates that the schema version of a DB is
+	// below the minimum required. See RequireSchemaVersion.
+	ErrSchemaVersionTooOld = errors.New("schema version too old")
+)
+
+// SchemaVersion identifies how far the schema of a DB has been migrated.
+type SchemaVersion struct {
+	// Version is the timestamp at the start of the name of the latest
+	// applied migration, e.g. "20240622230738". Versions increase as
+	// migrations are applied, and can be compared with AtLeast. Version is
+	// empty if no migrations have been applied, or the latest one is not
+	// named with a timestamp.
+	Version string
+
+	// Migration is the latest applied migration, in the order migrations
+	// are run.
+	Migration string
+
+	// Release is the highest release declared with Version by an applied
+	// migration, e.g. "1.4.0", and can be compared with ReleaseAtLeast.
+	// Release is empty if no applied migration declares one.
+	Release string
+}
+
+// String returns the Version.
+func (x SchemaVersion) String() string {
+	return x.Version
+}
+
+// AtLeast reports whether the version is equal to or after minimum, a
+// migration timestamp such as "20240622230738".
+func (x SchemaVersion) AtLeast(minimum string) (bool, error) {
+	return versionAtLeast(x.Version, minimum)
+}
+
+// ReleaseAtLeast reports whether the release is equal to or after minimum,
+// a release such as "v2.14". An empty release is before every other.
+func (x SchemaVersion) ReleaseAtLeast(minimum string) (bool, error) {
+	return versionAtLeast(x.Release, minimum)
+}
+
+// versionAtLeast reports whether version is equal to or after minimum.
+// An empty version is before every other.
+func versionAtLeast(version string, minimum string) (bool, error) {
+	target, err := parseVersion(minimum)
+	if err != nil {
+		return false, err
+	}
+	if version == "" {
+		return false, nil
+	}
+	current, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	return compareVersions(current, target) >= 0, nil
+}
+
+// SchemaVersion returns the version of the schema of the DB, derived from
+// the applied migrations. A zero SchemaVersion is returned if the
+// migration table does not exist.
+func (x *Migrator) SchemaVersion() (SchemaVersion, error) {
+	return x.schemaVersion(x.stateDB().WithContext(x.ctx))
+}
+
+// schemaVersion works out the version of the schema from the migrations
+// applied according to db.
+func (x *Migrator) schemaVersion(db Querier) (SchemaVersion, error) {
+	exists, err := x.migrationTableExists(db)
+	if err != nil || !exists {
+		return SchemaVersion{}, err
+	}
+	applied, err := x.getCompletedMigrations(db)
+	if err != nil || len(applied) == 0 {
+		return SchemaVersion{}, err
+	}
+	x.sortMigrations(applied)
+
+	version := SchemaVersion{Migration: applied[len(applied)-1]}
+	var highest releaseVersion
+	for _, name := range applied {
+		migration, ok := x.registry.Get(name)
+		if !ok || migration.Version == "" {
+			continue
+		}
+		release, err := parseVersion(migration.Version)
+		if err != nil {
+			continue
+		}
+		if version.Release == "" || compareVersions(release, highest) > 0 {
+			version.Release = migration.Version
+			highest = release
+		}
+	}
+
+	end := 0
+	for end < len(version.Migration) && isDigit(version.Migration[end]) {
+		end++
+	}
+	version.Version = version.Migration[:end]
+	return version, nil
+}
+
+// WithSchemaVersionComment initialises a Migrator which publishes the
+// version of the schema, as returned by SchemaVersion, as the comment of
+// the migration table after each batch of migrations is applied or rolled
+// back, so that other services can check it with ReadSchemaVersion or
+// RequireSchemaVersion without knowing the migrations. Any other comment
+// on the migration table is replaced.
+//
+// The comment is set once the batch has been committed, so it may briefly
+// lag behind the migrations. Failures to set it are logged, but do not
+// fail the run.
+//
+// Intended for use with NewMigrator.
+func WithSchemaVersionComment() MigratorOpt {
+	return func(x *Migrator) error {
+		x.schemaVersionComment = true
+		return nil
+	}
+}
+
+// publishSchemaVersion sets the comment of the migration table to the
+// version of the schema, if enabled with WithSchemaVersionComment. Called
+// by finishRun once a batch has been committed.
+func (x *Migrator) publishSchemaVersion() {
+	if !x.schemaVersionComment {
+		return
+	}
+
+	db := x.stateDB().WithContext(x.ctx)
+	version, err := x.schemaVersion(db)
+	if err == nil {
+		_, err = db.Exec("COMMENT ON TABLE ? IS ?", pg.Ident(x.migrationTableName), version.Version)
+	}
+	if err != nil {
+		x.logAtLevel(LogLevelError, "Could not publish schema version: %v\n", err)
+		return
+	}
+	x.logAtLevel(LogLevelDebug, "Published schema version %s\n", version)
+}
+
+// ReadSchemaVersion reads the schema version published as the comment of
+// the migration table by a Migrator created with WithSchemaVersionComment.
+// migrationTable is the name of the migration table, as passed to
+// WithMigrationTableName, or DefaultMigrationTableName. An error wrapping
+// ErrNoSchemaVersion is returned if the table does not exist or has no
+// comment.
+func ReadSchemaVersion(db Querier, migrationTable string) (string, error) {
+	var comment *string
+	_, err := db.QueryOne(
+		pg.Scan(&comment),
+		"SELECT obj_description(to_regclass(?), 'pg_class')",
+		migrationTable,
+	)
+	if err != nil {
+		return "", err
+	}
+	if comment == nil {
+		return "", errors.Wrapf(ErrNoSchemaVersion, "table %s", migrationTable)
+	}
+	return *comment, nil
+}
+
+// RequireSchemaVersion checks that the schema version published for the
+// migration table is at least minimum, a migration timestamp, e.g. when a service starts, as
+// described by ReadSchemaVersion and SchemaVersion.AtLeast. An error
+// wrapping ErrSchemaVersionTooOld is returned if it is not.
+func RequireSchemaVersion(db Querier, migrationTable string, minimum string) error {
+	version, err := ReadSchemaVersion(db, migrationTable)
+	if err != nil {
+		return err
+	}
+
+	ok, err := versionAtLeast(version, minimum)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Wrapf(ErrSchemaVersionTooOld, "version %q, need %s", version, minimum)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package migrations
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMigrationNotScriptable indicates that a pending migration was
+	// registered as a Go function, is conditional, or references secrets,
+	// so its SQL cannot be exported.
+	ErrMigrationNotScriptable = errors.New("migration cannot be exported as sql")
+
+	// ErrSeparateStateDB indicates that a script was requested from a
+	// Migrator whose migration table is kept in a separate DB. See
+	// WithStateDB.
+	ErrSeparateStateDB = errors.New("migration table is kept in a separate db")
+)
+
+// ScriptPending writes a SQL script to w which applies all pending
+// migrations in a single transaction and records them as a single batch
+// in the migration table. This is intended for environments where the
+// migrations must be reviewed and applied by a DBA rather than by this
+// package.
+//
+// The DB is only read, to determine which migrations are pending. If the
+// migration table does not exist yet, all registered migrations are
+// considered pending and the script will create the table.
+//
+// Only migrations registered from SQL can be exported. If any pending
+// migration was registered as a Go function, this returns an error
+// wrapping ErrMigrationNotScriptable and nothing is written. The same
+// applies to migrations referencing secrets, which are only resolved when
+// migrations are run, and are never written to a script.
+//
+// The script upgrades the migration table from the version recorded in
+// the DB. If the table holds rows which prevent the upgrade, this returns
+// an error wrapping ErrMigrationTableInvalid and nothing is written.
+//
+// Since the script both applies and records the migrations, it cannot be
+// written if the migration table is kept in a separate DB, in which case
+// this returns an error wrapping ErrSeparateStateDB.
+func (x *Migrator) ScriptPending(w io.Writer) error {
+	if x.stateDBFactory != nil {
+		return errors.Wrap(ErrSeparateStateDB, "cannot script pending migrations")
+	}
+
+	db := x.dbFactory()
+	migrationsToRun, err := x.getPendingMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	pending := make([]migration, 0, len(migrationsToRun))
+	var notScriptable, withSecrets []string
+	for _, migrationName := range migrationsToRun {
+		migration, exists := x.registry.Get(migrationName)
+		if !exists {
+			return errors.Wrapf(ErrMigrationNotKnown, "migration %s", migrationName)
+		}
+		// The ShouldRun predicate of a conditional migration is Go code.
+		if migration.UpSQL == "" || migration.ShouldRun != nil {
+			notScriptable = append(notScriptable, migrationName)
+			continue
+		}
+		if secretPlaceholderPattern.MatchString(migration.UpSQL) {
+			withSecrets = append(withSecrets, migrationName)
+			continue
+		}
+		pending = append(pending, migration)
+	}
+	if len(notScriptable) > 0 {
+		return errors.Wrapf(
+			ErrMigrationNotScriptable,
+			"go migrations: %+v",
+			notScriptable,
+		)
+	}
+	if len(withSecrets) > 0 {
+		return errors.Wrapf(
+			ErrMigrationNotScriptable,
+			"migrations referencing secrets: %+v",
+			withSecrets,
+		)
+	}
+
+	metaVersion, err := x.scriptedMetaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	formatter := db.Formatter()
+	table := pg.Ident(x.migrationTableName)
+	buf := bufio.NewWriter(w)
+	writeRaw := func(query string) {
+		query = strings.TrimSpace(query)
+		_, _ = buf.WriteString(query)
+		if !strings.HasSuffix(query, ";") {
+			_, _ = buf.WriteString(";")
+		}
+		_, _ = buf.WriteString("\n\n")
+	}
+	writeStatement := func(query string, params ...interface{}) {
+		writeRaw(string(formatter.FormatQuery(nil, strings.TrimSpace(query), params...)))
+	}
+	writeComment := func(label string, text string) {
+		// Every line is commented, in case the text spans several.
+		lines := strings.Split(strings.TrimSpace(text), "\n")
+		_, _ = buf.WriteString("-- " + label + ": " + lines[0] + "\n")
+		for _, line := range lines[1:] {
+			_, _ = buf.WriteString("--   " + line + "\n")
+		}
+	}
+
+	_, _ = buf.WriteString("-- Pending migrations: ")
+	_, _ = buf.WriteString(strings.Join(migrationsToRun, ", "))
+	_, _ = buf.WriteString("\n\n")
+
+	writeStatement("BEGIN")
+	for _, metaMigration := range metaMigrations {
+		if metaMigration.version <= metaVersion {
+			continue
+		}
+		if metaMigration.scriptPrepare != "" {
+			writeStatement(metaMigration.scriptPrepare, table)
+		}
+		writeStatement(metaMigration.query, table)
+	}
+	metaTable := pg.Ident(x.metaTableName())
+	writeStatement(createMetaTableQuery, metaTable)
+	writeStatement(setMetaVersionQuery, metaTable, latestMetaVersion())
+	if _, ok := x.locker.(TableLocker); ok {
+		writeStatement("LOCK ? IN SHARE ROW EXCLUSIVE MODE", table)
+	}
+
+	for i, migration := range pending {
+		_, _ = buf.WriteString("-- Migration: ")
+		_, _ = buf.WriteString(migration.Name)
+		_, _ = buf.WriteString("\n")
+		if migration.Description != "" {
+			writeComment("Description", migration.Description)
+		}
+		if migration.Author != "" {
+			writeComment("Author", migration.Author)
+		}
+		// The SQL is written verbatim, as it would be sent by RegisterSQL.
+		writeRaw(migration.UpSQL)
+
+		// The first insert starts a new batch, which the remaining
+		// inserts then join.
+		batchQuery := "SELECT max(batch) FROM ?"
+		if i == 0 {
+			batchQuery = "SELECT coalesce(max(batch), 0) + 1 FROM ?"
+		}
+		var source interface{}
+		if migration.Source != "" {
+			source = migration.Source
+		}
+		writeStatement(
+			"INSERT INTO ? (name, batch, migration_time, source) VALUES (?, ("+batchQuery+"), now(), ?)",
+			table,
+			migration.Name,
+			table,
+			source,
+		)
+	}
+
+	writeStatement("COMMIT")
+	return buf.Flush()
+}
+
+// scriptedMetaVersion returns the version of the migration table which a
+// script will upgrade from, 0 if the table does not exist yet. The prepare
+// steps of the changes which the script will apply, and which have no
+// scriptPrepare, are run against db so that rows which prevent the
+// upgrade are reported before the script is written.
+func (x *Migrator) scriptedMetaVersion(db Querier) (int, error) {
+	exists, err := x.migrationTableExists(db)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var metaExists bool
+	_, err = db.QueryOne(pg.Scan(&metaExists), "SELECT to_regclass(?) IS NOT NULL", x.metaTableName())
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	if metaExists {
+		current, err = x.metaVersion(db)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, metaMigration := range metaMigrations {
+		if metaMigration.version <= current || metaMigration.prepare == nil || metaMigration.scriptPrepare != "" {
+			continue
+		}
+		err = metaMigration.prepare(x, db)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return current, nil
+}
+
+// getPendingMigrations returns the sorted list of migrations which have
+// not been run yet, without creating the migration table if it does not
+// exist.
+func (x *Migrator) getPendingMigrations(db Querier) ([]string, error) {
+	exists, err := x.migrationTableExists(db)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return x.getMigrationsToRun(db)
+	}
+
+	migrationsToRun := append([]string(nil), x.registry.List()...)
+	x.sortMigrations(migrationsToRun)
+	return migrationsToRun, nil
+}
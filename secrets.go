@@ -0,0 +1,195 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrSecretNotFound indicates that a secrets provider has no secret
+	// by the requested name.
+	ErrSecretNotFound = errors.New("secret not found")
+
+	// ErrNoSecretsProvider indicates that a migration referenced a secret,
+	// but the Migrator has no secrets provider. See WithSecretsProvider.
+	ErrNoSecretsProvider = errors.New("no secrets provider")
+
+	// ErrInvalidSecretName indicates that a secret name contains
+	// characters other than letters, digits, '_', '-', '.' and '/'.
+	ErrInvalidSecretName = errors.New("invalid secret name")
+)
+
+// SecretsProvider resolves the secrets referenced by migrations, e.g.
+// from a secrets manager. Providers should return an error wrapping
+// ErrSecretNotFound for unknown names.
+type SecretsProvider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// SecretsProviderFunc adapts a function to a SecretsProvider.
+type SecretsProviderFunc func(ctx context.Context, name string) (string, error)
+
+// Interface Compliance
+var _ SecretsProvider = SecretsProviderFunc(nil)
+
+// Secret calls the function.
+func (x SecretsProviderFunc) Secret(ctx context.Context, name string) (string, error) {
+	return x(ctx, name)
+}
+
+// EnvSecrets returns a SecretsProvider which reads secrets from
+// environment variables, named by joining prefix and the secret name in
+// upper case with an underscore, with other punctuation replaced by
+// underscores, e.g. "SECRET_DEFAULT_ADMIN_PW" for the secret
+// "default_admin_pw" and the prefix "SECRET".
+func EnvSecrets(prefix string) SecretsProvider {
+	return SecretsProviderFunc(func(_ context.Context, name string) (string, error) {
+		envName := strings.ToUpper(secretEnvReplacer.Replace(name))
+		if prefix != "" && !strings.HasSuffix(prefix, "_") {
+			envName = prefix + "_" + envName
+		} else {
+			envName = prefix + envName
+		}
+
+		value, exists := os.LookupEnv(envName)
+		if !exists {
+			return "", errors.Wrap(ErrSecretNotFound, envName)
+		}
+		return value, nil
+	})
+}
+
+// secretEnvReplacer replaces the punctuation allowed in secret names which
+// cannot be used in environment variable names.
+var secretEnvReplacer = strings.NewReplacer("-", "_", ".", "_", "/", "_")
+
+// WithSecretsProvider initialises a Migrator which resolves the secrets
+// referenced by migrations with provider. Secrets are referenced with
+// Context.Secret, or with placeholders such as ${secret:default_admin_pw}
+// in SQL, which are generated by the secret function of migration
+// templates. Placeholders are resolved automatically in migrations
+// registered with RegisterSQL or loaded from SQL files, and by
+// Context.ResolveSecrets in migrations written in Go.
+//
+// Resolved secrets are sent to the DB as literals in the SQL of the
+// migration, so they are seen by query hooks, e.g. those which log
+// queries. They are redacted from the statements and plans captured with
+// WithExplain.
+//
+// Intended for use with NewMigrator.
+func WithSecretsProvider(provider SecretsProvider) MigratorOpt {
+	return func(x *Migrator) error {
+		x.secretsProvider = provider
+		return nil
+	}
+}
+
+// secretNamePattern matches valid secret names.
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+
+// secretPlaceholderPattern matches the placeholders of secrets in SQL.
+var secretPlaceholderPattern = regexp.MustCompile(`\$\{secret:([A-Za-z0-9_./-]+)\}`)
+
+// secretPlaceholder returns the placeholder of a secret in SQL, for use
+// by the secret function of migration templates.
+func secretPlaceholder(name string) (string, error) {
+	if !secretNamePattern.MatchString(name) {
+		return "", errors.Wrapf(ErrInvalidSecretName, "%q", name)
+	}
+	return "${secret:" + name + "}", nil
+}
+
+// Secret returns the named secret from the secrets provider of the
+// Migrator running the migration. See WithSecretsProvider.
+func (x *Context) Secret(name string) (string, error) {
+	if x == nil || x.migrator == nil || x.migrator.secretsProvider == nil {
+		return "", errors.Wrapf(ErrNoSecretsProvider, "secret %s", name)
+	}
+
+	value, err := x.migrator.secretsProvider.Secret(x.migrator.ctx, name)
+	if err != nil {
+		return "", errors.Wrapf(err, "secret %s", name)
+	}
+	x.migrator.rememberSecret(value)
+	return value, nil
+}
+
+// redactedSecret replaces resolved secrets in the output of the Migrator.
+const redactedSecret = "[redacted]"
+
+// rememberSecret records a resolved secret, so that it can be redacted.
+func (x *Migrator) rememberSecret(value string) {
+	if value == "" {
+		return
+	}
+
+	x.secretsMtx.Lock()
+	defer x.secretsMtx.Unlock()
+	if x.resolvedSecrets == nil {
+		x.resolvedSecrets = make(map[string]struct{})
+	}
+	x.resolvedSecrets[value] = struct{}{}
+	// Secrets are quoted as SQL literals, doubling any quotes.
+	x.resolvedSecrets[strings.ReplaceAll(value, "'", "''")] = struct{}{}
+}
+
+// redactSecrets replaces every secret resolved by the Migrator in s with
+// redactedSecret.
+func (x *Migrator) redactSecrets(s string) string {
+	x.secretsMtx.Lock()
+	defer x.secretsMtx.Unlock()
+	if len(x.resolvedSecrets) == 0 {
+		return s
+	}
+
+	// Longer secrets are replaced first, so that a secret containing
+	// another is not partly revealed.
+	secrets := make([]string, 0, len(x.resolvedSecrets))
+	for secret := range x.resolvedSecrets {
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redactedSecret)
+	}
+	return s
+}
+
+// ResolveSecrets replaces the placeholders of secrets in query, such as
+// ${secret:default_admin_pw}, with the secrets quoted as SQL string
+// literals, e.g.
+//
+//	query, err := cont.ResolveSecrets(`CREATE ROLE admin PASSWORD ${secret:default_admin_pw}`)
+//
+// A query without placeholders is returned unchanged.
+func (x *Context) ResolveSecrets(query string) (string, error) {
+	if !strings.Contains(query, "${secret:") {
+		return query, nil
+	}
+
+	var err error
+	resolved := secretPlaceholderPattern.ReplaceAllStringFunc(query, func(placeholder string) string {
+		if err != nil {
+			return placeholder
+		}
+		name := secretPlaceholderPattern.FindStringSubmatch(placeholder)[1]
+		var value string
+		value, err = x.Secret(name)
+		if err != nil {
+			return placeholder
+		}
+		return string(orm.NewFormatter().FormatQuery(nil, "?", value))
+	})
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
@@ -0,0 +1,213 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrSchemaDrift indicates that the schema produced by replaying the
+	// migrations applied to the primary DB does not match its schema.
+	ErrSchemaDrift = errors.New("schema drift detected")
+)
+
+// SchemaDifference describes a single object whose definition differs
+// between the primary DB and a shadow DB.
+//
+// An empty Primary indicates that the object only exists in the shadow
+// DB, which usually means that a migration has not been applied to the
+// primary. An empty Shadow indicates that the object only exists in the
+// primary DB, which usually means that a change was applied by hand
+// without a corresponding migration.
+type SchemaDifference struct {
+	// Object is a human-readable name of the object, e.g.
+	// "column public.users.email" or "index public.users_email_idx".
+	Object string
+
+	// Primary is the definition of the object in the primary DB.
+	Primary string
+
+	// Shadow is the definition of the object in the shadow DB.
+	Shadow string
+}
+
+// String returns a human-readable description of the difference.
+func (x SchemaDifference) String() string {
+	switch {
+	case x.Primary == "":
+		return fmt.Sprintf("%s only exists in shadow: %s", x.Object, x.Shadow)
+	case x.Shadow == "":
+		return fmt.Sprintf("%s only exists in primary: %s", x.Object, x.Primary)
+	default:
+		return fmt.Sprintf("%s differs: primary %s, shadow %s", x.Object, x.Primary, x.Shadow)
+	}
+}
+
+// ValidateAgainstShadow replays the migrations applied to the primary DB,
+// in the order they were applied, against a scratch DB returned by
+// shadowFactory and compares the resulting schema to the current schema of
+// the primary DB. This catches changes which were applied by hand without
+// ever becoming migrations. Pending migrations are not replayed, so they
+// are not reported as drift.
+//
+// The replay runs in a single transaction on the shadow DB, which is always
+// rolled back, so the same scratch DB can be used repeatedly. Migrations which
+// have already been recorded as completed on the shadow DB, or which were
+// skipped on the primary because their ShouldRun predicate returned false,
+// are not replayed.
+//
+// Tables, columns and indexes are compared. Any differences are returned,
+// along with an error wrapping ErrSchemaDrift. An error wrapping
+// ErrMigrationNotKnown is returned if a migration applied to the primary
+// is not registered.
+func (x *Migrator) ValidateAgainstShadow(shadowFactory DBFactory) ([]SchemaDifference, error) {
+	primary, err := x.snapshotSchema(x.dbFactory())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read primary schema")
+	}
+
+	applied, err := x.getAppliedMigrations(x.stateDB().WithContext(x.ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read applied migrations")
+	}
+
+	shadowDB := shadowFactory()
+	tx, err := shadowDB.BeginContext(x.ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The replay is always rolled back, so the shadow DB is left untouched.
+	defer tx.Close()
+
+	// The shadow DB is always written to, even by a read-only Migrator.
+	err = x.createMigrationTable(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	completed, err := x.getCompletedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+	completedOnShadow := make(map[string]bool, len(completed))
+	for _, name := range completed {
+		completedOnShadow[name] = true
+	}
+
+	var migrationsToRun []string
+	for _, appliedMigration := range applied {
+		if !appliedMigration.Skipped && !completedOnShadow[appliedMigration.Name] {
+			migrationsToRun = append(migrationsToRun, appliedMigration.Name)
+		}
+	}
+
+	x.logAtLevel(LogLevelInfo, "Shadow replay: %d migrations\n", len(migrationsToRun))
+	for _, migrationName := range migrationsToRun {
+		migration, exists := x.registry.Get(migrationName)
+		if !exists {
+			return nil, errors.Wrapf(ErrMigrationNotKnown, "migration %s", migrationName)
+		}
+
+		err = x.runMigrationFunc(tx, migrationName, Up, migration.Up)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s failed to migrate shadow", migrationName)
+		}
+	}
+
+	shadow, err := x.snapshotSchema(tx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read shadow schema")
+	}
+
+	differences := compareSchemaSnapshots(primary, shadow)
+	if len(differences) > 0 {
+		for _, difference := range differences {
+			x.logAtLevel(LogLevelDebug, "Schema drift: %s\n", difference)
+		}
+		return differences, errors.Wrapf(
+			ErrSchemaDrift,
+			"%d differences",
+			len(differences),
+		)
+	}
+
+	return nil, nil
+}
+
+// snapshotSchema returns a description of every user column and index
+// in the DB, keyed by object name. The migration table is excluded.
+func (x *Migrator) snapshotSchema(db Querier) (map[string]string, error) {
+	schema, err := Introspect(db)
+	if err != nil {
+		return nil, err
+	}
+
+	migrationTable := x.migrationTableName
+	snapshot := make(map[string]string)
+	for _, table := range schema.Tables {
+		if isMigrationTable(migrationTable, table.Schema, table.Name) {
+			continue
+		}
+		for _, column := range table.Columns {
+			key := fmt.Sprintf("column %s.%s.%s", table.Schema, table.Name, column.Name)
+			snapshot[key] = fmt.Sprintf(
+				"%s nullable=%t default=%q",
+				column.Type,
+				column.Nullable,
+				column.Default,
+			)
+		}
+		for _, index := range table.Indexes {
+			key := fmt.Sprintf("index %s.%s", table.Schema, index.Name)
+			snapshot[key] = index.Definition
+		}
+	}
+
+	return snapshot, nil
+}
+
+// systemSchemas lists schemas which are never considered for schema
+// comparisons.
+var systemSchemas = []string{
+	"pg_catalog",
+	"information_schema",
+	"crdb_internal",
+	"pg_extension",
+}
+
+// isMigrationTable reports whether the given schema and table refer to
+// the migration table, which may or may not be schema-qualified.
+func isMigrationTable(migrationTable string, schema string, table string) bool {
+	return migrationTable == table || migrationTable == schema+"."+table
+}
+
+// compareSchemaSnapshots returns all differences between two snapshots,
+// sorted by object name.
+func compareSchemaSnapshots(primary map[string]string, shadow map[string]string) []SchemaDifference {
+	var differences []SchemaDifference
+	for object, primaryDef := range primary {
+		shadowDef, ok := shadow[object]
+		if !ok || shadowDef != primaryDef {
+			differences = append(differences, SchemaDifference{
+				Object:  object,
+				Primary: primaryDef,
+				Shadow:  shadowDef,
+			})
+		}
+	}
+	for object, shadowDef := range shadow {
+		if _, ok := primary[object]; !ok {
+			differences = append(differences, SchemaDifference{
+				Object: object,
+				Shadow: shadowDef,
+			})
+		}
+	}
+
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Object < differences[j].Object
+	})
+	return differences
+}
@@ -0,0 +1,253 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// Source records a reference to the code which defines a migration, such
+// as a file path and revision, e.g. "migrations/20240102150405_users.go@3f2c1d0".
+// The reference is stored in the migration table when the migration is
+// applied, and is reported by Status and History.
+//
+// Migrations generated by Create include their source reference.
+func Source(ref string) MigrationOpt {
+	return func(x *migration) error {
+		x.Source = ref
+		return nil
+	}
+}
+
+// AppliedMigration describes a migration recorded in the migration table.
+type AppliedMigration struct {
+	Name       string
+	Batch      int
+	MigratedAt time.Time
+
+	// Duration is how long the up function of the migration took to
+	// run, or zero if it was applied by an older version of this package.
+	Duration time.Duration
+
+	// Source is the source reference of the migration when it was
+	// applied, if known. See Source.
+	Source string
+
+	// Objects lists the tables and other objects the migration touched,
+	// if recorded. See WithObjectTracking.
+	Objects []string
+
+	// RowsAffected is the number of rows the migration affected, or zero
+	// if none were counted. See WithRowCounting.
+	RowsAffected int64
+
+	// Skipped indicates that the migration was recorded without being
+	// run, because its ShouldRun predicate returned false.
+	Skipped bool
+
+	// Memo is the note given to the run which applied the migration, if
+	// any. See WithMemo.
+	Memo string
+}
+
+// MigrationStatus describes a migration which is registered, applied, or
+// both.
+type MigrationStatus struct {
+	Name string
+
+	// Registered indicates that the migration is known to the Migrator.
+	Registered bool
+
+	// Applied indicates that the migration is recorded in the migration
+	// table. Batch and MigratedAt are only set for applied migrations.
+	Applied    bool
+	Batch      int
+	MigratedAt time.Time
+
+	// Source is the source reference recorded when the migration was
+	// applied or, for pending migrations, the one it was registered with.
+	Source string
+
+	// Description and Author are those the migration was registered with,
+	// if any. They are empty for migrations which are not registered.
+	Description string
+	Author      string
+
+	// RequiresApproval indicates that the migration is only run by
+	// ApproveAndRun. See RequiresApproval.
+	RequiresApproval bool
+
+	// Phase is the phase of a deploy a registered migration belongs to.
+	// See Phase.
+	Phase string
+
+	// Skipped indicates that the migration was applied without being
+	// run, because its ShouldRun predicate returned false.
+	Skipped bool
+
+	// Memo is the note given to the run which applied the migration, if
+	// any. See WithMemo.
+	Memo string
+}
+
+// History returns the migrations recorded in the migration table, in the
+// order they were applied, along with the batch each belongs to and when
+// it was applied. This allows tools such as dashboards and drift detectors
+// to inspect the applied migrations without depending on the layout of
+// the migration table. The migration table is not created if it does not
+// exist.
+func (x *Migrator) History() ([]AppliedMigration, error) {
+	db := x.stateDB().WithContext(x.ctx)
+	return x.getAppliedMigrations(db)
+}
+
+// Status returns every registered migration along with any applied
+// migrations which are not registered, sorted by name, noting whether
+// each one has been applied. The migration table is not created if it
+// does not exist.
+//
+// The migrations returned may be limited with opts, such as StatusSince.
+// Only migrations matching every option are returned.
+func (x *Migrator) Status(opts ...StatusOpt) ([]MigrationStatus, error) {
+	options, err := newStatusOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	db := x.stateDB().WithContext(x.ctx)
+	applied, err := x.getAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]*MigrationStatus, len(applied))
+	for _, name := range x.registry.List() {
+		migration, _ := x.registry.Get(name)
+		statuses[name] = &MigrationStatus{
+			Name:             name,
+			Registered:       true,
+			Source:           migration.Source,
+			Description:      migration.Description,
+			Author:           migration.Author,
+			RequiresApproval: migration.RequiresApproval,
+			Phase:            migration.phase(),
+		}
+	}
+	for _, appliedMigration := range applied {
+		status, exists := statuses[appliedMigration.Name]
+		if !exists {
+			status = &MigrationStatus{Name: appliedMigration.Name}
+			statuses[appliedMigration.Name] = status
+		}
+		status.Applied = true
+		status.Batch = appliedMigration.Batch
+		status.MigratedAt = appliedMigration.MigratedAt
+		status.Skipped = appliedMigration.Skipped
+		status.Memo = appliedMigration.Memo
+		if appliedMigration.Source != "" {
+			status.Source = appliedMigration.Source
+		}
+	}
+
+	result := make([]MigrationStatus, 0, len(statuses))
+	for _, status := range statuses {
+		if options.matches(*status) {
+			result = append(result, *status)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// getAppliedMigrations returns the rows of the migration table, in the
+// order they were inserted, or nothing if the table does not exist.
+func (x *Migrator) getAppliedMigrations(db Querier) ([]AppliedMigration, error) {
+	exists, err := x.migrationTableExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	// The newer columns are read through to_jsonb, so that tables which
+	// predate them can still be read without upgrading them.
+	var rows []struct {
+		Name         string
+		Batch        int
+		MigratedAt   time.Time
+		DurationMs   int64
+		Source       string
+		Objects      []string `pg:",array"`
+		RowsAffected int64
+		Skipped      bool
+		Memo         string
+	}
+	_, err = db.Query(
+		&rows,
+		`
+			SELECT
+				name,
+				batch,
+				migration_time AS migrated_at,
+				(to_jsonb(m)->>'duration_ms')::bigint AS duration_ms,
+				to_jsonb(m)->>'source' AS source,
+				CASE jsonb_typeof(to_jsonb(m)->'objects')
+					WHEN 'array' THEN ARRAY(SELECT jsonb_array_elements_text(to_jsonb(m)->'objects'))
+				END AS objects,
+				(to_jsonb(m)->>'rows_affected')::bigint AS rows_affected,
+				coalesce((to_jsonb(m)->>'skipped')::boolean, false) AS skipped,
+				to_jsonb(m)->>'memo' AS memo
+			FROM ? AS m
+			ORDER BY id
+		`,
+		pg.Ident(x.migrationTableName),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]AppliedMigration, 0, len(rows))
+	for _, row := range rows {
+		applied = append(applied, AppliedMigration{
+			Name:         x.currentName(row.Name),
+			Batch:        row.Batch,
+			MigratedAt:   row.MigratedAt,
+			Duration:     time.Duration(row.DurationMs) * time.Millisecond,
+			Source:       row.Source,
+			Objects:      row.Objects,
+			RowsAffected: row.RowsAffected,
+			Skipped:      row.Skipped,
+			Memo:         row.Memo,
+		})
+	}
+	return applied, nil
+}
+
+// sourceReference returns the source reference of a generated migration
+// file: its path relative to the working directory and, if the running
+// binary was built from version control, the revision it was built from.
+func sourceReference(filePath string) string {
+	ref := filePath
+	workingDir, err := os.Getwd()
+	if err == nil {
+		relativePath, err := filepath.Rel(workingDir, filePath)
+		if err == nil {
+			ref = filepath.ToSlash(relativePath)
+		}
+	}
+
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ref
+	}
+	for _, setting := range buildInfo.Settings {
+		if setting.Key == "vcs.revision" && setting.Value != "" {
+			return ref + "@" + setting.Value
+		}
+	}
+	return ref
+}
@@ -0,0 +1,427 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/go-pg/pg/v10/types"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrSQLAdapterOpen indicates that an attempt was made to open a
+	// connection by name with the driver behind the database/sql adapter,
+	// which can only be used with the transaction of a migration.
+	ErrSQLAdapterOpen = errors.New("database/sql adapter cannot open connections")
+
+	// ErrInvalidPlaceholder indicates that a query run through the
+	// database/sql adapter refers to an argument which was not given.
+	ErrInvalidPlaceholder = errors.New("invalid placeholder")
+)
+
+// Column types which are returned as time.Time rather than as text by the
+// database/sql adapter, as lib/pq and pgx do.
+const (
+	pgDateOID        = 1082
+	pgTimestampOID   = 1114
+	pgTimestamptzOID = 1184
+	pgByteaOID       = 17
+)
+
+// runSQLTxFunc calls a migration function written against database/sql,
+// passing it a *sql.Tx backed by tx. See Registry.Register.
+func runSQLTxFunc(tx *pg.Tx, fn func(*sql.Tx) error) error {
+	return withSQLDB(tx, func(db *sql.DB) error {
+		sqlTx, err := db.BeginTx(tx.Context(), nil)
+		if err != nil {
+			return err
+		}
+
+		err = fn(sqlTx)
+		if err != nil {
+			_ = sqlTx.Rollback()
+			return err
+		}
+		return sqlTx.Commit()
+	})
+}
+
+// withSQLDB calls fn with a *sql.DB whose only connection runs queries in
+// tx. Transactions begun on the *sql.DB are no-ops, since tx is committed
+// or rolled back along with the rest of the migration.
+func withSQLDB(tx *pg.Tx, fn func(*sql.DB) error) error {
+	db := sql.OpenDB(sqlTxConnector{tx: tx})
+	db.SetMaxOpenConns(1)
+	err := fn(db)
+	closeErr := db.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// sqlTxConnector is a driver.Connector whose connections run queries in
+// the transaction of a migration.
+type sqlTxConnector struct {
+	tx *pg.Tx
+}
+
+// Interface Compliance: This ensures compile-time checks
+// that sqlTxConnector indeed implements all methods of driver.Connector.
+var _ driver.Connector = (*sqlTxConnector)(nil)
+
+func (x sqlTxConnector) Connect(context.Context) (driver.Conn, error) {
+	return &sqlTxConn{tx: x.tx}, nil
+}
+
+func (x sqlTxConnector) Driver() driver.Driver {
+	return sqlTxDriver{}
+}
+
+// sqlTxDriver is the driver of sqlTxConnector. It cannot open connections
+// by name.
+type sqlTxDriver struct{}
+
+// Interface Compliance: This ensures compile-time checks
+// that sqlTxDriver indeed implements all methods of driver.Driver.
+var _ driver.Driver = (*sqlTxDriver)(nil)
+
+func (x sqlTxDriver) Open(string) (driver.Conn, error) {
+	return nil, ErrSQLAdapterOpen
+}
+
+// sqlTxConn is a driver.Conn which runs queries in a transaction.
+type sqlTxConn struct {
+	tx *pg.Tx
+}
+
+// Interface Compliance: This ensures compile-time checks
+// that sqlTxConn indeed implements all methods of the driver
+// interfaces it is used through.
+var (
+	_ driver.Conn           = (*sqlTxConn)(nil)
+	_ driver.ConnBeginTx    = (*sqlTxConn)(nil)
+	_ driver.ExecerContext  = (*sqlTxConn)(nil)
+	_ driver.QueryerContext = (*sqlTxConn)(nil)
+)
+
+func (x *sqlTxConn) Prepare(query string) (driver.Stmt, error) {
+	return &sqlTxStmt{conn: x, query: query}, nil
+}
+
+func (x *sqlTxConn) Close() error {
+	return nil
+}
+
+func (x *sqlTxConn) Begin() (driver.Tx, error) {
+	return sqlNoopTx{}, nil
+}
+
+func (x *sqlTxConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return sqlNoopTx{}, nil
+}
+
+func (x *sqlTxConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	formatted, err := formatSQLQuery(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := x.tx.ExecContext(ctx, formatted)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(result.RowsAffected()), nil
+}
+
+func (x *sqlTxConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	formatted, err := formatSQLQuery(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := &sqlTxRows{}
+	_, err = x.tx.QueryContext(ctx, rows, formatted)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// sqlTxStmt is a driver.Stmt for a query which is formatted and sent when
+// it is run, rather than prepared on the server.
+type sqlTxStmt struct {
+	conn  *sqlTxConn
+	query string
+}
+
+// Interface Compliance: This ensures compile-time checks
+// that sqlTxStmt indeed implements all methods of the driver
+// interfaces it is used through.
+var (
+	_ driver.Stmt             = (*sqlTxStmt)(nil)
+	_ driver.StmtExecContext  = (*sqlTxStmt)(nil)
+	_ driver.StmtQueryContext = (*sqlTxStmt)(nil)
+)
+
+func (x *sqlTxStmt) Close() error {
+	return nil
+}
+
+func (x *sqlTxStmt) NumInput() int {
+	return -1
+}
+
+func (x *sqlTxStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return x.ExecContext(x.conn.tx.Context(), namedValues(args))
+}
+
+func (x *sqlTxStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return x.QueryContext(x.conn.tx.Context(), namedValues(args))
+}
+
+func (x *sqlTxStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return x.conn.ExecContext(ctx, x.query, args)
+}
+
+func (x *sqlTxStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return x.conn.QueryContext(ctx, x.query, args)
+}
+
+// sqlNoopTx is returned when a transaction is begun through the adapter.
+// The queries already run in the transaction of the migration, which is
+// committed or rolled back by the Migrator.
+type sqlNoopTx struct{}
+
+// Interface Compliance: This ensures compile-time checks
+// that sqlNoopTx indeed implements all methods of driver.Tx.
+var _ driver.Tx = (*sqlNoopTx)(nil)
+
+func (x sqlNoopTx) Commit() error {
+	return nil
+}
+
+func (x sqlNoopTx) Rollback() error {
+	return nil
+}
+
+// sqlTxRows holds the rows returned by a query, which are read in full by
+// go-pg before being handed to database/sql. It implements both
+// orm.HooklessModel, to receive the rows, and driver.Rows.
+//
+// Columns are only known if at least one row is returned.
+type sqlTxRows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+// Interface Compliance: This ensures compile-time checks
+// that sqlTxRows indeed implements all methods of orm.HooklessModel,
+// orm.ColumnScanner and driver.Rows.
+var (
+	_ orm.HooklessModel = (*sqlTxRows)(nil)
+	_ orm.ColumnScanner = (*sqlTxRows)(nil)
+	_ driver.Rows       = (*sqlTxRows)(nil)
+)
+
+func (x *sqlTxRows) Init() error {
+	return nil
+}
+
+func (x *sqlTxRows) NextColumnScanner() orm.ColumnScanner {
+	x.rows = append(x.rows, nil)
+	return x
+}
+
+func (x *sqlTxRows) AddColumnScanner(orm.ColumnScanner) error {
+	return nil
+}
+
+func (x *sqlTxRows) ScanColumn(col types.ColumnInfo, rd types.Reader, n int) error {
+	value, err := readDriverValue(col, rd, n)
+	if err != nil {
+		return err
+	}
+
+	row := len(x.rows) - 1
+	x.rows[row] = append(x.rows[row], value)
+	if row == 0 {
+		x.columns = append(x.columns, col.Name)
+	}
+	return nil
+}
+
+func (x *sqlTxRows) Columns() []string {
+	return x.columns
+}
+
+func (x *sqlTxRows) Close() error {
+	return nil
+}
+
+func (x *sqlTxRows) Next(dest []driver.Value) error {
+	if x.next >= len(x.rows) {
+		return io.EOF
+	}
+	copy(dest, x.rows[x.next])
+	x.next++
+	return nil
+}
+
+// readDriverValue reads a column value in text format as a driver.Value.
+// Dates and timestamps are returned as time.Time and bytea as []byte, while
+// everything else is returned as text for database/sql to convert.
+func readDriverValue(col types.ColumnInfo, rd types.Reader, n int) (driver.Value, error) {
+	if n == -1 {
+		return nil, nil
+	}
+
+	switch col.DataType {
+	case pgDateOID, pgTimestampOID, pgTimestamptzOID:
+		return types.ScanTime(rd, n)
+	case pgByteaOID:
+		return types.ScanBytes(rd, n)
+	default:
+		return types.ScanString(rd, n)
+	}
+}
+
+// namedValues converts positional arguments to named values.
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+// formatSQLQuery replaces the $1, $2, ... placeholders of a query written
+// for database/sql with the corresponding arguments, formatted as literals
+// by go-pg. Placeholders within quotes, dollar-quoted strings and comments
+// are left alone.
+func formatSQLQuery(query string, args []driver.NamedValue) (string, error) {
+	if len(args) == 0 {
+		return query, nil
+	}
+
+	formatter := orm.NewFormatter()
+	builder := &strings.Builder{}
+	for i := 0; i < len(query); {
+		end := skipSQLLiteral(query, i)
+		if end > i {
+			builder.WriteString(query[i:end])
+			i = end
+			continue
+		}
+
+		if query[i] != '$' || i+1 >= len(query) || !isDigit(query[i+1]) {
+			builder.WriteByte(query[i])
+			i++
+			continue
+		}
+
+		end = i + 1
+		for end < len(query) && isDigit(query[end]) {
+			end++
+		}
+		ordinal, err := strconv.Atoi(query[i+1 : end])
+		if err != nil || ordinal < 1 || ordinal > len(args) {
+			return "", errors.Wrapf(ErrInvalidPlaceholder, "%s with %d arguments", query[i:end], len(args))
+		}
+		builder.Write(formatter.FormatQuery(nil, "?", args[ordinal-1].Value))
+		i = end
+	}
+	return builder.String(), nil
+}
+
+// skipSQLLiteral returns the end of the quoted string, quoted identifier,
+// dollar-quoted string or comment starting at query[i], or i if none
+// starts there.
+func skipSQLLiteral(query string, i int) int {
+	rest := query[i:]
+	switch {
+	case rest[0] == '\'':
+		// Backslashes only escape quotes in E'...' strings.
+		escapes := i > 0 && (query[i-1] == 'E' || query[i-1] == 'e')
+		for j := 1; j < len(rest); j++ {
+			switch {
+			case escapes && rest[j] == '\\':
+				j++
+			case rest[j] == '\'' && j+1 < len(rest) && rest[j+1] == '\'':
+				j++
+			case rest[j] == '\'':
+				return i + j + 1
+			}
+		}
+		return len(query)
+	case rest[0] == '"':
+		end := strings.IndexByte(rest[1:], '"')
+		if end == -1 {
+			return len(query)
+		}
+		return i + end + 2
+	case strings.HasPrefix(rest, "--"):
+		end := strings.IndexByte(rest, '\n')
+		if end == -1 {
+			return len(query)
+		}
+		return i + end + 1
+	case strings.HasPrefix(rest, "/*"):
+		depth := 0
+		for j := 0; j+1 < len(rest); j++ {
+			switch rest[j : j+2] {
+			case "/*":
+				depth++
+				j++
+			case "*/":
+				depth--
+				j++
+				if depth == 0 {
+					return i + j + 1
+				}
+			}
+		}
+		return len(query)
+	case rest[0] == '$':
+		tag, ok := dollarQuoteTag(rest)
+		if !ok {
+			return i
+		}
+		end := strings.Index(rest[len(tag):], tag)
+		if end == -1 {
+			return len(query)
+		}
+		return i + len(tag) + end + len(tag)
+	default:
+		return i
+	}
+}
+
+// dollarQuoteTag returns the opening tag of a dollar-quoted string, such
+// as $$ or $body$, at the start of s.
+func dollarQuoteTag(s string) (string, bool) {
+	for j := 1; j < len(s); j++ {
+		char := s[j]
+		switch {
+		case char == '$':
+			return s[:j+1], true
+		case char == '_' || char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= 0x80:
+		case isDigit(char) && j > 1:
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isDigit reports whether char is an ASCII digit.
+func isDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
@@ -0,0 +1,142 @@
+package migrations
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMissingSQLFile indicates that an up migration file was found
+	// without a matching down migration file, or vice versa.
+	ErrMissingSQLFile = errors.New("missing sql migration file")
+)
+
+const (
+	// UpSQLSuffix is the suffix of files containing up migrations,
+	// when loading migrations from SQL files.
+	UpSQLSuffix = ".up.sql"
+
+	// DownSQLSuffix is the suffix of files containing down migrations,
+	// when loading migrations from SQL files.
+	DownSQLSuffix = ".down.sql"
+)
+
+// sqlMigrationFunc returns a migration function which executes the
+// given SQL, after resolving the placeholders of any secrets. Empty SQL is
+// treated as a no-op.
+func sqlMigrationFunc(query string) func(*pg.Tx, *Context) error {
+	return func(tx *pg.Tx, cont *Context) error {
+		if strings.TrimSpace(query) == "" {
+			return nil
+		}
+		resolved, err := cont.ResolveSecrets(query)
+		if err != nil {
+			return err
+		}
+		// Without params, go-pg sends the query verbatim, so question
+		// marks are not treated as placeholders.
+		_, err = tx.Exec(resolved)
+		return err
+	}
+}
+
+// RegisterSQL adds a migration consisting of plain SQL statements to the
+// list of known migrations. Multiple statements may be separated by
+// semicolons. The SQL is not formatted, so question marks do not need to
+// be escaped. Placeholders of secrets, such as ${secret:admin_pw}, are
+// resolved when the migration runs. See WithSecretsProvider.
+//
+// If a migration by the given name is already known, this will
+// return ErrMigrationAlreadyExists.
+func (x *Registry) RegisterSQL(name string, upSQL string, downSQL string, opts ...MigrationOpt) error {
+	return x.register(migration{
+		Name:    name,
+		Up:      sqlMigrationFunc(upSQL),
+		Down:    sqlMigrationFunc(downSQL),
+		UpSQL:   upSQL,
+		DownSQL: downSQL,
+	}, opts)
+}
+
+// LoadSQLFiles registers all SQL migrations found in dir within fsys.
+// Each migration consists of a pair of files named <name>.up.sql and
+// <name>.down.sql, and is registered as <name>.
+//
+// Subdirectories and files with other suffixes are ignored. Using an
+// embed.FS allows migrations to be compiled into the binary.
+func (x *Registry) LoadSQLFiles(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return errors.Wrap(err, "could not read migration directory")
+	}
+
+	upFiles := make(map[string]string)
+	downFiles := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		filename := entry.Name()
+		switch {
+		case strings.HasSuffix(filename, UpSQLSuffix):
+			upFiles[strings.TrimSuffix(filename, UpSQLSuffix)] = filename
+		case strings.HasSuffix(filename, DownSQLSuffix):
+			downFiles[strings.TrimSuffix(filename, DownSQLSuffix)] = filename
+		}
+	}
+
+	for name := range downFiles {
+		if _, ok := upFiles[name]; !ok {
+			return errors.Wrapf(ErrMissingSQLFile, "no up migration for %s", name)
+		}
+	}
+
+	names := make([]string, 0, len(upFiles))
+	for name := range upFiles {
+		if _, ok := downFiles[name]; !ok {
+			return errors.Wrapf(ErrMissingSQLFile, "no down migration for %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		upSQL, err := fs.ReadFile(fsys, path.Join(dir, upFiles[name]))
+		if err != nil {
+			return errors.Wrapf(err, "could not read %s", upFiles[name])
+		}
+
+		downSQL, err := fs.ReadFile(fsys, path.Join(dir, downFiles[name]))
+		if err != nil {
+			return errors.Wrapf(err, "could not read %s", downFiles[name])
+		}
+
+		err = x.RegisterSQL(name, string(upSQL), string(downSQL))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisterSQL adds a migration consisting of plain SQL statements to the
+// list of known migrations.
+//
+// If a migration by the given name is already known, this will
+// return ErrMigrationAlreadyExists.
+func (x *Migrator) RegisterSQL(name string, upSQL string, downSQL string, opts ...MigrationOpt) error {
+	return x.registry.RegisterSQL(name, upSQL, downSQL, opts...)
+}
+
+// LoadSQLFiles registers all SQL migrations found in dir within fsys.
+// See Registry.LoadSQLFiles.
+func (x *Migrator) LoadSQLFiles(fsys fs.FS, dir string) error {
+	return x.registry.LoadSQLFiles(fsys, dir)
+}
@@ -0,0 +1,84 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithStateDB initialises a Migrator which keeps the migration table, and
+// the other tables it uses to track migrations, in the DB returned by
+// factory, rather than in the DB which migrations are run against. This
+// allows the state of several DBs to be kept on a separate control-plane
+// cluster, for example.
+//
+// Each migration is run in a transaction on the target DB, while it is
+// recorded in a transaction on the state DB. The target transaction is
+// committed first, so if committing the state transaction fails, the
+// migration will have been applied without being recorded. Query hooks
+// only observe the target DB, and ScriptPending cannot be used, since the
+// script would have to update both DBs.
+//
+// Intended for use with NewMigrator.
+func WithStateDB(factory DBFactory) MigratorOpt {
+	return func(x *Migrator) error {
+		x.stateDBFactory = factory
+		return nil
+	}
+}
+
+// stateDB returns the DB holding the migration table.
+func (x *Migrator) stateDB() *pg.DB {
+	if x.stateDBFactory == nil {
+		return x.dbFactory()
+	}
+	return x.stateDBFactory()
+}
+
+// runInTransactions runs fn with a transaction on db, which migrations are
+// run against, and a transaction on the state DB, which migrations are
+// recorded in. Unless a state DB was set with WithStateDB, both are the
+// same transaction.
+//
+// With a separate state DB, the state transaction is started first and
+// committed last, so that the migration table stays locked until the
+// migrations have been committed.
+//
+// Any lock timeout set with WithLockTimeout applies to both transactions.
+func (x *Migrator) runInTransactions(
+	ctx context.Context,
+	db TxRunner,
+	fn func(tx *pg.Tx, stateTx *pg.Tx) error,
+) error {
+	if x.stateDBFactory == nil {
+		return db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+			err := x.setLockTimeout(tx)
+			if err != nil {
+				return err
+			}
+			err = fn(tx, tx)
+			if err != nil {
+				return err
+			}
+			return x.checkLeases()
+		})
+	}
+
+	return x.stateDB().RunInTransaction(ctx, func(stateTx *pg.Tx) error {
+		err := x.setLockTimeout(stateTx)
+		if err != nil {
+			return err
+		}
+		return db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+			err := x.setLockTimeout(tx)
+			if err != nil {
+				return err
+			}
+			err = fn(tx, stateTx)
+			if err != nil {
+				return err
+			}
+			return x.checkLeases()
+		})
+	})
+}
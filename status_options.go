@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StatusOpt represents an option which limits the migrations returned by
+// Status. See the Status* functions in this package.
+type StatusOpt func(*statusOptions)
+
+// statusOptions holds the filters applied by Status.
+type statusOptions struct {
+	since    time.Time
+	patterns []string
+	batches  []int
+}
+
+// newStatusOptions applies opts and checks that any patterns are valid.
+func newStatusOptions(opts []StatusOpt) (statusOptions, error) {
+	var options statusOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	for _, pattern := range options.patterns {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return options, errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+	}
+	return options, nil
+}
+
+// matches reports whether a migration passes every filter.
+func (x statusOptions) matches(status MigrationStatus) bool {
+	if !x.since.IsZero() && (!status.Applied || status.MigratedAt.Before(x.since)) {
+		return false
+	}
+
+	for _, pattern := range x.patterns {
+		if matched, _ := path.Match(pattern, status.Name); !matched {
+			return false
+		}
+	}
+
+	for _, batch := range x.batches {
+		if !status.Applied || status.Batch != batch {
+			return false
+		}
+	}
+	return true
+}
+
+// StatusSince limits Status to migrations which were applied at or after
+// since. Pending migrations are excluded.
+func StatusSince(since time.Time) StatusOpt {
+	return func(x *statusOptions) {
+		x.since = since
+	}
+}
+
+// StatusMatching limits Status to migrations whose names match the glob
+// pattern, using the syntax of path.Match, e.g. "2024*_users_*".
+func StatusMatching(pattern string) StatusOpt {
+	return func(x *statusOptions) {
+		x.patterns = append(x.patterns, pattern)
+	}
+}
+
+// StatusBatch limits Status to migrations which were applied in the given
+// batch. Pending migrations are excluded.
+func StatusBatch(batch int) StatusOpt {
+	return func(x *statusOptions) {
+		x.batches = append(x.batches, batch)
+	}
+}
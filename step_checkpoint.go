@@ -0,0 +1,53 @@
+package migrations
+
+// Checkpoint describes the progress of MigrateStepByStep after a migration
+// has been committed. Since each migration is recorded as soon as it
+// completes, a run which stops early, e.g. because of WithMaxMigrations, is
+// resumed by running MigrateStepByStep again.
+type Checkpoint struct {
+	// Migration is the migration which was committed.
+	Migration string
+
+	// Batch is the batch the migration was recorded in.
+	Batch int
+
+	// Completed is the number of migrations committed by the run so far.
+	Completed int
+
+	// Remaining is the number of pending migrations which are left,
+	// including those left for a later run by WithMaxMigrations.
+	Remaining int
+}
+
+// WithMaxMigrations limits a run to the first n pending migrations, so that
+// a large number of migrations can be rolled out gradually, e.g. by a cron
+// job. The other migrations are left pending for the next run, and
+// repeatable migrations are not run until none are left. Values less than
+// or equal to 0 do not limit the run (the default).
+//
+// Intended for use with MigrateStepByStep.
+func WithMaxMigrations(n int) RunOpt {
+	return func(x *runOptions) {
+		x.maxMigrations = n
+	}
+}
+
+// WithCheckpoint calls fn after each migration run by MigrateStepByStep
+// has been committed, e.g. to report progress or to save how far a run
+// got. fn is called from the goroutine running the migrations.
+//
+// Intended for use with MigrateStepByStep.
+func WithCheckpoint(fn func(Checkpoint)) RunOpt {
+	return func(x *runOptions) {
+		x.checkpoint = fn
+	}
+}
+
+// limitMigrations returns the migrations a run may apply, and the number
+// it leaves for a later run.
+func (x runOptions) limitMigrations(migrationsToRun []string) ([]string, int) {
+	if x.maxMigrations <= 0 || len(migrationsToRun) <= x.maxMigrations {
+		return migrationsToRun, 0
+	}
+	return migrationsToRun[:x.maxMigrations], len(migrationsToRun) - x.maxMigrations
+}
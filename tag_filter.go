@@ -0,0 +1,87 @@
+package migrations
+
+// WithTags limits a run to pending migrations which have at least one of
+// the given tags. See Tags. If given more than once, the tags are combined.
+//
+// Migrations which are left pending are run by a later run, even if later
+// migrations have already been applied. Repeatable migrations are only run
+// once no migrations are left pending.
+//
+// Intended for use with MigrateBatch or MigrateStepByStep.
+func WithTags(tags ...string) RunOpt {
+	return func(x *runOptions) {
+		x.includeTags = append(x.includeTags, tags...)
+	}
+}
+
+// WithoutTags limits a run to pending migrations which have none of the
+// given tags, e.g. to leave migrations tagged "data" for a separate job.
+// See WithTags.
+//
+// Intended for use with MigrateBatch or MigrateStepByStep.
+func WithoutTags(tags ...string) RunOpt {
+	return func(x *runOptions) {
+		x.excludeTags = append(x.excludeTags, tags...)
+	}
+}
+
+// filtersTags reports whether the run is limited by tags.
+func (x runOptions) filtersTags() bool {
+	return len(x.includeTags) > 0 || len(x.excludeTags) > 0
+}
+
+// tagSelector returns a function selecting the pending migrations which
+// are in the phase of the run and pass its tag filters, for use with
+// migrateBatch, or nil if the run is not limited by phase or tags. See
+// MigratePhase.
+func (x runOptions) tagSelector(registry *Registry) func(pending []string) ([]string, error) {
+	if !x.filtersTags() && x.phase == "" {
+		return nil
+	}
+	return func(pending []string) ([]string, error) {
+		if x.phase != "" {
+			var err error
+			pending, err = x.selectPhase(registry, pending)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return x.selectTagged(registry, pending), nil
+	}
+}
+
+// selectTagged returns the pending migrations which pass the tag filters
+// of the run, keeping their order.
+func (x runOptions) selectTagged(registry *Registry, pending []string) []string {
+	if !x.filtersTags() {
+		return pending
+	}
+
+	selected := make([]string, 0, len(pending))
+	for _, name := range pending {
+		migration, _ := registry.Get(name)
+		if x.matchesTags(migration) {
+			selected = append(selected, name)
+		}
+	}
+	return selected
+}
+
+// matchesTags reports whether a migration has one of the included tags, if
+// any, and none of the excluded tags.
+func (x runOptions) matchesTags(m migration) bool {
+	for _, tag := range x.excludeTags {
+		if m.hasTag(tag) {
+			return false
+		}
+	}
+	if len(x.includeTags) == 0 {
+		return true
+	}
+	for _, tag := range x.includeTags {
+		if m.hasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
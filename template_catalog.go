@@ -0,0 +1,194 @@
+package migrations
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTemplateNotKnown indicates that no template by the given name
+	// was found in the template directory.
+	ErrTemplateNotKnown = errors.New("no template by name")
+
+	// ErrNoTemplateDir indicates that a named template was requested, but
+	// no template directory was configured.
+	ErrNoTemplateDir = errors.New("no template directory configured")
+
+	// ErrInvalidTemplateParam indicates that a template parameter was
+	// missing, or was not declared by the template.
+	ErrInvalidTemplateParam = errors.New("invalid template parameter")
+)
+
+// TemplateExtension is the extension of template files in the template
+// directory. The name of a template is its file name without the
+// extension.
+const TemplateExtension = ".tmpl"
+
+// TemplateParam is a parameter declared by a template.
+type TemplateParam struct {
+	Name        string
+	Description string
+}
+
+// TemplateInfo describes a template in the template directory.
+type TemplateInfo struct {
+	Name        string
+	Path        string
+	Description string
+	Params      []TemplateParam
+}
+
+// TemplateCatalog lists the templates in a template directory, along
+// with their descriptions and parameters.
+//
+// Templates describe themselves with a header comment at the start of the
+// file, containing a description and one line per parameter:
+//
+//	{{/*
+//	description: Creates an enum type.
+//	param: name - The name of the type.
+//	param: values - The quoted, comma-separated values.
+//	*/}}
+//
+// Parameters are available to the template as {{.Params.name}}, alongside
+// the usual {{.Filename}} and {{.FuncName}}, and may be transformed with
+// the functions listed by TemplateFuncs. Partials shared by the templates
+// are not listed; see PartialPrefix.
+type TemplateCatalog struct {
+	templates map[string]TemplateInfo
+}
+
+// LoadTemplateCatalog reads the templates in dir.
+func LoadTemplateCatalog(dir string) (*TemplateCatalog, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+TemplateExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &TemplateCatalog{
+		templates: make(map[string]TemplateInfo, len(paths)),
+	}
+	for _, path := range paths {
+		if isPartial(path) {
+			continue
+		}
+
+		info, err := readTemplateInfo(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "template %s", path)
+		}
+		catalog.templates[info.Name] = info
+	}
+	return catalog, nil
+}
+
+// List returns every template in the catalog, sorted by name.
+func (x *TemplateCatalog) List() []TemplateInfo {
+	templates := make([]TemplateInfo, 0, len(x.templates))
+	for _, info := range x.templates {
+		templates = append(templates, info)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates
+}
+
+// Get returns the template with the given name and a bool to indicate
+// whether it exists.
+func (x *TemplateCatalog) Get(name string) (TemplateInfo, bool) {
+	info, exists := x.templates[name]
+	return info, exists
+}
+
+// readTemplateInfo reads the header comment of a template file.
+func readTemplateInfo(path string) (TemplateInfo, error) {
+	info := TemplateInfo{
+		Name: strings.TrimSuffix(filepath.Base(path), TemplateExtension),
+		Path: path,
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "{{/*" {
+		return info, scanner.Err()
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "*/}}" {
+			break
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "description":
+			info.Description = value
+		case "param":
+			name, description, _ := strings.Cut(value, " - ")
+			info.Params = append(info.Params, TemplateParam{
+				Name:        strings.TrimSpace(name),
+				Description: strings.TrimSpace(description),
+			})
+		}
+	}
+	return info, scanner.Err()
+}
+
+// TemplateCatalog returns the catalog of templates in the template
+// directory. See WithTemplateDir.
+func (x *Migrator) TemplateCatalog() (*TemplateCatalog, error) {
+	if x.templateDir == "" {
+		return nil, ErrNoTemplateDir
+	}
+	return LoadTemplateCatalog(x.templateDir)
+}
+
+// CreateFromNamedTemplate renders the named template from the template
+// directory to the configured migration directory, passing params to it.
+// Every parameter declared by the template must be given, and no others.
+func (x *Migrator) CreateFromNamedTemplate(description string, templateName string, params map[string]string) error {
+	catalog, err := x.TemplateCatalog()
+	if err != nil {
+		return err
+	}
+
+	info, exists := catalog.Get(templateName)
+	if !exists {
+		return errors.Wrapf(ErrTemplateNotKnown, "template %s in %s", templateName, x.templateDir)
+	}
+
+	declared := make(map[string]struct{}, len(info.Params))
+	for _, param := range info.Params {
+		declared[param.Name] = struct{}{}
+		if _, exists := params[param.Name]; !exists {
+			return errors.Wrapf(ErrInvalidTemplateParam, "template %s requires %s", templateName, param.Name)
+		}
+	}
+	for name := range params {
+		if _, exists := declared[name]; !exists {
+			return errors.Wrapf(ErrInvalidTemplateParam, "template %s does not declare %s", templateName, name)
+		}
+	}
+
+	template, err := os.ReadFile(info.Path)
+	if err != nil {
+		return err
+	}
+	return x.createAt(time.Now(), description, string(template), params)
+}
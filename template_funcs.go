@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/jinzhu/inflection"
+)
+
+// TemplateFuncs returns the functions available to migration templates,
+// in addition to the built-in functions of text/template:
+//
+//	snake        "UserAccount" → "user_account"
+//	camel        "user_account" → "userAccount"
+//	pascal       "user_account" → "UserAccount"
+//	pluralize    "person" → "people"
+//	singularize  "people" → "person"
+//	quoteIdent   "public.user" → "\"public\".\"user\""
+//	secret       "admin_pw" → "${secret:admin_pw}"
+//
+// secret generates the placeholder of a secret, which is resolved from the
+// secrets provider of the Migrator when the migration runs, so that the
+// secret is not written into the migration. See WithSecretsProvider.
+//
+// For example, a template given a table parameter with -param table=user
+// might contain:
+//
+//	CREATE TABLE {{quoteIdent (pluralize .Params.table)}} (id bigserial PRIMARY KEY);
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"snake":       ConvertCamelCaseToSnakeCase,
+		"camel":       convertToCamelCase,
+		"pascal":      convertToPascalCase,
+		"pluralize":   inflection.Plural,
+		"singularize": inflection.Singular,
+		"quoteIdent":  quoteIdent,
+		"secret":      secretPlaceholder,
+	}
+}
+
+// convertToCamelCase converts a snake-case or pascal-case string to
+// camel-case.
+func convertToCamelCase(word string) string {
+	return ConvertSnakeCaseToCamelCase(ConvertCamelCaseToSnakeCase(word))
+}
+
+// convertToPascalCase converts a snake-case or camel-case string to
+// pascal-case.
+func convertToPascalCase(word string) string {
+	camel := convertToCamelCase(word)
+	char, size := utf8.DecodeRuneInString(camel)
+	if size == 0 {
+		return ""
+	}
+	return string(unicode.ToUpper(char)) + camel[size:]
+}
+
+// quoteIdent quotes a possibly schema-qualified SQL identifier, as go-pg
+// does for pg.Ident.
+func quoteIdent(name string) string {
+	return string(orm.NewFormatter().FormatQuery(nil, "?", pg.Ident(name)))
+}
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// PartialPrefix marks a file in the template directory as a partial rather
+// than a template. A partial named _header.tmpl is not listed by
+// TemplateCatalog, but is available to every template as "header":
+//
+//	{{template "header" .}}
+//
+// Partials may also declare blocks with {{block "name" .}}, which templates
+// can override with {{define "name"}}, so that a layout partial can hold
+// the standard structure of every migration and each template fills in
+// only what differs.
+const PartialPrefix = "_"
+
+// isPartial reports whether a file in the template directory is a partial.
+func isPartial(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), PartialPrefix)
+}
+
+// parseMigrationTemplate parses a migration template, along with the
+// partials in the template directory, if one is configured. Partials are
+// parsed first, so that blocks defined by partials can be overridden by
+// the template.
+func (x *Migrator) parseMigrationTemplate(templateString string) (*template.Template, error) {
+	t := template.New("template").Funcs(TemplateFuncs())
+	if x.templateDir != "" {
+		paths, err := filepath.Glob(filepath.Join(x.templateDir, PartialPrefix+"*"+TemplateExtension))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), PartialPrefix), TemplateExtension)
+			_, err = t.New(name).Parse(string(content))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse partial %s", path)
+			}
+		}
+	}
+
+	_, err := t.Parse(templateString)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse template")
+	}
+	return t, nil
+}
@@ -0,0 +1,99 @@
+package migrations
+
+import (
+	"bytes"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTestTemplate is the template of the test file generated alongside
+// each migration by Create, when enabled with WithCreateTests.
+//
+// The test runs the migration up and then down against the fake DB of the
+// migratest package, so it expects the same registry variable as
+// DefaultMigrationTemplate. It is a starting point: assertions about the
+// queries sent should be added for each migration.
+const DefaultTestTemplate = `package main
+
+import (
+	"testing"
+
+	"github.com/chainql/migrations"
+	"github.com/chainql/migrations/migratest"
+)
+
+func Test{{.FuncName}}(t *testing.T) {
+	if _, ok := registry.Get("{{.Filename}}"); !ok {
+		t.Fatal("migration {{.Filename}} is not registered")
+	}
+
+	db := migratest.New()
+	defer db.Close()
+
+	migrator, err := migrations.NewMigrator(db.DBFactory(), migrations.WithMigrations(&registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrator.RunOne("{{.Filename}}", migrations.Up)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	// The fake DB does not keep state, so it is told that the migration
+	// was applied before it is rolled back.
+	db.On(` + "`" + `SELECT batch FROM .* WHERE name = '{{.Filename}}'` + "`" + `, migratest.Result{
+		Columns: []string{"batch"},
+		Rows:    [][]interface{}{{"{{"}}1{{"}}"}},
+	})
+	err = migrator.RunOne("{{.Filename}}", migrations.Down)
+	if err != nil {
+		t.Fatalf("down: %v", err)
+	}
+}
+`
+
+// WithCreateTests initialises a Migrator which generates a test file, named
+// after the migration with a _test.go suffix, whenever Create generates a
+// migration. The test is rendered from DefaultTestTemplate, and checks that
+// the migration can be run up and down.
+//
+// Intended for use with NewMigrator.
+func WithCreateTests() MigratorOpt {
+	return func(x *Migrator) error {
+		x.createTests = true
+		return nil
+	}
+}
+
+// testFilePath returns the path of the test file of a migration.
+func (x *Migrator) testFilePath(filename string) string {
+	return filepath.Join(x.migrationDir, filename+"_test.go")
+}
+
+// createTestFile renders the test template for a migration.
+func (x *Migrator) createTestFile(filename string, funcName string) error {
+	t, err := template.New("test").Parse(DefaultTestTemplate)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse test template")
+	}
+
+	buf := &bytes.Buffer{}
+	err = t.Execute(buf, map[string]interface{}{
+		"Filename": filename,
+		"FuncName": funcName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to render test template")
+	}
+
+	filePath := x.testFilePath(filename)
+	err = x.createFile(filePath, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	x.logAtLevel(LogLevelInfo, "Created migration test %s", filePath)
+	return nil
+}
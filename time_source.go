@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithTimeSource initialises a Migrator which records the time migrations
+// were applied, in the migration_time column of the migration table and of
+// the repeatable migration table, using now instead of the clock of the
+// DB. now is called once at the start of each batch, and every migration
+// of the batch is recorded with the same time. This allows tests to expect
+// a fixed history, and clock policies, e.g. truncating times to the second
+// in UTC, to be applied.
+//
+// Intended for use with NewMigrator.
+func WithTimeSource(now func() time.Time) MigratorOpt {
+	return func(x *Migrator) error {
+		x.timeSource = &timeSource{now: now}
+		return nil
+	}
+}
+
+// timeSource provides the times migrations are recorded with, keeping the
+// time the current batch started.
+type timeSource struct {
+	now func() time.Time
+
+	mtx       sync.Mutex
+	batchTime time.Time
+}
+
+// startBatch takes the time of a batch which is about to run.
+func (x *timeSource) startBatch() {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.batchTime = x.now()
+}
+
+// endBatch forgets the time of the batch which has finished, so that
+// migrations recorded outside of a batch are not given its time.
+func (x *timeSource) endBatch() {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	x.batchTime = time.Time{}
+}
+
+// currentBatchTime returns the time the current batch started, or the
+// current time if no batch has started.
+func (x *timeSource) currentBatchTime() time.Time {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	if x.batchTime.IsZero() {
+		return x.now()
+	}
+	return x.batchTime
+}
+
+// migrationTime returns the value to record as the migration_time of a
+// migration applied in the current batch: the time from the time source,
+// if any, or now() of the DB.
+func (x *Migrator) migrationTime() interface{} {
+	if x.timeSource == nil {
+		return pg.Safe("now()")
+	}
+	return x.timeSource.currentBatchTime()
+}
+
+// repeatableTime returns the value to record as the migration_time of a
+// repeatable migration, which does not belong to a batch.
+func (x *Migrator) repeatableTime() interface{} {
+	if x.timeSource == nil {
+		return pg.Safe("now()")
+	}
+	return x.timeSource.now()
+}
@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// WithTransactionPooling initialises a Migrator which only relies on
+// transaction-scoped state, so that it works when the DB can only be
+// reached through a pooler in transaction mode, such as PgBouncer with
+// pool_mode=transaction. Such a pooler may send each transaction, or each
+// statement outside of a transaction, to a different server connection,
+// so session-level locks and settings would be lost or leak to other
+// clients.
+//
+// In this mode:
+//
+//   - An AdvisoryLocker is replaced by a TxAdvisoryLocker, which takes the
+//     same advisory lock in each transaction instead of once per session.
+//   - MigrateParallel holds its advisory lock in a transaction which is
+//     kept open for the duration of the run, rather than on a session, so
+//     the pooler needs room for one more server connection.
+//
+// Settings such as the lock timeout are always made with SET LOCAL, and
+// statements are not prepared outside of transactions, so need no
+// changes. Custom Lockers which hold session-level state are not changed.
+//
+// Intended for use with NewMigrator.
+func WithTransactionPooling() MigratorOpt {
+	return func(x *Migrator) error {
+		x.transactionPooling = true
+		return nil
+	}
+}
+
+// TxAdvisoryLocker takes a transaction-level advisory lock, keyed by the
+// name of the migration table, in each transaction which reads or changes
+// the migration table, like TableLocker. The lock is released when the
+// transaction ends, so it works through poolers in transaction mode. It
+// takes the same lock as AdvisoryLocker, so the two exclude each other.
+type TxAdvisoryLocker struct{}
+
+// Interface Compliance
+var _ TxLocker = TxAdvisoryLocker{}
+
+// Acquire does nothing, since the lock is taken in each transaction.
+func (x TxAdvisoryLocker) Acquire(context.Context, *pg.DB, string) error {
+	return nil
+}
+
+// Release does nothing, since the lock is released with the transaction.
+func (x TxAdvisoryLocker) Release(context.Context, *pg.DB, string) error {
+	return nil
+}
+
+// LockTx takes the advisory lock of table in tx.
+func (x TxAdvisoryLocker) LockTx(ctx context.Context, tx *pg.Tx, table string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext(?))", table)
+	return err
+}
+
+// String returns "transaction advisory lock".
+func (x TxAdvisoryLocker) String() string {
+	return "transaction advisory lock"
+}
+
+// useTransactionScopedLocker replaces a session-level AdvisoryLocker with
+// a TxAdvisoryLocker, if the Migrator was created with
+// WithTransactionPooling.
+func (x *Migrator) useTransactionScopedLocker() {
+	if !x.transactionPooling {
+		return
+	}
+	if _, ok := x.locker.(*AdvisoryLocker); ok {
+		x.logAtLevel(LogLevelDebug, "Using a transaction advisory lock for transaction pooling")
+		x.locker = TxAdvisoryLocker{}
+	}
+}
+
+// holdRunAdvisoryLock takes the advisory lock of the migration table for
+// the duration of a run which is not otherwise locked, such as a parallel
+// run, until the returned function is called. With WithTransactionPooling
+// the lock is held by a transaction, and otherwise by a session.
+func (x *Migrator) holdRunAdvisoryLock() (release func(), err error) {
+	if x.transactionPooling {
+		tx, err := x.stateDB().BeginContext(x.ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = x.waitForLock(tx, "transaction advisory lock", func() error {
+			return TxAdvisoryLocker{}.LockTx(x.ctx, tx, x.migrationTableName)
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+		return func() { _ = tx.Rollback() }, nil
+	}
+
+	conn := x.stateDB().Conn()
+	err = x.waitForLock(conn.WithContext(x.ctx), "advisory lock", func() error {
+		_, err := conn.ExecContext(x.ctx, "SELECT pg_advisory_lock(hashtext(?))", x.migrationTableName)
+		return err
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return func() {
+		_, _ = conn.Exec("SELECT pg_advisory_unlock(hashtext(?))", x.migrationTableName)
+		_ = conn.Close()
+	}, nil
+}
@@ -0,0 +1,102 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+)
+
+// MigrationFunc is an up or down migration function which does not need
+// the migration context.
+type MigrationFunc func(*pg.Tx) error
+
+// CtxMigrationFunc is an up or down migration function which receives the
+// migration context.
+type CtxMigrationFunc func(*pg.Tx, *Context) error
+
+// MigrationFuncSignature is satisfied by the valid signatures of migration
+// functions. See RegisterTyped.
+//
+// Named function types such as MigrationFunc must be converted to the
+// underlying function type, since they are not recognised when the
+// migration is run.
+type MigrationFuncSignature interface {
+	func(*pg.Tx) error | func(*pg.Tx, *Context) error | func(*sql.Tx) error | func(*sql.DB) error
+}
+
+// RegisterFuncs adds a migration to the list of known migrations. Unlike
+// Register, invalid function signatures are rejected at compile time.
+//
+// If a migration by the given name is already known, this will
+// return ErrMigrationAlreadyExists.
+func (x *Registry) RegisterFuncs(name string, up MigrationFunc, down MigrationFunc, opts ...MigrationOpt) error {
+	return RegisterTyped(
+		x,
+		name,
+		(func(*pg.Tx) error)(up),
+		(func(*pg.Tx) error)(down),
+		opts...,
+	)
+}
+
+// RegisterCtxFuncs adds a migration whose functions receive the migration
+// context to the list of known migrations. Unlike Register, invalid
+// function signatures are rejected at compile time.
+//
+// If a migration by the given name is already known, this will
+// return ErrMigrationAlreadyExists.
+func (x *Registry) RegisterCtxFuncs(name string, up CtxMigrationFunc, down CtxMigrationFunc, opts ...MigrationOpt) error {
+	return RegisterTyped(
+		x,
+		name,
+		(func(*pg.Tx, *Context) error)(up),
+		(func(*pg.Tx, *Context) error)(down),
+		opts...,
+	)
+}
+
+// RegisterTyped adds a migration to registry. The functions may have
+// either of the signatures accepted by Register, but both must have the
+// same one, and invalid signatures are rejected at compile time.
+//
+// If a migration by the given name is already known, this will
+// return ErrMigrationAlreadyExists.
+func RegisterTyped[F MigrationFuncSignature](registry *Registry, name string, up F, down F, opts ...MigrationOpt) error {
+	upFunc, err := untypedMigrationFunc(up)
+	if err != nil {
+		return errors.Wrap(err, "invalid up migration")
+	}
+
+	downFunc, err := untypedMigrationFunc(down)
+	if err != nil {
+		return errors.Wrap(err, "invalid down migration")
+	}
+
+	return registry.register(migration{
+		Name: name,
+		Up:   upFunc,
+		Down: downFunc,
+	}, opts)
+}
+
+// untypedMigrationFunc returns fn as an interface, rejecting nil functions,
+// which would otherwise be hidden by the conversion.
+func untypedMigrationFunc[F MigrationFuncSignature](fn F) (interface{}, error) {
+	if fn == nil {
+		return nil, ErrNullMigrationFunc
+	}
+	return fn, nil
+}
+
+// RegisterFuncs adds a migration to the list of known migrations.
+// See Registry.RegisterFuncs.
+func (x *Migrator) RegisterFuncs(name string, up MigrationFunc, down MigrationFunc, opts ...MigrationOpt) error {
+	return x.registry.RegisterFuncs(name, up, down, opts...)
+}
+
+// RegisterCtxFuncs adds a migration whose functions receive the migration
+// context to the list of known migrations. See Registry.RegisterCtxFuncs.
+func (x *Migrator) RegisterCtxFuncs(name string, up CtxMigrationFunc, down CtxMigrationFunc, opts ...MigrationOpt) error {
+	return x.registry.RegisterCtxFuncs(name, up, down, opts...)
+}